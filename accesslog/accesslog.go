@@ -0,0 +1,100 @@
+// Package accesslog logs a line for every request served: what was
+// asked, how it was answered and how long that took, along with the
+// request's telemetry identifiers and the JA3 fingerprint of TLS
+// clients.
+package accesslog
+
+import (
+	"net/http"
+	"time"
+
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/ja3"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+// Config controls the access log.
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// DefaultConfig logs every request.
+func DefaultConfig() Config {
+	return Config{Enabled: true}
+}
+
+// Middleware writes the access log.
+// アクセスログを書き出す
+type Middleware struct {
+	cfg Config
+	log *zap.Logger
+}
+
+// New builds a Middleware that writes through a child of the given
+// logger.
+func New(cfg Config, log *zap.Logger) *Middleware {
+	return &Middleware{cfg: cfg, log: log.Named("access")}
+}
+
+// Name names the middleware in traces.
+func (*Middleware) Name() string {
+	return "accesslog"
+}
+
+// Wrap returns a handler that logs each request once it has been
+// served.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	if !m.cfg.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		if rw.status == 0 {
+			rw.status = http.StatusOK // 何も書かなかった場合
+		}
+		fields := []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", rw.status),
+			zap.Int64("bytes", rw.bytes),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("client", clientip.FromRequest(r)),
+		}
+		if fp := ja3.FromContext(r.Context()); fp.Hash != "" {
+			fields = append(fields, zap.String("ja3", fp.Hash)) // TLSのときだけ
+		}
+		telemetry.Logger(r.Context(), m.log).Info("Served request", fields...)
+	})
+}
+
+// recorder remembers the status and size of the response.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *recorder) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 { // informational responses come before the real one
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying writer.
+func (w *recorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
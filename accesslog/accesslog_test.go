@@ -0,0 +1,12 @@
+package accesslog
+
+import (
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	httpfxtest.Benchmark(b, New(DefaultConfig(), zap.NewNop()).Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,239 @@
+package amqp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+// broker is a fake broker for one connection. It answers the setup
+// methods, hands the consumers one message each, confirms the messages
+// published unless their routing key is "refused", and records the
+// methods it receives.
+type broker struct {
+	methods chan uint32 // the methods received, after the handshake
+	acked   chan uint64 // the delivery tags acknowledged
+}
+
+func newBroker(t *testing.T) (*broker, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	b := &broker{methods: make(chan uint32, 64), acked: make(chan uint64, 8)}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b.serve(t, conn)
+	}()
+	return b, l.Addr().String()
+}
+
+func (b *broker) serve(t *testing.T, conn net.Conn) {
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	send := func(frames ...frame) {
+		if err := writeFrames(w, frames...); err != nil {
+			t.Errorf("broker: %v", err)
+		}
+	}
+	recv := func() (frame, method) {
+		for {
+			f, err := readFrame(r, maxFrame)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					t.Errorf("broker: %v", err)
+				}
+				return frame{}, method{}
+			}
+			if f.kind != frameHeartbeat {
+				m, _ := parseMethod(f)
+				return f, m
+			}
+		}
+	}
+
+	if _, err := io.ReadFull(r, make([]byte, len(protocolHeader))); err != nil {
+		t.Errorf("broker: %v", err)
+		return
+	}
+	start := appendLongstr([]byte{0, 9, 0, 0, 0, 0}, "AMQPLAIN PLAIN") // サーバーのプロパティは空
+	send(methodFrame(0, connectionStart, appendLongstr(start, "en_US")))
+	if _, m := recv(); m.id != connectionStartOk {
+		t.Errorf("broker: got method %x, want StartOk", m.id)
+		return
+	}
+	tune := binary.BigEndian.AppendUint32([]byte{0, 0}, 4096)
+	send(methodFrame(0, connectionTune, binary.BigEndian.AppendUint16(tune, 0)))
+	recv() // TuneOk
+	recv() // Open
+	send(methodFrame(0, connectionOpenOk, appendShortstr(nil, "")))
+
+	answers := map[uint32]uint32{
+		channelOpen:     channelOpenOk,
+		exchangeDeclare: exchangeDeclareOk,
+		queueDeclare:    queueDeclareOk,
+		queueBind:       queueBindOk,
+		basicQos:        basicQosOk,
+		confirmSelect:   confirmSelectOk,
+	}
+	var published uint64
+	for {
+		f, m := recv()
+		if m.id == 0 {
+			return
+		}
+		b.methods <- m.id
+		args := reader{b: m.args}
+		switch m.id {
+		case basicConsume:
+			send(methodFrame(f.channel, basicConsumeOk, appendShortstr(nil, "ctag")))
+			d := appendShortstr(nil, "ctag")
+			d = appendBits(binary.BigEndian.AppendUint64(d, uint64(f.channel)), false)
+			d = appendShortstr(appendShortstr(d, "orders"), "order.created")
+			body := []byte(`{"id": 1}`)
+			send(append([]frame{methodFrame(f.channel, basicDeliver, d), headerFrame(f.channel, Message{ContentType: "application/json", Body: body})},
+				bodyFrames(f.channel, body, 4096)...)...)
+		case basicAck:
+			b.acked <- args.longlong()
+		case basicPublish:
+			args.short()
+			args.shortstr()
+			key := args.shortstr()
+			header, _ := recv()
+			size, _, _ := parseHeader(header)
+			for n := uint64(0); n < size; {
+				body, _ := recv()
+				n += uint64(len(body.payload))
+			}
+			published++
+			confirm := methodFrame(f.channel, basicAck, appendBits(binary.BigEndian.AppendUint64(nil, published), false))
+			if key == "refused" {
+				confirm = methodFrame(f.channel, basicNack, appendBits(binary.BigEndian.AppendUint64(nil, published), false, false))
+			}
+			send(confirm)
+		case connectionClose:
+			send(methodFrame(0, connectionCloseOk, nil))
+			return
+		default:
+			if ok, found := answers[m.id]; found {
+				send(methodFrame(f.channel, ok, nil))
+			}
+		}
+	}
+}
+
+// consumerFunc consumes queue with a function.
+type consumerFunc struct {
+	queue  string
+	handle func(context.Context, Delivery) error
+}
+
+func (c consumerFunc) Queue() string                                { return c.queue }
+func (c consumerFunc) Handle(ctx context.Context, d Delivery) error { return c.handle(ctx, d) }
+
+// TestClient declares a topology, consumes a message and publishes two
+// against the fake broker.
+func TestClient(t *testing.T) {
+	b, addr := newBroker(t)
+	got := make(chan Delivery, 1)
+	cfg := DefaultConfig()
+	cfg.URL = "amqp://fxdemo:secret@" + addr + "/"
+	cfg.Events = []string{"*"}
+	cfg.Queues = []Queue{{
+		Name:      "orders",
+		Durable:   true,
+		Arguments: map[string]any{"x-dead-letter-exchange": "orders.dlx", "x-message-ttl": 60000},
+		Bindings:  []Binding{{Exchange: "fxdemo.events", RoutingKey: "order.#"}},
+	}}
+	lc := fxtest.NewLifecycle(t)
+	c, err := NewClient(Params{
+		Lifecycle: lc,
+		Config:    cfg,
+		Log:       zap.NewNop(),
+		Consumers: []Consumer{consumerFunc{"orders", func(_ context.Context, d Delivery) error {
+			got <- d
+			return nil
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc.RequireStart()
+
+	select {
+	case d := <-got:
+		if d.RoutingKey != "order.created" || d.ContentType != "application/json" || string(d.Body) != `{"id": 1}` {
+			t.Errorf("consumer got %+v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message consumed")
+	}
+	if tag := <-b.acked; tag != 2 {
+		t.Errorf("acknowledged delivery %d, want 2", tag)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Publish(ctx, "fxdemo.events", "order.created", Message{Body: make([]byte, 10000)}); err != nil {
+		t.Errorf("Publish() = %v", err)
+	}
+	if err := c.Publish(ctx, "fxdemo.events", "refused", Message{}); !errors.Is(err, ErrNacked) {
+		t.Errorf("Publish() of a refused message = %v, want ErrNacked", err)
+	}
+	lc.RequireStop()
+
+	var methods []uint32
+	for len(b.methods) > 0 {
+		methods = append(methods, <-b.methods)
+	}
+	want := []uint32{
+		channelOpen, exchangeDeclare, queueDeclare, queueBind, confirmSelect,
+		channelOpen, basicQos, basicConsume, basicAck, basicPublish, basicPublish, connectionClose,
+	}
+	if !slices.Equal(methods, want) {
+		t.Errorf("broker received methods %x, want %x", methods, want)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  func(*Config)
+		err  bool
+	}{
+		{"off", func(c *Config) { c.URL = "" }, false},
+		{"plain", func(*Config) {}, false},
+		{"tls with a vhost", func(c *Config) { c.URL = "amqps://u:p@broker/prod" }, false},
+		{"unknown scheme", func(c *Config) { c.URL = "mqtt://broker" }, true},
+		{"no heartbeat", func(c *Config) { c.Heartbeat = 0 }, true},
+		{"no prefetch", func(c *Config) { c.Prefetch = 0 }, true},
+		{"exchange without a type", func(c *Config) { c.Exchanges = []Exchange{{Name: "x"}} }, true},
+		{"queue without a name", func(c *Config) { c.Queues = []Queue{{Durable: true}} }, true},
+		{"unsupported argument", func(c *Config) { c.Queues = []Queue{{Name: "q", Arguments: map[string]any{"x": []int{1}}}} }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.URL = "amqp://localhost"
+			tt.cfg(&cfg)
+			_, err := NewClient(Params{Lifecycle: fxtest.NewLifecycle(t), Config: cfg, Log: zap.NewNop()})
+			if (err != nil) != tt.err {
+				t.Errorf("NewClient() error = %v, want error %v", err, tt.err)
+			}
+		})
+	}
+}
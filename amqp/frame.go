@@ -0,0 +1,378 @@
+package amqp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// protocolHeader opens a connection in AMQP 0-9-1.
+const protocolHeader = "AMQP\x00\x00\x09\x01"
+
+// Frame types, and the octet that ends every frame.
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xce
+)
+
+// Classes and methods of AMQP 0-9-1, as far as they are used here, as
+// class<<16 | method.
+const (
+	connectionStart     = 10<<16 | 10
+	connectionStartOk   = 10<<16 | 11
+	connectionTune      = 10<<16 | 30
+	connectionTuneOk    = 10<<16 | 31
+	connectionOpen      = 10<<16 | 40
+	connectionOpenOk    = 10<<16 | 41
+	connectionClose     = 10<<16 | 50
+	connectionCloseOk   = 10<<16 | 51
+	connectionBlocked   = 10<<16 | 60
+	connectionUnblocked = 10<<16 | 61
+	channelOpen         = 20<<16 | 10
+	channelOpenOk       = 20<<16 | 11
+	channelClose        = 20<<16 | 40
+	channelCloseOk      = 20<<16 | 41
+	exchangeDeclare     = 40<<16 | 10
+	exchangeDeclareOk   = 40<<16 | 11
+	queueDeclare        = 50<<16 | 10
+	queueDeclareOk      = 50<<16 | 11
+	queueBind           = 50<<16 | 20
+	queueBindOk         = 50<<16 | 21
+	basicQos            = 60<<16 | 10
+	basicQosOk          = 60<<16 | 11
+	basicConsume        = 60<<16 | 20
+	basicConsumeOk      = 60<<16 | 21
+	basicCancel         = 60<<16 | 30
+	basicPublish        = 60<<16 | 40
+	basicDeliver        = 60<<16 | 60
+	basicAck            = 60<<16 | 80
+	basicNack           = 60<<16 | 120
+	confirmSelect       = 85<<16 | 10
+	confirmSelectOk     = 85<<16 | 11
+
+	classBasic = 60
+)
+
+// frame is a frame of any type: its type, the channel it belongs to and
+// its payload.
+type frame struct {
+	kind    byte
+	channel uint16
+	payload []byte
+}
+
+// writeFrames writes the frames and flushes them together, so that the
+// frames of one message are not interleaved with others.
+func writeFrames(w *bufio.Writer, frames ...frame) error {
+	for _, f := range frames {
+		if len(f.payload) > math.MaxUint32 {
+			return errors.New("amqp: frame too large")
+		}
+		w.WriteByte(f.kind)
+		w.Write(binary.BigEndian.AppendUint16(nil, f.channel))
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(f.payload))))
+		w.Write(f.payload)
+		w.WriteByte(frameEnd)
+	}
+	return w.Flush()
+}
+
+// readFrame reads the next frame, refusing payloads over limit bytes.
+func readFrame(r *bufio.Reader, limit int) (frame, error) {
+	var h [7]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return frame{}, err
+	}
+	n := binary.BigEndian.Uint32(h[3:])
+	if int64(n) > int64(limit) {
+		return frame{}, fmt.Errorf("amqp: %d byte frame exceeds the limit", n)
+	}
+	payload := make([]byte, n+1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return frame{}, err
+	}
+	if payload[n] != frameEnd {
+		return frame{}, errors.New("amqp: malformed frame end")
+	}
+	return frame{kind: h[0], channel: binary.BigEndian.Uint16(h[1:]), payload: payload[:n]}, nil
+}
+
+// method is a method frame's payload, decoded up to its arguments.
+type method struct {
+	id   uint32 // class<<16 | method
+	args []byte
+}
+
+// parseMethod reads the method carried by f.
+func parseMethod(f frame) (method, error) {
+	if f.kind != frameMethod || len(f.payload) < 4 {
+		return method{}, errors.New("amqp: expected a method frame")
+	}
+	return method{id: binary.BigEndian.Uint32(f.payload), args: f.payload[4:]}, nil
+}
+
+// methodFrame builds the frame of the method id on channel, its
+// arguments built by args.
+func methodFrame(channel uint16, id uint32, args []byte) frame {
+	return frame{kind: frameMethod, channel: channel, payload: append(binary.BigEndian.AppendUint32(nil, id), args...)}
+}
+
+// appendShortstr appends s as a short string, cut to 255 bytes.
+func appendShortstr(b []byte, s string) []byte {
+	s = s[:min(len(s), math.MaxUint8)]
+	return append(append(b, byte(len(s))), s...)
+}
+
+// appendLongstr appends s as a long string.
+func appendLongstr(b []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(s))), s...)
+}
+
+// appendBits packs the flags into an octet, the first in the lowest bit.
+func appendBits(b []byte, flags ...bool) []byte {
+	var o byte
+	for i, f := range flags {
+		if f {
+			o |= 1 << i
+		}
+	}
+	return append(b, o)
+}
+
+// appendTable appends t as a field table. Strings, booleans, integers,
+// floats and nested tables are supported, which is what the arguments
+// of queues and exchanges take.
+func appendTable(b []byte, t map[string]any) ([]byte, error) {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0)
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		b = appendShortstr(b, k)
+		switch v := t[k].(type) {
+		case string:
+			b = appendLongstr(append(b, 'S'), v)
+		case bool:
+			b = appendBits(append(b, 't'), v)
+		case int:
+			b = binary.BigEndian.AppendUint64(append(b, 'l'), uint64(v))
+		case int64:
+			b = binary.BigEndian.AppendUint64(append(b, 'l'), uint64(v))
+		case float64:
+			b = binary.BigEndian.AppendUint64(append(b, 'd'), math.Float64bits(v))
+		case nil:
+			b = append(b, 'V')
+		case map[string]any:
+			var err error
+			if b, err = appendTable(append(b, 'F'), v); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("amqp: unsupported %T value for argument %q", v, k)
+		}
+	}
+	binary.BigEndian.PutUint32(b[start:], uint32(len(b)-start-4))
+	return b, nil
+}
+
+// reader decodes the arguments of a method or the properties of a
+// content header, in order. Its first error sticks, so that the values
+// can be read before checking it once.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || len(r.b) < n {
+		if r.err == nil {
+			r.err = errors.New("amqp: truncated arguments")
+		}
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) octet() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) short() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) long() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) longlong() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) shortstr() string {
+	return string(r.next(int(r.octet())))
+}
+
+func (r *reader) longstr() string {
+	n := r.long()
+	if uint64(n) > uint64(len(r.b)) {
+		r.next(len(r.b) + 1) // 切り詰められている
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+// table skips a field table; the tables received are not needed.
+func (r *reader) table() {
+	r.longstr()
+}
+
+// closeError returns the error of a Connection.Close or Channel.Close
+// received from the broker.
+func closeError(m method) error {
+	r := reader{b: m.args}
+	code, text := r.short(), r.shortstr()
+	what := "connection"
+	if m.id == channelClose {
+		what = "channel"
+	}
+	if r.err != nil {
+		return fmt.Errorf("amqp: broker closed the %s", what)
+	}
+	return fmt.Errorf("amqp: broker closed the %s: %d %s", what, code, text)
+}
+
+// Property flags of the basic class, in the order of the properties.
+const (
+	propContentType = 1 << (15 - iota)
+	propContentEncoding
+	propHeaders
+	propDeliveryMode
+	propPriority
+	propCorrelationID
+	propReplyTo
+	propExpiration
+	propMessageID
+	propTimestamp
+	propType
+	propUserID
+	propAppID
+	propClusterID
+)
+
+// headerFrame builds the content header of m on channel.
+func headerFrame(channel uint16, m Message) frame {
+	var flags uint16
+	var props []byte
+	if m.ContentType != "" {
+		flags |= propContentType
+		props = appendShortstr(props, m.ContentType)
+	}
+	if m.Persistent {
+		flags |= propDeliveryMode
+		props = append(props, 2)
+	}
+	if m.MessageID != "" {
+		flags |= propMessageID
+		props = appendShortstr(props, m.MessageID)
+	}
+	if !m.Timestamp.IsZero() {
+		flags |= propTimestamp
+		props = binary.BigEndian.AppendUint64(props, uint64(m.Timestamp.Unix()))
+	}
+	if m.Type != "" {
+		flags |= propType
+		props = appendShortstr(props, m.Type)
+	}
+	b := binary.BigEndian.AppendUint16(nil, classBasic)
+	b = binary.BigEndian.AppendUint16(b, 0) // weight
+	b = binary.BigEndian.AppendUint64(b, uint64(len(m.Body)))
+	b = binary.BigEndian.AppendUint16(b, flags)
+	return frame{kind: frameHeader, channel: channel, payload: append(b, props...)}
+}
+
+// parseHeader reads a content header, returning the size of the body
+// to follow and the properties of the message.
+func parseHeader(f frame) (uint64, Message, error) {
+	r := reader{b: f.payload}
+	class, _, size, flags := r.short(), r.short(), r.longlong(), r.short()
+	if r.err != nil || class != classBasic {
+		return 0, Message{}, errors.New("amqp: malformed content header")
+	}
+	if flags&1 != 0 {
+		return 0, Message{}, errors.New("amqp: unsupported property flags")
+	}
+	var m Message
+	if flags&propContentType != 0 {
+		m.ContentType = r.shortstr()
+	}
+	if flags&propContentEncoding != 0 {
+		r.shortstr()
+	}
+	if flags&propHeaders != 0 {
+		r.table()
+	}
+	if flags&propDeliveryMode != 0 {
+		m.Persistent = r.octet() == 2
+	}
+	if flags&propPriority != 0 {
+		r.octet()
+	}
+	for _, p := range []uint16{propCorrelationID, propReplyTo, propExpiration} {
+		if flags&p != 0 {
+			r.shortstr()
+		}
+	}
+	if flags&propMessageID != 0 {
+		m.MessageID = r.shortstr()
+	}
+	if flags&propTimestamp != 0 {
+		m.Timestamp = time.Unix(int64(r.longlong()), 0).UTC()
+	}
+	if flags&propType != 0 {
+		m.Type = r.shortstr()
+	}
+	for _, p := range []uint16{propUserID, propAppID, propClusterID} {
+		if flags&p != 0 {
+			r.shortstr()
+		}
+	}
+	if r.err != nil {
+		return 0, Message{}, r.err
+	}
+	return size, m, nil
+}
+
+// bodyFrames splits body into frames no larger than frameMax.
+func bodyFrames(channel uint16, body []byte, frameMax int) []frame {
+	var frames []frame
+	for n := frameMax - 8; len(body) > 0; body = body[min(n, len(body)):] {
+		frames = append(frames, frame{kind: frameBody, channel: channel, payload: body[:min(n, len(body))]})
+	}
+	return frames
+}
@@ -0,0 +1,56 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// FuzzReadFrame checks that a frame read from the wire is written back
+// as it was read, and that a content header is built again into one that
+// parses the same.
+func FuzzReadFrame(f *testing.F) {
+	const limit = 1 << 16
+	encode := func(frames ...frame) []byte {
+		var b bytes.Buffer
+		if err := writeFrames(bufio.NewWriter(&b), frames...); err != nil {
+			f.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	m := Message{ContentType: "application/json", MessageID: "1", Type: "honeypot.hit", Timestamp: time.Unix(1700000000, 0).UTC(), Persistent: true, Body: []byte(`{}`)}
+	f.Add(encode(headerFrame(1, m)))
+	f.Add(encode(headerFrame(2, Message{})))
+	f.Add(encode(methodFrame(0, connectionTune, []byte{0, 0, 0, 2, 0, 0, 0, 60})))
+	f.Add(encode(frame{kind: frameHeartbeat}))
+	f.Add([]byte{frameHeader, 0, 1, 0, 0, 0, 14, 0, 60, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xfe, frameEnd})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fr, err := readFrame(bufio.NewReader(bytes.NewReader(data)), limit)
+		if err != nil {
+			return
+		}
+		if len(fr.payload) > limit {
+			t.Fatalf("readFrame() read a %d byte payload, over the limit", len(fr.payload))
+		}
+		again, err := readFrame(bufio.NewReader(bytes.NewReader(encode(fr))), limit)
+		if err != nil || !reflect.DeepEqual(again, fr) {
+			t.Errorf("readFrame(writeFrames(%+v)) = %+v, %v", fr, again, err)
+		}
+
+		if fr.kind != frameHeader {
+			return
+		}
+		size, m, err := parseHeader(fr)
+		if err != nil {
+			return
+		}
+		m.Body = make([]byte, size%1024)
+		_, built, err := parseHeader(headerFrame(fr.channel, m))
+		built.Body = m.Body
+		if err != nil || !reflect.DeepEqual(built, m) {
+			t.Errorf("parseHeader(%+v) = %+v, which builds a header parsed as %+v, %v", fr, m, built, err)
+		}
+	})
+}
@@ -0,0 +1,16 @@
+package amqp
+
+import (
+	"example.com/fxdemo/events"
+	"go.uber.org/fx"
+)
+
+// Module provides the Client and adds the Bridge to the event sinks.
+// Both do nothing until a broker is configured.
+// RabbitMQへのブリッジ
+var Module = fx.Module("amqp",
+	fx.Provide(
+		NewClient,
+		events.AsSink[*Bridge](NewBridge),
+	),
+)
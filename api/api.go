@@ -0,0 +1,170 @@
+// Package api holds the OpenAPI description of the server and the subset
+// of OpenAPI needed to read it: the client generator and the contract
+// checks both work from it.
+package api
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the OpenAPI description of the server.
+//
+//go:embed openapi.yaml
+var Spec []byte
+
+// Document is an OpenAPI document.
+type Document struct {
+	Paths      map[string]map[string]*Operation `yaml:"paths"` // by path template, then lower case method
+	Components struct {
+		Schemas map[string]*Schema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+// Operation is a single method on a path.
+type Operation struct {
+	OperationID string               `yaml:"operationId"`
+	Summary     string               `yaml:"summary"`
+	Parameters  []Parameter          `yaml:"parameters"`
+	RequestBody *RequestBody         `yaml:"requestBody"`
+	Responses   map[string]*Response `yaml:"responses"` // by status code or "default"
+}
+
+// Parameter is a header, query or path parameter.
+type Parameter struct {
+	Name     string `yaml:"name"`
+	In       string `yaml:"in"`
+	Required bool   `yaml:"required"`
+	Schema   Schema `yaml:"schema"`
+}
+
+// RequestBody lists the content types an operation accepts.
+type RequestBody struct {
+	Content map[string]MediaType `yaml:"content"`
+}
+
+// Response is a response an operation may give.
+type Response struct {
+	Description string               `yaml:"description"`
+	Headers     map[string]Header    `yaml:"headers"`
+	Content     map[string]MediaType `yaml:"content"` // by media range, such as "text/plain" or "*/*"
+}
+
+// Header is a response header.
+type Header struct {
+	Required bool   `yaml:"required"`
+	Schema   Schema `yaml:"schema"`
+}
+
+// MediaType describes a body of one content type.
+type MediaType struct {
+	Schema Schema `yaml:"schema"`
+}
+
+// Schema is the part of a JSON schema the server's description uses.
+type Schema struct {
+	Ref        string             `yaml:"$ref"` // Ref is resolved by Parse.
+	Type       string             `yaml:"type"`
+	Format     string             `yaml:"format"`
+	Properties map[string]*Schema `yaml:"properties"`
+	Required   []string           `yaml:"required"`
+	Items      *Schema            `yaml:"items"`
+}
+
+// Parse reads an OpenAPI document, replacing references to the schemas
+// under components with the schemas themselves.
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for _, ops := range doc.Paths {
+		for _, op := range ops {
+			for i := range op.Parameters {
+				if err := doc.resolve(&op.Parameters[i].Schema, 0); err != nil {
+					return nil, err
+				}
+			}
+			if op.RequestBody != nil {
+				if err := doc.resolveContent(op.RequestBody.Content); err != nil {
+					return nil, err
+				}
+			}
+			for _, resp := range op.Responses {
+				if err := doc.resolveContent(resp.Content); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return &doc, nil
+}
+
+func (d *Document) resolveContent(content map[string]MediaType) error {
+	for t, m := range content {
+		if err := d.resolve(&m.Schema, 0); err != nil {
+			return err
+		}
+		content[t] = m
+	}
+	return nil
+}
+
+// resolve replaces s with the schema it refers to, if any, and does the
+// same for the schemas within it. Recursive schemas are not supported.
+func (d *Document) resolve(s *Schema, depth int) error {
+	if depth > 32 {
+		return fmt.Errorf("api: schema nested too deeply")
+	}
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		target, ok := d.Components.Schemas[name]
+		if !ok || name == s.Ref {
+			return fmt.Errorf("api: unknown schema %q", s.Ref)
+		}
+		*s = *target
+	}
+	for _, p := range s.Properties {
+		if err := d.resolve(p, depth+1); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return d.resolve(s.Items, depth+1)
+	}
+	return nil
+}
+
+// Find returns the operation serving method on path, and the template of
+// the path it was declared under.
+func (d *Document) Find(method, path string) (*Operation, string) {
+	method = strings.ToLower(method)
+	for tmpl, ops := range d.Paths {
+		if op := ops[method]; op != nil && matchPath(tmpl, path) {
+			return op, tmpl
+		}
+	}
+	return nil, ""
+}
+
+// matchPath reports whether path fits tmpl, where a "{name}" segment
+// of the template stands for any non-empty segment.
+func matchPath(tmpl, path string) bool {
+	ts, ps := strings.Split(tmpl, "/"), strings.Split(path, "/")
+	if len(ts) != len(ps) {
+		return false
+	}
+	for i, t := range ts {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			if ps[i] == "" {
+				return false
+			}
+		} else if t != ps[i] {
+			return false
+		}
+	}
+	return true
+}
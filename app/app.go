@@ -0,0 +1,152 @@
+// Package app assembles the fxdemo API on top of httpfx: its routes,
+// the middleware every request goes through and the services behind
+// them.
+//
+// Subsystems that stand on their own — the server, logging, config and
+// the greeting, upload and metering resources — come as fx.Modules from
+// their packages, so other binaries can reuse them without this package.
+//
+// New builds the application. Its options extend it without any Fx
+// annotations:
+//
+//	app.New(
+//		app.WithRoutes(NewReportHandler),
+//		app.WithMiddleware(NewAuditTrail),
+//		app.WithConfigFile("prod.yaml"),
+//	).Run()
+package app
+
+import (
+	"example.com/fxdemo/accesslog"
+	"example.com/fxdemo/amqp"
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/audit"
+	"example.com/fxdemo/auth"
+	"example.com/fxdemo/challenge"
+	"example.com/fxdemo/config"
+	"example.com/fxdemo/contract"
+	"example.com/fxdemo/cors"
+	"example.com/fxdemo/db"
+	"example.com/fxdemo/deadline"
+	"example.com/fxdemo/debugmode"
+	"example.com/fxdemo/declared"
+	"example.com/fxdemo/degrade"
+	"example.com/fxdemo/denylist"
+	"example.com/fxdemo/deprecation"
+	"example.com/fxdemo/earlyhints"
+	"example.com/fxdemo/events"
+	"example.com/fxdemo/flags"
+	"example.com/fxdemo/fxstats"
+	"example.com/fxdemo/geoip"
+	"example.com/fxdemo/greeting"
+	"example.com/fxdemo/headers"
+	"example.com/fxdemo/health"
+	"example.com/fxdemo/honeypot"
+	"example.com/fxdemo/limits"
+	"example.com/fxdemo/links"
+	"example.com/fxdemo/logging"
+	"example.com/fxdemo/metering"
+	"example.com/fxdemo/metrics"
+	"example.com/fxdemo/mqtt"
+	"example.com/fxdemo/normalize"
+	"example.com/fxdemo/notes"
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/ratelimit"
+	"example.com/fxdemo/region"
+	"example.com/fxdemo/rewrite"
+	"example.com/fxdemo/shape"
+	"example.com/fxdemo/staticfiles"
+	"example.com/fxdemo/telemetry"
+	"example.com/fxdemo/tracing"
+	"example.com/fxdemo/transfer"
+	"example.com/fxdemo/upload"
+	"example.com/fxdemo/user"
+	"example.com/fxdemo/useragent"
+	"example.com/fxdemo/waf"
+	"example.com/fxdemo/worker"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxevent"
+	"go.uber.org/zap"
+)
+
+// module is the whole fxdemo application but for its configuration,
+// which New provides.
+// アプリケーション全体
+var module = fx.Options(
+	httpfx.Module,      // アプリケーションにサーバーを提供している
+	logging.Module,     // ロガー
+	config.Module,      // 設定を各サブシステムに配る
+	greeting.Module,    // 挨拶リソース
+	upload.Module,      // 再開可能なアップロード
+	metering.Module,    // テナントごとの利用量
+	health.Module,      // /healthzと/readyz
+	metrics.Module,     // Prometheus形式のメトリクス
+	tracing.Module,     // トレース
+	mqtt.Module,        // イベントをMQTTに流す
+	amqp.Module,        // イベントをRabbitMQに流す
+	db.Module,          // データベースの接続プール
+	notes.Module,       // データベースを使う例
+	user.Module,        // ユーザーリソース
+	staticfiles.Module, // 静的ファイル
+	worker.Module,      // バックグラウンドジョブ
+	fx.Provide(
+		NewServerOptions,
+		NewRouter,
+		fx.Annotate(
+			declared.NewRoutes, // 設定ファイルで宣言されたルート
+			fx.ResultTags(`group:"routes,flatten"`),
+		),
+		NewHandler,       // ミドルウェアを適用したハンドラ
+		NewReloadHandler, // muxの入れ替え
+		NewChains,        // ルートごとのミドルウェアの記録
+		httpfx.AsRoute[*RoutesHandler](NewRoutesHandler),
+		// 404と405もJSONで返す
+		fx.Annotate(apperror.NotFoundHandler, fx.As(new(httpfx.NotFoundHandler))),
+		fx.Annotate(apperror.MethodNotAllowedHandler, fx.As(new(httpfx.MethodNotAllowedHandler))),
+		challenge.NewVerifier,
+		challenge.NewMiddleware,
+		httpfx.AsRoute[*VarsHandler](NewVarsHandler),
+		deprecation.NewTracker, // 非推奨ルートの利用状況
+		httpfx.AsRoute[*deprecation.ReportHandler](deprecation.NewReportHandler),
+		flags.New,           // フィーチャーフラグ
+		shape.NewTranslator, // 移行中のレスポンスを旧形式に戻す
+		links.NewBuilder,    // プロキシを考慮した絶対URL
+		links.NewResolver,   // 信頼するプロキシの先のクライアント
+		degrade.NewRegistry, // 機能の縮退
+		httpfx.AsRoute[*degrade.Handler](degrade.NewHandler),
+		honeypot.NewTrap, // おとりのルート
+		denylist.New,
+		waf.NewEngine,           // ルールによるリクエスト検査
+		rewrite.NewEngine,       // リダイレクトとパスの書き換え
+		normalize.NewNormalizer, // パスの表記揃え
+		accesslog.New,           // アクセスログ
+		httpfx.AsMiddleware[*cors.Middleware](cors.New), // ブラウザからのオリジン間リクエスト
+		ratelimit.New,        // 流量制限
+		ratelimit.NewLimiter, // 実行中に変えられる制限値
+		audit.NewLogger,      // 監査ログ
+		events.NewBus,        // 内部イベントの転送
+		debugmode.NewMiddleware,
+		telemetry.NewMiddleware, // リクエストIDとテナント
+		contract.NewValidator,   // API定義との照合
+		deadline.NewMiddleware,  // リクエストの期限
+		transfer.NewMeter,       // 転送量の計測とクォータ
+		region.NewRouter,        // リージョンへの固定
+		geoip.NewResolver,       // 国とASの判定
+		useragent.NewClassifier, // クライアントの種類
+		headers.NewStandardizer, // レスポンスヘッダーの統一
+		limits.NewEnforcer,      // ボディの大きさと処理時間の上限
+		auth.NewAuthenticator,   // APIキーとJWT
+		auth.NewGuard,           // 認証が必要なルート
+		earlyhints.NewHinter,    // 103 Early Hints
+	),
+	fx.Invoke(LogRoutes),       // 起動時のルート一覧
+	fx.Decorate(region.Logger), // ログにリージョンを付ける
+	fx.WithLogger(func(log *zap.Logger, cfg logging.Config) (fxevent.Logger, error) { // fx自体のログ
+		fxlog, err := logging.NewFxLogger(log, cfg)
+		if err != nil {
+			return nil, err
+		}
+		// 起動にかかった時間も計測する
+		return fxstats.NewLogger(fxlog, log), nil
+	}),
+)
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+
+	"example.com/fxdemo/config"
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/fx"
+)
+
+// newBenchHandlers builds the application with its default
+// configuration, without starting it, and returns the handler the
+// server runs and the bare mux within it.
+func newBenchHandlers(b *testing.B) (handler http.Handler, mux *Router) {
+	b.Helper()
+	a := New(
+		WithModules(fx.Invoke(func(h http.Handler, r *Router) { handler, mux = h, r })),
+		WithOverrides(func(cfg *config.Config) {
+			// ログの書き出しを計測に含めない
+			cfg.Log.Level = "error"
+			cfg.Log.FxLevel = "error"
+		}),
+	)
+	if err := a.Err(); err != nil {
+		b.Fatal(httpfx.Explain(err))
+	}
+	return handler, mux
+}
+
+// BenchmarkNoop is the baseline of the other benchmarks: a handler that
+// does nothing.
+func BenchmarkNoop(b *testing.B) {
+	httpfxtest.Benchmark(b, httpfxtest.Noop)
+}
+
+// BenchmarkMux measures the routes without the middleware around them.
+func BenchmarkMux(b *testing.B) {
+	_, mux := newBenchHandlers(b)
+	httpfxtest.Benchmark(b, mux)
+}
+
+// BenchmarkHandler measures the routes with all the middleware, as the
+// server runs them.
+func BenchmarkHandler(b *testing.B) {
+	handler, _ := newBenchHandlers(b)
+	httpfxtest.Benchmark(b, handler)
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/redact"
+	"example.com/fxdemo/tracing"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// RouteChain is what the requests of a route go through, outermost
+// first: the middleware every request goes through, then the route's
+// own middleware, then the route itself.
+type RouteChain struct {
+	Route    string   `json:"route"`
+	Patterns []string `json:"patterns"`
+	Chain    []string `json:"chain"`
+}
+
+// Chains records the middleware in front of each route, to tell which
+// of them may have touched a request.
+// ルートごとのミドルウェアの並び
+type Chains struct {
+	mu     sync.RWMutex
+	global []string
+	routes []RouteChain // in the order the mux registered them
+}
+
+// NewChains builds an empty Chains.
+func NewChains() *Chains {
+	return &Chains{}
+}
+
+// setGlobal records the middleware every request goes through.
+func (c *Chains) setGlobal(names []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.global = names
+}
+
+// setRoutes records the routes of a newly built mux, replacing those of
+// the previous one.
+func (c *Chains) setRoutes(routes []RouteChain) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes = routes
+}
+
+// Routes returns the chain of each route.
+func (c *Chains) Routes() []RouteChain {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]RouteChain, len(c.routes))
+	for i, rc := range c.routes {
+		rc.Chain = append(append([]string(nil), c.global...), rc.Chain...)
+		out[i] = rc
+	}
+	return out
+}
+
+// annotated returns a handler that records the route's own chain on the
+// span of each request, the middleware in front of it having spans of
+// their own.
+func annotated(chain []string, h http.Handler) http.Handler {
+	value := strings.Join(chain, " > ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tracing.FromContext(r.Context()).SetAttr("route.chain", value)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RoutesHandler lists the chain of each route. It is only served to the
+// loopback interface, as it describes the server's insides, and is
+// redacted in case a route names itself after its configuration.
+// ルートとミドルウェアの一覧
+type RoutesHandler struct {
+	chains *Chains
+}
+
+// NewRoutesHandler builds a new RoutesHandler.
+func NewRoutesHandler(chains *Chains) *RoutesHandler {
+	return &RoutesHandler{chains: chains}
+}
+
+// Pattern reports the path at which this is registered.
+func (*RoutesHandler) Pattern() string {
+	return "/admin/routes"
+}
+
+// Methods reports the methods this serves.
+func (*RoutesHandler) Methods() []string {
+	return []string{http.MethodGet}
+}
+
+func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !clientip.IsLoopback(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	redact.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(h.chains.Routes())
+	})).ServeHTTP(w, r)
+}
+
+// describeRoute names a route by its type, and by its String method if
+// it has one.
+func describeRoute(route httpfx.Route) string {
+	if s, ok := route.(fmt.Stringer); ok {
+		return fmt.Sprintf("%T %s", route, s)
+	}
+	return fmt.Sprintf("%T", route)
+}
+
+// LogRoutes logs the route table on start, one route per pattern sorted
+// by pattern, if the server is configured to.
+// 起動時にルート一覧をログに出す
+func LogRoutes(lc fx.Lifecycle, cfg httpfx.Config, chains *Chains, log *zap.Logger) {
+	if !cfg.LogRoutes {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			type entry struct{ pattern, route string }
+			var table []entry
+			for _, rc := range chains.Routes() {
+				for _, pattern := range rc.Patterns {
+					table = append(table, entry{pattern, rc.Route})
+				}
+			}
+			sort.Slice(table, func(i, j int) bool { return table[i].pattern < table[j].pattern })
+			for _, e := range table {
+				log.Info("Serving route", zap.String("pattern", e.pattern), zap.String("route", e.route))
+			}
+			return nil
+		},
+	})
+}
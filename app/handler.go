@@ -0,0 +1,273 @@
+package app
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+
+	"example.com/fxdemo/accesslog"
+	"example.com/fxdemo/auth"
+	"example.com/fxdemo/challenge"
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/config"
+	"example.com/fxdemo/contract"
+	"example.com/fxdemo/deadline"
+	"example.com/fxdemo/debugmode"
+	"example.com/fxdemo/declared"
+	"example.com/fxdemo/denylist"
+	"example.com/fxdemo/deprecation"
+	"example.com/fxdemo/earlyhints"
+	"example.com/fxdemo/geoip"
+	"example.com/fxdemo/headers"
+	"example.com/fxdemo/health"
+	"example.com/fxdemo/honeypot"
+	"example.com/fxdemo/ja3"
+	"example.com/fxdemo/limits"
+	"example.com/fxdemo/metering"
+	"example.com/fxdemo/metrics"
+	"example.com/fxdemo/normalize"
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/ratelimit"
+	"example.com/fxdemo/redact"
+	"example.com/fxdemo/region"
+	"example.com/fxdemo/rewrite"
+	"example.com/fxdemo/shape"
+	"example.com/fxdemo/telemetry"
+	"example.com/fxdemo/tracing"
+	"example.com/fxdemo/transfer"
+	"example.com/fxdemo/useragent"
+	"example.com/fxdemo/versioning"
+	"example.com/fxdemo/waf"
+	"go.uber.org/zap"
+)
+
+// NewServerOptions fingerprints TLS clients, keeps secrets out of the
+// server's error log and the panics it recovers, and counts the
+// connections of slow clients.
+func NewServerOptions(log *zap.Logger, stats *metrics.Instrumentation) httpfx.ServerOptions {
+	return httpfx.ServerOptions{
+		ConnContext: ja3.ConnContext,       // JA3をリクエストのcontextから参照できるようにする
+		ErrorLog:    redact.NewStdLog(log), // panicの出力から秘密情報を消す
+		Listener: func(ln net.Listener) net.Listener {
+			return ja3.NewListener(ln, log)
+		},
+		SlowClient: stats.SlowClient, // slowloris対策で切った接続を数える
+		Redact:     httpfx.Redaction{String: redact.String, Bytes: redact.Bytes},
+	}
+}
+
+// VarsHandler exposes the expvar variables, such as the WAF counters,
+// to clients on the loopback interface only. The dump includes the
+// command line, so it is passed through redaction.
+// expvarの値を公開するハンドラ
+type VarsHandler struct {
+	vars http.Handler
+}
+
+// NewVarsHandler builds a new VarsHandler.
+func NewVarsHandler() *VarsHandler {
+	return &VarsHandler{vars: redact.Handler(expvar.Handler())}
+}
+
+func (h *VarsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !clientip.IsLoopback(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	h.vars.ServeHTTP(w, r)
+}
+
+// Pattern reports the path at which this is registered.
+func (*VarsHandler) Pattern() string {
+	return "/debug/vars"
+}
+
+// Reloadable lists the sections of the configuration that Router.Apply
+// puts in effect; the others take a restart.
+var Reloadable = []string{"declared", "limits"}
+
+// Router routes requests to the routes, through a Swapper so that Apply
+// can rebuild them with a new configuration while the server runs.
+// ルートのmux
+type Router struct {
+	mux    *httpfx.Swapper
+	routes httpfx.Routes
+	paths  *normalize.Normalizer
+	log    *zap.Logger
+	// build builds the mux of the routes of each version, bounding them
+	// by lim.
+	build func(versions [][]httpfx.Route, lim *limits.Enforcer) (http.Handler, error)
+}
+
+// NewRouter builds a router that will route requests to the given
+// routes. Routes that are deprecation.Deprecated announce it in their
+// responses, and shape.Migrating routes answer in the old shape to the
+// clients that have not been moved yet. Every route's requests are
+// recorded in the request metrics, and bounded by its limits. Routes
+// requiring authentication refuse clients without it before reading
+// their requests, and bill those with it for their usage.
+// ハンドラ
+func NewRouter(routes httpfx.Routes, usage *deprecation.Tracker, shapes *shape.Translator, std *headers.Standardizer, lim *limits.Enforcer, billing *metering.Recorder, guard *auth.Guard, stats *metrics.Instrumentation, chains *Chains, paths *normalize.Normalizer, log *zap.Logger) (*Router, error) {
+	rt := &Router{routes: routes, paths: paths, log: log}
+	rt.build = func(versions [][]httpfx.Route, lim *limits.Enforcer) (http.Handler, error) {
+		var recorded []RouteChain
+		var patterns []string
+		mux, err := httpfx.NewRouter(versions, func(route httpfx.Route) http.Handler {
+			patterns = append(patterns, route.Pattern())
+			// ハンドラごとにスパンを作る
+			h := usage.Wrap(route.Pattern(), route)
+			h = shapes.Wrap(route, h)
+			h = std.Route(route, h)
+			h = lim.Route(route, h) // 打ち切った応答もメトリクスに記録する
+			if _, ok := guard.Of(route); ok {
+				h = billing.Wrap(h) // 課金は認証された主体に対して行う
+			}
+			h = guard.Route(route, h)
+			h = stats.Route(route, h)
+			chain := routeChain(route, usage, shapes, std, lim, billing, guard, stats)
+			recorded = append(recorded, RouteChain{Route: describeRoute(route), Patterns: httpfx.PatternsOf(route), Chain: chain})
+			return tracing.Handler(fmt.Sprintf("%T", route), annotated(chain, h))
+		}, versioning.Resolve, routes.Fallbacks())
+		if err != nil {
+			return nil, err
+		}
+		if err := guard.CheckRoutes(patterns); err != nil {
+			return nil, err
+		}
+		chains.setRoutes(recorded)
+		return mux, nil
+	}
+	mux, err := httpfx.NewSwapper(func() (http.Handler, error) {
+		return rt.build(routes.Versions(), lim)
+	})
+	if err != nil {
+		return nil, err
+	}
+	rt.mux = mux
+	return rt, nil
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// Apply rebuilds the routes with the sections of cfg that are
+// Reloadable: the declared routes and the limits. It swaps them in and
+// waits for the previous ones as httpfx.Swapper.Swap does; if they
+// cannot be built, the previous ones stay.
+func (rt *Router) Apply(ctx context.Context, cfg config.Config) error {
+	fresh, err := declared.NewRoutes(cfg.Declared, rt.log)
+	if err != nil {
+		return err
+	}
+	versions := rt.routes.Versions()
+	// 設定で宣言されたルートだけを入れ替える
+	versions[0] = append(slices.DeleteFunc(slices.Clone(versions[0]), func(route httpfx.Route) bool {
+		_, ok := route.(*declared.Route)
+		return ok
+	}), fresh...)
+	h, err := rt.build(versions, limits.NewEnforcer(cfg.Limits))
+	if err != nil {
+		return err
+	}
+	if err := rt.paths.SetRoutes(versions); err != nil {
+		return err
+	}
+	return rt.mux.Swap(ctx, h)
+}
+
+// routeChain lists what NewRouter puts in front of route, outermost
+// first, ending with the route itself.
+func routeChain(route httpfx.Route, usage *deprecation.Tracker, shapes *shape.Translator, std *headers.Standardizer, lim *limits.Enforcer, billing *metering.Recorder, guard *auth.Guard, stats *metrics.Instrumentation) []string {
+	var chain []string
+	if len(httpfx.MethodsOf(route)) > 0 {
+		chain = append(chain, "httpfx.Restricted")
+	}
+	chain = append(chain, fmt.Sprintf("%T", stats))
+	if _, ok := guard.Of(route); ok {
+		chain = append(chain, fmt.Sprintf("%T", guard), fmt.Sprintf("%T", billing))
+	}
+	if lim.Applies(route) {
+		chain = append(chain, fmt.Sprintf("%T", lim))
+	}
+	if _, ok := route.(headers.Custom); ok {
+		chain = append(chain, fmt.Sprintf("%T", std))
+	}
+	if _, ok := route.(shape.Migrating); ok {
+		chain = append(chain, fmt.Sprintf("%T", shapes))
+	}
+	if _, ok := route.(deprecation.Deprecated); ok {
+		chain = append(chain, fmt.Sprintf("%T", usage))
+	}
+	return append(chain, describeRoute(route))
+}
+
+// NewHandler wraps the mux with the middleware that applies to every request,
+// the built-in middleware first, then the Pipeline in its order, then the
+// middleware added with WithMiddleware. Each middleware runs in a span
+// named after it. The chain is recorded in chains for /admin/routes.
+// muxをミドルウェアで包んでサーバーに渡す
+func NewHandler(
+	mux *Router,
+	reload *ReloadHandler,
+	clientIPs *clientip.Resolver,
+	debug *debugmode.Middleware,
+	ids *telemetry.Middleware,
+	access *accesslog.Middleware,
+	limit *ratelimit.Middleware,
+	probes *health.Probes,
+	std *headers.Standardizer,
+	geo *geoip.Resolver,
+	clients *useragent.Classifier,
+	regions *region.Router,
+	hints *earlyhints.Hinter,
+	deadlines *deadline.Middleware,
+	tracer *tracing.Tracer,
+	meter *transfer.Meter,
+	deny *denylist.List,
+	trap *honeypot.Trap,
+	rules *waf.Engine,
+	bot *challenge.Middleware,
+	rewrites *rewrite.Engine,
+	paths *normalize.Normalizer,
+	spec *contract.Validator,
+	pipeline httpfx.Pipeline,
+	extra extraMiddleware,
+	chains *Chains,
+) http.Handler {
+	// 先頭から順に実行される。検査は書き換え前のパスに対して行う。制限を超えたリクエストには検査の手間もかけない
+	chain := []httpfx.Middleware{limit, geo, clients, regions, hints, meter, deny, trap, rules, bot, rewrites, paths}
+	chain = append(chain, pipeline.Sorted()...) // AsMiddlewareで登録されたもの
+	chain = append(chain, extra...)             // WithMiddlewareで追加されたもの
+	outer := []httpfx.Middleware{clientIPs, ids, debug, std, deadlines, tracer, access, probes}
+	var names []string
+	for _, mw := range append(outer, chain...) {
+		names = append(names, httpfx.NameOf(mw))
+	}
+	chains.setGlobal(append(names, httpfx.NameOf(spec)))
+	for i, mw := range chain {
+		chain[i] = traced(mw)
+	}
+	// API定義と照合するのはハンドラのレスポンスだけ
+	// 入れ替えを待つ間にリクエストが残らないよう、リロードはmuxの外で受ける
+	routes := http.NewServeMux()
+	routes.Handle(reload.Pattern(), reload)
+	routes.Handle("/", mux)
+	h := httpfx.Chain(spec.Wrap(routes), chain...)
+	// クライアント、リクエストID、デバッグモードと期限はトレースより先に決める。
+	// 以降のすべてのミドルウェアが同じクライアントを見るよう、クライアントは最初に決める。
+	// ブロックしたリクエストも記録するよう、アクセスログは検査より外、
+	// トレースIDが決まった直後に置く。プローブは検査や制限より先に答える
+	return httpfx.Chain(h, outer...)
+}
+
+// traced runs mw in a span named after it.
+func traced(mw httpfx.Middleware) httpfx.Middleware {
+	name := httpfx.NameOf(mw)
+	return httpfx.MiddlewareFunc(func(next http.Handler) http.Handler {
+		return tracing.Handler(name, mw.Wrap(next))
+	})
+}
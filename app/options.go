@@ -0,0 +1,136 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+
+	"example.com/fxdemo/config"
+	"example.com/fxdemo/health"
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+)
+
+// Option customizes the application built by New.
+type Option func(*options)
+
+type options struct {
+	routes     []any
+	middleware []any
+	modules    []fx.Option
+	configFile string
+	fromEnv    bool
+	overrides  []func(*config.Config)
+}
+
+// WithRoutes adds routes to the API. Each constructor returns an
+// httpfx.Route, and may take anything the application provides.
+func WithRoutes(constructors ...any) Option {
+	return func(o *options) {
+		o.routes = append(o.routes, constructors...)
+	}
+}
+
+// WithMiddleware adds middleware to every request. Each constructor
+// returns an httpfx.Middleware. They run in the order they are added,
+// after the built-in middleware has let the request through and after
+// any middleware provided with httpfx.AsMiddleware.
+func WithMiddleware(constructors ...any) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, constructors...)
+	}
+}
+
+// WithModules adds Fx options, such as fx.Provide or fx.Module, for
+// anything the other options do not cover.
+func WithModules(modules ...fx.Option) Option {
+	return func(o *options) {
+		o.modules = append(o.modules, modules...)
+	}
+}
+
+// WithConfigFile loads the configuration bundle at path instead of the
+// one named by FXDEMO_CONFIG.
+func WithConfigFile(path string) Option {
+	return func(o *options) {
+		o.configFile, o.fromEnv = path, false
+	}
+}
+
+// WithOverrides changes the configuration once it is loaded, after the
+// environment overrides, such as for command-line flags.
+func WithOverrides(fs ...func(*config.Config)) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, fs...)
+	}
+}
+
+// New builds the fxdemo application with the given options.
+// アプリケーションを組み立てる
+func New(opts ...Option) *fx.App {
+	o := options{fromEnv: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	loadConfig := config.Load
+	if !o.fromEnv {
+		path := o.configFile
+		loadConfig = func() (config.Config, error) {
+			return config.LoadFile(path)
+		}
+	}
+	if len(o.overrides) > 0 {
+		load := loadConfig
+		loadConfig = func() (config.Config, error) {
+			cfg, err := load()
+			if err != nil {
+				return cfg, err
+			}
+			for _, f := range o.overrides {
+				f(&cfg)
+			}
+			return cfg, nil
+		}
+	}
+	provides := []any{loadConfig, func() ConfigLoader { return loadConfig }}
+	for _, f := range o.routes {
+		provides = append(provides, httpfx.AsGroup[httpfx.Route, httpfx.Route](f, httpfx.RoutesGroup))
+	}
+	provides = append(provides, orderedMiddleware(o.middleware)...)
+
+	options := append([]fx.Option{module, fx.Provide(provides...)}, o.modules...)
+	// 最後に登録し、他のOnStartが全て終わってから準備完了にする
+	return fx.New(append(options, fx.Invoke(health.Track))...)
+}
+
+// extraMiddleware is the middleware added with WithMiddleware, in order.
+type extraMiddleware []httpfx.Middleware
+
+// orderedMiddleware returns the providers of the extraMiddleware built
+// by the given constructors. Fx value groups do not keep their order, so
+// each middleware is named after its position and collected by a
+// function taking them all as arguments.
+func orderedMiddleware(constructors []any) []any {
+	if len(constructors) == 0 {
+		return []any{func() extraMiddleware { return nil }}
+	}
+	var provides []any
+	tags := make([]string, len(constructors))
+	in := make([]reflect.Type, len(constructors))
+	for i, f := range constructors {
+		tags[i] = fmt.Sprintf(`name:"app.middleware.%d"`, i)
+		in[i] = reflect.TypeOf((*httpfx.Middleware)(nil)).Elem()
+		provides = append(provides, fx.Annotate(f, fx.As(new(httpfx.Middleware)), fx.ResultTags(tags[i])))
+	}
+	collect := reflect.MakeFunc(
+		reflect.FuncOf(in, []reflect.Type{reflect.TypeOf(extraMiddleware(nil))}, false),
+		func(args []reflect.Value) []reflect.Value {
+			mws := make(extraMiddleware, len(args))
+			for i, arg := range args {
+				mws[i] = arg.Interface().(httpfx.Middleware)
+			}
+			return []reflect.Value{reflect.ValueOf(mws)}
+		},
+	)
+	return append(provides, fx.Annotate(collect.Interface(), fx.ParamTags(tags...)))
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/config"
+	"example.com/fxdemo/rewrite"
+	"example.com/fxdemo/telemetry"
+	"example.com/fxdemo/waf"
+	"go.uber.org/zap"
+)
+
+// drainTimeout bounds how long a reload waits for the requests served
+// by the previous mux.
+const drainTimeout = 30 * time.Second
+
+// ConfigLoader loads the configuration as the application was given it:
+// from the same bundle and environment, with the same overrides.
+type ConfigLoader func() (config.Config, error)
+
+// Reloaded is the response to a reload.
+type Reloaded struct {
+	Version int `json:"version"` // Version is the version of the bundle loaded.
+	// Applied lists the changed sections that were put in effect, and
+	// the rules files switched to, as "waf.rules_file".
+	Applied []string `json:"applied"`
+	// RestartRequired lists the changed sections that only take effect
+	// when the server is restarted.
+	RestartRequired []string `json:"restart_required"`
+	// Draining reports that the previous routes were still serving
+	// requests when the wait for them timed out.
+	Draining bool `json:"draining"`
+	// Errors are the errors of the rules files that could not be
+	// loaded, by section. The rules in effect before are kept.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// ReloadHandler loads the configuration again when POSTed to, rebuilds
+// the routes with it and swaps them in once the previous ones are done
+// with their requests. The WAF and rewrite rules files are read again
+// too, from their new paths if they moved; if one cannot be loaded, the
+// reload is answered with 500 and the Errors. The other sections of the
+// configuration are reported when they changed, as they need a restart.
+// It is only accepted from the
+// loopback interface, and is served beside the mux rather than by it,
+// so that it is not one of the requests the swap waits for.
+// 設定を読み直してmuxを作り直す
+type ReloadHandler struct {
+	router   *Router
+	load     ConfigLoader
+	rules    *waf.Engine
+	rewrites *rewrite.Engine
+	log      *zap.Logger
+
+	mu      sync.Mutex    // serializes reloads
+	running config.Config // the configuration in effect
+}
+
+// NewReloadHandler builds a new ReloadHandler.
+func NewReloadHandler(router *Router, cfg config.Config, load ConfigLoader, rules *waf.Engine, rewrites *rewrite.Engine, log *zap.Logger) *ReloadHandler {
+	return &ReloadHandler{router: router, load: load, rules: rules, rewrites: rewrites, log: log, running: cfg}
+}
+
+// Pattern reports the path at which this is registered.
+func (*ReloadHandler) Pattern() string {
+	return "/admin/reload"
+}
+
+func (h *ReloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apperror.Handler(h.log, h.serve).ServeHTTP(w, r)
+}
+
+func (h *ReloadHandler) serve(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		return apperror.New(http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed here", r.Method)
+	}
+	if !clientip.IsLoopback(r) {
+		return apperror.Forbidden("Reloads are only accepted from the host")
+	}
+	log := telemetry.Logger(r.Context(), h.log)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cfg, err := h.load()
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("load configuration: %w", err))
+	}
+	res := Reloaded{Version: cfg.Version, Applied: []string{}, RestartRequired: []string{}}
+	// 規則ファイルの場所の変更は下で読み込んで反映する
+	running := h.running
+	running.WAF.RulesFile, running.Rewrite.RulesFile = cfg.WAF.RulesFile, cfg.Rewrite.RulesFile
+	for _, section := range config.Changed(running, cfg) {
+		if slices.Contains(Reloadable, section) {
+			res.Applied = append(res.Applied, section)
+		} else {
+			res.RestartRequired = append(res.RestartRequired, section)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), drainTimeout)
+	defer cancel()
+	start := time.Now()
+	switch err := h.router.Apply(ctx, cfg); {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Warn("Swapped the mux, but the previous one is still serving requests", zap.Error(err))
+		res.Draining = true
+	case err != nil:
+		return apperror.Internal(fmt.Errorf("rebuild the routes: %w", err)) // 以前のルートのまま
+	}
+	h.running.Declared, h.running.Limits = cfg.Declared, cfg.Limits
+	// 設定の読み直しに合わせてルールファイルも読み直す
+	for _, rules := range []struct {
+		section string
+		file    string
+		running *string
+		load    func(string) error
+	}{
+		{"waf", cfg.WAF.RulesFile, &h.running.WAF.RulesFile, h.rules.Load},
+		{"rewrite", cfg.Rewrite.RulesFile, &h.running.Rewrite.RulesFile, h.rewrites.Load},
+	} {
+		if err := rules.load(rules.file); err != nil {
+			log.Error("Failed to reload the rules", zap.String("section", rules.section), zap.Error(err))
+			if res.Errors == nil {
+				res.Errors = make(map[string]string)
+			}
+			res.Errors[rules.section] = err.Error()
+			continue
+		}
+		if rules.file != *rules.running {
+			res.Applied = append(res.Applied, rules.section+".rules_file")
+			*rules.running = rules.file
+		}
+	}
+
+	log.Info("Reloaded the configuration",
+		zap.Int("version", cfg.Version),
+		zap.Strings("applied", res.Applied),
+		zap.Strings("restart_required", res.RestartRequired),
+		zap.Duration("drained_in", time.Since(start)),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case len(res.Errors) > 0:
+		w.WriteHeader(http.StatusInternalServerError)
+	case res.Draining:
+		w.WriteHeader(http.StatusAccepted)
+	}
+	return json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"example.com/fxdemo/config"
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+)
+
+// TestReloadRulesFiles reloads a bundle that moves the WAF rules file,
+// then one that names a malformed file.
+func TestReloadRulesFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("old.json", `[{"name": "old", "target": "path", "pattern": "^/old$", "action": "block"}]`)
+	write("new.json", `[{"name": "new", "target": "path", "pattern": "^/new$", "action": "block"}]`)
+	write("bad.json", `[{"name": "bad", "target": "path", "pattern": "(", "action": "block"}]`)
+	bundle := filepath.Join(dir, "bundle.yaml")
+	write("bundle.yaml", "waf:\n  rules_file: old.json\n")
+
+	var h *ReloadHandler
+	a := New(
+		WithConfigFile(bundle),
+		WithModules(fx.Invoke(func(r *ReloadHandler) { h = r })),
+		WithOverrides(func(cfg *config.Config) { cfg.Log.Level, cfg.Log.FxLevel = "error", "error" }),
+	)
+	if err := a.Err(); err != nil {
+		t.Fatal(httpfx.Explain(err))
+	}
+	reload := func() (int, Reloaded) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+		r.RemoteAddr = "127.0.0.1:40000"
+		h.ServeHTTP(rec, r)
+		var res Reloaded
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%d %s: %v", rec.Code, rec.Body, err)
+		}
+		return rec.Code, res
+	}
+
+	write("bundle.yaml", "waf:\n  rules_file: new.json\n")
+	status, res := reload()
+	if status != http.StatusOK || !slices.Contains(res.Applied, "waf.rules_file") || len(res.RestartRequired) != 0 {
+		t.Errorf("moving the rules file: %d %+v, want 200 with waf.rules_file applied", status, res)
+	}
+	if got, want := h.rules.RulesFile(), filepath.Join(dir, "new.json"); got != want {
+		t.Errorf("WAF rules from %s, want %s", got, want)
+	}
+
+	write("bundle.yaml", "waf:\n  rules_file: bad.json\n")
+	status, res = reload()
+	if status != http.StatusInternalServerError || res.Errors["waf"] == "" || slices.Contains(res.Applied, "waf.rules_file") {
+		t.Errorf("a malformed rules file: %d %+v, want 500 with the error of waf", status, res)
+	}
+	if got, want := h.rules.RulesFile(), filepath.Join(dir, "new.json"); got != want {
+		t.Errorf("after a malformed file: WAF rules from %s, want %s kept", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+// Package apperror gives handlers one way to fail: they return an
+// *Error, or any error, and the client gets a JSON body
+//
+//	{"code": "not_found", "message": "No such greeting", "request_id": "..."}
+//
+// with the matching status. Errors that are not an *Error are internal:
+// they are logged, and the client only learns that something went wrong.
+package apperror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+// ContentType is the media type of error bodies.
+const ContentType = "application/json"
+
+// Codes of the errors built by this package.
+const (
+	CodeBadRequest   = "bad_request"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeNotFound     = "not_found"
+	CodeTooLarge     = "request_too_large"
+	CodeInternal     = "internal"
+)
+
+// Error is an error to answer a request with.
+type Error struct {
+	Status  int    // the HTTP status
+	Code    string // a stable, machine-readable code, such as "not_found"
+	Message string // for people; sent to the client
+	Err     error  // the cause, if any; logged, never sent
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Message + ": " + e.Err.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Unwrap returns the cause of e.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns an Error with the given status and code.
+func New(status int, code, format string, args ...any) *Error {
+	return &Error{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// BadRequest reports a request the client must change before retrying.
+func BadRequest(format string, args ...any) *Error {
+	return New(http.StatusBadRequest, CodeBadRequest, format, args...)
+}
+
+// Unauthorized reports a request without valid credentials.
+func Unauthorized(format string, args ...any) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, format, args...)
+}
+
+// Forbidden reports a request whose client is not allowed to make it.
+func Forbidden(format string, args ...any) *Error {
+	return New(http.StatusForbidden, CodeForbidden, format, args...)
+}
+
+// NotFound reports that what the request names does not exist.
+func NotFound(format string, args ...any) *Error {
+	return New(http.StatusNotFound, CodeNotFound, format, args...)
+}
+
+// TooLarge reports a request body larger than the limit of limit bytes.
+func TooLarge(limit int64) *Error {
+	return New(http.StatusRequestEntityTooLarge, CodeTooLarge, "The request body is larger than %d bytes", limit)
+}
+
+// Internal reports a failure of the server, caused by err. Its message
+// says nothing about err.
+func Internal(err error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Internal server error", Err: err}
+}
+
+// body is what clients receive.
+type body struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Write answers r with err, as an Internal error unless it is, or
+// wraps, an *Error. A body read past the limit of http.MaxBytesReader
+// is answered with TooLarge, however the handler reported it. Server
+// errors are logged through log with the request's telemetry, if log is
+// not nil; client errors are not logged.
+// エラーをJSONで返す
+func Write(w http.ResponseWriter, r *http.Request, log *zap.Logger, err error) {
+	var e *Error
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		e = TooLarge(tooLarge.Limit)
+	case !errors.As(err, &e):
+		e = Internal(err)
+	}
+	if e.Status >= 500 && log != nil {
+		telemetry.Logger(r.Context(), log).Error("Failed to handle request", zap.String("code", e.Code), zap.Error(err))
+	}
+	h := w.Header()
+	h.Del("Content-Length") // 途中まで設定されたヘッダーを引き継がない
+	h.Set("Content-Type", ContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(body{Code: e.Code, Message: e.Message, RequestID: telemetry.From(r.Context()).RequestID})
+}
+
+// HandlerFunc is a handler that may fail. It must not have written the
+// response when it returns an error.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// Handler returns an http.Handler that answers with the error f returns,
+// if any, as Write does.
+func Handler(log *zap.Logger, f HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := f(w, r); err != nil {
+			Write(w, r, log, err)
+		}
+	})
+}
+
+// NotFoundHandler answers every request with a NotFound error. It can
+// be provided as the httpfx.NotFoundHandler.
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, nil, NotFound("No route matches %s", r.URL.Path))
+	})
+}
+
+// MethodNotAllowedHandler answers every request with a 405 error,
+// keeping the Allow header the route set. It can be provided as the
+// httpfx.MethodNotAllowedHandler.
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, nil, New(http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed here", r.Method))
+	})
+}
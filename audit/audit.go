@@ -0,0 +1,64 @@
+// Package audit records security relevant events separately
+// from the regular application log.
+package audit
+
+import (
+	"context"
+	"time"
+
+	"example.com/fxdemo/events"
+	"example.com/fxdemo/ja3"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+// Event is a single entry in the audit log.
+type Event struct {
+	Time   time.Time
+	Kind   string // Kind classifies the event, e.g. "honeypot.hit".
+	Client string // Client is the IP address the event originated from.
+	Detail map[string]string
+}
+
+// Logger writes events to the audit log.
+// 監査ログを書き出す
+type Logger struct {
+	log *zap.Logger
+	bus *events.Bus
+}
+
+// NewLogger builds a Logger that writes through a child of the given
+// logger, and publishes each event on bus as well.
+func NewLogger(log *zap.Logger, bus *events.Bus) *Logger {
+	return &Logger{log: log.Named("audit"), bus: bus}
+}
+
+// Record writes the event to the audit log, along with the request's
+// telemetry identifiers and the TLS fingerprint of the client's
+// connection when there is one.
+func (l *Logger) Record(ctx context.Context, e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	fields := make([]zap.Field, 0, 8+len(e.Detail))
+	fields = append(fields,
+		zap.Time("time", e.Time),
+		zap.String("kind", e.Kind),
+		zap.String("client", e.Client),
+	)
+	for k, v := range e.Detail {
+		fields = append(fields, zap.String(k, v))
+	}
+	fields = append(fields, telemetry.From(ctx).Fields()...)
+	if fp := ja3.FromContext(ctx); fp.Hash != "" {
+		fields = append(fields, zap.String("ja3", fp.Hash))
+	}
+	l.log.Warn("Audit event", fields...)
+
+	data := make(map[string]string, len(e.Detail)+1)
+	for k, v := range e.Detail {
+		data[k] = v
+	}
+	data["client"] = e.Client
+	l.bus.Publish(ctx, events.Event{Kind: e.Kind, Time: e.Time, Data: data})
+}
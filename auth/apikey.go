@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// APIKey is a key accepted by APIKeys, and the identity it stands for.
+type APIKey struct {
+	Key     string   `yaml:"key"`
+	Subject string   `yaml:"subject"`
+	Scopes  []string `yaml:"scopes"`
+}
+
+// errUnknownKey is returned for API keys that are not configured.
+var errUnknownKey = errors.New("auth: unknown API key")
+
+// APIKeys authenticates requests by a static key in a header.
+// APIキーによる認証
+type APIKeys struct {
+	header string
+	keys   []hashedKey
+}
+
+// hashedKey is an APIKey with its key hashed, so that all compare in
+// the same time whatever their length.
+type hashedKey struct {
+	sum [sha256.Size]byte
+	key APIKey
+}
+
+// NewAPIKeys builds APIKeys reading keys from header. Keys must be set
+// and have a subject.
+func NewAPIKeys(header string, keys []APIKey) (*APIKeys, error) {
+	if header == "" {
+		return nil, errors.New("auth: API keys need a header")
+	}
+	a := &APIKeys{header: header}
+	for i, k := range keys {
+		if k.Key == "" || k.Subject == "" {
+			return nil, fmt.Errorf("auth: API key %d needs a key and a subject", i)
+		}
+		a.keys = append(a.keys, hashedKey{sum: sha256.Sum256([]byte(k.Key)), key: k})
+	}
+	return a, nil
+}
+
+// Authenticate returns the identity of the key r carries. Every key is
+// compared, so that the time taken does not tell which one came close.
+func (a *APIKeys) Authenticate(r *http.Request) (Identity, error) {
+	v := r.Header.Get(a.header)
+	if v == "" {
+		return Identity{}, ErrNoCredentials
+	}
+	sum := sha256.Sum256([]byte(v))
+	var found *APIKey
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], a.keys[i].sum[:]) == 1 {
+			found = &a.keys[i].key
+		}
+	}
+	if found == nil {
+		return Identity{}, errUnknownKey
+	}
+	return Identity{Subject: found.Subject, Method: "api_key", Scopes: found.Scopes}, nil
+}
@@ -0,0 +1,147 @@
+// Package auth authenticates the clients of the routes that require it,
+// with static API keys, JWTs signed by keys published as a JWKS, or
+// both, and hands the Identity it establishes to the route.
+//
+// Routes require authentication by implementing AuthenticatedRoute, or
+// by being listed in the Config by pattern:
+//
+//	auth:
+//	  api_keys:
+//	    - {key: "...", subject: reporting, scopes: [greetings:read]}
+//	  jwt:
+//	    jwks_url: https://id.example.com/.well-known/jwks.json
+//	    issuer: https://id.example.com/
+//	    audience: fxdemo
+//	  routes:
+//	    /api/v1/greetings/: [greetings:read]
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Config sets up the authenticators and the routes requiring them.
+type Config struct {
+	// APIKeyHeader is the request header carrying API keys.
+	APIKeyHeader string `yaml:"api_key_header"`
+	// APIKeys are the keys accepted; none means API keys are not.
+	APIKeys []APIKey `yaml:"api_keys"`
+	// JWT sets up bearer tokens; they are not accepted without a
+	// JWKSURL.
+	JWT JWTConfig `yaml:"jwt"`
+	// Routes are the patterns of the routes that require authentication
+	// besides the AuthenticatedRoute ones, with the scopes their clients
+	// need. They take precedence over the scopes the routes declare.
+	Routes map[string][]string `yaml:"routes"`
+}
+
+// DefaultConfig reads API keys from X-API-Key, and refreshes the JWKS
+// every hour. It accepts no credentials until some are configured.
+func DefaultConfig() Config {
+	return Config{
+		APIKeyHeader: "X-API-Key",
+		JWT:          JWTConfig{RefreshInterval: time.Hour, Leeway: time.Minute},
+	}
+}
+
+// Identity is who a request was authenticated as.
+type Identity struct {
+	Subject string   // the API key's subject, or the token's "sub"
+	Method  string   // "api_key" or "jwt"
+	Scopes  []string // what the client is allowed to do
+	// Claims are those of the token, for JWTs.
+	Claims map[string]any
+}
+
+// HasScope reports whether the identity was granted scope.
+func (id Identity) HasScope(scope string) bool {
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// FromContext returns the Identity the request was authenticated as,
+// and whether it was.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// WithIdentity returns a copy of ctx carrying id.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// ErrNoCredentials is returned by authenticators for requests without
+// credentials of their kind.
+var ErrNoCredentials = errors.New("auth: no credentials")
+
+// Authenticator establishes who sent a request.
+type Authenticator interface {
+	// Authenticate returns the identity of the client of r. It returns
+	// ErrNoCredentials if r carries no credentials it understands, and
+	// another error if those it carries are not valid.
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// Chain tries each of its authenticators in turn, until one finds
+// credentials it understands.
+type Chain []Authenticator
+
+// Authenticate returns the result of the first authenticator that does
+// not return ErrNoCredentials, or ErrNoCredentials if they all do.
+func (c Chain) Authenticate(r *http.Request) (Identity, error) {
+	for _, a := range c {
+		id, err := a.Authenticate(r)
+		if !errors.Is(err, ErrNoCredentials) {
+			return id, err
+		}
+	}
+	return Identity{}, ErrNoCredentials
+}
+
+// NewAuthenticator builds the authenticators that cfg sets up, as a
+// Chain trying API keys first. The JWKS is fetched when the Fx
+// application starts and refreshed for as long as it runs.
+// 設定された認証方式をまとめる
+func NewAuthenticator(lc fx.Lifecycle, cfg Config, log *zap.Logger) (Authenticator, error) {
+	var chain Chain
+	if len(cfg.APIKeys) > 0 {
+		keys, err := NewAPIKeys(cfg.APIKeyHeader, cfg.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+	if cfg.JWT.JWKSURL != "" {
+		jwks := NewJWKS(cfg.JWT.JWKSURL, log)
+		done := make(chan struct{})
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				// 鍵を取れなくても起動はする。次の更新で取り直す
+				if err := jwks.Refresh(ctx); err != nil {
+					log.Warn("Failed to fetch the JWKS, bearer tokens are refused until it is", zap.String("url", cfg.JWT.JWKSURL), zap.Error(err))
+				}
+				go jwks.watch(cfg.JWT.RefreshInterval, done)
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				close(done)
+				return nil
+			},
+		})
+		chain = append(chain, &JWT{cfg: cfg.JWT, keys: jwks})
+	}
+	return chain, nil
+}
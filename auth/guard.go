@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+// AuthenticatedRoute is implemented by routes that require their
+// clients to be authenticated.
+type AuthenticatedRoute interface {
+	// RequiredScopes returns the scopes the client needs, all of them;
+	// none means any authenticated client is served.
+	RequiredScopes() []string
+}
+
+// Guard refuses the requests of the routes requiring authentication
+// whose clients are not authenticated, or lack a scope.
+// 認証が必要なルートを守る
+type Guard struct {
+	cfg   Config
+	authn Authenticator
+	log   *zap.Logger
+}
+
+// NewGuard builds a Guard authenticating clients with authn.
+func NewGuard(cfg Config, authn Authenticator, log *zap.Logger) *Guard {
+	return &Guard{cfg: cfg, authn: authn, log: log}
+}
+
+// Of returns the scopes route requires, those of the Config for its
+// pattern or else those it declares, and whether it requires
+// authentication at all.
+func (g *Guard) Of(route httpfx.Route) ([]string, bool) {
+	if scopes, ok := g.cfg.Routes[route.Pattern()]; ok {
+		return scopes, true
+	}
+	if r, ok := route.(AuthenticatedRoute); ok {
+		return r.RequiredScopes(), true
+	}
+	return nil, false
+}
+
+// CheckRoutes returns an error naming the patterns of the Config that
+// are none of patterns, those of the routes registered, so that a typo
+// fails the start rather than leaving a route unguarded.
+func (g *Guard) CheckRoutes(patterns []string) error {
+	var unknown []string
+	for pattern := range g.cfg.Routes {
+		if !slices.Contains(patterns, pattern) {
+			unknown = append(unknown, pattern)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("auth: no route is registered at %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// Route returns next guarded as route requires, or next itself if it
+// does not. Requests without valid credentials are refused with 401
+// Unauthorized, and those whose client lacks a scope with 403
+// Forbidden. The others reach next with their Identity in their
+// context; see FromContext.
+func (g *Guard) Route(route httpfx.Route, next http.Handler) http.Handler {
+	scopes, ok := g.Of(route)
+	if !ok {
+		return next
+	}
+	challenge := g.challenge()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := g.authn.Authenticate(r)
+		switch {
+		case errors.Is(err, ErrNoCredentials):
+			w.Header().Set("WWW-Authenticate", challenge)
+			apperror.Write(w, r, nil, apperror.Unauthorized("Credentials are required"))
+			return
+		case err != nil:
+			// 理由はクライアントに伝えない
+			telemetry.Logger(r.Context(), g.log).Info("Refused invalid credentials", zap.Error(err))
+			w.Header().Set("WWW-Authenticate", challenge+`, error="invalid_token"`)
+			apperror.Write(w, r, nil, apperror.Unauthorized("The credentials are not valid"))
+			return
+		}
+		for _, scope := range scopes {
+			if !id.HasScope(scope) {
+				apperror.Write(w, r, nil, apperror.Forbidden("The %q scope is required", scope))
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// challenge returns the WWW-Authenticate header of refusals, naming the
+// schemes accepted.
+func (g *Guard) challenge() string {
+	var schemes []string
+	if len(g.cfg.APIKeys) > 0 {
+		schemes = append(schemes, `APIKey header="`+g.cfg.APIKeyHeader+`"`)
+	}
+	if g.cfg.JWT.JWKSURL != "" || len(schemes) == 0 {
+		schemes = append(schemes, `Bearer realm="fxdemo"`)
+	}
+	return strings.Join(schemes, ", ")
+}
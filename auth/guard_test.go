@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/zap"
+)
+
+type testRoute struct {
+	pattern string
+	scopes  []string
+}
+
+func (r testRoute) Pattern() string                            { return r.pattern }
+func (testRoute) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+type authenticatedRoute struct{ testRoute }
+
+func (r authenticatedRoute) RequiredScopes() []string { return r.scopes }
+
+func TestGuardCheckRoutes(t *testing.T) {
+	registered := []string{"/api/v1/greetings/", "/api/v1/usage"}
+	tests := []struct {
+		name    string
+		routes  map[string][]string
+		unknown []string
+	}{
+		{"none", nil, nil},
+		{"registered", map[string][]string{"/api/v1/greetings/": {"greetings:read"}}, nil},
+		{"typo", map[string][]string{
+			"/api/v1/greetings/": nil,
+			"/api/v1/greeting/":  {"greetings:read"},
+			"/api/v2/usage":      nil,
+		}, []string{"/api/v1/greeting/", "/api/v2/usage"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGuard(Config{Routes: tt.routes}, Chain{}, zap.NewNop())
+			err := g.CheckRoutes(registered)
+			if len(tt.unknown) == 0 {
+				if err != nil {
+					t.Fatalf("CheckRoutes() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("CheckRoutes() = nil, want an error")
+			}
+			for _, p := range tt.unknown {
+				if !strings.Contains(err.Error(), p) {
+					t.Errorf("CheckRoutes() = %v, want it to name %s", err, p)
+				}
+			}
+		})
+	}
+}
+
+func TestGuardOf(t *testing.T) {
+	g := NewGuard(Config{Routes: map[string][]string{"/configured": {"admin"}, "/overridden": {"config"}}}, Chain{}, zap.NewNop())
+	tests := []struct {
+		route  httpfx.Route
+		scopes []string
+		ok     bool
+	}{
+		{testRoute{pattern: "/open"}, nil, false},
+		{testRoute{pattern: "/configured"}, []string{"admin"}, true},
+		{authenticatedRoute{testRoute{pattern: "/declared", scopes: []string{"read"}}}, []string{"read"}, true},
+		{authenticatedRoute{testRoute{pattern: "/overridden", scopes: []string{"route"}}}, []string{"config"}, true},
+	}
+	for _, tt := range tests {
+		scopes, ok := g.Of(tt.route)
+		if ok != tt.ok || strings.Join(scopes, ",") != strings.Join(tt.scopes, ",") {
+			t.Errorf("Of(%s) = %v, %v, want %v, %v", tt.route.Pattern(), scopes, ok, tt.scopes, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 for RS256 and ES256
+	_ "crypto/sha512" // SHA-384 and SHA-512 for the others
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// JWTConfig sets up the verification of bearer tokens.
+type JWTConfig struct {
+	// JWKSURL is where the keys the tokens are signed with are
+	// published, as a JSON Web Key Set.
+	JWKSURL string `yaml:"jwks_url"`
+	// Issuer and Audience, if set, must be the token's "iss" and one of
+	// its "aud".
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// RefreshInterval is how often the JWKS is fetched again. Tokens
+	// signed with a key it does not have yet make it be fetched sooner.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// Leeway is how far the clocks of the issuer and of the server may
+	// disagree when checking "exp" and "nbf".
+	Leeway time.Duration `yaml:"leeway"`
+}
+
+// minRefresh bounds how often tokens with an unknown key make the JWKS
+// be fetched again.
+const minRefresh = time.Minute
+
+// JWKS holds the keys of a JSON Web Key Set, by key ID.
+// JWKSの公開鍵
+type JWKS struct {
+	url    string
+	client *http.Client
+	log    *zap.Logger
+	keys   atomic.Pointer[map[string]crypto.PublicKey]
+
+	mu      sync.Mutex // serializes fetches
+	fetched time.Time
+}
+
+// NewJWKS builds a JWKS fetched from url. It has no keys until Refresh
+// is called.
+func NewJWKS(url string, log *zap.Logger) *JWKS {
+	j := &JWKS{url: url, client: &http.Client{Timeout: 10 * time.Second}, log: log}
+	j.keys.Store(&map[string]crypto.PublicKey{})
+	return j
+}
+
+// Refresh fetches the key set again. The previous keys stay in use if
+// it cannot be fetched. Keys of types other than RSA and EC are skipped.
+func (j *JWKS) Refresh(ctx context.Context) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.refresh(ctx)
+}
+
+func (j *JWKS) refresh(ctx context.Context) error {
+	j.fetched = time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: fetching %s: %s", j.url, resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("auth: decoding %s: %w", j.url, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			j.log.Warn("Skipping a key of the JWKS", zap.String("kid", k.Kid), zap.Error(err))
+			continue
+		}
+		if pub != nil {
+			keys[k.Kid] = pub
+		}
+	}
+	j.keys.Store(&keys)
+	j.log.Info("Fetched the JWKS", zap.String("url", j.url), zap.Int("keys", len(keys)))
+	return nil
+}
+
+// key returns the key kid, fetching the key set again if it does not
+// have it and has not just been fetched.
+func (j *JWKS) key(ctx context.Context, kid string) (crypto.PublicKey, bool) {
+	if k, ok := (*j.keys.Load())[kid]; ok {
+		return k, true
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if k, ok := (*j.keys.Load())[kid]; ok { // 待っている間に取得された
+		return k, true
+	}
+	if time.Since(j.fetched) < minRefresh {
+		return nil, false
+	}
+	if err := j.refresh(ctx); err != nil {
+		j.log.Warn("Failed to refresh the JWKS", zap.String("url", j.url), zap.Error(err))
+		return nil, false
+	}
+	k, ok := (*j.keys.Load())[kid]
+	return k, ok
+}
+
+// watch refreshes the key set every interval until done is closed.
+func (j *JWKS) watch(interval time.Duration, done <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := j.Refresh(context.Background()); err != nil {
+				j.log.Error("Failed to refresh the JWKS, keeping the previous keys", zap.String("url", j.url), zap.Error(err))
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// jwk is a JSON Web Key (RFC 7517), as far as RSA and EC keys go.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the key k describes, or nil if it is of another
+// type.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := bigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := bigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := bigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := bigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on its curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func bigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// algorithms are the signature algorithms accepted, by "alg". Neither
+// "none" nor the HMAC ones are, as the keys are public.
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// curves are the curves of the ECDSA algorithms (RFC 7518, 3.4).
+var curves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// errInvalidToken is returned for bearer tokens that are not valid,
+// whatever the reason, which is only logged.
+var errInvalidToken = errors.New("auth: invalid token")
+
+// JWT authenticates requests by a bearer token signed with a key of a
+// JWKS.
+// JWTによる認証
+type JWT struct {
+	cfg  JWTConfig
+	keys *JWKS
+}
+
+// Authenticate verifies the bearer token of r and returns the identity
+// of its subject, with the scopes of its "scope" or "scp" claim.
+func (a *JWT) Authenticate(r *http.Request) (Identity, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Identity{}, ErrNoCredentials
+	}
+	claims, err := a.verify(r.Context(), strings.TrimSpace(token))
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", errInvalidToken, err)
+	}
+	sub, _ := claims["sub"].(string)
+	return Identity{Subject: sub, Method: "jwt", Scopes: scopesOf(claims), Claims: claims}, nil
+}
+
+// verify checks the signature and the registered claims of token, and
+// returns its claims.
+func (a *JWT) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("not a signed JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	hash, ok := algorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	key, ok := a.keys.key(ctx, header.Kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", header.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key, hash, h.Sum(nil), sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	t := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("no expiry")
+	}
+	if t.After(time.Unix(int64(exp), 0).Add(a.cfg.Leeway)) {
+		return nil, errors.New("expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && t.Add(a.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("not valid yet")
+	}
+	if a.cfg.Issuer != "" && claims["iss"] != a.cfg.Issuer {
+		return nil, fmt.Errorf("issued by %v", claims["iss"])
+	}
+	if a.cfg.Audience != "" && !hasAudience(claims["aud"], a.cfg.Audience) {
+		return nil, fmt.Errorf("meant for %v", claims["aud"])
+	}
+	return claims, nil
+}
+
+// verifySignature checks sig, the signature of digest by key with alg.
+func verifySignature(alg string, key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, sig); err != nil {
+			return errors.New("bad signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if curves[alg] != k.Curve || len(sig) != 2*size {
+			break
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("key does not match algorithm %q", alg)
+}
+
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// hasAudience reports whether aud, a string or an array of them,
+// includes want.
+func hasAudience(aud any, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []any:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scopesOf returns the scopes of claims: those of a space-separated
+// "scope" (RFC 8693), or of an array "scp".
+func scopesOf(claims map[string]any) []string {
+	if s, ok := claims["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+	var scopes []string
+	if scp, ok := claims["scp"].([]any); ok {
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testKeys are the private keys of the test JWKS, by key ID.
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	p256 *ecdsa.PrivateKey
+	p384 *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	r, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: r, p256: p256, p384: p384}
+}
+
+// newTestJWT returns a JWT authenticator trusting the public keys of
+// keys as "rsa", "p256" and "p384". The JWKS counts as just fetched, so
+// that unknown keys are not looked for.
+func newTestJWT(keys testKeys) *JWT {
+	jwks := NewJWKS("http://127.0.0.1:0/jwks", zap.NewNop())
+	jwks.keys.Store(&map[string]crypto.PublicKey{
+		"rsa":  &keys.rsa.PublicKey,
+		"p256": &keys.p256.PublicKey,
+		"p384": &keys.p384.PublicKey,
+	})
+	jwks.fetched = time.Now()
+	cfg := JWTConfig{Issuer: "https://id.example.com/", Audience: "fxdemo", Leeway: time.Minute}
+	return &JWT{cfg: cfg, keys: jwks}
+}
+
+// sign returns a token of claims with the given header, signed with key
+// using hash. ECDSA signatures are sized for the key's curve.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, hash crypto.Hash, claims map[string]any) string {
+	t.Helper()
+	segment := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	input := segment(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + segment(claims)
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func claims(mod func(map[string]any)) map[string]any {
+	now := time.Now()
+	c := map[string]any{
+		"sub":   "alice",
+		"iss":   "https://id.example.com/",
+		"aud":   []string{"other", "fxdemo"},
+		"exp":   now.Add(time.Hour).Unix(),
+		"nbf":   now.Add(-time.Minute).Unix(),
+		"scope": "greetings:read greetings:write",
+	}
+	if mod != nil {
+		mod(c)
+	}
+	return c
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	keys := newTestKeys(t)
+	a := newTestJWT(keys)
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", sign(t, "RS256", "rsa", keys.rsa, crypto.SHA256, claims(nil)), true},
+		{"RS512", sign(t, "RS512", "rsa", keys.rsa, crypto.SHA512, claims(nil)), true},
+		{"ES256", sign(t, "ES256", "p256", keys.p256, crypto.SHA256, claims(nil)), true},
+		{"ES384", sign(t, "ES384", "p384", keys.p384, crypto.SHA384, claims(nil)), true},
+		{"expired within the leeway", sign(t, "RS256", "rsa", keys.rsa, crypto.SHA256, claims(func(c map[string]any) {
+			c["exp"] = now.Add(-30 * time.Second).Unix()
+		})), true},
+
+		// signature
+		{"bad signature", func() string {
+			other := sign(t, "RS256", "rsa", keys.rsa, crypto.SHA256, claims(func(c map[string]any) { c["sub"] = "mallory" }))
+			valid := sign(t, "RS256", "rsa", keys.rsa, crypto.SHA256, claims(nil))
+			return valid[:strings.LastIndexByte(valid, '.')] + other[strings.LastIndexByte(other, '.'):]
+		}(), false},
+		{"signed by another key", func() string {
+			stray, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sign(t, "RS256", "rsa", stray, crypto.SHA256, claims(nil))
+		}(), false},
+		{"malformed signature", sign(t, "RS256", "rsa", keys.rsa, crypto.SHA256, claims(nil)) + "!", false},
+		{"not a JWT", "abc.def", false},
+
+		// algorithm
+		{"alg none", sign(t, "none", "rsa", keys.rsa, crypto.SHA256, claims(nil)), false},
+		{"alg HS256", sign(t, "HS256", "rsa", keys.rsa, crypto.SHA256, claims(nil)), false},
+		{"RS256 with an EC key", sign(t, "RS256", "p256", keys.p256, crypto.SHA256, claims(nil)), false},
+		{"ES256 with an RSA key", sign(t, "ES256", "rsa", keys.rsa, crypto.SHA256, claims(nil)), false},
+
+		// ES algorithm and curve
+		{"ES256 on P-384", sign(t, "ES256", "p384", keys.p384, crypto.SHA256, claims(nil)), false},
+		{"ES384 on P-256", sign(t, "ES384", "p256", keys.p256, crypto.SHA384, claims(nil)), false},
+
+		// time
+		{"expired", sign(t, "RS256", "rsa", keys.rsa, crypto.SHA256, claims(func(c map[string]any) {
+			c["exp"] = now.Add(-2 * time.Minute).Unix()
+		})), false},
+		{"no expiry", sign(t, "RS256", "rsa", keys.rsa, crypto.SHA256, claims(func(c map[string]any) {
+			delete(c, "exp")
+		})), false},
+		{"not valid yet", sign(t, "RS256", "rsa", keys.rsa, crypto.SHA256, claims(func(c map[string]any) {
+			c["nbf"] = now.Add(2 * time.Minute).Unix()
+		})), false},
+
+		// key
+		{"unknown kid", sign(t, "RS256", "gone", keys.rsa, crypto.SHA256, claims(nil)), false},
+		{"no kid", sign(t, "RS256", "", keys.rsa, crypto.SHA256, claims(nil)), false},
+
+		// registered claims
+		{"other issuer", sign(t, "RS256", "rsa", keys.rsa, crypto.SHA256, claims(func(c map[string]any) {
+			c["iss"] = "https://evil.example.com/"
+		})), false},
+		{"other audience", sign(t, "RS256", "rsa", keys.rsa, crypto.SHA256, claims(func(c map[string]any) {
+			c["aud"] = "other"
+		})), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			id, err := a.Authenticate(r)
+			if !tt.ok {
+				if !errors.Is(err, errInvalidToken) {
+					t.Fatalf("Authenticate() = %+v, %v, want errInvalidToken", id, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if id.Subject != "alice" || id.Method != "jwt" || !id.HasScope("greetings:write") {
+				t.Errorf("Authenticate() = %+v", id)
+			}
+		})
+	}
+}
+
+func TestJWTNoCredentials(t *testing.T) {
+	a := newTestJWT(newTestKeys(t))
+	for _, header := range []string{"", "Basic dXNlcjpwYXNz", "Bearer"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if _, err := a.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("Authorization %q: error = %v, want ErrNoCredentials", header, err)
+		}
+	}
+}
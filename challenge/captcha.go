@@ -0,0 +1,60 @@
+package challenge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/clientip"
+)
+
+// Captcha is a Verifier that delegates to a captcha provider. The client
+// sends the token obtained from the provider in X-Captcha-Token and it is
+// checked against the provider's verification callback, using the
+// reCAPTCHA/hCaptcha "siteverify" request and response shape.
+type Captcha struct {
+	URL     string
+	Secret  string
+	SiteKey string
+	Client  *http.Client
+}
+
+// Challenge tells the client that a captcha must be solved.
+func (c *Captcha) Challenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Challenge", "captcha")
+	w.Header().Set("X-Challenge-Site-Key", c.SiteKey)
+	apperror.Write(w, r, nil, apperror.New(http.StatusForbidden, "captcha_required", "Captcha required"))
+}
+
+// Verify asks the provider whether the client's token is valid.
+func (c *Captcha) Verify(r *http.Request) (bool, error) {
+	token := r.Header.Get("X-Captcha-Token")
+	if token == "" {
+		return false, nil
+	}
+	form := url.Values{
+		"secret":   {c.Secret},
+		"response": {token},
+		"remoteip": {clientip.FromRequest(r)},
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, c.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Success, nil
+}
@@ -0,0 +1,134 @@
+// Package challenge implements optional bot mitigation. Clients that send
+// more requests to a protected route than a threshold allows must solve
+// a challenge (proof of work or a captcha) before being let through.
+package challenge
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config controls which routes are protected and how hard the
+// challenge is to solve.
+type Config struct {
+	Enabled   bool          `yaml:"enabled"`
+	Paths     []string      `yaml:"paths"`     // Paths are route prefixes the challenge applies to.
+	Threshold int           `yaml:"threshold"` // Threshold is the number of requests allowed per Window.
+	Window    time.Duration `yaml:"window"`    // Window is the period requests are counted over.
+	PassTTL   time.Duration `yaml:"pass_ttl"`  // PassTTL is how long a solved challenge is honoured.
+	Secret    string        `yaml:"secret"`    // Secret signs nonces and passes; random if empty.
+
+	Mode       string `yaml:"mode"`       // Mode is "pow" (default) or "captcha".
+	Difficulty int    `yaml:"difficulty"` // Difficulty is the number of leading zero bits a proof of work needs.
+
+	CaptchaURL    string `yaml:"captcha_url"` // CaptchaURL is the verification callback for captcha mode.
+	CaptchaSecret string `yaml:"captcha_secret"`
+	CaptchaSite   string `yaml:"captcha_site"` // CaptchaSite is the public site key handed to clients.
+}
+
+// DefaultConfig returns a disabled Config with reasonable limits.
+func DefaultConfig() Config {
+	return Config{
+		Threshold:  60,
+		Window:     time.Minute,
+		PassTTL:    time.Hour,
+		Mode:       "pow",
+		Difficulty: 18,
+	}
+}
+
+// Verifier issues challenges and checks their solutions.
+type Verifier interface {
+	// Challenge writes a response asking the client to solve a challenge.
+	Challenge(w http.ResponseWriter, r *http.Request)
+	// Verify reports whether the request carries a valid solution.
+	Verify(r *http.Request) (bool, error)
+}
+
+// NewVerifier builds the Verifier selected by cfg.Mode.
+func NewVerifier(cfg Config) (Verifier, error) {
+	secret, err := secretOf(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Mode {
+	case "", "pow":
+		return &ProofOfWork{Difficulty: cfg.Difficulty, Secret: secret, TTL: 2 * time.Minute}, nil
+	case "captcha":
+		if cfg.CaptchaURL == "" {
+			return nil, errors.New("challenge: captcha mode requires CaptchaURL")
+		}
+		return &Captcha{
+			URL:     cfg.CaptchaURL,
+			Secret:  cfg.CaptchaSecret,
+			SiteKey: cfg.CaptchaSite,
+			Client:  &http.Client{Timeout: 5 * time.Second},
+		}, nil
+	default:
+		return nil, errors.New("challenge: unknown mode " + cfg.Mode)
+	}
+}
+
+func secretOf(cfg Config) ([]byte, error) {
+	if cfg.Secret != "" {
+		return []byte(cfg.Secret), nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// signer produces and checks short-lived tokens bound to a client.
+type signer struct {
+	secret []byte
+}
+
+func (s signer) sign(client string, expires time.Time, extra []byte) string {
+	payload := make([]byte, 8, 8+len(extra))
+	binary.BigEndian.PutUint64(payload, uint64(expires.Unix()))
+	payload = append(payload, extra...)
+	return enc(payload) + "." + enc(s.mac(client, payload))
+}
+
+// verify checks the token's signature and expiry, returning its payload
+// past the expiry prefix.
+func (s signer) verify(client, token string) ([]byte, bool) {
+	p, m, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil || len(payload) < 8 {
+		return nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(m)
+	if err != nil || !hmac.Equal(mac, s.mac(client, payload)) {
+		return nil, false
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if time.Now().After(expires) {
+		return nil, false
+	}
+	return payload[8:], true
+}
+
+func (s signer) mac(client string, payload []byte) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(client))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+func enc(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
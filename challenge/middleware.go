@@ -0,0 +1,137 @@
+package challenge
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+const passCookie = "fxdemo_pass"
+
+// Middleware challenges clients that exceed the request threshold
+// on protected routes.
+// しきい値を超えたクライアントにチャレンジを要求する
+type Middleware struct {
+	cfg      Config
+	verifier Verifier
+	passes   signer
+	log      *zap.Logger
+
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+// window counts a client's requests since start.
+type window struct {
+	start time.Time
+	count int
+}
+
+// NewMiddleware builds a new Middleware.
+func NewMiddleware(cfg Config, verifier Verifier, log *zap.Logger) (*Middleware, error) {
+	secret, err := secretOf(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Middleware{
+		cfg:      cfg,
+		verifier: verifier,
+		passes:   signer{secret},
+		log:      log,
+		windows:  make(map[string]*window),
+	}, nil
+}
+
+// Wrap returns a handler that applies the challenge before calling next.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	if !m.cfg.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.protected(r.URL.Path) || m.hasPass(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := clientip.FromRequest(r)
+		if m.hit(client) <= m.cfg.Threshold {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		log := telemetry.Logger(r.Context(), m.log)
+		ok, err := m.verifier.Verify(r)
+		if err != nil {
+			log.Warn("Failed to verify challenge", zap.String("client", client), zap.Error(err))
+		}
+		if !ok {
+			log.Info("Challenging client", zap.String("client", client), zap.String("path", r.URL.Path))
+			m.verifier.Challenge(w, r)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     passCookie,
+			Value:    m.passes.sign(client, time.Now().Add(m.cfg.PassTTL), nil),
+			Path:     "/",
+			MaxAge:   int(m.cfg.PassTTL.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middleware) protected(path string) bool {
+	for _, p := range m.cfg.Paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Middleware) hasPass(r *http.Request) bool {
+	c, err := r.Cookie(passCookie)
+	if err != nil {
+		return false
+	}
+	_, ok := m.passes.verify(clientip.FromRequest(r), c.Value)
+	return ok
+}
+
+// hit records a request from the client and returns how many
+// it has made in the current window.
+func (m *Middleware) hit(client string) int {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.windows[client]
+	if !ok || now.Sub(w.start) >= m.cfg.Window {
+		if !ok {
+			m.prune(now)
+		}
+		w = &window{start: now}
+		m.windows[client] = w
+	}
+	w.count++
+	return w.count
+}
+
+// prune drops windows that have already ended so that the map does not
+// grow with every client ever seen. m.mu must be held.
+func (m *Middleware) prune(now time.Time) {
+	if len(m.windows) < 1024 {
+		return
+	}
+	for client, w := range m.windows {
+		if now.Sub(w.start) >= m.cfg.Window {
+			delete(m.windows, client)
+		}
+	}
+}
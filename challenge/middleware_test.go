@@ -0,0 +1,25 @@
+package challenge
+
+import (
+	"math"
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+// BenchmarkWrap measures requests to a protected path that are under
+// the threshold, so that no challenge is sent.
+func BenchmarkWrap(b *testing.B) {
+	cfg := DefaultConfig()
+	cfg.Enabled, cfg.Paths, cfg.Threshold = true, []string{httpfxtest.Path}, math.MaxInt
+	v, err := NewVerifier(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	m, err := NewMiddleware(cfg, v, zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	httpfxtest.Benchmark(b, m.Wrap(httpfxtest.Noop))
+}
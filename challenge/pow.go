@@ -0,0 +1,66 @@
+package challenge
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/clientip"
+)
+
+// ProofOfWork is a Verifier that asks clients to find a counter such that
+// SHA-256(nonce + ":" + counter) starts with Difficulty zero bits.
+//
+// The nonce is signed and bound to the client, so no server side state
+// is needed to check a solution.
+type ProofOfWork struct {
+	Difficulty int
+	Secret     []byte
+	TTL        time.Duration // TTL is how long a nonce can be solved for.
+}
+
+// Challenge responds with a fresh nonce for the client to solve.
+func (p *ProofOfWork) Challenge(w http.ResponseWriter, r *http.Request) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		apperror.Write(w, r, nil, apperror.Internal(err))
+		return
+	}
+	nonce := signer{p.Secret}.sign(clientip.FromRequest(r), time.Now().Add(p.TTL), salt)
+
+	w.Header().Set("X-Challenge", "pow")
+	w.Header().Set("X-Challenge-Nonce", nonce)
+	w.Header().Set("X-Challenge-Difficulty", strconv.Itoa(p.Difficulty))
+	apperror.Write(w, r, nil, apperror.New(http.StatusForbidden, "challenge_required", "Proof-of-work challenge required"))
+}
+
+// Verify checks the X-Challenge-Solution header, formatted as
+// "<nonce>:<counter>".
+func (p *ProofOfWork) Verify(r *http.Request) (bool, error) {
+	solution := r.Header.Get("X-Challenge-Solution")
+	nonce, counter, ok := strings.Cut(solution, ":")
+	if !ok {
+		return false, nil
+	}
+	if _, ok := (signer{p.Secret}).verify(clientip.FromRequest(r), nonce); !ok {
+		return false, nil
+	}
+	sum := sha256.Sum256([]byte(nonce + ":" + counter))
+	return leadingZeroBits(sum[:]) >= p.Difficulty, nil
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
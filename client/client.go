@@ -0,0 +1,73 @@
+// Package client is a Go client for the fxdemo server.
+//
+// The operations are generated from api/openapi.yaml; run go generate
+// after changing the description of the server.
+package client
+
+//go:generate go run ../cmd/genclient -spec ../api/openapi.yaml -out operations.go
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of this package, sent in the User-Agent of
+// its requests as "fxdemo-go/<version>".
+const Version = "0.1"
+
+// Client calls the operations of an fxdemo server.
+type Client struct {
+	// BaseURL is the scheme and host of the server, such as
+	// "http://localhost:8080".
+	BaseURL string
+	// HTTPClient sends the requests.
+	HTTPClient *http.Client
+}
+
+// New builds a Client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+// Error is returned when the server answers with a status the operation
+// does not declare as successful.
+type Error struct {
+	StatusCode int
+	Body       string // the start of the response body
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("fxdemo: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// do sends req and returns the response if its status is one of ok.
+// The caller must close the body of the response.
+func (c *Client) do(req *http.Request, ok ...int) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "fxdemo-go/"+Version)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, &Error{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+}
+
+func escape(s string) string {
+	return url.PathEscape(s)
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
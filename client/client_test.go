@@ -0,0 +1,251 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"example.com/fxdemo/app"
+	"example.com/fxdemo/client"
+	"example.com/fxdemo/config"
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+)
+
+// newTestClient starts the application on a free port of the loopback
+// interface, with its default configuration, and returns a Client for
+// it. The application is stopped when the test ends.
+func newTestClient(t *testing.T) *client.Client {
+	t.Helper()
+	addr := make(chan string, 1)
+	a := app.New(
+		app.WithConfigFile(""),
+		app.WithOverrides(func(cfg *config.Config) {
+			cfg.Server.Addr = "127.0.0.1:0"
+			cfg.Upload.Dir = t.TempDir()
+			cfg.WAF.RulesFile = ""
+			cfg.Log.Level = "error"
+			cfg.Log.FxLevel = "error"
+		}),
+		// 割り当てられたポートを知るためにリスナーを覗く
+		app.WithModules(fx.Decorate(func(o httpfx.ServerOptions) httpfx.ServerOptions {
+			wrap := o.Listener
+			o.Listener = func(ln net.Listener) net.Listener {
+				addr <- ln.Addr().String()
+				if wrap != nil {
+					ln = wrap(ln)
+				}
+				return ln
+			}
+			return o
+		})),
+	)
+	if err := a.Start(context.Background()); err != nil {
+		t.Fatal(httpfx.Explain(err))
+	}
+	t.Cleanup(func() {
+		if err := a.Stop(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	return client.New("http://" + <-addr)
+}
+
+// decode reads the JSON body of resp into v and closes it.
+func decode(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: %v", resp.Request.Method, resp.Request.URL.Path, err)
+	}
+}
+
+type greeting struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Message  string `json:"message"`
+	Language string `json:"language"`
+	Version  int64  `json:"version"`
+}
+
+func TestGreetingRoundTrip(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	resp, err := c.CreateGreeting(ctx, strings.NewReader(`{"name":"Alice","message":"Hello","language":"en"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created greeting
+	decode(t, resp, &created)
+	if created.ID == "" || created.Name != "Alice" || created.Version != 1 {
+		t.Fatalf("CreateGreeting = %+v", created)
+	}
+
+	resp, err = c.GetGreeting(ctx, created.ID, client.GetGreetingParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := resp.Header.Get("ETag")
+	var got greeting
+	decode(t, resp, &got)
+	if got != created || etag == "" {
+		t.Fatalf("GetGreeting = %+v with ETag %q, want %+v", got, etag, created)
+	}
+
+	resp, err = c.GetGreeting(ctx, created.ID, client.GetGreetingParams{IfNoneMatch: etag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GetGreeting with If-None-Match: status = %d, want 304", resp.StatusCode)
+	}
+
+	resp, err = c.PatchGreeting(ctx, created.ID, client.PatchGreetingParams{IfMatch: etag},
+		"application/merge-patch+json", strings.NewReader(`{"message":"Hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patched greeting
+	decode(t, resp, &patched)
+	if patched.Message != "Hi" || patched.Name != "Alice" || patched.Version != 2 {
+		t.Errorf("PatchGreeting = %+v", patched)
+	}
+
+	// 古いETagでの更新は拒否される
+	_, err = c.PatchGreeting(ctx, created.ID, client.PatchGreetingParams{IfMatch: etag},
+		"application/merge-patch+json", strings.NewReader(`{"message":"Hey"}`))
+	var stale *client.Error
+	if !errors.As(err, &stale) || stale.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PatchGreeting with a stale ETag: error = %v, want a 412", err)
+	}
+}
+
+func TestUserRoundTrip(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	type user struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	resp, err := c.CreateUser(ctx, strings.NewReader(`{"name":"Bob","email":"bob@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created user
+	decode(t, resp, &created)
+	if created.ID == "" || created.Email != "bob@example.com" {
+		t.Fatalf("CreateUser = %+v", created)
+	}
+
+	resp, err = c.ReplaceUser(ctx, created.ID, strings.NewReader(`{"name":"Robert","email":"bob@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = c.ListUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var users []user
+	decode(t, resp, &users)
+	if len(users) != 1 || users[0].ID != created.ID || users[0].Name != "Robert" {
+		t.Errorf("ListUsers = %+v, want the replaced user", users)
+	}
+
+	resp, err = c.DeleteUser(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	_, err = c.GetUser(ctx, created.ID)
+	var gone *client.Error
+	if !errors.As(err, &gone) || gone.StatusCode != http.StatusNotFound {
+		t.Errorf("GetUser after DeleteUser: error = %v, want a 404", err)
+	}
+}
+
+func TestUploadRoundTrip(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	const content = "hello, world"
+
+	resp, err := c.CreateUpload(ctx, client.CreateUploadParams{UploadLength: int64(len(content))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	id := resp.Header.Get("Location")
+	id = id[strings.LastIndexByte(id, '/')+1:]
+	if id == "" {
+		t.Fatalf("CreateUpload: Location = %q", resp.Header.Get("Location"))
+	}
+
+	// 二回に分けて送る
+	for _, chunk := range []struct{ offset, end int }{{0, 5}, {5, len(content)}} {
+		resp, err := c.AppendUpload(ctx, id, client.AppendUploadParams{UploadOffset: int64(chunk.offset)},
+			strings.NewReader(content[chunk.offset:chunk.end]))
+		if err != nil {
+			t.Fatalf("AppendUpload at %d: %v", chunk.offset, err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err = c.GetUploadOffset(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Upload-Offset"); got != "12" {
+		t.Errorf("GetUploadOffset: Upload-Offset = %q, want 12", got)
+	}
+
+	resp, err = c.DownloadFile(ctx, id, client.DownloadFileParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); string(b) != content {
+		t.Errorf("DownloadFile = %q, want %q", b, content)
+	}
+}
+
+func TestProbesAndAdmin(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	for name, call := range map[string]func(context.Context) (*http.Response, error){
+		"GetHealth":       c.GetHealth,
+		"GetReadiness":    c.GetReadiness,
+		"GetMetrics":      c.GetMetrics,
+		"GetRoutes":       c.GetRoutes,
+		"GetDegradations": c.GetDegradations,
+	} {
+		resp, err := call(ctx)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestUndeclaredStatus(t *testing.T) {
+	c := newTestClient(t)
+	_, err := c.GetJob(context.Background(), "missing")
+	var e *client.Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusNotFound {
+		t.Fatalf("GetJob(missing): error = %v, want a 404", err)
+	}
+	if !strings.Contains(e.Body, `"code"`) {
+		t.Errorf("GetJob(missing): body = %q, want the JSON error", e.Body)
+	}
+}
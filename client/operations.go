@@ -0,0 +1,362 @@
+// Code generated by genclient from ../api/openapi.yaml. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// AppendUploadParams holds the header and query parameters of AppendUpload.
+type AppendUploadParams struct {
+	UploadOffset int64
+}
+
+// AppendUpload writes the request body at the given offset.
+func (c *Client) AppendUpload(ctx context.Context, id string, params AppendUploadParams, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "PATCH", c.BaseURL+"/uploads/"+escape(id), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", itoa(params.UploadOffset))
+	return c.do(req, 204)
+}
+
+// CreateGreeting stores a new greeting.
+func (c *Client) CreateGreeting(ctx context.Context, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/api/v1/greetings/", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, 201)
+}
+
+// CreateNote stores a new note.
+func (c *Client) CreateNote(ctx context.Context, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/api/v1/notes", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, 201)
+}
+
+// CreateUploadParams holds the header and query parameters of CreateUpload.
+type CreateUploadParams struct {
+	UploadLength   int64
+	UploadMetadata string // optional
+}
+
+// CreateUpload starts a resumable upload.
+func (c *Client) CreateUpload(ctx context.Context, params CreateUploadParams) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/uploads/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Upload-Length", itoa(params.UploadLength))
+	if params.UploadMetadata != "" {
+		req.Header.Set("Upload-Metadata", params.UploadMetadata)
+	}
+	return c.do(req, 201)
+}
+
+// CreateUser adds a user.
+func (c *Client) CreateUser(ctx context.Context, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/users", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, 201)
+}
+
+// DebugDeprecations reports which clients still call deprecated routes.
+func (c *Client) DebugDeprecations(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/debug/deprecations", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// DebugVars returns the expvar variables.
+func (c *Client) DebugVars(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/debug/vars", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// DeleteGreetingsParams holds the header and query parameters of DeleteGreetings.
+type DeleteGreetingsParams struct {
+	Filter string
+}
+
+// DeleteGreetings queues the deletion of every greeting matching the filter and returns the job doing it.
+func (c *Client) DeleteGreetings(ctx context.Context, params DeleteGreetingsParams) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.BaseURL+"/api/v1/greetings", nil)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	q.Set("filter", params.Filter)
+	req.URL.RawQuery = q.Encode()
+	return c.do(req, 202)
+}
+
+// DeleteUser removes a user.
+func (c *Client) DeleteUser(ctx context.Context, id string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.BaseURL+"/users/"+escape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 204)
+}
+
+// DownloadFileParams holds the header and query parameters of DownloadFile.
+type DownloadFileParams struct {
+	Download string // optional
+}
+
+// DownloadFile fetches a completed upload.
+func (c *Client) DownloadFile(ctx context.Context, id string, params DownloadFileParams) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/files/"+escape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	if params.Download != "" {
+		q.Set("download", params.Download)
+	}
+	req.URL.RawQuery = q.Encode()
+	return c.do(req, 200)
+}
+
+// Echo sends the request body back unchanged.
+func (c *Client) Echo(ctx context.Context, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/echo", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	return c.do(req, 200)
+}
+
+// GetDegradations lists the features that can fall back and their state.
+func (c *Client) GetDegradations(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/admin/degradations", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// GetGreetingParams holds the header and query parameters of GetGreeting.
+type GetGreetingParams struct {
+	IfNoneMatch string // optional
+	AsOf        string // optional
+}
+
+// GetGreeting fetches a greeting.
+func (c *Client) GetGreeting(ctx context.Context, id string, params GetGreetingParams) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/api/v1/greetings/"+escape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	if params.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", params.IfNoneMatch)
+	}
+	if params.AsOf != "" {
+		q.Set("as_of", params.AsOf)
+	}
+	req.URL.RawQuery = q.Encode()
+	return c.do(req, 200, 304)
+}
+
+// GetHealth reports that the server is alive, without checking its dependencies.
+func (c *Client) GetHealth(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/healthz", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// GetJob reports the progress of a bulk deletion.
+func (c *Client) GetJob(ctx context.Context, id string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/api/v1/jobs/"+escape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// GetMetrics returns the request metrics and the numeric expvar variables in the Prometheus text format.
+func (c *Client) GetMetrics(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// GetReadiness reports whether the server should be sent traffic: it has started, is not stopping and its health checks pass.
+func (c *Client) GetReadiness(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/readyz", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// GetRoutes lists the middleware and handlers the requests of each route go through, outermost first. Only served to the loopback interface.
+func (c *Client) GetRoutes(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/admin/routes", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// GetUploadOffset reports how much of an upload has been received.
+func (c *Client) GetUploadOffset(ctx context.Context, id string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", c.BaseURL+"/uploads/"+escape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// GetUsageParams holds the header and query parameters of GetUsage.
+type GetUsageParams struct {
+	Tenant string // optional
+	From   string // optional
+	To     string // optional
+}
+
+// GetUsage reports API usage by tenant and day. Clients see the usage of the tenant they are authenticated as; those granted the usage:admin scope see every tenant's.
+func (c *Client) GetUsage(ctx context.Context, params GetUsageParams) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/api/v1/usage", nil)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	if params.Tenant != "" {
+		q.Set("tenant", params.Tenant)
+	}
+	if params.From != "" {
+		q.Set("from", params.From)
+	}
+	if params.To != "" {
+		q.Set("to", params.To)
+	}
+	req.URL.RawQuery = q.Encode()
+	return c.do(req, 200)
+}
+
+// GetUser fetches a user.
+func (c *Client) GetUser(ctx context.Context, id string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/users/"+escape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// Hello greets the name sent in the request body.
+func (c *Client) Hello(ctx context.Context, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/hello", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	return c.do(req, 200)
+}
+
+// HelloV2 greets the name sent in the request body in JSON.
+func (c *Client) HelloV2(ctx context.Context, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/v2/hello", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	return c.do(req, 200)
+}
+
+// ListNotesParams holds the header and query parameters of ListNotes.
+type ListNotesParams struct {
+	Limit int64 // optional
+}
+
+// ListNotes returns the latest notes, newest first.
+func (c *Client) ListNotes(ctx context.Context, params ListNotesParams) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/api/v1/notes", nil)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	if params.Limit != 0 {
+		q.Set("limit", itoa(params.Limit))
+	}
+	req.URL.RawQuery = q.Encode()
+	return c.do(req, 200)
+}
+
+// ListUsers returns every user, oldest first.
+func (c *Client) ListUsers(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/users", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200)
+}
+
+// PatchGreetingParams holds the header and query parameters of PatchGreeting.
+type PatchGreetingParams struct {
+	IfMatch string
+}
+
+// PatchGreeting updates part of a greeting with a JSON Merge Patch (RFC 7386) or a JSON Patch (RFC 6902).
+func (c *Client) PatchGreeting(ctx context.Context, id string, params PatchGreetingParams, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "PATCH", c.BaseURL+"/api/v1/greetings/"+escape(id), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("If-Match", params.IfMatch)
+	return c.do(req, 200)
+}
+
+// Reload loads the configuration again, rebuilds the routes with its declared and limits sections and swaps them in once the previous ones have finished their requests. The WAF and rewrite rules files are read again too. Only accepted from the loopback interface.
+func (c *Client) Reload(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/admin/reload", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, 200, 202)
+}
+
+// ReplaceUser sets the name and email of a user.
+func (c *Client) ReplaceUser(ctx context.Context, id string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", c.BaseURL+"/users/"+escape(id), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, 200)
+}
+
+// SetDegradation degrades or restores a feature. Only accepted from the loopback interface.
+func (c *Client) SetDegradation(ctx context.Context, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/admin/degradations", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, 200)
+}
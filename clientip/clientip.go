@@ -0,0 +1,118 @@
+// Package clientip works out which client a request came from. Behind
+// reverse proxies, the Resolver finds the client in the X-Forwarded-For
+// header the trusted ones set, for FromRequest to return.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientKey struct{}
+
+// FromRequest returns the IP address of the client that sent the
+// request: the one the Resolver found, or else the peer's.
+func FromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientKey{}).(string); ok {
+		return ip
+	}
+	return Peer(r)
+}
+
+// Peer returns the IP address of the peer that sent the request, which
+// may be a proxy.
+func Peer(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// IsLoopback reports whether the request came from the server's own
+// host, to which the admin endpoints are restricted.
+func IsLoopback(r *http.Request) bool {
+	ip := net.ParseIP(FromRequest(r))
+	return ip != nil && ip.IsLoopback()
+}
+
+// Resolver finds the client of requests that came through trusted
+// reverse proxies.
+// プロキシの先のクライアントを特定する
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver builds a Resolver believing the proxies in trusted, which
+// holds IP addresses and CIDR ranges.
+func NewResolver(trusted []string) (*Resolver, error) {
+	res := &Resolver{}
+	for _, p := range trusted {
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("clientip: trusted proxy: %w", err)
+		}
+		res.trusted = append(res.trusted, n)
+	}
+	return res, nil
+}
+
+// TrustedPeer reports whether the peer that sent r is a trusted proxy,
+// whose X-Forwarded-* headers may be believed.
+func (res *Resolver) TrustedPeer(r *http.Request) bool {
+	return res.trusts(Peer(r))
+}
+
+func (res *Resolver) trusts(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range res.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client returns the IP address of the client of r. X-Forwarded-For is
+// read from the right, past the trusted proxies, so that a client
+// cannot pass for another by sending the header itself.
+func (res *Resolver) Client(r *http.Request) string {
+	client := Peer(r)
+	if !res.trusts(client) {
+		return client
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		client = hop
+		if !res.trusts(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// Wrap returns a handler that records the client of each request for
+// FromRequest, before passing it on to next.
+func (res *Resolver) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientKey{}, res.Client(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
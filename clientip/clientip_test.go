@@ -0,0 +1,15 @@
+package clientip
+
+import (
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	res, err := NewResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	httpfxtest.Benchmark(b, res.Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,62 @@
+// Command debugtoken issues X-Debug-Token values for debugging a
+// single request in production.
+//
+//	debugtoken -genkey -key support.key             # prints the public key
+//	debugtoken -key support.key -subject TICKET-123 # prints a token
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"example.com/fxdemo/debugmode"
+)
+
+func main() {
+	keyPath := flag.String("key", "", "path of the private key")
+	genkey := flag.Bool("genkey", false, "generate a new key pair")
+	subject := flag.String("subject", "", "who or what the token is for, e.g. a ticket number")
+	ttl := flag.Duration("ttl", time.Hour, "how long the token is valid for")
+	flag.Parse()
+
+	if *keyPath == "" {
+		log.Fatal("-key is required")
+	}
+	if *genkey {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*keyPath, []byte(base64.StdEncoding.EncodeToString(priv)), 0o600); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(pub))
+		return
+	}
+
+	if *subject == "" {
+		log.Fatal("-subject is required")
+	}
+	encoded, err := os.ReadFile(*keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	priv, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		log.Fatal("malformed private key")
+	}
+	token, err := debugmode.Sign(priv, debugmode.Claims{
+		Subject: *subject,
+		Expires: time.Now().Add(*ttl).Unix(),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(token)
+}
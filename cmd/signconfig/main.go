@@ -0,0 +1,60 @@
+// Command signconfig creates signing keys for configuration bundles
+// and signs bundles with them.
+//
+//	signconfig -genkey -key signing.key      # prints the public key
+//	signconfig -key signing.key config.yaml  # writes config.yaml.sig
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"example.com/fxdemo/config"
+)
+
+func main() {
+	keyPath := flag.String("key", "", "path of the private key")
+	genkey := flag.Bool("genkey", false, "generate a new key pair")
+	flag.Parse()
+
+	if *keyPath == "" {
+		log.Fatal("-key is required")
+	}
+	if *genkey {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*keyPath, []byte(base64.StdEncoding.EncodeToString(priv)), 0o600); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(pub))
+		return
+	}
+
+	if flag.NArg() != 1 {
+		log.Fatal("usage: signconfig -key signing.key config.yaml")
+	}
+	encoded, err := os.ReadFile(*keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	priv, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		log.Fatal("malformed private key")
+	}
+	bundle := flag.Arg(0)
+	data, err := os.ReadFile(bundle)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sig := ed25519.Sign(priv, data)
+	if err := os.WriteFile(bundle+config.SignatureSuffix, []byte(base64.StdEncoding.EncodeToString(sig)), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"example.com/fxdemo/app"
+	"example.com/fxdemo/client"
+	"example.com/fxdemo/config"
+	"example.com/fxdemo/logging"
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/zap"
+)
+
+// serve runs the server until it is interrupted. Flags that are set
+// override the configuration bundle and the environment.
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := fs.String("config", "", "configuration bundle to load instead of $"+config.EnvBundle)
+	addr := fs.String("addr", "", "address to listen on (server.addr)")
+	logLevel := fs.String("log-level", "", "lowest level logged: debug, info, warn or error (log.level)")
+	tlsCert := fs.String("tls-cert", "", "PEM certificate to serve HTTPS with (server.tls.cert_file)")
+	tlsKey := fs.String("tls-key", "", "PEM key of the certificate (server.tls.key_file)")
+	shutdown := fs.Duration("shutdown-timeout", 0, "how long to wait for requests when stopping (server.shutdown_timeout)")
+	logRoutes := fs.Bool("log-routes", false, "log the routes served when starting (server.log_routes)")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("serve takes no arguments, got %q", fs.Args())
+	}
+
+	opts := []app.Option{
+		app.WithModules(demoRoutes), // -tags demo のときだけ /echo と /hello を提供する
+		app.WithOverrides(func(cfg *config.Config) {
+			// 指定されたフラグだけを反映する
+			fs.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "addr":
+					cfg.Server.Addr = *addr
+				case "log-level":
+					cfg.Log.Level = *logLevel
+				case "tls-cert":
+					cfg.Server.TLS.CertFile = *tlsCert
+				case "tls-key":
+					cfg.Server.TLS.KeyFile = *tlsKey
+				case "shutdown-timeout":
+					cfg.Server.ShutdownTimeout = *shutdown
+				case "log-routes":
+					cfg.Server.LogRoutes = *logRoutes
+				}
+			})
+		}),
+	}
+	if *configFile != "" {
+		opts = append(opts, app.WithConfigFile(*configFile))
+	}
+	a := app.New(opts...)
+	if err := a.Err(); err != nil {
+		// 設定自体が壊れているかもしれないので既定のロガーを使う
+		log, _ := logging.Build(logging.DefaultConfig())
+		// 依存関係の連鎖ではなく、失敗したコンストラクタだけを報告する
+		log.Fatal("Failed to build the application", zap.Error(httpfx.Explain(err)))
+	}
+	a.Run()
+	return nil
+}
+
+// routes prints the routes of a running server, as /admin/routes
+// reports them. The server only answers requests from its own host.
+func routes(args []string) error {
+	fs := flag.NewFlagSet("routes", flag.ExitOnError)
+	server := fs.String("server", "http://localhost"+httpfx.DefaultAddr, "base URL of the server")
+	chains := fs.Bool("chains", false, "also print the middleware in front of each route")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := client.New(*server).GetRoutes(ctx)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var rcs []app.RouteChain
+	if err := json.NewDecoder(resp.Body).Decode(&rcs); err != nil {
+		return fmt.Errorf("reading routes: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, rc := range rcs {
+		line := strings.Join(rc.Patterns, ", ") + "\t" + rc.Route
+		if *chains {
+			line += "\t" + strings.Join(rc.Chain, " → ")
+		}
+		fmt.Fprintln(w, line)
+	}
+	return w.Flush()
+}
+
+// version prints the module version and the commit the binary was
+// built from, as recorded by the Go toolchain.
+func version(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.Parse(args)
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fmt.Errorf("no build information in the binary")
+	}
+	fmt.Printf("fxdemo %s %s\n", info.Main.Version, info.GoVersion)
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified", "-tags":
+			fmt.Printf("%s=%s\n", s.Key, s.Value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables read by Load.
+const (
+	EnvMode       = "FXDEMO_ENV"                // "production" requires a signed, versioned bundle
+	EnvBundle     = "FXDEMO_CONFIG"             // path to the configuration bundle
+	EnvPublicKey  = "FXDEMO_CONFIG_PUBLIC_KEY"  // base64 ed25519 key, if PublicKey is not set
+	EnvMinVersion = "FXDEMO_CONFIG_MIN_VERSION" // lowest bundle version accepted, if MinVersion is not set
+	EnvState      = "FXDEMO_CONFIG_STATE"       // file recording the last version applied, across restarts
+)
+
+// SignatureSuffix is appended to the bundle path to find its
+// detached signature.
+const SignatureSuffix = ".sig"
+
+// PublicKey is the base64 encoded ed25519 key bundles must be signed
+// with. It is meant to be set at build time:
+//
+//	go build -ldflags "-X example.com/fxdemo/config.PublicKey=..."
+var PublicKey string
+
+// MinVersion is the lowest bundle version accepted, in decimal. Like
+// PublicKey, it is meant to be set at build time, so that bundles older
+// than the build are refused. In production mode it is at least 1.
+var MinVersion string
+
+// Errors of LoadFile.
+var (
+	// ErrUnsigned is returned in production mode for a bundle without
+	// a signature.
+	ErrUnsigned = errors.New("config: bundle is not signed")
+	// ErrRollback is returned for a bundle older than the minimum
+	// version or than the last one applied, such as a signed bundle
+	// replayed to undo a fix.
+	ErrRollback = errors.New("config: bundle version is too old")
+)
+
+// applied is the highest bundle version loaded by this process.
+var applied atomic.Int64
+
+// Load reads the configuration bundle named by FXDEMO_CONFIG on top of
+// Default; see LoadFile.
+func Load() (Config, error) {
+	return LoadFile(os.Getenv(EnvBundle))
+}
+
+// LoadFile reads the configuration bundle at path on top of Default, or
+// returns Default if path is empty, and then applies the environment
+// overrides (see FromEnv). A bundle that has a signature must verify
+// against the public key; in production mode every bundle must be
+// signed. Its version must be at least the minimum version, and at
+// least that of the last bundle applied, by this process or, with
+// FXDEMO_CONFIG_STATE, by an earlier one; it is recorded as applied.
+// The WAF and rewrite rules files it names are relative to the
+// directory of the bundle, unless their paths are absolute.
+//
+// Nothing is logged, as the logger is itself configured from the
+// result; Report logs where the configuration came from.
+// 署名付きの設定バンドルを読み込む
+func LoadFile(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := decodeFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := FromEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+	if path != "" {
+		if err := recordApplied(cfg.Version); err != nil {
+			return Config{}, err
+		}
+	}
+	return cfg, nil
+}
+
+func decodeFile(path string, cfg *Config) error {
+	production := os.Getenv(EnvMode) == "production"
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sig, err := os.ReadFile(path + SignatureSuffix)
+	switch {
+	case os.IsNotExist(err) && production:
+		return ErrUnsigned
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if err := Verify(data, sig); err != nil {
+			return fmt.Errorf("config: %s: %w", path, err)
+		}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	if err := checkVersion(path, cfg.Version, production); err != nil {
+		return err
+	}
+	cfg.Source = Source{Path: path, Signed: sig != nil, keys: make(map[string]bool)}
+	collectKeys(&doc, "", cfg.Source.keys)
+	// 規則ファイルはバンドルと一緒に配布されるので、バンドルからの相対パスとする
+	for key, file := range map[string]*string{
+		"waf.rules_file":     &cfg.WAF.RulesFile,
+		"rewrite.rules_file": &cfg.Rewrite.RulesFile,
+	} {
+		if cfg.Source.keys[key] && *file != "" && !filepath.IsAbs(*file) {
+			*file = filepath.Join(filepath.Dir(path), *file)
+		}
+	}
+	return nil
+}
+
+// collectKeys adds the dotted path of every mapping key under n, such
+// as "server" and "server.addr", to keys.
+func collectKeys(n *yaml.Node, prefix string, keys map[string]bool) {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := prefix + n.Content[i].Value
+		keys[key] = true
+		collectKeys(n.Content[i+1], key+".", keys)
+	}
+}
+
+// checkVersion refuses a bundle version below the minimum version or
+// below that of the last bundle applied.
+func checkVersion(path string, version int, production bool) error {
+	floor, err := minVersion(production)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if version < floor {
+		return fmt.Errorf("%w: %s: version %d is below the minimum version %d", ErrRollback, path, version, floor)
+	}
+	last, err := lastApplied()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if int64(version) < last {
+		return fmt.Errorf("%w: %s: version %d is below version %d, which was applied already", ErrRollback, path, version, last)
+	}
+	return nil
+}
+
+func minVersion(production bool) (int, error) {
+	encoded := MinVersion
+	if encoded == "" {
+		encoded = os.Getenv(EnvMinVersion)
+	}
+	floor := 0
+	if encoded != "" {
+		var err error
+		if floor, err = strconv.Atoi(encoded); err != nil {
+			return 0, fmt.Errorf("malformed minimum version %q", encoded)
+		}
+	}
+	if production {
+		floor = max(floor, 1) // 本番ではバージョンのないバンドルを受け付けない
+	}
+	return floor, nil
+}
+
+// lastApplied returns the highest version applied by this process or
+// recorded in the state file.
+func lastApplied() (int64, error) {
+	last := applied.Load()
+	path := os.Getenv(EnvState)
+	if path == "" {
+		return last, nil
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return last, nil
+	case err != nil:
+		return 0, err
+	}
+	recorded, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: malformed version: %w", path, err)
+	}
+	return max(last, recorded), nil
+}
+
+// recordApplied records version as applied, if it is the highest yet.
+func recordApplied(version int) error {
+	for {
+		last := applied.Load()
+		if int64(version) <= last || applied.CompareAndSwap(last, int64(version)) {
+			break
+		}
+	}
+	path := os.Getenv(EnvState)
+	if path == "" {
+		return nil
+	}
+	last, err := lastApplied()
+	if err != nil || last > int64(version) {
+		return err
+	}
+	// 書きかけのファイルを読まれないよう、書き終えてから置き換える
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(version)+"\n"), 0o600); err != nil {
+		return fmt.Errorf("config: record version: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("config: record version: %w", err)
+	}
+	return nil
+}
+
+// Report logs where the configuration was loaded from.
+func Report(cfg Config, log *zap.Logger) {
+	if cfg.Source.Path == "" {
+		return
+	}
+	if !cfg.Source.Signed {
+		log.Warn("Loaded unsigned configuration bundle", zap.String("path", cfg.Source.Path))
+	}
+	log.Info("Loaded configuration bundle",
+		zap.String("path", cfg.Source.Path),
+		zap.Int("version", cfg.Version),
+		zap.Bool("signed", cfg.Source.Signed),
+	)
+}
+
+// Verify checks the base64 encoded detached signature of a bundle.
+func Verify(data, sig []byte) error {
+	key, err := publicKey()
+	if err != nil {
+		return err
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+	if !ed25519.Verify(key, data, raw) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func publicKey() (ed25519.PublicKey, error) {
+	encoded := PublicKey
+	if encoded == "" {
+		encoded = os.Getenv(EnvPublicKey)
+	}
+	if encoded == "" {
+		return nil, errors.New("no public key to verify the signature with")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("malformed public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadFileRulesFiles checks that the rules files a bundle names are
+// found next to it, wherever the server runs from.
+func TestLoadFileRulesFiles(t *testing.T) {
+	dir := t.TempDir()
+	abs := filepath.Join(t.TempDir(), "rewrite.json")
+	path := filepath.Join(dir, "bundle.yaml")
+	bundle := "waf:\n  rules_file: rules/waf.json\nrewrite:\n  rules_file: " + abs + "\n"
+	if err := os.WriteFile(path, []byte(bundle), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "rules", "waf.json"); cfg.WAF.RulesFile != want {
+		t.Errorf("waf.rules_file = %q, want %q", cfg.WAF.RulesFile, want)
+	}
+	if cfg.Rewrite.RulesFile != abs {
+		t.Errorf("rewrite.rules_file = %q, want %q kept", cfg.Rewrite.RulesFile, abs)
+	}
+	if def, err := LoadFile(""); err != nil || def.WAF.RulesFile != "" {
+		t.Errorf("LoadFile(\"\") = waf.rules_file %q, %v, want none", def.WAF.RulesFile, err)
+	}
+}
+
+// FuzzVerify checks that Verify accepts the signature of a bundle, and
+// no other signature or bundle.
+func FuzzVerify(f *testing.F) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		f.Fatal(err)
+	}
+	saved := PublicKey
+	PublicKey = base64.StdEncoding.EncodeToString(pub)
+	f.Cleanup(func() { PublicKey = saved })
+	sign := func(data []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data)))
+	}
+
+	bundle := []byte("version: 3\nserver:\n  addr: :8080\n")
+	f.Add(bundle, sign(bundle))
+	f.Add(bundle, append(sign(bundle), '\n'))
+	f.Add(bundle, sign(bundle[1:]))
+	f.Add([]byte{}, []byte("not base64!"))
+	f.Add([]byte{}, []byte(base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize))))
+	f.Fuzz(func(t *testing.T, data, sig []byte) {
+		if err := Verify(data, sign(data)); err != nil {
+			t.Fatalf("Verify() of a signed bundle = %v", err)
+		}
+		if Verify(data, sig) != nil {
+			return
+		}
+		// ed25519の署名は一意なので、受け入れた署名は正しい署名と同じはず
+		raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+		if err != nil || !bytes.Equal(raw, ed25519.Sign(priv, data)) {
+			t.Errorf("Verify(%q, %q) accepted a signature that is not the bundle's", data, sig)
+		}
+	})
+}
@@ -0,0 +1,230 @@
+// Package config gathers the configuration of every subsystem into a
+// single Config, loaded from an optionally signed configuration bundle.
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"example.com/fxdemo/accesslog"
+	"example.com/fxdemo/amqp"
+	"example.com/fxdemo/auth"
+	"example.com/fxdemo/challenge"
+	"example.com/fxdemo/contract"
+	"example.com/fxdemo/cors"
+	"example.com/fxdemo/db"
+	"example.com/fxdemo/deadline"
+	"example.com/fxdemo/debugmode"
+	"example.com/fxdemo/declared"
+	"example.com/fxdemo/earlyhints"
+	"example.com/fxdemo/flags"
+	"example.com/fxdemo/geoip"
+	"example.com/fxdemo/headers"
+	"example.com/fxdemo/health"
+	"example.com/fxdemo/honeypot"
+	"example.com/fxdemo/limits"
+	"example.com/fxdemo/links"
+	"example.com/fxdemo/logging"
+	"example.com/fxdemo/metering"
+	"example.com/fxdemo/mqtt"
+	"example.com/fxdemo/normalize"
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/ratelimit"
+	"example.com/fxdemo/region"
+	"example.com/fxdemo/rewrite"
+	"example.com/fxdemo/staticfiles"
+	"example.com/fxdemo/tracing"
+	"example.com/fxdemo/transfer"
+	"example.com/fxdemo/upload"
+	"example.com/fxdemo/waf"
+	"example.com/fxdemo/worker"
+	"go.uber.org/fx"
+)
+
+// Config is the configuration of the whole application.
+// アプリケーション全体の設定
+type Config struct {
+	// Version identifies the bundle the configuration was loaded from.
+	Version int `yaml:"version"`
+	// Source is where the configuration was loaded from.
+	Source Source `yaml:"-"`
+
+	Server     httpfx.Config      `yaml:"server"`
+	Log        logging.Config     `yaml:"log"`
+	Upload     upload.Config      `yaml:"upload"`
+	Challenge  challenge.Config   `yaml:"challenge"`
+	Honeypot   honeypot.Config    `yaml:"honeypot"`
+	WAF        waf.Config         `yaml:"waf"`
+	Tracing    tracing.Config     `yaml:"tracing"`
+	Debug      debugmode.Config   `yaml:"debug"`
+	Contract   contract.Config    `yaml:"contract"`
+	Flags      flags.Config       `yaml:"flags"`
+	Links      links.Config       `yaml:"links"`
+	Deadline   deadline.Config    `yaml:"deadline"`
+	Transfer   transfer.Config    `yaml:"transfer"`
+	Metering   metering.Config    `yaml:"metering"`
+	Region     region.Config      `yaml:"region"`
+	GeoIP      geoip.Config       `yaml:"geoip"`
+	Headers    headers.Config     `yaml:"headers"`
+	EarlyHints earlyhints.Config  `yaml:"early_hints"`
+	Declared   declared.Config    `yaml:"declared"`
+	Rewrite    rewrite.Config     `yaml:"rewrite"`
+	Normalize  normalize.Config   `yaml:"normalize"`
+	AccessLog  accesslog.Config   `yaml:"access_log"`
+	Health     health.Config      `yaml:"health"`
+	MQTT       mqtt.Config        `yaml:"mqtt"`
+	AMQP       amqp.Config        `yaml:"amqp"`
+	DB         db.Config          `yaml:"db"`
+	RateLimit  ratelimit.Config   `yaml:"rate_limit"`
+	CORS       cors.Config        `yaml:"cors"`
+	Static     staticfiles.Config `yaml:"static_files"`
+	Limits     limits.Config      `yaml:"limits"`
+	Auth       auth.Config        `yaml:"auth"`
+	Worker     worker.Config      `yaml:"worker"`
+}
+
+// Source is the bundle a Config was loaded from.
+type Source struct {
+	Path   string // empty if no bundle was loaded
+	Signed bool
+
+	keys map[string]bool // the keys set by the bundle, such as "server.addr"
+}
+
+// Default returns the configuration used when no bundle is given.
+func Default() Config {
+	return Config{
+		Server:     httpfx.DefaultConfig(),
+		Log:        logging.DefaultConfig(),
+		Upload:     upload.DefaultConfig(),
+		Challenge:  challenge.DefaultConfig(),
+		Honeypot:   honeypot.DefaultConfig(),
+		WAF:        waf.DefaultConfig(),
+		Tracing:    tracing.DefaultConfig(),
+		Contract:   contract.DefaultConfig(),
+		Flags:      flags.DefaultConfig(),
+		Links:      links.DefaultConfig(),
+		Deadline:   deadline.DefaultConfig(),
+		Transfer:   transfer.DefaultConfig(),
+		Metering:   metering.DefaultConfig(),
+		Region:     region.DefaultConfig(),
+		GeoIP:      geoip.DefaultConfig(),
+		Headers:    headers.DefaultConfig(),
+		EarlyHints: earlyhints.DefaultConfig(),
+		Declared:   declared.DefaultConfig(),
+		Rewrite:    rewrite.DefaultConfig(),
+		Normalize:  normalize.DefaultConfig(),
+		AccessLog:  accesslog.DefaultConfig(),
+		Health:     health.DefaultConfig(),
+		MQTT:       mqtt.DefaultConfig(),
+		AMQP:       amqp.DefaultConfig(),
+		DB:         db.DefaultConfig(),
+		RateLimit:  ratelimit.DefaultConfig(),
+		CORS:       cors.DefaultConfig(),
+		Static:     staticfiles.DefaultConfig(),
+		Limits:     limits.DefaultConfig(),
+		Auth:       auth.DefaultConfig(),
+		Worker:     worker.DefaultConfig(),
+	}
+}
+
+// Sections provides each part of the Config to the subsystem using it.
+type Sections struct {
+	fx.Out
+
+	Server     httpfx.Config
+	Log        logging.Config
+	Upload     upload.Config
+	Challenge  challenge.Config
+	Honeypot   honeypot.Config
+	WAF        waf.Config
+	Tracing    tracing.Config
+	Debug      debugmode.Config
+	Contract   contract.Config
+	Flags      flags.Config
+	Links      links.Config
+	Deadline   deadline.Config
+	Transfer   transfer.Config
+	Metering   metering.Config
+	Region     region.Config
+	GeoIP      geoip.Config
+	Headers    headers.Config
+	EarlyHints earlyhints.Config
+	Declared   declared.Config
+	Rewrite    rewrite.Config
+	Normalize  normalize.Config
+	AccessLog  accesslog.Config
+	Health     health.Config
+	MQTT       mqtt.Config
+	AMQP       amqp.Config
+	DB         db.Config
+	RateLimit  ratelimit.Config
+	CORS       cors.Config
+	Static     staticfiles.Config
+	Limits     limits.Config
+	Auth       auth.Config
+	Worker     worker.Config
+}
+
+// Split breaks the Config up into its Sections.
+// 各サブシステムの設定をfxに提供する
+func Split(cfg Config) Sections {
+	return Sections{
+		Server:     cfg.Server,
+		Log:        cfg.Log,
+		Upload:     cfg.Upload,
+		Challenge:  cfg.Challenge,
+		Honeypot:   cfg.Honeypot,
+		WAF:        cfg.WAF,
+		Tracing:    cfg.Tracing,
+		Debug:      cfg.Debug,
+		Contract:   cfg.Contract,
+		Flags:      cfg.Flags,
+		Links:      cfg.Links,
+		Deadline:   cfg.Deadline,
+		Transfer:   cfg.Transfer,
+		Metering:   cfg.Metering,
+		Region:     cfg.Region,
+		GeoIP:      cfg.GeoIP,
+		Headers:    cfg.Headers,
+		EarlyHints: cfg.EarlyHints,
+		Declared:   cfg.Declared,
+		Rewrite:    cfg.Rewrite,
+		Normalize:  cfg.Normalize,
+		AccessLog:  cfg.AccessLog,
+		Health:     cfg.Health,
+		MQTT:       cfg.MQTT,
+		AMQP:       cfg.AMQP,
+		DB:         cfg.DB,
+		RateLimit:  cfg.RateLimit,
+		CORS:       cfg.CORS,
+		Static:     cfg.Static,
+		Limits:     cfg.Limits,
+		Auth:       cfg.Auth,
+		Worker:     cfg.Worker,
+	}
+}
+
+// Changed lists the sections that differ between a and b, by their
+// name in the bundle, such as "declared" or "rate_limit".
+func Changed(a, b Config) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var changed []string
+	for i := range va.NumField() {
+		name, _, _ := strings.Cut(va.Type().Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" || name == "version" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// Module provides each section of a Config, which the application
+// provides, to its subsystem, and logs where the Config came from.
+var Module = fx.Module("config",
+	fx.Provide(Split),
+	fx.Invoke(Report), // 設定の読み込み元を記録する
+)
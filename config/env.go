@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Environment variables that override the loaded configuration, for the
+// settings that depend on where the server is deployed rather than on
+// what it serves.
+const (
+	EnvAddr              = "FXDEMO_ADDR"                // server.addr
+	EnvReadTimeout       = "FXDEMO_READ_TIMEOUT"        // server.read_timeout, such as "30s"
+	EnvWriteTimeout      = "FXDEMO_WRITE_TIMEOUT"       // server.write_timeout
+	EnvReadHeaderTimeout = "FXDEMO_READ_HEADER_TIMEOUT" // server.read_header_timeout
+	EnvIdleTimeout       = "FXDEMO_IDLE_TIMEOUT"        // server.idle_timeout
+	EnvShutdownTimeout   = "FXDEMO_SHUTDOWN_TIMEOUT"    // server.shutdown_timeout
+	EnvTLSCert           = "FXDEMO_TLS_CERT"            // server.tls.cert_file
+	EnvTLSKey            = "FXDEMO_TLS_KEY"             // server.tls.key_file
+	EnvTLSRedirectAddr   = "FXDEMO_TLS_REDIRECT"        // server.tls.redirect_addr, such as ":80"
+	EnvLogLevel          = "FXDEMO_LOG_LEVEL"           // log.level
+	EnvDBDriver          = "FXDEMO_DB_DRIVER"           // db.driver
+	EnvDBDSN             = "FXDEMO_DB_DSN"              // db.dsn, which often holds a password
+)
+
+// FromEnv applies the environment overrides that are set to cfg, but
+// for the keys set by the bundle cfg was loaded from: what a bundle
+// sets, and its signature vouches for, the environment cannot change.
+// 環境変数で上書きする
+func FromEnv(cfg *Config) error {
+	for _, s := range []struct {
+		env, key string
+		dst      *string
+	}{
+		{EnvAddr, "server.addr", &cfg.Server.Addr},
+		{EnvTLSCert, "server.tls.cert_file", &cfg.Server.TLS.CertFile},
+		{EnvTLSKey, "server.tls.key_file", &cfg.Server.TLS.KeyFile},
+		{EnvTLSRedirectAddr, "server.tls.redirect_addr", &cfg.Server.TLS.RedirectAddr},
+		{EnvLogLevel, "log.level", &cfg.Log.Level},
+		{EnvDBDriver, "db.driver", &cfg.DB.Driver},
+		{EnvDBDSN, "db.dsn", &cfg.DB.DSN},
+	} {
+		if v := os.Getenv(s.env); v != "" && !cfg.Source.keys[s.key] {
+			*s.dst = v
+		}
+	}
+	for _, d := range []struct {
+		env, key string
+		dst      *time.Duration
+	}{
+		{EnvReadTimeout, "server.read_timeout", &cfg.Server.ReadTimeout},
+		{EnvWriteTimeout, "server.write_timeout", &cfg.Server.WriteTimeout},
+		{EnvReadHeaderTimeout, "server.read_header_timeout", &cfg.Server.ReadHeaderTimeout},
+		{EnvIdleTimeout, "server.idle_timeout", &cfg.Server.IdleTimeout},
+		{EnvShutdownTimeout, "server.shutdown_timeout", &cfg.Server.ShutdownTimeout},
+	} {
+		v := os.Getenv(d.env)
+		if v == "" || cfg.Source.keys[d.key] {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", d.env, err)
+		}
+		*d.dst = parsed
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+// Package contract checks the responses of the server against its OpenAPI
+// description, so that handlers and the description cannot drift apart
+// unnoticed. It is meant for development and test deployments: every
+// checked response is held in memory until it has been validated.
+//
+// Responses that are flushed, such as NDJSON streams, are only checked
+// up to their first flush: their status and headers, and the body so
+// far. From then on they go straight to the client. Informational
+// responses, such as 103 Early Hints, are never held back.
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"example.com/fxdemo/api"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+// Modes of the Validator.
+const (
+	Off     = "off"     // responses are not checked
+	Log     = "log"     // divergences are logged
+	Enforce = "enforce" // divergences are logged and turned into 500 responses
+)
+
+// Config selects what happens when a response diverges from the description.
+type Config struct {
+	Mode string `yaml:"mode"`
+}
+
+// DefaultConfig leaves responses unchecked.
+func DefaultConfig() Config {
+	return Config{Mode: Off}
+}
+
+// violations counts divergent responses by operation ID; it is published
+// with the other expvar variables.
+var violations = expvar.NewMap("contract_violations")
+
+// Validator checks responses against the description in package api.
+// レスポンスがAPI定義どおりか検査する
+type Validator struct {
+	cfg Config
+	log *zap.Logger
+	doc *api.Document
+}
+
+// NewValidator builds a new Validator.
+func NewValidator(cfg Config, log *zap.Logger) (*Validator, error) {
+	switch cfg.Mode {
+	case Off, "":
+		return &Validator{cfg: cfg, log: log}, nil
+	case Log, Enforce:
+	default:
+		return nil, fmt.Errorf("contract: unknown mode %q", cfg.Mode)
+	}
+	doc, err := api.Parse(api.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("contract: %w", err)
+	}
+	return &Validator{cfg: cfg, log: log, doc: doc}, nil
+}
+
+// Wrap returns a handler that checks the responses of next to requests
+// for operations in the description. Other requests pass untouched.
+func (v *Validator) Wrap(next http.Handler) http.Handler {
+	if v.doc == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, tmpl := v.doc.Find(r.Method, r.URL.Path)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &recorder{w: w, header: make(http.Header), status: http.StatusOK}
+		rec.commit = func() bool { return v.commit(w, r, op, tmpl, rec) }
+		next.ServeHTTP(rec, r)
+		if !rec.streaming {
+			rec.commit()
+		}
+	})
+}
+
+// commit checks the response recorded so far and sends it to w, or
+// sends a 500 response instead if it diverges in Enforce mode. It
+// reports whether the recorded response was sent.
+func (v *Validator) commit(w http.ResponseWriter, r *http.Request, op *api.Operation, tmpl string, rec *recorder) bool {
+	if problems := check(op, r.Method, rec); len(problems) > 0 {
+		violations.Add(op.OperationID, 1)
+		telemetry.Logger(r.Context(), v.log).Warn("Response does not match the API description",
+			zap.String("operation", op.OperationID),
+			zap.String("path", tmpl),
+			zap.Int("status", rec.status),
+			zap.Bool("streaming", rec.streaming),
+			zap.Strings("problems", problems),
+		)
+		if v.cfg.Mode == Enforce {
+			http.Error(w, "Response does not match the API description: "+strings.Join(problems, "; "), http.StatusInternalServerError)
+			return false
+		}
+	}
+	copyHeader(w.Header(), rec.header)
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+	return true
+}
+
+// check lists the ways the recorded response diverges from op.
+func check(op *api.Operation, method string, rec *recorder) []string {
+	resp := op.Responses[strconv.Itoa(rec.status)]
+	if resp == nil {
+		resp = op.Responses["default"]
+	}
+	if resp == nil {
+		return []string{fmt.Sprintf("status %d is not declared", rec.status)}
+	}
+
+	var problems []string
+	names := make([]string, 0, len(resp.Headers))
+	for name := range resp.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h := resp.Headers[name]
+		value := rec.header.Get(name)
+		switch {
+		case value == "" && h.Required:
+			problems = append(problems, fmt.Sprintf("header %s is missing", name))
+		case value != "" && h.Schema.Type == "integer":
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				problems = append(problems, fmt.Sprintf("header %s is not an integer", name))
+			}
+		}
+	}
+
+	if len(resp.Content) == 0 || method == http.MethodHead || rec.body.Len() == 0 {
+		return problems
+	}
+	ct := rec.header.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(rec.body.Bytes())
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return append(problems, fmt.Sprintf("malformed content type %q", ct))
+	}
+	media, ok := findContent(resp.Content, mediaType)
+	if !ok {
+		return append(problems, fmt.Sprintf("content type %s is not declared", mediaType))
+	}
+	if rec.streaming {
+		return problems // 途中までの本文は検査できない
+	}
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		var body any
+		if err := json.Unmarshal(rec.body.Bytes(), &body); err != nil {
+			return append(problems, "body is not valid JSON")
+		}
+		problems = append(problems, checkSchema("body", &media.Schema, body)...)
+	}
+	return problems
+}
+
+// findContent looks mediaType up in content, falling back on the
+// "type/*" and "*/*" ranges.
+func findContent(content map[string]api.MediaType, mediaType string) (api.MediaType, bool) {
+	if m, ok := content[mediaType]; ok {
+		return m, true
+	}
+	if i := strings.IndexByte(mediaType, '/'); i > 0 {
+		if m, ok := content[mediaType[:i]+"/*"]; ok {
+			return m, true
+		}
+	}
+	m, ok := content["*/*"]
+	return m, ok
+}
+
+// checkSchema lists the ways the decoded JSON value v diverges from s.
+func checkSchema(at string, s *api.Schema, v any) []string {
+	mismatch := func() []string {
+		return []string{fmt.Sprintf("%s is not of type %s", at, s.Type)}
+	}
+	switch s.Type {
+	case "":
+		return nil
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return mismatch()
+		}
+		var problems []string
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s is missing", at, name))
+			}
+		}
+		for name, prop := range s.Properties {
+			if pv, ok := obj[name]; ok {
+				problems = append(problems, checkSchema(at+"."+name, prop, pv)...)
+			}
+		}
+		sort.Strings(problems)
+		return problems
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return mismatch()
+		}
+		var problems []string
+		if s.Items != nil {
+			for i, item := range arr {
+				problems = append(problems, checkSchema(fmt.Sprintf("%s[%d]", at, i), s.Items, item)...)
+			}
+		}
+		return problems
+	case "string":
+		if _, ok := v.(string); !ok {
+			return mismatch()
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return mismatch()
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return mismatch()
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+			return mismatch()
+		}
+	}
+	return nil
+}
+
+// errRefused is returned by the writes that follow a streaming response
+// refused in Enforce mode, so that its handler stops.
+var errRefused = errors.New("contract: response does not match the API description")
+
+// recorder is a minimal http.ResponseWriter that keeps the response
+// in memory until it has been checked, or until it is flushed.
+type recorder struct {
+	w      http.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool // WriteHeader was called with a final status
+
+	commit    func() bool // checks and sends what was recorded
+	streaming bool        // flushed: writes go straight to w
+	refused   bool        // the flushed response was refused
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) Write(b []byte) (int, error) {
+	switch {
+	case r.refused:
+		return 0, errRefused
+	case r.streaming:
+		return r.w.Write(b)
+	}
+	r.wrote = true
+	return r.body.Write(b)
+}
+
+// WriteHeader records the status of the response. Informational
+// statuses are sent at once with the headers set so far, as they are
+// meant to reach the client before the response.
+func (r *recorder) WriteHeader(status int) {
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		if !r.wrote && !r.streaming {
+			copyHeader(r.w.Header(), r.header)
+			r.w.WriteHeader(status)
+		}
+		return
+	}
+	if !r.wrote && !r.streaming {
+		r.status, r.wrote = status, true
+	}
+}
+
+// Flush checks and sends the response so far, then lets the rest of it
+// through unchecked.
+func (r *recorder) Flush() {
+	if !r.streaming {
+		r.streaming = true
+		r.refused = !r.commit()
+	}
+	if !r.refused {
+		http.NewResponseController(r.w).Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *recorder) Unwrap() http.ResponseWriter { return r.w }
+
+// copyHeader sets the headers of src in dst.
+func copyHeader(dst, src http.Header) {
+	for k, vs := range src {
+		dst[k] = vs
+	}
+}
@@ -0,0 +1,223 @@
+package contract
+
+import (
+	"bufio"
+	"expvar"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/fxdemo/api"
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+const testSpec = `
+paths:
+  /things/{name}:
+    get:
+      operationId: getThing
+      responses:
+        "200":
+          description: The thing.
+          headers:
+            X-Count:
+              required: true
+              schema:
+                type: integer
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Thing"
+            application/x-ndjson:
+              schema:
+                type: string
+        "404":
+          description: No such thing.
+components:
+  schemas:
+    Thing:
+      type: object
+      required: [name]
+      properties:
+        name:
+          type: string
+        size:
+          type: integer
+`
+
+func newTestValidator(t *testing.T, mode string) *Validator {
+	t.Helper()
+	doc, err := api.Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Validator{cfg: Config{Mode: mode}, log: zap.NewNop(), doc: doc}
+}
+
+// thing answers with the given status, content type and body, and an
+// X-Count header unless count is empty.
+func thing(status int, contentType, count, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count != "" {
+			w.Header().Set("X-Count", count)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	})
+}
+
+// violationCount reports how many responses to getThing diverged.
+func violationCount() int64 {
+	if n, ok := violations.Get("getThing").(*expvar.Int); ok {
+		return n.Value()
+	}
+	return 0
+}
+
+func TestValidatorDivergence(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.Handler
+		diverge bool
+	}{
+		{"matching", thing(200, "application/json", "1", `{"name":"a","size":2}`), false},
+		{"declared status without content", thing(404, "text/plain", "", ""), false},
+		{"undeclared status", thing(418, "application/json", "1", `{"name":"a"}`), true},
+		{"missing header", thing(200, "application/json", "", `{"name":"a"}`), true},
+		{"header not an integer", thing(200, "application/json", "x", `{"name":"a"}`), true},
+		{"undeclared content type", thing(200, "text/html", "1", `<p>a</p>`), true},
+		{"invalid JSON", thing(200, "application/json", "1", `{"name":`), true},
+		{"missing required field", thing(200, "application/json", "1", `{"size":2}`), true},
+		{"field of the wrong type", thing(200, "application/json", "1", `{"name":"a","size":2.5}`), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range []string{Log, Enforce} {
+				before := violationCount()
+				rec := httptest.NewRecorder()
+				newTestValidator(t, mode).Wrap(tt.handler).ServeHTTP(rec, httptest.NewRequest("GET", "/things/a", nil))
+
+				if diverged := violationCount() > before; diverged != tt.diverge {
+					t.Errorf("%s: diverged = %v, want %v", mode, diverged, tt.diverge)
+				}
+				refused := rec.Code == http.StatusInternalServerError
+				if want := tt.diverge && mode == Enforce; refused != want {
+					t.Errorf("%s: status = %d, refused = %v, want %v", mode, rec.Code, refused, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatorPassesOtherRequests(t *testing.T) {
+	rec := httptest.NewRecorder()
+	h := newTestValidator(t, Enforce).Wrap(thing(418, "text/plain", "", "teapot"))
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/other", nil))
+	if rec.Code != 418 || rec.Body.String() != "teapot" {
+		t.Errorf("response = %d %q, want it untouched", rec.Code, rec.Body.String())
+	}
+}
+
+// TestValidatorStreaming checks that a flushed response reaches the
+// client before its handler returns.
+func TestValidatorStreaming(t *testing.T) {
+	next := make(chan struct{})
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("X-Count", "2")
+		io.WriteString(w, `{"name":"a"}`+"\n")
+		http.NewResponseController(w).Flush()
+		<-next // the client reads the first line first
+		io.WriteString(w, `{"name":"b"}`+"\n")
+	})
+	srv := httptest.NewServer(newTestValidator(t, Enforce).Wrap(stream))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/things/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("X-Count") != "2" {
+		t.Fatalf("response = %d %v", resp.StatusCode, resp.Header)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || lines.Text() != `{"name":"a"}` {
+		t.Fatalf("first line = %q, %v", lines.Text(), lines.Err())
+	}
+	close(next)
+	if !lines.Scan() || lines.Text() != `{"name":"b"}` {
+		t.Fatalf("second line = %q, %v", lines.Text(), lines.Err())
+	}
+}
+
+// TestValidatorStreamingRefused checks that a stream whose head diverges
+// is refused in Enforce mode, and that its handler is told to stop.
+func TestValidatorStreamingRefused(t *testing.T) {
+	var writeErr error
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson") // X-Count is missing
+		io.WriteString(w, `{"name":"a"}`+"\n")
+		http.NewResponseController(w).Flush()
+		_, writeErr = io.WriteString(w, `{"name":"b"}`+"\n")
+	})
+	rec := httptest.NewRecorder()
+	newTestValidator(t, Enforce).Wrap(stream).ServeHTTP(rec, httptest.NewRequest("GET", "/things/a", nil))
+
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "name") {
+		t.Errorf("response = %d %q, want a 500", rec.Code, rec.Body.String())
+	}
+	if writeErr != errRefused {
+		t.Errorf("write after the refusal: error = %v, want errRefused", writeErr)
+	}
+}
+
+// TestValidatorEarlyHints checks that a 103 response is sent ahead of
+// the final response rather than replacing its status.
+func TestValidatorEarlyHints(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", "</app.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		thing(200, "application/json", "1", `{"name":"a"}`).ServeHTTP(w, r)
+	})
+	srv := httptest.NewServer(newTestValidator(t, Enforce).Wrap(h))
+	defer srv.Close()
+
+	conn, err := (&net.Dialer{}).Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /things/a HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(raw)
+	hints, final := strings.Index(s, "HTTP/1.1 103 "), strings.Index(s, "HTTP/1.1 200 ")
+	if hints != 0 || final < 0 {
+		t.Fatalf("response = %q, want a 103 then a 200", s)
+	}
+	if !strings.Contains(s[:final], "Link: </app.css>") {
+		t.Errorf("103 response = %q, want the Link header", s[:final])
+	}
+	if !strings.HasSuffix(s, `{"name":"a"}`) {
+		t.Errorf("response = %q, want the body", s)
+	}
+}
+
+// BenchmarkWrap measures a listUsers response that matches the
+// description.
+func BenchmarkWrap(b *testing.B) {
+	v, err := NewValidator(Config{Mode: Log}, zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	h := thing(200, "application/json", "", `[]`)
+	httpfxtest.Benchmark(b, v.Wrap(h))
+}
@@ -0,0 +1,219 @@
+// Package cors lets browser pages from other origins call the API, as
+// the Config allows. Preflight requests are answered by the middleware
+// itself and never reach the routes.
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Config says which cross-origin requests browsers may make.
+type Config struct {
+	// AllowedOrigins are the origins allowed, such as
+	// https://app.example.com; https://*.example.com allows every
+	// subdomain, and * every origin. Cross-origin requests are not
+	// allowed if it is empty.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// AllowedMethods are the methods a preflight may ask for.
+	AllowedMethods []string `yaml:"allowed_methods"`
+	// AllowedHeaders are the request headers a preflight may ask for.
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// ExposedHeaders are the response headers pages may read, beyond
+	// the few browsers always expose.
+	ExposedHeaders []string `yaml:"exposed_headers"`
+	// AllowCredentials lets requests carry cookies. It cannot be used
+	// with the * origin.
+	AllowCredentials bool `yaml:"allow_credentials"`
+	// MaxAge is how long browsers may cache a preflight's answer.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// DefaultConfig allows no origin. Once some are, they may use the
+// methods of the API and the headers its clients send, and read the
+// headers it answers with.
+func DefaultConfig() Config {
+	return Config{
+		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "If-Match", "If-None-Match", "X-Request-ID"},
+		ExposedHeaders: []string{"ETag", "Location", "Retry-After", "X-Request-ID"},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// Middleware adds the CORS headers to the responses to allowed origins
+// and answers their preflights.
+// オリジン間リクエストの許可
+type Middleware struct {
+	cfg      Config
+	log      *zap.Logger
+	any      bool
+	exact    map[string]bool
+	wildcard []subdomains
+	methods  map[string]bool
+	headers  map[string]bool
+}
+
+// subdomains matches the origins of every subdomain of a host, such as
+// https://*.example.com.
+type subdomains struct {
+	scheme string // "https://"
+	suffix string // ".example.com"
+}
+
+func (d subdomains) match(origin string) bool {
+	rest, ok := strings.CutPrefix(origin, d.scheme)
+	return ok && len(rest) > len(d.suffix) && strings.HasSuffix(rest, d.suffix)
+}
+
+// New builds the Middleware of cfg.
+func New(cfg Config, log *zap.Logger) (*Middleware, error) {
+	m := &Middleware{cfg: cfg, log: log, exact: make(map[string]bool), methods: make(map[string]bool), headers: make(map[string]bool)}
+	for _, o := range cfg.AllowedOrigins {
+		o = strings.ToLower(strings.TrimSuffix(o, "/"))
+		switch {
+		case o == "*":
+			m.any = true
+		case strings.Contains(o, "://*."):
+			scheme, suffix, _ := strings.Cut(o, "://*")
+			m.wildcard = append(m.wildcard, subdomains{scheme: scheme + "://", suffix: suffix})
+		default:
+			m.exact[o] = true
+		}
+	}
+	if m.any && cfg.AllowCredentials {
+		return nil, errors.New("cors: allow_credentials cannot be used with the * origin")
+	}
+	for _, method := range cfg.AllowedMethods {
+		m.methods[strings.ToUpper(method)] = true
+	}
+	for _, h := range cfg.AllowedHeaders {
+		m.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	return m, nil
+}
+
+// Name names the middleware in traces.
+func (*Middleware) Name() string {
+	return "cors"
+}
+
+// Order runs CORS before the rate limiter, so that a browser's
+// preflight does not use up the request it precedes.
+func (*Middleware) Order() int {
+	return -95
+}
+
+// Wrap returns a handler adding the CORS headers.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	if len(m.cfg.AllowedOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		if !m.any {
+			h.Add("Vary", "Origin") // オリジンごとに応答が異なる
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			m.preflight(w, r, origin)
+			return
+		}
+		if m.allowed(origin) {
+			m.allowOrigin(h, origin)
+			if len(m.cfg.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(m.cfg.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflight answers a preflight request. When the request it precedes
+// is not allowed, the answer has no CORS headers, which browsers take as
+// a refusal.
+func (m *Middleware) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	method := r.Header.Get("Access-Control-Request-Method")
+	requested := requestedHeaders(r)
+	switch {
+	case !m.allowed(origin):
+		m.log.Debug("Refused CORS preflight", zap.String("origin", origin), zap.String("reason", "origin"))
+	case !m.methods[method]:
+		m.log.Debug("Refused CORS preflight", zap.String("origin", origin), zap.String("reason", "method"), zap.String("method", method))
+	case !m.allowHeaders(requested):
+		m.log.Debug("Refused CORS preflight", zap.String("origin", origin), zap.String("reason", "headers"), zap.Strings("headers", requested))
+	default:
+		m.allowOrigin(h, origin)
+		h.Set("Access-Control-Allow-Methods", strings.Join(m.cfg.AllowedMethods, ", "))
+		if len(requested) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		if m.cfg.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(m.cfg.MaxAge.Seconds())))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowed reports whether the origin may make cross-origin requests.
+func (m *Middleware) allowed(origin string) bool {
+	if m.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if m.exact[origin] {
+		return true
+	}
+	for _, d := range m.wildcard {
+		if d.match(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Middleware) allowOrigin(h http.Header, origin string) {
+	if m.any {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if m.cfg.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (m *Middleware) allowHeaders(requested []string) bool {
+	for _, name := range requested {
+		if !m.headers[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// requestedHeaders returns the headers a preflight asks for, in
+// canonical form.
+func requestedHeaders(r *http.Request) []string {
+	var names []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
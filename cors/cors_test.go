@@ -0,0 +1,18 @@
+package cors
+
+import (
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	cfg := DefaultConfig()
+	cfg.AllowedOrigins = []string{"https://app.example.com", "https://*.example.org"}
+	m, err := New(cfg, zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	httpfxtest.Benchmark(b, m.Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,284 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+// These tests run the module against fakeDriver, a database/sql driver
+// that keeps no data but can be stopped, hung and failed over. They can
+// be skipped by setting FXDEMO_SKIP_INTEGRATION.
+
+const fakeDriverName = "fxdemo-fake"
+
+func init() {
+	sql.Register(fakeDriverName, fakeDriver{})
+}
+
+func integration(t *testing.T) {
+	t.Helper()
+	if os.Getenv("FXDEMO_SKIP_INTEGRATION") != "" {
+		t.Skip("FXDEMO_SKIP_INTEGRATION is set")
+	}
+}
+
+// fakeServer is the database a DSN of fakeDriver names.
+type fakeServer struct {
+	mu         sync.Mutex
+	down       bool // refuses connections, and breaks those it had
+	hang       bool // answers pings only when they are canceled
+	generation int  // incremented by each failover
+	opened     int  // connections opened so far
+	open       map[*fakeConn]bool
+}
+
+var (
+	serversMu sync.Mutex
+	servers   = map[string]*fakeServer{}
+)
+
+// newFakeServer returns a server that is up, and the Config of a
+// database on it.
+func newFakeServer(t *testing.T) (*fakeServer, Config) {
+	t.Helper()
+	s := &fakeServer{open: map[*fakeConn]bool{}}
+	serversMu.Lock()
+	servers[t.Name()] = s
+	serversMu.Unlock()
+	t.Cleanup(func() {
+		serversMu.Lock()
+		delete(servers, t.Name())
+		serversMu.Unlock()
+	})
+	cfg := DefaultConfig()
+	cfg.Driver = fakeDriverName
+	cfg.DSN = t.Name()
+	cfg.PingTimeout = 100 * time.Millisecond
+	return s, cfg
+}
+
+func (s *fakeServer) set(f func(*fakeServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s)
+}
+
+// failover moves the server to a new primary: the connections to the
+// previous one are broken, but new ones succeed.
+func (s *fakeServer) failover() {
+	s.set(func(s *fakeServer) { s.generation++ })
+}
+
+func (s *fakeServer) stats() (opened, open int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opened, len(s.open)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	serversMu.Lock()
+	s := servers[dsn]
+	serversMu.Unlock()
+	if s == nil {
+		return nil, errors.New("no such server")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return nil, errors.New("connection refused")
+	}
+	c := &fakeConn{server: s, generation: s.generation}
+	s.opened++
+	s.open[c] = true
+	return c, nil
+}
+
+type fakeConn struct {
+	server     *fakeServer
+	generation int
+}
+
+var (
+	_ driver.Pinger          = (*fakeConn)(nil)
+	_ driver.SessionResetter = (*fakeConn)(nil)
+)
+
+// broken reports whether the connection was lost, as a driver finds out
+// when it reuses the connection.
+func (c *fakeConn) broken() bool {
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.down || s.generation != c.generation
+}
+
+// ResetSession is called by database/sql before it reuses the
+// connection, which it replaces with a new one if this fails.
+func (c *fakeConn) ResetSession(context.Context) error {
+	if c.broken() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	s := c.server
+	s.mu.Lock()
+	hang := s.hang
+	s.mu.Unlock()
+	switch {
+	case c.broken():
+		return driver.ErrBadConn
+	case hang:
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.server.set(func(s *fakeServer) { delete(s.open, c) })
+	return nil
+}
+
+func (*fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (*fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func TestLifecycle(t *testing.T) {
+	integration(t)
+	s, cfg := newFakeServer(t)
+	lc := fxtest.NewLifecycle(t)
+	db, err := New(lc, cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, _ := s.stats(); opened != 0 {
+		t.Errorf("%d connections opened before the application started, want none", opened)
+	}
+	lc.RequireStart()
+	if opened, _ := s.stats(); opened != 1 {
+		t.Errorf("%d connections opened by the start, want the ping's", opened)
+	}
+	if stats, ok := expvar.Get("db_stats").(expvar.Func)().(sql.DBStats); !ok || stats.OpenConnections != 1 {
+		t.Errorf("db_stats = %+v, want the pool's", expvar.Get("db_stats"))
+	}
+
+	lc.RequireStop()
+	if _, open := s.stats(); open != 0 {
+		t.Errorf("%d connections still open after the stop", open)
+	}
+	if err := db.Ping(); err == nil {
+		t.Error("Ping() after the stop succeeded, want the pool closed")
+	}
+	if got := expvar.Get("db_stats").(expvar.Func)(); got != nil {
+		t.Errorf("db_stats after the stop = %+v, want null", got)
+	}
+}
+
+func TestStartFails(t *testing.T) {
+	integration(t)
+	tests := []struct {
+		name  string
+		fault func(*fakeServer)
+		err   string
+	}{
+		{"server down", func(s *fakeServer) { s.down = true }, "connection refused"},
+		{"ping timeout", func(s *fakeServer) { s.hang = true }, "deadline exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, cfg := newFakeServer(t)
+			s.set(tt.fault)
+			lc := fxtest.NewLifecycle(t)
+			if _, err := New(lc, cfg, zap.NewNop()); err != nil {
+				t.Fatal(err)
+			}
+			err := lc.Start(context.Background())
+			if err == nil || !strings.Contains(err.Error(), "db: ping") || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Start() error = %v, want a failed ping mentioning %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	integration(t)
+	lc := fxtest.NewLifecycle(t)
+	if db, err := New(lc, DefaultConfig(), zap.NewNop()); db != nil || err != nil {
+		t.Errorf("New() without a DSN = %v, %v, want no database", db, err)
+	}
+	if err := NewCheck(nil).Check(context.Background()); err != nil {
+		t.Errorf("check without a database = %v, want it to pass", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Driver, cfg.DSN = "missing", "x"
+	_, err := New(lc, cfg, zap.NewNop())
+	if err == nil || !strings.Contains(err.Error(), `no driver "missing"`) || !strings.Contains(err.Error(), fakeDriverName) {
+		t.Errorf("New() with an unknown driver: error = %v, want the drivers linked in", err)
+	}
+}
+
+// TestHealthCheckFailover checks that the health check follows the
+// database as it goes down, comes back, and fails over to a new primary
+// whose address the pool's connections do not know.
+func TestHealthCheckFailover(t *testing.T) {
+	integration(t)
+	s, cfg := newFakeServer(t)
+	lc := fxtest.NewLifecycle(t)
+	db, err := New(lc, cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc.RequireStart()
+	defer lc.RequireStop()
+	check := NewCheck(db)
+	if check.Name() != "database" {
+		t.Errorf("Name() = %q", check.Name())
+	}
+
+	steps := []struct {
+		name    string
+		change  func(*fakeServer)
+		healthy bool
+	}{
+		{"up", func(*fakeServer) {}, true},
+		{"down", func(s *fakeServer) { s.down = true }, false},
+		{"back up", func(s *fakeServer) { s.down = false }, true},
+		{"failed over", func(s *fakeServer) { s.generation++ }, true},
+		{"hung", func(s *fakeServer) { s.hang = true }, false},
+		{"recovered", func(s *fakeServer) { s.hang = false }, true},
+	}
+	for _, step := range steps {
+		s.set(step.change)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := check.Check(ctx)
+		cancel()
+		if (err == nil) != step.healthy {
+			t.Errorf("%s: Check() = %v, want healthy %v", step.name, err, step.healthy)
+		}
+	}
+
+	// 切り替え後は古い接続を捨てて新しいプライマリにつなぎ直す
+	opened, _ := s.stats()
+	s.failover()
+	if err := check.Check(context.Background()); err != nil {
+		t.Fatalf("Check() after a failover = %v", err)
+	}
+	if now, _ := s.stats(); now <= opened {
+		t.Errorf("no connection opened after the failover (%d before, %d after)", opened, now)
+	}
+}
@@ -0,0 +1,132 @@
+// Package deadline gives every request a deadline and derives the
+// timeouts of the calls made on its behalf from what is left of it, so
+// that no backend is waited on for longer than the client will wait.
+package deadline
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Header lets a client state how long it will wait, as a Go duration
+// such as "2.5s". The server never waits longer than Config.Max.
+const Header = "X-Request-Timeout"
+
+// Config bounds how long requests may take.
+type Config struct {
+	Default time.Duration `yaml:"default"` // Default applies when the client states no timeout.
+	Max     time.Duration `yaml:"max"`     // Max caps the timeout a client may ask for.
+	// Margin is kept back from downstream calls, leaving time to
+	// write a response once they have timed out.
+	Margin time.Duration `yaml:"margin"`
+}
+
+// DefaultConfig gives requests 30 seconds, and keeps 50 milliseconds back.
+func DefaultConfig() Config {
+	return Config{
+		Default: 30 * time.Second,
+		Max:     60 * time.Second,
+		Margin:  50 * time.Millisecond,
+	}
+}
+
+type marginKey struct{}
+
+// Middleware sets the deadline of each request.
+// リクエストに期限を設定する
+type Middleware struct {
+	cfg Config
+}
+
+// NewMiddleware builds a new Middleware.
+func NewMiddleware(cfg Config) *Middleware {
+	return &Middleware{cfg: cfg}
+}
+
+// Wrap returns a handler that calls next with a context that expires
+// when the client stops waiting.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := m.cfg.Default
+		if d, err := time.ParseDuration(r.Header.Get(Header)); err == nil && d > 0 {
+			timeout = d
+		}
+		if m.cfg.Max > 0 && timeout > m.cfg.Max {
+			timeout = m.cfg.Max
+		}
+		ctx := context.WithValue(r.Context(), marginKey{}, m.cfg.Margin)
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Remaining returns how long is left before the deadline of ctx, and
+// false if it has none.
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(d), true
+}
+
+// Downstream returns a context for a call to a backend, which expires
+// after limit or, if sooner, the safety margin before the request's
+// deadline. If there is no time left for the call, the returned context
+// has already expired.
+func Downstream(ctx context.Context, limit time.Duration) (context.Context, context.CancelFunc) {
+	left, ok := Remaining(ctx)
+	if !ok {
+		if limit <= 0 {
+			return context.WithCancel(ctx)
+		}
+		return context.WithTimeout(ctx, limit)
+	}
+	margin, _ := ctx.Value(marginKey{}).(time.Duration)
+	timeout := left - margin
+	if limit > 0 && limit < timeout {
+		timeout = limit
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Transport is an http.RoundTripper that bounds each outgoing request
+// with Downstream.
+type Transport struct {
+	Base  http.RoundTripper // Base sends the request; nil means http.DefaultTransport.
+	Limit time.Duration     // Limit is the longest any single call may take; zero means no limit.
+}
+
+// RoundTrip sends req with a deadline derived from its context.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx, cancel := Downstream(req.Context(), t.Limit)
+	resp, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody releases the context of a call once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
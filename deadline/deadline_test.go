@@ -0,0 +1,11 @@
+package deadline
+
+import (
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	httpfxtest.Benchmark(b, NewMiddleware(DefaultConfig()).Wrap(httpfxtest.Noop))
+}
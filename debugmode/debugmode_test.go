@@ -0,0 +1,24 @@
+package debugmode
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+// BenchmarkWrap measures requests without a token, with the middleware
+// configured to check those that have one.
+func BenchmarkWrap(b *testing.B) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	m, err := NewMiddleware(Config{PublicKey: base64.StdEncoding.EncodeToString(pub)}, zap.NewNop(), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	httpfxtest.Benchmark(b, m.Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,210 @@
+// Package declared builds routes from their declaration in the
+// configuration, for the trivial ones that need no code: fixed
+// responses, redirects and reverse proxies.
+//
+//	declared:
+//	  routes:
+//	    - pattern: /robots.txt
+//	      static: {content_type: text/plain, body: "User-agent: *\nDisallow: /api/\n"}
+//	    - pattern: /docs/
+//	      redirect: {to: https://docs.example.com/, keep_path: true, status: 301}
+//	    - pattern: /status/
+//	      proxy: {url: http://status.internal:9000, strip_prefix: true}
+package declared
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/deadline"
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+// Config declares the routes.
+type Config struct {
+	Routes []Definition `yaml:"routes"`
+}
+
+// DefaultConfig declares no routes.
+func DefaultConfig() Config {
+	return Config{}
+}
+
+// Definition is one route: its pattern and exactly one of the ways it
+// can respond.
+type Definition struct {
+	Pattern  string    `yaml:"pattern"`
+	Static   *Static   `yaml:"static"`
+	Redirect *Redirect `yaml:"redirect"`
+	Proxy    *Proxy    `yaml:"proxy"`
+}
+
+// Static is a fixed response to GET and HEAD requests.
+type Static struct {
+	Status      int               `yaml:"status"` // 200 if zero
+	ContentType string            `yaml:"content_type"`
+	Headers     map[string]string `yaml:"headers"`
+	Body        string            `yaml:"body"`
+}
+
+// Redirect sends clients elsewhere.
+type Redirect struct {
+	To     string `yaml:"to"`
+	Status int    `yaml:"status"` // 302 if zero
+	// KeepPath appends the part of the path below the pattern, and the
+	// query, to To.
+	KeepPath bool `yaml:"keep_path"`
+}
+
+// Proxy forwards requests to another server.
+type Proxy struct {
+	URL string `yaml:"url"`
+	// StripPrefix removes the pattern from the path of forwarded
+	// requests.
+	StripPrefix bool          `yaml:"strip_prefix"`
+	Timeout     time.Duration `yaml:"timeout"` // no limit but the request's deadline if zero
+}
+
+// Route is a route built from a Definition.
+type Route struct {
+	http.Handler
+	pattern string
+	kind    string
+}
+
+// Pattern reports the path at which this is registered.
+func (r *Route) Pattern() string {
+	return r.pattern
+}
+
+// String describes the route, such as `redirect "/docs/"`.
+func (r *Route) String() string {
+	return fmt.Sprintf("%s %q", r.kind, r.pattern)
+}
+
+// NormalizationExempt reports whether the route's paths are left as
+// they were requested: file names and the paths of proxied services
+// are not ours to normalize.
+func (r *Route) NormalizationExempt() bool {
+	return r.kind == "static" || r.kind == "proxy"
+}
+
+// NewRoutes builds the declared routes, failing on the first declaration
+// that is not valid.
+// 設定で宣言されたルートを作る
+func NewRoutes(cfg Config, log *zap.Logger) ([]httpfx.Route, error) {
+	routes := make([]httpfx.Route, 0, len(cfg.Routes))
+	for i, def := range cfg.Routes {
+		r, err := build(def, log)
+		if err != nil {
+			return nil, fmt.Errorf("declared: route %d (%q): %w", i, def.Pattern, err)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+func build(def Definition, log *zap.Logger) (*Route, error) {
+	if !strings.HasPrefix(def.Pattern, "/") {
+		return nil, fmt.Errorf("pattern must start with a slash")
+	}
+	n := 0
+	for _, set := range []bool{def.Static != nil, def.Redirect != nil, def.Proxy != nil} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, fmt.Errorf("exactly one of static, redirect and proxy must be given")
+	}
+	switch {
+	case def.Static != nil:
+		return &Route{Handler: static(*def.Static), pattern: def.Pattern, kind: "static"}, nil
+	case def.Redirect != nil:
+		h, err := redirect(def.Pattern, *def.Redirect)
+		return &Route{Handler: h, pattern: def.Pattern, kind: "redirect"}, err
+	default:
+		h, err := proxy(def.Pattern, *def.Proxy, log)
+		return &Route{Handler: h, pattern: def.Pattern, kind: "proxy"}, err
+	}
+}
+
+func static(s Static) http.Handler {
+	status := s.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			apperror.Write(w, r, nil, apperror.New(http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed here", r.Method))
+			return
+		}
+		for k, v := range s.Headers {
+			w.Header().Set(k, v)
+		}
+		if s.ContentType != "" {
+			w.Header().Set("Content-Type", s.ContentType)
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(s.Body)))
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write([]byte(s.Body))
+		}
+	})
+}
+
+func redirect(pattern string, rd Redirect) (http.Handler, error) {
+	status := rd.Status
+	if status == 0 {
+		status = http.StatusFound
+	}
+	if status < 300 || status > 399 {
+		return nil, fmt.Errorf("redirect status %d is not a redirection", status)
+	}
+	if rd.To == "" {
+		return nil, fmt.Errorf("redirect has no target")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		to := rd.To
+		if rd.KeepPath {
+			to += strings.TrimPrefix(r.URL.Path, pattern)
+			if r.URL.RawQuery != "" {
+				to += "?" + r.URL.RawQuery
+			}
+		}
+		http.Redirect(w, r, to, status)
+	}), nil
+}
+
+func proxy(pattern string, p Proxy, log *zap.Logger) (http.Handler, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("proxy has an invalid URL %q", p.URL)
+	}
+	rp := httputil.NewSingleHostReverseProxy(u)
+	rp.Transport = &deadline.Transport{Limit: p.Timeout}
+	direct := rp.Director
+	rp.Director = func(r *http.Request) {
+		direct(r)
+		// 生成したIDも上流に渡し、両方のログを突き合わせられるようにする
+		if id := telemetry.From(r.Context()).RequestID; id != "" {
+			r.Header.Set(telemetry.RequestIDHeader, id)
+		}
+	}
+	rp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		telemetry.Logger(r.Context(), log).Error("Failed to proxy request", zap.String("to", p.URL), zap.Error(err))
+		apperror.Write(w, r, nil, apperror.New(http.StatusBadGateway, "bad_gateway", "The upstream server could not be reached"))
+	}
+	if p.StripPrefix {
+		return http.StripPrefix(strings.TrimSuffix(pattern, "/"), rp), nil
+	}
+	return rp, nil
+}
@@ -0,0 +1,148 @@
+// Package degrade keeps track of the features that are running on their
+// fallback, so that the server can shed optional work while it or the
+// services it depends on are struggling.
+//
+// Features register the fallback they have when the server starts and
+// check Degraded on their hot path. Operators switch features between
+// their normal and degraded behaviour at /admin/degradations.
+package degrade
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/redact"
+	"go.uber.org/zap"
+)
+
+// Feature is the degradation state of a feature.
+type Feature struct {
+	Name     string     `json:"name"`
+	Fallback string     `json:"fallback"` // Fallback describes what the feature does when degraded.
+	Degraded bool       `json:"degraded"`
+	Reason   string     `json:"reason,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+// Registry holds the degradation state of every registered feature.
+// 機能ごとの縮退状態
+type Registry struct {
+	log *zap.Logger
+
+	mu       sync.RWMutex
+	features map[string]*Feature
+}
+
+// NewRegistry builds an empty Registry.
+func NewRegistry(log *zap.Logger) *Registry {
+	return &Registry{log: log, features: make(map[string]*Feature)}
+}
+
+// Register adds a feature, described by its fallback, in its normal state.
+func (r *Registry) Register(name, fallback string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.features[name]; !ok {
+		r.features[name] = &Feature{Name: name, Fallback: fallback}
+	}
+}
+
+// Degraded reports whether the feature should use its fallback.
+func (r *Registry) Degraded(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	f, ok := r.features[name]
+	return ok && f.Degraded
+}
+
+// Set degrades or restores the feature. It reports false if the feature
+// is not registered.
+func (r *Registry) Set(name string, degraded bool, reason string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.features[name]
+	if !ok {
+		return false
+	}
+	if f.Degraded == degraded {
+		return true
+	}
+	f.Degraded = degraded
+	if degraded {
+		now := time.Now()
+		f.Reason, f.Since = reason, &now
+		r.log.Warn("Feature degraded", zap.String("feature", name), zap.String("fallback", f.Fallback), zap.String("reason", reason))
+	} else {
+		f.Reason, f.Since = "", nil
+		r.log.Info("Feature restored", zap.String("feature", name))
+	}
+	return true
+}
+
+// Features returns the state of every feature, sorted by name.
+func (r *Registry) Features() []Feature {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Feature, 0, len(r.features))
+	for _, f := range r.features {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Handler serves the degradation state at /admin/degradations. A POST of
+// {"name": ..., "degraded": ..., "reason": ...} changes the state of a
+// feature; it is only accepted from the loopback interface.
+type Handler struct {
+	registry *Registry
+}
+
+// NewHandler builds a new Handler.
+func NewHandler(registry *Registry) *Handler {
+	return &Handler{registry: registry}
+}
+
+// Pattern reports the path at which this is registered.
+func (*Handler) Pattern() string {
+	return "/admin/degradations"
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		if !clientip.IsLoopback(r) {
+			apperror.Write(w, r, nil, apperror.Forbidden("Degradations are only changed from the host"))
+			return
+		}
+		var change struct {
+			Name     string `json:"name"`
+			Degraded bool   `json:"degraded"`
+			Reason   string `json:"reason"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&change); err != nil {
+			apperror.Write(w, r, nil, apperror.BadRequest("Invalid request: %v", err))
+			return
+		}
+		if !h.registry.Set(change.Name, change.Degraded, change.Reason) {
+			apperror.Write(w, r, nil, apperror.NotFound("Unknown feature"))
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		apperror.Write(w, r, nil, apperror.New(http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed here", r.Method))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	// 理由は自由記述なので秘密が紛れうる
+	redact.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(h.registry.Features())
+	})).ServeHTTP(w, r)
+}
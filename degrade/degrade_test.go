@@ -0,0 +1,43 @@
+package degrade
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// TestRegistryConcurrent registers, switches and reads features from
+// many goroutines at once. Run it with -race.
+func TestRegistryConcurrent(t *testing.T) {
+	const goroutines = 16
+	r := NewRegistry(zap.NewNop())
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("feature-%d", i%4)
+			r.Register(name, "fallback")
+			for j := range 200 {
+				r.Set(name, j%2 == 0, "load")
+				r.Degraded(name)
+				r.Features()
+			}
+			// 最後は全員が縮退させる
+			r.Set(name, true, "load")
+		}()
+	}
+	wg.Wait()
+
+	features := r.Features()
+	if len(features) != 4 {
+		t.Fatalf("features = %+v, want 4", features)
+	}
+	for _, f := range features {
+		if !f.Degraded || f.Since == nil || f.Reason != "load" {
+			t.Errorf("%s = %+v, want degraded since a time, for load", f.Name, f)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+//go:build demo
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/events"
+	"example.com/fxdemo/limits"
+	"example.com/fxdemo/links"
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/shape"
+	"example.com/fxdemo/telemetry"
+	"example.com/fxdemo/versioning"
+	"example.com/fxdemo/worker"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// demoRoutes provides the toy endpoints /echo, /hello and /hello/later,
+// and logs the greetings /hello sends. They are only built with the demo tag, so
+// that a production build serves the real API alone:
+//
+//	go run -tags demo .
+//
+// デモ用のハンドラ
+var demoRoutes = fx.Provide(
+	httpfx.AsRoute[*EchoHandler](NewEchoHandler), // AsRouteでハンドラをラップしている
+	httpfx.AsRoute[*HelloHandler](NewHelloHandler),
+	httpfx.AsRouteVersion[*HelloV2Handler](NewHelloV2Handler, 2), // JSONで返すバージョン2
+	events.AsSubscriber[events.Subscriber](NewGreetingLogger),    // greeting.sentをログに出す
+	httpfx.AsRoute[*LaterHandler](NewLaterHandler),               // ジョブキューの例
+)
+
+// GreetingSent is published by HelloHandler for each greeting it sends.
+var GreetingSent = events.NewTopic[SentGreeting]("greeting.sent")
+
+// SentGreeting is the data of GreetingSent.
+type SentGreeting struct {
+	Name string `json:"name"`
+}
+
+// NewGreetingLogger builds a subscriber logging the greetings sent.
+// 送った挨拶をバックグラウンドでログに出す
+func NewGreetingLogger(log *zap.Logger) events.Subscriber {
+	return events.On(GreetingSent, func(ctx context.Context, g SentGreeting) {
+		telemetry.Logger(ctx, log).Info("Greeting sent", zap.String("name", g.Name))
+	})
+}
+
+// EchoHandler is an http.Handler that copies its request body
+// back to the response.
+type EchoHandler struct {
+	log *zap.Logger
+}
+
+// HelloHandler is an HTTP handler that
+// prints a greeting to the user.
+// 新たに作成したハンドラ Helloと返す
+type HelloHandler struct {
+	bus *events.Bus
+	log *zap.Logger
+}
+
+// HelloV2Handler is version 2 of HelloHandler: it returns the
+// greeting as JSON. The greeting is moving from "greeting" to
+// "message", next to the name it was made for.
+type HelloV2Handler struct {
+	links *links.Builder
+	log   *zap.Logger
+}
+
+// NewEchoHandler builds a new EchoHandler.
+// Echoハンドラのインスタンスを生成する関数
+func NewEchoHandler(log *zap.Logger) *EchoHandler {
+	return &EchoHandler{log: log}
+}
+
+// NewHelloHandler builds a new HelloHandler.
+// HelloHandlerインスタンスを生成する
+func NewHelloHandler(bus *events.Bus, log *zap.Logger) *HelloHandler {
+	return &HelloHandler{bus: bus, log: log}
+}
+
+// NewHelloV2Handler builds a new HelloV2Handler.
+func NewHelloV2Handler(links *links.Builder, log *zap.Logger) *HelloV2Handler {
+	return &HelloV2Handler{links: links, log: log}
+}
+
+// ServeHTTP handles an HTTP request to the /echo endpoint.
+// EchoHandlerに付与するメソッド  リクエストボディをそのまま返す処理
+func (h *EchoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apperror.Handler(h.log, h.echo).ServeHTTP(w, r)
+}
+
+func (h *EchoHandler) echo(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return &apperror.Error{Status: http.StatusBadRequest, Code: apperror.CodeBadRequest, Message: "Failed to read the request body", Err: err}
+	}
+	if _, err := w.Write(body); err != nil {
+		telemetry.Logger(r.Context(), h.log).Warn("Failed to write response", zap.Error(err))
+	}
+	return nil
+}
+
+// HelloHandlerに付与するメソッド  リクエストボディにHelloを付けて返す
+func (h *HelloHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apperror.Handler(h.log, h.hello).ServeHTTP(w, r)
+}
+
+func (h *HelloHandler) hello(w http.ResponseWriter, r *http.Request) error {
+	log := telemetry.Logger(r.Context(), h.log) // デバッグトークン付きなら詳細ログを出す
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("reading request: %w", err))
+	}
+	log.Debug("Greeting", zap.Int("name_bytes", len(body)))
+	if _, err := fmt.Fprintf(w, "Hello, %s\n", body); err != nil {
+		log.Error("Failed to write response", zap.Error(err)) // 書き始めているのでエラーは返せない
+		return nil
+	}
+	GreetingSent.Publish(r.Context(), h.bus, SentGreeting{Name: string(body)})
+	return nil
+}
+
+// HelloV2Handlerに付与するメソッド  挨拶をJSONで返す
+func (h *HelloV2Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apperror.Handler(h.log, h.hello).ServeHTTP(w, r)
+}
+
+func (h *HelloV2Handler) hello(w http.ResponseWriter, r *http.Request) error {
+	log := telemetry.Logger(r.Context(), h.log)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("reading request: %w", err))
+	}
+	log.Debug("Greeting", zap.Int("name_bytes", len(body)))
+	w.Header().Set("Content-Type", versioning.MediaType(2))
+	greeting := map[string]any{
+		"message": "Hello, " + string(body),
+		"name":    string(body),
+		"_links":  links.Links{"self": h.links.Self(r)},
+	}
+	if err := json.NewEncoder(w).Encode(greeting); err != nil {
+		log.Error("Failed to write response", zap.Error(err))
+	}
+	return nil
+}
+
+// Migration moves clients to the new greeting shape as the
+// "hello_v2_message" flag is rolled out.
+// 旧形式 {"greeting": ...} への変換
+func (*HelloV2Handler) Migration() shape.Migration {
+	return shape.Migration{
+		Flag: "hello_v2_message",
+		Down: func(obj map[string]any) map[string]any {
+			return map[string]any{"greeting": obj["message"]}
+		},
+	}
+}
+
+// EchoHandlerにPattern()メソッドを追加
+func (*EchoHandler) Pattern() string {
+	return "/echo"
+}
+
+// HelloHandlerにPattern()メソッドを追加
+func (*HelloHandler) Pattern() string {
+	return "/hello"
+}
+
+// Pattern reports the path at which this is registered.
+func (*HelloV2Handler) Pattern() string {
+	return "/hello"
+}
+
+// Methods reports the methods this serves: the body is echoed back, so
+// only POST makes sense.
+func (*EchoHandler) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+// Limits keeps what is echoed back small, and gives up on slow
+// clients sooner than the default deadline.
+// 大きなボディをそのまま返さない
+func (*EchoHandler) Limits() limits.Limits {
+	return limits.Limits{MaxBodyBytes: 64 << 10, Timeout: 5 * time.Second}
+}
+
+// Methods reports the methods this serves. The name to greet is sent in
+// the body.
+// POSTのみ受け付ける
+func (*HelloHandler) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+// Methods reports the methods this serves, like version 1.
+func (*HelloV2Handler) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+// laterDelay is how long /hello/later waits before greeting.
+const laterDelay = 3 * time.Second
+
+// LaterHandler greets in the background: it queues a job that logs the
+// greeting after a delay, and answers 202 Accepted straight away.
+// 後で挨拶するジョブを積む
+type LaterHandler struct {
+	queue *worker.Queue
+	log   *zap.Logger
+}
+
+// NewLaterHandler builds a new LaterHandler.
+func NewLaterHandler(queue *worker.Queue, log *zap.Logger) *LaterHandler {
+	return &LaterHandler{queue: queue, log: log}
+}
+
+// Pattern reports the path at which this is registered.
+func (*LaterHandler) Pattern() string {
+	return "/hello/later"
+}
+
+// Methods reports the methods this serves. The name to greet is sent in
+// the body.
+func (*LaterHandler) Methods() []string {
+	return []string{http.MethodPost}
+}
+
+func (h *LaterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apperror.Handler(h.log, h.later).ServeHTTP(w, r)
+}
+
+func (h *LaterHandler) later(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return &apperror.Error{Status: http.StatusBadRequest, Code: apperror.CodeBadRequest, Message: "Failed to read the request body", Err: err}
+	}
+	switch err := h.queue.Enqueue(r.Context(), &laterGreeting{name: string(body), log: h.log}); {
+	case errors.Is(err, worker.ErrQueueFull), errors.Is(err, worker.ErrStopped):
+		w.Header().Set("Retry-After", "1")
+		return &apperror.Error{Status: http.StatusServiceUnavailable, Code: "busy", Message: "Too many greetings are waiting", Err: err}
+	case err != nil:
+		return apperror.Internal(err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// laterGreeting is the job LaterHandler queues.
+type laterGreeting struct {
+	name string
+	log  *zap.Logger
+}
+
+func (*laterGreeting) Name() string {
+	return "demo.later_greeting"
+}
+
+// Run logs the greeting after laterDelay, unless the server stops
+// first.
+func (g *laterGreeting) Run(ctx context.Context) error {
+	select {
+	case <-time.After(laterDelay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	telemetry.Logger(ctx, g.log).Info("Hello, later", zap.String("name", g.name))
+	return nil
+}
@@ -0,0 +1,71 @@
+// Package denylist blocks clients for a limited time.
+package denylist
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/pkg/clock"
+	"go.uber.org/zap"
+)
+
+// List is a set of client IPs that are refused until their entry expires.
+// 一時的に拒否するIPの一覧
+type List struct {
+	log   *zap.Logger
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]time.Time // client IP to expiry
+}
+
+// New builds an empty List.
+func New(log *zap.Logger) *List {
+	return newList(log, clock.Real)
+}
+
+func newList(log *zap.Logger, clk clock.Clock) *List {
+	return &List{log: log, clock: clk, entries: make(map[string]time.Time)}
+}
+
+// Add denies the client for the given duration. Adding a client that is
+// already denied extends its entry if the new expiry is later.
+func (l *List) Add(ip string, d time.Duration) {
+	expires := l.clock.Now().Add(d)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if expires.After(l.entries[ip]) {
+		l.entries[ip] = expires
+	}
+	l.log.Info("Client added to deny list", zap.String("client", ip), zap.Time("until", expires))
+}
+
+// Denied reports whether the client is currently denied.
+func (l *List) Denied(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	expires, ok := l.entries[ip]
+	if !ok {
+		return false
+	}
+	if l.clock.Now().After(expires) {
+		delete(l.entries, ip)
+		return false
+	}
+	return true
+}
+
+// Wrap returns a handler that refuses requests from denied clients.
+func (l *List) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.Denied(clientip.FromRequest(r)) {
+			apperror.Write(w, r, nil, apperror.Forbidden("Access denied"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
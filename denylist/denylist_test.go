@@ -0,0 +1,55 @@
+package denylist
+
+import (
+	"testing"
+	"time"
+
+	"example.com/fxdemo/pkg/clock/clocktest"
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+// TestExpiry adds and extends entries on a fake clock, checking at each
+// step which clients are denied.
+func TestExpiry(t *testing.T) {
+	fake := clocktest.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := newList(zap.NewNop(), fake)
+	steps := []struct {
+		name    string
+		advance time.Duration
+		add     map[string]time.Duration
+		denied  map[string]bool
+	}{
+		{"added", 0, map[string]time.Duration{"a": 10 * time.Minute, "b": time.Hour}, map[string]bool{"a": true, "b": true, "c": false}},
+		{"just before expiry", 10*time.Minute - 1, nil, map[string]bool{"a": true, "b": true}},
+		{"at expiry", 1, nil, map[string]bool{"a": true, "b": true}},
+		{"expired", 1, nil, map[string]bool{"a": false, "b": true}},
+		{"shorter entry kept", 0, map[string]time.Duration{"b": time.Minute}, map[string]bool{"b": true}},
+		{"past the shorter entry", 30 * time.Minute, nil, map[string]bool{"b": true}},
+		{"extended", 0, map[string]time.Duration{"b": time.Hour}, map[string]bool{"b": true}},
+		{"past the first entry", 30 * time.Minute, nil, map[string]bool{"b": true}},
+		{"past the extension", 30*time.Minute + 1, nil, map[string]bool{"b": false}},
+		{"denied again", 0, map[string]time.Duration{"a": time.Second}, map[string]bool{"a": true}},
+		{"expired again", 2 * time.Second, nil, map[string]bool{"a": false}},
+	}
+	for _, step := range steps {
+		fake.Advance(step.advance)
+		for ip, d := range step.add {
+			l.Add(ip, d)
+		}
+		for ip, want := range step.denied {
+			if got := l.Denied(ip); got != want {
+				t.Errorf("%s: Denied(%s) = %v, want %v", step.name, ip, got, want)
+			}
+		}
+	}
+	if len(l.entries) != 0 {
+		t.Errorf("entries = %v, want the expired ones dropped", l.entries)
+	}
+}
+
+func BenchmarkWrap(b *testing.B) {
+	l := New(zap.NewNop())
+	l.Add("192.0.2.1", time.Hour) // 他のクライアントが拒否されている
+	httpfxtest.Benchmark(b, l.Wrap(httpfxtest.Noop))
+}
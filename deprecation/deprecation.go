@@ -0,0 +1,163 @@
+// Package deprecation announces deprecated routes to their clients with
+// the Deprecation and Sunset headers (RFC 9745, RFC 8594) and keeps track
+// of who still calls them.
+package deprecation
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+// Notice describes when a route was deprecated and when it goes away.
+type Notice struct {
+	Since  time.Time // Since is when the route was deprecated.
+	Sunset time.Time // Sunset is when the route stops being served; zero if undecided.
+	Link   string    // Link documents the replacement, if there is one.
+}
+
+// Deprecated is implemented by routes that are deprecated.
+type Deprecated interface {
+	Deprecation() Notice
+}
+
+// maxClients bounds how many clients are tracked per route.
+const maxClients = 1000
+
+// Tracker records the use of deprecated routes by client.
+// 非推奨ルートの利用状況を記録する
+type Tracker struct {
+	log *zap.Logger
+
+	mu     sync.Mutex
+	routes map[string]*routeUsage // by pattern
+}
+
+type routeUsage struct {
+	notice  Notice
+	clients map[string]*ClientUsage // by client IP
+}
+
+// ClientUsage is how much one client used a deprecated route.
+type ClientUsage struct {
+	Client   string    `json:"client"`
+	Requests int64     `json:"requests"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// NewTracker builds a new Tracker.
+func NewTracker(log *zap.Logger) *Tracker {
+	return &Tracker{log: log, routes: make(map[string]*routeUsage)}
+}
+
+// Wrap returns h unchanged unless it is Deprecated, in which case the
+// returned handler announces the deprecation and records each use of
+// the route registered at pattern.
+func (t *Tracker) Wrap(pattern string, h http.Handler) http.Handler {
+	d, ok := h.(Deprecated)
+	if !ok {
+		return h
+	}
+	notice := d.Deprecation()
+	t.mu.Lock()
+	if usage, ok := t.routes[pattern]; ok {
+		usage.notice = notice // the mux was rebuilt; keep the usage recorded so far
+	} else {
+		t.routes[pattern] = &routeUsage{notice: notice, clients: make(map[string]*ClientUsage)}
+	}
+	t.mu.Unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(notice.Since.Unix(), 10))
+		if !notice.Sunset.IsZero() {
+			w.Header().Set("Sunset", notice.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if notice.Link != "" {
+			w.Header().Add("Link", "<"+notice.Link+`>; rel="deprecation"`)
+		}
+		client := clientip.FromRequest(r)
+		if t.record(pattern, client) {
+			telemetry.Logger(r.Context(), t.log).Warn("Deprecated route used",
+				zap.String("pattern", pattern),
+				zap.String("client", client),
+				zap.Time("sunset", notice.Sunset),
+			)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// record counts a request from client and reports whether it is the
+// first one from that client.
+func (t *Tracker) record(pattern, client string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := t.routes[pattern]
+	c, ok := usage.clients[client]
+	if !ok {
+		if len(usage.clients) >= maxClients {
+			return false
+		}
+		c = &ClientUsage{Client: client}
+		usage.clients[client] = c
+	}
+	c.Requests++
+	c.LastSeen = time.Now()
+	return !ok
+}
+
+// RouteReport is the usage of one deprecated route.
+type RouteReport struct {
+	Pattern string        `json:"pattern"`
+	Since   time.Time     `json:"since"`
+	Sunset  *time.Time    `json:"sunset,omitempty"`
+	Clients []ClientUsage `json:"clients"`
+}
+
+// Report returns the usage of every deprecated route, busiest clients first.
+func (t *Tracker) Report() []RouteReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := make([]RouteReport, 0, len(t.routes))
+	for pattern, usage := range t.routes {
+		rr := RouteReport{Pattern: pattern, Since: usage.notice.Since, Clients: []ClientUsage{}}
+		if !usage.notice.Sunset.IsZero() {
+			sunset := usage.notice.Sunset
+			rr.Sunset = &sunset
+		}
+		for _, c := range usage.clients {
+			rr.Clients = append(rr.Clients, *c)
+		}
+		sort.Slice(rr.Clients, func(i, j int) bool { return rr.Clients[i].Requests > rr.Clients[j].Requests })
+		report = append(report, rr)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Pattern < report[j].Pattern })
+	return report
+}
+
+// ReportHandler serves the Report of a Tracker as JSON.
+type ReportHandler struct {
+	tracker *Tracker
+}
+
+// NewReportHandler builds a new ReportHandler.
+func NewReportHandler(t *Tracker) *ReportHandler {
+	return &ReportHandler{tracker: t}
+}
+
+// Pattern reports the path at which this is registered.
+func (*ReportHandler) Pattern() string {
+	return "/debug/deprecations"
+}
+
+func (h *ReportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.tracker.Report())
+}
@@ -0,0 +1,99 @@
+// Package disposition builds Content-Disposition header values
+// as described in RFC 6266, including the RFC 5987 encoding of
+// non-ASCII filenames.
+package disposition
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// fallbackName is used when a filename has nothing usable left
+// after sanitizing.
+const fallbackName = "download"
+
+// Attachment returns a header value asking the client to save the
+// response as a file with the given name.
+func Attachment(filename string) string {
+	return format("attachment", filename)
+}
+
+// Inline returns a header value letting the client display the
+// response, while still suggesting a name should it be saved.
+func Inline(filename string) string {
+	return format("inline", filename)
+}
+
+func format(kind, filename string) string {
+	name := SanitizeFilename(filename)
+	ascii := asciiFallback(name)
+	if ascii == name {
+		return kind + `; filename="` + ascii + `"`
+	}
+	return kind + `; filename="` + ascii + `"; filename*=UTF-8''` + encodeExtValue(name)
+}
+
+// SanitizeFilename strips directory components and characters that
+// are unsafe in a filename or a quoted header parameter.
+func SanitizeFilename(filename string) string {
+	filename = strings.ReplaceAll(filename, `\`, "/")
+	filename = path.Base(filename)
+
+	var b strings.Builder
+	for _, r := range filename {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r):
+			continue
+		case r == '"', r == '/', r == ':', r == '*', r == '?', r == '<', r == '>', r == '|':
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	name := strings.Trim(b.String(), ". ")
+	if name == "" {
+		return fallbackName
+	}
+	return name
+}
+
+// asciiFallback replaces everything outside printable ASCII so the
+// name can be used in the plain filename parameter.
+func asciiFallback(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r < 0x20 || r > 0x7e {
+			b.WriteRune('_')
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// encodeExtValue percent-encodes s per the RFC 5987 attr-char rules.
+func encodeExtValue(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,89 @@
+// Package earlyhints sends 103 Early Hints (RFC 8297) ahead of HTML
+// pages, so that browsers start fetching a page's static assets while
+// the server is still rendering it.
+package earlyhints
+
+import (
+	"expvar"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Config lists the assets to preload for each route.
+type Config struct {
+	// Routes maps mux patterns to the Link header values sent for pages
+	// under them, such as "</static/app.css>; rel=preload; as=style".
+	Routes map[string][]string `yaml:"routes"`
+}
+
+// DefaultConfig sends no hints.
+func DefaultConfig() Config {
+	return Config{}
+}
+
+var sent = expvar.NewMap("early_hints_sent") // by pattern
+
+// Hinter sends the Early Hints configured for the route of each request
+// for an HTML page.
+// HTMLページの前に103 Early Hintsを送る
+type Hinter struct {
+	links map[string][]string
+	mux   *http.ServeMux // matches requests to the configured patterns
+}
+
+// NewHinter builds a new Hinter. It fails if a pattern of cfg is not a
+// valid mux pattern, or conflicts with another.
+func NewHinter(cfg Config) (*Hinter, error) {
+	h := &Hinter{links: cfg.Routes, mux: http.NewServeMux()}
+	for _, pattern := range slices.Sorted(maps.Keys(cfg.Routes)) {
+		if err := h.handle(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// handle registers pattern, returning the error http.ServeMux panics
+// with for an invalid or conflicting pattern.
+func (h *Hinter) handle(pattern string) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("earlyhints: route %q: %v", pattern, p)
+		}
+	}()
+	h.mux.Handle(pattern, http.NotFoundHandler())
+	return nil
+}
+
+// Wrap returns a handler that sends a 103 response with the preload
+// links of the request's route before calling next. The links are left
+// in the headers, so they are repeated in the final response as RFC 8297
+// recommends.
+func (h *Hinter) Wrap(next http.Handler) http.Handler {
+	if len(h.links) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wantsPage(r) {
+			if _, pattern := h.mux.Handler(r); pattern != "" {
+				for _, link := range h.links[pattern] {
+					w.Header().Add("Link", link)
+				}
+				w.WriteHeader(http.StatusEarlyHints)
+				sent.Add(pattern, 1)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wantsPage reports whether r is a browser's request for an HTML page
+// over a protocol that allows informational responses.
+func wantsPage(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		r.ProtoAtLeast(1, 1) &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
@@ -0,0 +1,17 @@
+package earlyhints
+
+import (
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	h, err := NewHinter(Config{Routes: map[string][]string{
+		"/static/": {"</static/app.css>; rel=preload; as=style"},
+	}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	httpfxtest.Benchmark(b, h.Wrap(httpfxtest.Noop))
+}
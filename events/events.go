@@ -0,0 +1,264 @@
+// Package events hands what happens inside the application, such as an
+// audit event or a usage threshold being crossed, to the components that
+// forward it elsewhere, without the packages raising events knowing
+// about them.
+//
+// Forwarders provide a Sink to the "events.sinks" value group:
+//
+//	fx.Provide(events.AsSink[*Bridge](NewBridge))
+//
+// Components that act on events inside the application subscribe to
+// typed topics instead, and are handed the events in the background:
+//
+//	var UserCreated = events.NewTopic[User]("user.created")
+//
+//	func NewWelcomer(log *zap.Logger) events.Subscriber {
+//		return events.On(UserCreated, func(ctx context.Context, u User) { ... })
+//	}
+//
+//	fx.Provide(events.AsSubscriber[*Welcomer](NewWelcomer))
+package events
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// subscriberQueue is how many events each subscriber may fall behind
+// by before those published to it are dropped.
+const subscriberQueue = 256
+
+// dropped counts the events dropped by kind, as their subscriber had
+// fallen too far behind.
+var dropped = expvar.NewMap("events_dropped")
+
+// Event is something that happened in the application.
+type Event struct {
+	// Kind classifies the event, as "honeypot.hit" or
+	// "usage.threshold_crossed".
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+	// Data describes the event; it is encoded as JSON by the sinks that
+	// need to.
+	Data any `json:"data,omitempty"`
+}
+
+// Sink receives the events of the Bus. Publish is called on the path of
+// the request that raised the event, so it must not block.
+type Sink interface {
+	Publish(ctx context.Context, e Event)
+}
+
+// SinksGroup is the value group of the sinks provided with AsSink.
+const SinksGroup = "events.sinks"
+
+// AsSink annotates the given constructor to state that it provides a
+// Sink to the "events.sinks" group, f returning an S.
+func AsSink[S Sink](f any) any {
+	return httpfx.AsGroup[Sink, S](f, SinksGroup)
+}
+
+// Subscriber handles the events of one kind, in the background.
+type Subscriber interface {
+	// Kind is the kind of the events handled.
+	Kind() string
+	// Handle handles e. It is called for one event at a time, in the
+	// order they were published, with the context they were published
+	// with, stripped of its cancellation.
+	Handle(ctx context.Context, e Event)
+}
+
+// SubscribersGroup is the value group of the subscribers provided with
+// AsSubscriber.
+const SubscribersGroup = "events.subscribers"
+
+// AsSubscriber annotates the given constructor to state that it
+// provides a Subscriber to the "events.subscribers" group, f returning
+// an S.
+func AsSubscriber[S Subscriber](f any) any {
+	return httpfx.AsGroup[Subscriber, S](f, SubscribersGroup)
+}
+
+// Topic is a kind of event whose Data is always a T.
+type Topic[T any] struct {
+	kind string
+}
+
+// NewTopic returns the topic of the events of kind, such as
+// "greeting.sent".
+func NewTopic[T any](kind string) Topic[T] {
+	return Topic[T]{kind: kind}
+}
+
+// Kind returns the kind of the events of t.
+func (t Topic[T]) Kind() string {
+	return t.kind
+}
+
+// Publish publishes data on bus as an event of t.
+func (t Topic[T]) Publish(ctx context.Context, bus *Bus, data T) {
+	bus.Publish(ctx, Event{Kind: t.kind, Data: data})
+}
+
+// On returns a Subscriber calling handle with the data of the events
+// of t.
+func On[T any](t Topic[T], handle func(ctx context.Context, data T)) Subscriber {
+	return subscriber[T]{topic: t, handle: handle}
+}
+
+type subscriber[T any] struct {
+	topic  Topic[T]
+	handle func(context.Context, T)
+}
+
+func (s subscriber[T]) Kind() string { return s.topic.kind }
+
+func (s subscriber[T]) Handle(ctx context.Context, e Event) {
+	data, ok := e.Data.(T)
+	if !ok {
+		panic(fmt.Sprintf("events: %q carries a %T rather than a %T", e.Kind, e.Data, data))
+	}
+	s.handle(ctx, data)
+}
+
+// Params are the dependencies of NewBus.
+type Params struct {
+	fx.In
+
+	Lifecycle   fx.Lifecycle
+	Log         *zap.Logger
+	Sinks       []Sink       `group:"events.sinks"`
+	Subscribers []Subscriber `group:"events.subscribers"`
+}
+
+// Bus hands each event to every sink, and queues it for the subscribers
+// of its kind. Without either, events go nowhere.
+// イベントを転送先と購読者に配る
+type Bus struct {
+	sinks []Sink
+	log   *zap.Logger
+
+	mu     sync.RWMutex        // guards queues against their closing on stop
+	queues map[string][]*queue // by kind
+}
+
+// queue holds the events published to a subscriber that it has yet to
+// handle.
+type queue struct {
+	sub    Subscriber
+	events chan published
+}
+
+type published struct {
+	ctx context.Context
+	e   Event
+}
+
+// NewBus builds a Bus over the sinks and the subscribers of the groups.
+// The subscribers are handed their events from when the Fx application
+// starts; those published before then wait in their queues. When it
+// stops, the bus waits for the subscribers to handle the events already
+// published, as long as Fx allows.
+func NewBus(p Params) *Bus {
+	b := &Bus{sinks: p.Sinks, log: p.Log, queues: make(map[string][]*queue)}
+	if len(p.Subscribers) == 0 {
+		return b
+	}
+	var all []*queue
+	for _, s := range p.Subscribers {
+		q := &queue{sub: s, events: make(chan published, subscriberQueue)}
+		b.queues[s.Kind()] = append(b.queues[s.Kind()], q)
+		all = append(all, q)
+	}
+	var wg sync.WaitGroup
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			for _, q := range all {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					b.run(q)
+				}()
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			// 以降のPublishは捨てる
+			b.mu.Lock()
+			queues := b.queues
+			b.queues = nil
+			b.mu.Unlock()
+			for _, qs := range queues {
+				for _, q := range qs {
+					close(q.events)
+				}
+			}
+			drained := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(drained)
+			}()
+			select {
+			case <-drained:
+				return nil
+			case <-ctx.Done():
+				return fmt.Errorf("events: subscribers still handling events: %w", ctx.Err())
+			}
+		},
+	})
+	return b
+}
+
+// Publish hands e to the sinks and queues it for its subscribers,
+// setting its Time if it is not set. Subscribers too far behind to take
+// it, and those of a stopped Bus, miss it. A nil Bus drops it.
+func (b *Bus) Publish(ctx context.Context, e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, s := range b.sinks {
+		s.Publish(ctx, e)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, q := range b.queues[e.Kind] {
+		select {
+		case q.events <- published{ctx: context.WithoutCancel(ctx), e: e}:
+		default:
+			dropped.Add(e.Kind, 1)
+		}
+	}
+}
+
+// run hands the events of q to its subscriber until q is closed.
+func (b *Bus) run(q *queue) {
+	for p := range q.events {
+		b.handle(q.sub, p)
+	}
+}
+
+// handle hands one event to s, recovering from a panic in it so that
+// the subscriber goes on with the next event.
+func (b *Bus) handle(s Subscriber, p published) {
+	defer func() {
+		if v := recover(); v != nil {
+			telemetry.Logger(p.ctx, b.log).Error("Subscriber panicked",
+				zap.String("kind", p.e.Kind),
+				zap.String("subscriber", fmt.Sprintf("%T", s)),
+				zap.Any("panic", v),
+			)
+		}
+	}()
+	s.Handle(p.ctx, p.e)
+}
@@ -0,0 +1,107 @@
+// Package fanout runs the parts of a request that can proceed
+// concurrently, such as one call per item of a batch, without letting a
+// large batch start an unbounded number of goroutines or one slow item
+// hold up the whole request.
+//
+// Unlike an errgroup, every task gets a result: the caller learns which
+// items succeeded even when others failed or ran out of time.
+package fanout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Options bounds how tasks are run. The zero value runs every task at
+// once with no timeout of its own, and runs all of them whatever fails.
+type Options struct {
+	Limit   int           // Limit is the most tasks run at a time; zero means no limit.
+	Timeout time.Duration // Timeout bounds each task; zero means only the parent context does.
+	// FailFast cancels the tasks still running or waiting once one of
+	// them fails. Those that had not started get the context's error.
+	FailFast bool
+}
+
+// Result is the outcome of one task.
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// Map calls f for each item concurrently, within the bounds of opts, and
+// returns the results in the order of the items once every call has
+// returned.
+// 並行実行して、すべての結果を返す
+func Map[In, Out any](ctx context.Context, items []In, opts Options, f func(context.Context, In) (Out, error)) []Result[Out] {
+	results := make([]Result[Out], len(items))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	limit := opts.Limit
+	if limit <= 0 || limit > len(items) {
+		limit = len(items)
+	}
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i, item := range items {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			// Nothing more will run: the remaining items fail as a batch.
+			for j := i; j < len(items); j++ {
+				results[j].Err = ctx.Err()
+			}
+			wg.Wait()
+			return results
+		}
+		wg.Add(1)
+		go func(i int, item In) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			tctx := ctx
+			if opts.Timeout > 0 {
+				var tcancel context.CancelFunc
+				tctx, tcancel = context.WithTimeout(ctx, opts.Timeout)
+				defer tcancel()
+			}
+			v, err := f(tctx, item)
+			results[i] = Result[Out]{Value: v, Err: err}
+			if err != nil && opts.FailFast {
+				cancel()
+			}
+		}(i, item)
+	}
+	wg.Wait()
+	return results
+}
+
+// Run is Map for tasks that are functions in their own right.
+func Run[T any](ctx context.Context, tasks []func(context.Context) (T, error), opts Options) []Result[T] {
+	return Map(ctx, tasks, opts, func(ctx context.Context, task func(context.Context) (T, error)) (T, error) {
+		return task(ctx)
+	})
+}
+
+// FirstError returns the error of the first failed result, or nil if
+// every task succeeded. Errors other than cancellation come first, as
+// with FailFast they are what cancelled the rest.
+func FirstError[T any](results []Result[T]) error {
+	var canceled error
+	for _, r := range results {
+		switch {
+		case r.Err == nil:
+		case errors.Is(r.Err, context.Canceled):
+			if canceled == nil {
+				canceled = r.Err
+			}
+		default:
+			return r.Err
+		}
+	}
+	return canceled
+}
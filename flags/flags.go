@@ -0,0 +1,59 @@
+// Package flags decides which clients a feature is turned on for.
+//
+// Each flag is on for a share of clients, chosen by hashing the flag name
+// with the tenant or, without one, the client IP: a client keeps the same
+// answer as the share grows.
+package flags
+
+import (
+	"hash/fnv"
+	"net/http"
+
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/telemetry"
+)
+
+// Flag is the rollout of a feature.
+type Flag struct {
+	Percent int      `yaml:"percent"` // Percent is the share of clients the flag is on for.
+	Tenants []string `yaml:"tenants"` // Tenants always have the flag on.
+}
+
+// Config holds the flags by name. Flags that are not configured are off.
+type Config map[string]Flag
+
+// DefaultConfig turns every flag off.
+func DefaultConfig() Config {
+	return Config{}
+}
+
+// Set evaluates the configured flags.
+// フィーチャーフラグの判定
+type Set struct {
+	cfg Config
+}
+
+// New builds a new Set.
+func New(cfg Config) *Set {
+	return &Set{cfg: cfg}
+}
+
+// Enabled reports whether the flag is on for the client that sent r.
+func (s *Set) Enabled(r *http.Request, name string) bool {
+	f, ok := s.cfg[name]
+	if !ok {
+		return false
+	}
+	client := telemetry.From(r.Context()).Tenant
+	for _, t := range f.Tenants {
+		if client != "" && t == client {
+			return true
+		}
+	}
+	if client == "" {
+		client = clientip.FromRequest(r)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name + "/" + client))
+	return int(h.Sum32()%100) < f.Percent
+}
@@ -0,0 +1,101 @@
+// Package fxstats measures where the time goes while the Fx application
+// starts, to help diagnose slow cold starts.
+//
+// fx v1.18 reports no event per constructor, so constructors are timed
+// through the invocations that build them: each fx.Invoke runs the
+// constructors of its dependencies that have not run yet. The OnStart
+// hooks are timed as well and attributed to the constructor that
+// appended them.
+package fxstats
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/fx/fxevent"
+	"go.uber.org/zap"
+)
+
+// slowest is how many timings the startup summary lists.
+const slowest = 5
+
+// startup holds the timings in milliseconds, by "invoke:<function>" and
+// "on_start:<constructor>"; it is published with the other expvar variables.
+var startup = expvar.NewMap("fx_startup_ms")
+
+// Logger is an fxevent.Logger that times the startup of the application
+// before passing each event on.
+// 起動時間を計測するfxのロガー
+type Logger struct {
+	next fxevent.Logger
+	log  *zap.Logger
+
+	mu       sync.Mutex
+	begun    time.Time
+	invoking map[string]time.Time
+	timings  map[string]time.Duration
+}
+
+// NewLogger builds a Logger that passes events on to next and logs the
+// summary with log.
+func NewLogger(next fxevent.Logger, log *zap.Logger) *Logger {
+	return &Logger{
+		next:     next,
+		log:      log,
+		begun:    time.Now(),
+		invoking: make(map[string]time.Time),
+		timings:  make(map[string]time.Duration),
+	}
+}
+
+// LogEvent records the timing carried by the event, if any.
+func (l *Logger) LogEvent(event fxevent.Event) {
+	l.next.LogEvent(event)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch e := event.(type) {
+	case *fxevent.Invoking:
+		l.invoking[e.FunctionName] = time.Now()
+	case *fxevent.Invoked:
+		if start, ok := l.invoking[e.FunctionName]; ok {
+			l.record("invoke:"+e.FunctionName, time.Since(start))
+			delete(l.invoking, e.FunctionName)
+		}
+	case *fxevent.OnStartExecuted:
+		l.record("on_start:"+e.CallerName, e.Runtime)
+	case *fxevent.Started:
+		l.summarize(e.Err)
+	}
+}
+
+// record must be called with l.mu held. Hooks appended by the same
+// constructor add up.
+func (l *Logger) record(name string, d time.Duration) {
+	l.timings[name] += d
+	ms := new(expvar.Float)
+	ms.Set(l.timings[name].Seconds() * 1000)
+	startup.Set(name, ms)
+}
+
+// summarize must be called with l.mu held.
+func (l *Logger) summarize(err error) {
+	names := make([]string, 0, len(l.timings))
+	for name := range l.timings {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return l.timings[names[i]] > l.timings[names[j]] })
+	if len(names) > slowest {
+		names = names[:slowest]
+	}
+	fields := []zap.Field{zap.Duration("total", time.Since(l.begun))}
+	for _, name := range names {
+		fields = append(fields, zap.Duration(name, l.timings[name]))
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	l.log.Info("Startup timings", fields...)
+}
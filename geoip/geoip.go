@@ -0,0 +1,213 @@
+// Package geoip resolves the country and autonomous system of each
+// client from MaxMind databases, for the WAF rules, the logs and the
+// analytics. The databases are loaded at startup and reloaded whenever
+// their files change, so they can be updated without a restart.
+package geoip
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Config says where the databases are. Either may be left empty.
+type Config struct {
+	CountryDB      string        `yaml:"country_db"`      // CountryDB is a GeoIP2 or GeoLite2 Country (or City) database.
+	ASNDB          string        `yaml:"asn_db"`          // ASNDB is a GeoLite2 ASN database.
+	ReloadInterval time.Duration `yaml:"reload_interval"` // ReloadInterval is how often the files are checked for changes.
+}
+
+// DefaultConfig returns a Config without any databases.
+func DefaultConfig() Config {
+	return Config{ReloadInterval: time.Minute}
+}
+
+// Info is what is known of where a client is.
+type Info struct {
+	Country string // ISO 3166-1 alpha-2 code, empty if unknown
+	ASN     uint32 // zero if unknown
+	ASOrg   string
+}
+
+// requestsByCountry counts requests by country, "unknown" if the
+// country could not be resolved.
+var requestsByCountry = expvar.NewMap("requests_by_country")
+
+type infoKey struct{}
+
+// FromContext returns the Info of the client of the request.
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(infoKey{}).(Info)
+	return info
+}
+
+// databases is a set of loaded databases along with the modification
+// times of their files.
+type databases struct {
+	country, asn       *Reader
+	countryMod, asnMod time.Time
+}
+
+// Resolver looks clients up in the current databases.
+// クライアントの国とASを調べる
+type Resolver struct {
+	cfg Config
+	log *zap.Logger
+	dbs atomic.Pointer[databases]
+}
+
+// NewResolver loads the databases and keeps them up to date for as long
+// as the Fx application runs.
+func NewResolver(lc fx.Lifecycle, cfg Config, log *zap.Logger) (*Resolver, error) {
+	r := &Resolver{cfg: cfg, log: log}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if cfg.CountryDB == "" && cfg.ASNDB == "" {
+		return r, nil
+	}
+
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go r.watch(done)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			close(done)
+			return nil
+		},
+	})
+	return r, nil
+}
+
+// Reload reads the databases again. The previous databases stay in use
+// if the new files cannot be loaded.
+func (r *Resolver) Reload() error {
+	dbs := &databases{}
+	var err error
+	if dbs.country, dbs.countryMod, err = load(r.cfg.CountryDB); err != nil {
+		return err
+	}
+	if dbs.asn, dbs.asnMod, err = load(r.cfg.ASNDB); err != nil {
+		return err
+	}
+	r.dbs.Store(dbs)
+	if dbs.country != nil || dbs.asn != nil {
+		r.log.Info("Loaded GeoIP databases", zap.String("country_db", r.cfg.CountryDB), zap.String("asn_db", r.cfg.ASNDB))
+	}
+	return nil
+}
+
+func load(path string) (*Reader, time.Time, error) {
+	if path == "" {
+		return nil, time.Time{}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	db, err := Open(path)
+	return db, info.ModTime(), err
+}
+
+// watch reloads the databases whenever a file's modification time changes.
+func (r *Resolver) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(r.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				r.log.Error("Failed to reload GeoIP databases, keeping the previous ones", zap.Error(err))
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (r *Resolver) changed() bool {
+	dbs := r.dbs.Load()
+	for _, f := range []struct {
+		path string
+		mod  time.Time
+	}{{r.cfg.CountryDB, dbs.countryMod}, {r.cfg.ASNDB, dbs.asnMod}} {
+		if f.path == "" {
+			continue
+		}
+		if info, err := os.Stat(f.path); err == nil && !info.ModTime().Equal(f.mod) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns what the databases know of ip. Failures are logged
+// with the telemetry of ctx.
+func (r *Resolver) Lookup(ctx context.Context, ip net.IP) Info {
+	var info Info
+	dbs := r.dbs.Load()
+	if dbs.country != nil {
+		rec, err := dbs.country.Lookup(ip)
+		if err != nil {
+			telemetry.Logger(ctx, r.log).Warn("Failed to look up country", zap.Stringer("ip", ip), zap.Error(err))
+		}
+		info.Country = isoCode(rec, "country")
+		if info.Country == "" {
+			info.Country = isoCode(rec, "registered_country")
+		}
+	}
+	if dbs.asn != nil {
+		rec, err := dbs.asn.Lookup(ip)
+		if err != nil {
+			telemetry.Logger(ctx, r.log).Warn("Failed to look up ASN", zap.Stringer("ip", ip), zap.Error(err))
+		}
+		info.ASN = uint32(asUint(rec["autonomous_system_number"]))
+		info.ASOrg, _ = rec["autonomous_system_organization"].(string)
+	}
+	return info
+}
+
+func isoCode(rec map[string]any, key string) string {
+	m, _ := rec[key].(map[string]any)
+	code, _ := m["iso_code"].(string)
+	return code
+}
+
+// Wrap returns a handler that resolves the client of each request,
+// making the result available through FromContext and adding it to the
+// request's telemetry.
+func (r *Resolver) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip := net.ParseIP(clientip.FromRequest(req))
+		if dbs := r.dbs.Load(); ip == nil || (dbs.country == nil && dbs.asn == nil) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		info := r.Lookup(req.Context(), ip)
+		country := info.Country
+		if country == "" {
+			country = "unknown"
+		}
+		requestsByCountry.Add(country, 1)
+
+		ctx := context.WithValue(req.Context(), infoKey{}, info)
+		ctx = telemetry.Update(ctx, func(tc *telemetry.Context) {
+			tc.Country, tc.ASN = info.Country, info.ASN
+		})
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,17 @@
+package geoip
+
+import (
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	r, err := NewResolver(fxtest.NewLifecycle(b), DefaultConfig(), zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	httpfxtest.Benchmark(b, r.Wrap(httpfxtest.Noop))
+}
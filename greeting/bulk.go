@@ -0,0 +1,306 @@
+package greeting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/audit"
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/degrade"
+	"example.com/fxdemo/fanout"
+	"example.com/fxdemo/links"
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Job states.
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled" // the server stopped before the job finished
+)
+
+const (
+	queueSize     = 16        // jobs waiting for the worker
+	jobHistory    = time.Hour // how long finished jobs can be looked up
+	deleteWorkers = 8         // greetings a job deletes at a time
+)
+
+// Job is a bulk deletion and how far it has got.
+type Job struct {
+	ID         string     `json:"id"`
+	Filter     string     `json:"filter"`
+	State      string     `json:"state"`
+	Matched    int        `json:"matched"`
+	Deleted    int        `json:"deleted"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	filter Filter
+	ctx    context.Context // carries the telemetry of the request that queued the job
+	client string
+}
+
+// BulkDeleter deletes the greetings matching a filter in the background,
+// one job at a time.
+// 一括削除ジョブを順に実行する
+type BulkDeleter struct {
+	repo  Repository
+	audit *audit.Logger
+	log   *zap.Logger
+	queue chan *Job
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewBulkDeleter builds a BulkDeleter whose worker runs for as long as
+// the Fx application.
+func NewBulkDeleter(lc fx.Lifecycle, repo Repository, auditLog *audit.Logger, log *zap.Logger) *BulkDeleter {
+	d := &BulkDeleter{
+		repo:  repo,
+		audit: auditLog,
+		log:   log,
+		queue: make(chan *Job, queueSize),
+		jobs:  make(map[string]*Job),
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go d.run(done, stopped)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(done)
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+	return d
+}
+
+// errQueueFull is returned by Enqueue when the worker is too far behind.
+var errQueueFull = errors.New("greeting: bulk deletion queue is full")
+
+// Enqueue queues the deletion of every greeting matching f on behalf of
+// the client that sent r.
+func (d *BulkDeleter) Enqueue(r *http.Request, filter string, f Filter) (Job, error) {
+	job := &Job{
+		ID:        newID(),
+		Filter:    filter,
+		State:     JobQueued,
+		CreatedAt: time.Now(),
+		filter:    f,
+		ctx:       telemetry.With(context.Background(), telemetry.From(r.Context())),
+		client:    clientip.FromRequest(r),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, j := range d.jobs {
+		if j.FinishedAt != nil && time.Since(*j.FinishedAt) > jobHistory {
+			delete(d.jobs, id)
+		}
+	}
+	select {
+	case d.queue <- job:
+	default:
+		return Job{}, errQueueFull
+	}
+	d.jobs[job.ID] = job
+	return *job, nil
+}
+
+// Job returns the current state of the job with the given ID.
+func (d *BulkDeleter) Job(id string) (Job, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	j, ok := d.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+func (d *BulkDeleter) run(done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	for {
+		select {
+		case job := <-d.queue:
+			d.process(job, done)
+		case <-done:
+			d.mu.Lock()
+			for _, j := range d.jobs {
+				if j.State == JobQueued {
+					d.finish(j, JobCanceled, "")
+				}
+			}
+			d.mu.Unlock()
+			return
+		}
+	}
+}
+
+// process deletes the greetings the job's filter matches, recording an
+// audit event for each, until they are gone or the server stops.
+func (d *BulkDeleter) process(job *Job, done <-chan struct{}) {
+	// Calls to the repository in flight when the server stops are
+	// abandoned rather than waited for.
+	ctx, cancel := context.WithCancel(job.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	log := telemetry.Logger(ctx, d.log).With(zap.String("job", job.ID))
+	d.update(job, func() { job.State = JobRunning })
+
+	greetings, err := d.repo.List(ctx, job.filter)
+	if err != nil {
+		log.Error("Failed to list greetings for bulk deletion", zap.Error(err))
+		d.update(job, func() { d.finish(job, JobFailed, err.Error()) })
+		return
+	}
+	d.update(job, func() { job.Matched = len(greetings) })
+	log.Info("Bulk deletion started", zap.String("filter", job.Filter), zap.Int("matched", len(greetings)))
+
+	results := fanout.Map(ctx, greetings, fanout.Options{Limit: deleteWorkers, Timeout: repoTimeout, FailFast: true},
+		func(ctx context.Context, g Greeting) (struct{}, error) {
+			err := d.repo.Delete(ctx, g.ID)
+			if errors.Is(err, ErrNotFound) {
+				return struct{}{}, nil // deleted by someone else in the meantime
+			}
+			if err != nil {
+				return struct{}{}, err
+			}
+			d.audit.Record(job.ctx, audit.Event{
+				Kind:   "greeting.deleted",
+				Client: job.client,
+				Detail: map[string]string{"id": g.ID, "job": job.ID},
+			})
+			d.update(job, func() { job.Deleted++ })
+			return struct{}{}, nil
+		})
+	switch err := fanout.FirstError(results); {
+	case errors.Is(err, context.Canceled):
+		d.update(job, func() { d.finish(job, JobCanceled, "") })
+		log.Warn("Bulk deletion canceled", zap.Int("deleted", job.Deleted))
+		return
+	case err != nil:
+		log.Error("Failed to delete greetings", zap.Error(err))
+		d.update(job, func() { d.finish(job, JobFailed, err.Error()) })
+		return
+	}
+	d.update(job, func() { d.finish(job, JobDone, "") })
+	log.Info("Bulk deletion finished", zap.Int("deleted", job.Deleted))
+}
+
+func (d *BulkDeleter) update(job *Job, f func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f()
+}
+
+// finish must be called with d.mu held.
+func (d *BulkDeleter) finish(job *Job, state, msg string) {
+	now := time.Now()
+	job.State, job.Error, job.FinishedAt = state, msg, &now
+}
+
+// BulkDelete is the degradable feature of queueing bulk deletions.
+const BulkDelete = "greetings.bulk_delete"
+
+// BulkHandler serves DELETE on the greetings collection, which queues a
+// bulk deletion of the greetings matching the filter query parameter.
+type BulkHandler struct {
+	deleter  *BulkDeleter
+	links    *links.Builder
+	degraded *degrade.Registry
+}
+
+// NewBulkHandler builds a new BulkHandler.
+func NewBulkHandler(deleter *BulkDeleter, links *links.Builder, degraded *degrade.Registry) *BulkHandler {
+	degraded.Register(BulkDelete, "new bulk deletions are refused with 503")
+	return &BulkHandler{deleter: deleter, links: links, degraded: degraded}
+}
+
+// Pattern reports the path at which this is registered.
+func (*BulkHandler) Pattern() string {
+	return "/api/v1/greetings"
+}
+
+func (h *BulkHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apperror.Write(w, r, nil, notAllowed(w, r, http.MethodDelete))
+		return
+	}
+	if h.degraded.Degraded(BulkDelete) {
+		w.Header().Set("Retry-After", "60")
+		apperror.Write(w, r, nil, apperror.New(http.StatusServiceUnavailable, "degraded", "Bulk deletion is temporarily unavailable"))
+		return
+	}
+	filter := r.URL.Query().Get("filter")
+	f, err := ParseFilter(filter)
+	if err != nil {
+		apperror.Write(w, r, nil, apperror.BadRequest("Invalid filter: %v", err))
+		return
+	}
+	job, err := h.deleter.Enqueue(r, filter, f)
+	if errors.Is(err, errQueueFull) {
+		w.Header().Set("Retry-After", "10")
+		apperror.Write(w, r, nil, apperror.New(http.StatusServiceUnavailable, "busy", "Too many bulk deletions in progress"))
+		return
+	}
+	w.Header().Set("Location", h.links.Related(r, httpfx.PathFor((*JobHandler)(nil).Pattern(), job.ID)).Href)
+	writeJob(w, http.StatusAccepted, job)
+}
+
+// JobHandler reports the progress of bulk deletions at /api/v1/jobs/{id}.
+type JobHandler struct {
+	deleter *BulkDeleter
+}
+
+// NewJobHandler builds a new JobHandler.
+func NewJobHandler(deleter *BulkDeleter) *JobHandler {
+	return &JobHandler{deleter: deleter}
+}
+
+// Pattern reports the path at which this is registered.
+func (*JobHandler) Pattern() string {
+	return "/api/v1/jobs/{id}"
+}
+
+func (h *JobHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.deleter.Job(httpfx.PathValue(r, "id"))
+	if !ok {
+		apperror.Write(w, r, nil, apperror.NotFound("No such job"))
+		return
+	}
+	writeJob(w, http.StatusOK, job)
+}
+
+func writeJob(w http.ResponseWriter, status int, job Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(job)
+}
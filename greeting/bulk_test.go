@@ -0,0 +1,53 @@
+package greeting
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/fxdemo/audit"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+// TestBulkDeleterStopsAtShutdown checks that a job stuck on the
+// repository is canceled when the application stops, rather than
+// holding up the shutdown.
+func TestBulkDeleterStopsAtShutdown(t *testing.T) {
+	repo := newStuckRepository()
+	for range 3 {
+		if _, err := repo.Create(context.Background(), Greeting{Name: "a", Message: "hi", Language: "en"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lc := fxtest.NewLifecycle(t)
+	d := NewBulkDeleter(lc, repo, audit.NewLogger(zap.NewNop(), nil), zap.NewNop())
+	lc.RequireStart()
+
+	job, err := d.Enqueue(httptest.NewRequest("DELETE", "/api/v1/greetings?filter=*", nil), "*", Filter{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); ; {
+		if j, _ := d.Job(job.ID); j.State == JobRunning && j.Matched == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the job did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := lc.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v, want the job abandoned in time", err)
+	}
+	if err := repo.abandoned(t); err != context.Canceled {
+		t.Errorf("repository call ended with %v, want context.Canceled", err)
+	}
+	if j, _ := d.Job(job.ID); j.State != JobCanceled || j.Deleted != 0 || j.FinishedAt == nil {
+		t.Errorf("job = %+v, want it canceled", j)
+	}
+}
@@ -0,0 +1,67 @@
+package greeting
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Filter selects greetings. The zero Filter matches nothing; use All to
+// match every greeting.
+type Filter struct {
+	All           bool
+	Name          string
+	Language      string
+	CreatedBefore time.Time
+	UpdatedBefore time.Time
+}
+
+// ParseFilter parses a filter of comma separated "field:value" terms, all
+// of which must match, such as "language:ja,updated_before:2024-01-01T00:00:00Z".
+// The filter "*" matches every greeting.
+func ParseFilter(s string) (Filter, error) {
+	if s == "*" {
+		return Filter{All: true}, nil
+	}
+	var f Filter
+	if strings.TrimSpace(s) == "" {
+		return f, fmt.Errorf("empty filter; use \"*\" to select every greeting")
+	}
+	for _, term := range strings.Split(s, ",") {
+		field, value, ok := strings.Cut(term, ":")
+		if !ok || value == "" {
+			return f, fmt.Errorf("filter term %q is not field:value", term)
+		}
+		var err error
+		switch field {
+		case "name":
+			f.Name = value
+		case "language":
+			f.Language = value
+		case "created_before":
+			f.CreatedBefore, err = time.Parse(time.RFC3339, value)
+		case "updated_before":
+			f.UpdatedBefore, err = time.Parse(time.RFC3339, value)
+		default:
+			return f, fmt.Errorf("unknown filter field %q", field)
+		}
+		if err != nil {
+			return f, fmt.Errorf("filter term %q: %w", term, err)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether the filter selects g.
+func (f Filter) Match(g Greeting) bool {
+	if f.All {
+		return true
+	}
+	if f == (Filter{}) {
+		return false
+	}
+	return (f.Name == "" || g.Name == f.Name) &&
+		(f.Language == "" || g.Language == f.Language) &&
+		(f.CreatedBefore.IsZero() || g.CreatedAt.Before(f.CreatedBefore)) &&
+		(f.UpdatedBefore.IsZero() || g.UpdatedAt.Before(f.UpdatedBefore))
+}
@@ -0,0 +1,67 @@
+// Package greeting serves the greetings resource under /api/v1/greetings/.
+// Greetings are versioned so that partial updates can be made conditional
+// on the version the client last saw.
+package greeting
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Greeting is a message for someone.
+type Greeting struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Message   string    `json:"message"`
+	Language  string    `json:"language,omitempty"` // Language is a BCP 47 tag such as "ja" or "en-GB".
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ETag returns the entity tag of this version of the greeting.
+func (g Greeting) ETag() string {
+	return strconv.Quote(strconv.FormatInt(g.Version, 10))
+}
+
+var language = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{1,8})*$`)
+
+// Validate checks the fields a client may set.
+func (g Greeting) Validate() error {
+	switch {
+	case strings.TrimSpace(g.Name) == "":
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	case len(g.Name) > 100:
+		return fmt.Errorf("%w: name is longer than 100 bytes", ErrInvalid)
+	case strings.TrimSpace(g.Message) == "":
+		return fmt.Errorf("%w: message is required", ErrInvalid)
+	case len(g.Message) > 1000:
+		return fmt.Errorf("%w: message is longer than 1000 bytes", ErrInvalid)
+	case g.Language != "" && !language.MatchString(g.Language):
+		return fmt.Errorf("%w: language is not a BCP 47 tag", ErrInvalid)
+	}
+	return nil
+}
+
+// newID returns a random ID of 32 hex characters.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// validID reports whether id looks like an ID returned by newID.
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,263 @@
+package greeting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/deadline"
+	"example.com/fxdemo/headers"
+	"example.com/fxdemo/links"
+	"example.com/fxdemo/servertiming"
+	"example.com/fxdemo/telemetry"
+	"example.com/fxdemo/tracing"
+	"go.uber.org/zap"
+)
+
+const (
+	maxBody     = 64 << 10        // maxBody bounds the size of the greetings and patches clients send.
+	repoTimeout = 5 * time.Second // repoTimeout bounds each repository call, within the request's deadline.
+)
+
+// Handler is an http.Handler serving the greetings resource.
+// 挨拶リソースのハンドラ
+type Handler struct {
+	repo   Repository
+	links  *links.Builder
+	tracer *tracing.Tracer
+	log    *zap.Logger
+	h      http.Handler
+}
+
+// NewHandler builds a new Handler.
+func NewHandler(repo Repository, links *links.Builder, tracer *tracing.Tracer, log *zap.Logger) *Handler {
+	h := &Handler{repo: repo, links: links, tracer: tracer, log: log}
+	h.h = apperror.Handler(nil, h.serve) // failは自分でログを書く
+	return h
+}
+
+// db times a repository call for Server-Timing and traces it in a span
+// named after op. The returned function ends both.
+func (h *Handler) db(ctx context.Context, op string) (context.Context, func()) {
+	ctx, span := h.tracer.Start(ctx, "greeting.Repository."+op)
+	stop := servertiming.Start(ctx, "db")
+	return ctx, func() {
+		stop()
+		span.End()
+	}
+}
+
+// Pattern reports the path at which this is registered.
+func (*Handler) Pattern() string {
+	return "/api/v1/greetings/"
+}
+
+// HeaderPolicy lets caches keep greetings, as long as they revalidate
+// them with their ETag.
+func (*Handler) HeaderPolicy() headers.Policy {
+	return headers.Policy{CacheControl: "private, no-cache"}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.h.ServeHTTP(w, r)
+}
+
+// serve dispatches on the method and on whether the request is for the
+// collection or for a single greeting.
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	id := strings.TrimPrefix(r.URL.Path, h.Pattern())
+	if id == "" {
+		switch r.Method {
+		case http.MethodPost:
+			return h.create(w, r)
+		default:
+			return notAllowed(w, r, http.MethodPost)
+		}
+	}
+	if !validID(id) {
+		return apperror.NotFound("No such greeting")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return h.get(w, r, id)
+	case http.MethodPatch:
+		return h.patch(w, r, id)
+	default:
+		return notAllowed(w, r, "GET, HEAD, PATCH")
+	}
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) error {
+	var g Greeting
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&g); err != nil {
+		return apperror.BadRequest("Invalid greeting: %v", err)
+	}
+	if err := g.Validate(); err != nil {
+		return invalid(err)
+	}
+	ctx, cancel := deadline.Downstream(r.Context(), repoTimeout)
+	defer cancel()
+	ctx, stop := h.db(ctx, "Create")
+	g, err := h.repo.Create(ctx, g)
+	stop()
+	if err != nil {
+		return h.fail(r, "Failed to create greeting", err)
+	}
+	w.Header().Set("Location", h.links.Related(r, h.Pattern()+g.ID).Href)
+	return h.write(w, r, http.StatusCreated, g)
+}
+
+// get returns the greeting, or with ?as_of= the version of it that was
+// current at that time.
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, id string) error {
+	ctx, cancel := deadline.Downstream(r.Context(), repoTimeout)
+	defer cancel()
+	var g Greeting
+	var err error
+	ctx, stop := h.db(ctx, "Get")
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		t, perr := time.Parse(time.RFC3339Nano, asOf)
+		if perr != nil {
+			stop()
+			return apperror.BadRequest("Invalid as_of; expected an RFC 3339 timestamp")
+		}
+		g, err = h.repo.GetAsOf(ctx, id, t)
+	} else {
+		g, err = h.repo.Get(ctx, id)
+	}
+	stop()
+	if errors.Is(err, ErrNotFound) {
+		return apperror.NotFound("No such greeting")
+	}
+	if err != nil {
+		return h.fail(r, "Failed to get greeting", err)
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && match == g.ETag() {
+		w.Header().Set("ETag", g.ETag())
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	return h.write(w, r, http.StatusOK, g)
+}
+
+// patch applies a merge patch or JSON patch to the greeting. The request
+// must be conditional on the version the client last saw, so that it
+// cannot overwrite changes it has not seen.
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, id string) error {
+	version, ok := ifMatch(r.Header.Get("If-Match"))
+	if !ok {
+		return apperror.New(http.StatusPreconditionRequired, "precondition_required", "If-Match with the ETag of the greeting is required")
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != MergePatch && mediaType != JSONPatch {
+		w.Header().Set("Accept-Patch", MergePatch+", "+JSONPatch)
+		return apperror.New(http.StatusUnsupportedMediaType, "unsupported_media_type", "A merge patch or a JSON patch is required")
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		return h.fail(r, "Failed to read request", err)
+	}
+	if len(body) > maxBody {
+		return apperror.TooLarge(maxBody)
+	}
+
+	ctx, cancel := deadline.Downstream(r.Context(), repoTimeout)
+	defer cancel()
+	ctx, stop := h.db(ctx, "Update")
+	g, err := h.repo.Update(ctx, id, version, func(g *Greeting) error {
+		patched, err := Patch(*g, mediaType, body)
+		if err != nil {
+			return err
+		}
+		*g = patched
+		return nil
+	})
+	stop()
+	switch {
+	case err == nil:
+		return h.write(w, r, http.StatusOK, g)
+	case errors.Is(err, ErrNotFound):
+		return apperror.NotFound("No such greeting")
+	case errors.Is(err, ErrVersionMismatch):
+		return apperror.New(http.StatusPreconditionFailed, "precondition_failed", "The greeting has changed since the given ETag")
+	case errors.Is(err, ErrTestFailed):
+		return apperror.New(http.StatusConflict, "test_failed", "%v", err)
+	case errors.Is(err, ErrBadPatch):
+		return apperror.BadRequest("%v", err)
+	case errors.Is(err, ErrInvalid):
+		return invalid(err)
+	default:
+		return h.fail(r, "Failed to update greeting", err)
+	}
+}
+
+// ifMatch parses an If-Match header holding a single strong ETag, or "*"
+// for any version, which is returned as zero.
+func ifMatch(h string) (int64, bool) {
+	if h == "*" {
+		return 0, true
+	}
+	s, err := strconv.Unquote(strings.TrimSpace(h))
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	return v, err == nil && v > 0
+}
+
+// write sends the greeting. It is encoded before the headers are sent,
+// so that the time spent rendering it shows in Server-Timing.
+func (h *Handler) write(w http.ResponseWriter, r *http.Request, status int, g Greeting) error {
+	stop := servertiming.Start(r.Context(), "render")
+	body, err := json.Marshal(g)
+	stop()
+	if err != nil {
+		return h.fail(r, "Failed to encode greeting", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", g.ETag())
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		telemetry.Logger(r.Context(), h.log).Error("Failed to write response", zap.Error(err))
+	}
+	return nil
+}
+
+// fail reports an unexpected error. A request that ran out of time gets a
+// 503, and one whose client has gone away gets nothing.
+func (h *Handler) fail(r *http.Request, msg string, err error) error {
+	log := telemetry.Logger(r.Context(), h.log)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Warn(msg, zap.Error(err))
+		return &apperror.Error{Status: http.StatusServiceUnavailable, Code: "timeout", Message: "Request timed out", Err: err}
+	case errors.Is(err, context.Canceled):
+		log.Debug(msg, zap.Error(err))
+		return nil
+	default:
+		log.Error(msg, zap.Error(err))
+		return apperror.Internal(err)
+	}
+}
+
+// invalid reports a greeting that breaks the rules of Validate.
+func invalid(err error) error {
+	return apperror.New(http.StatusUnprocessableEntity, "invalid", "%v", err)
+}
+
+func notAllowed(w http.ResponseWriter, r *http.Request, allow string) error {
+	w.Header().Set("Allow", allow)
+	return apperror.New(http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed here", r.Method)
+}
@@ -0,0 +1,103 @@
+package greeting
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/fxdemo/degrade"
+	"example.com/fxdemo/tracing"
+	"go.uber.org/zap"
+)
+
+// stuckRepository is a Repository whose calls block until their
+// context is done, as those of an overloaded database would. It reports
+// each call it gives up on.
+type stuckRepository struct {
+	Repository
+	released chan error
+}
+
+func newStuckRepository() *stuckRepository {
+	return &stuckRepository{Repository: NewMemoryRepository(), released: make(chan error, 16)}
+}
+
+func (s *stuckRepository) wait(ctx context.Context) error {
+	<-ctx.Done()
+	s.released <- ctx.Err()
+	return ctx.Err()
+}
+
+func (s *stuckRepository) Get(ctx context.Context, id string) (Greeting, error) {
+	return Greeting{}, s.wait(ctx)
+}
+
+func (s *stuckRepository) Delete(ctx context.Context, id string) error {
+	return s.wait(ctx)
+}
+
+func newTestHandler(repo Repository) *Handler {
+	tracer := tracing.NewTracer(tracing.DefaultConfig(), zap.NewNop(), degrade.NewRegistry(zap.NewNop()), nil)
+	return NewHandler(repo, nil, tracer, zap.NewNop())
+}
+
+// abandoned waits for the repository to give up on a call, and returns
+// the error it gave up with.
+func (s *stuckRepository) abandoned(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-s.released:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("the repository call was not abandoned")
+		return nil
+	}
+}
+
+func TestHandlerCanceledRequest(t *testing.T) {
+	repo := newStuckRepository()
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequestWithContext(ctx, "GET", "/api/v1/greetings/"+newID(), nil)
+	rec := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		newTestHandler(repo).ServeHTTP(rec, r)
+		close(served)
+	}()
+
+	cancel() // クライアントが切断した
+	if err := repo.abandoned(t); err != context.Canceled {
+		t.Errorf("repository call ended with %v, want context.Canceled", err)
+	}
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("the handler did not return once its request was canceled")
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("response = %d %q, want nothing for a client that went away", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandlerTimedOutRequest(t *testing.T) {
+	repo := newStuckRepository()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequestWithContext(ctx, "GET", "/api/v1/greetings/"+newID(), nil)
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	newTestHandler(repo).ServeHTTP(rec, r)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the handler took %v, want it to stop at the request's deadline", elapsed)
+	}
+	if err := repo.abandoned(t); err != context.DeadlineExceeded {
+		t.Errorf("repository call ended with %v, want context.DeadlineExceeded", err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"timeout"`) {
+		t.Errorf("response = %d %q, want a 503 timeout", rec.Code, rec.Body.String())
+	}
+}
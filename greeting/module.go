@@ -0,0 +1,20 @@
+package greeting
+
+import (
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+)
+
+// Module provides the greetings resource, its bulk deletion jobs and a
+// Repository on the *sql.DB of db.Module, or in memory if there is no
+// database.
+// 挨拶リソース
+var Module = fx.Module("greeting",
+	fx.Provide(
+		httpfx.AsRoute[*Handler](NewHandler),
+		httpfx.AsRoute[*BulkHandler](NewBulkHandler),
+		httpfx.AsRoute[*JobHandler](NewJobHandler),
+		NewBulkDeleter, // 一括削除ジョブ
+		NewRepository,
+	),
+)
@@ -0,0 +1,319 @@
+package greeting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media types of the partial updates accepted by PATCH.
+const (
+	MergePatch = "application/merge-patch+json" // RFC 7386
+	JSONPatch  = "application/json-patch+json"  // RFC 6902
+)
+
+var (
+	// ErrBadPatch is returned for a patch document that cannot be applied.
+	ErrBadPatch = errors.New("greeting: malformed patch")
+	// ErrTestFailed is returned when a JSON Patch "test" operation fails.
+	ErrTestFailed = errors.New("greeting: patch test failed")
+	// ErrInvalid is returned when a greeting, as patched, is not valid.
+	ErrInvalid = errors.New("greeting: invalid")
+)
+
+// Patch applies a patch document of the given media type to g.
+// The ID, version and timestamps of g cannot be patched.
+// 部分更新を適用する
+func Patch(g Greeting, mediaType string, patch []byte) (Greeting, error) {
+	raw, err := json.Marshal(g)
+	if err != nil {
+		return Greeting{}, err
+	}
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return Greeting{}, err
+	}
+
+	switch mediaType {
+	case MergePatch:
+		var p any
+		if err := json.Unmarshal(patch, &p); err != nil {
+			return Greeting{}, fmt.Errorf("%w: %v", ErrBadPatch, err)
+		}
+		doc = mergePatch(doc, p)
+	case JSONPatch:
+		var ops []operation
+		if err := json.Unmarshal(patch, &ops); err != nil {
+			return Greeting{}, fmt.Errorf("%w: %v", ErrBadPatch, err)
+		}
+		for i, op := range ops {
+			if doc, err = op.apply(doc); err != nil {
+				return Greeting{}, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+			}
+		}
+	default:
+		return Greeting{}, fmt.Errorf("%w: unsupported media type %q", ErrBadPatch, mediaType)
+	}
+
+	raw, err = json.Marshal(doc)
+	if err != nil {
+		return Greeting{}, err
+	}
+	var patched Greeting
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		return Greeting{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if patched.ID != g.ID || patched.Version != g.Version ||
+		!patched.CreatedAt.Equal(g.CreatedAt) || !patched.UpdatedAt.Equal(g.UpdatedAt) {
+		return Greeting{}, fmt.Errorf("%w: id, version, created_at and updated_at are read-only", ErrInvalid)
+	}
+	if err := patched.Validate(); err != nil {
+		return Greeting{}, err
+	}
+	return patched, nil
+}
+
+// mergePatch applies an RFC 7386 merge patch to target.
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// operation is an RFC 6902 JSON Patch operation.
+type operation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+func (op operation) apply(doc any) (any, error) {
+	path, err := pointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", ErrBadPatch)
+		}
+		if err := json.Unmarshal(*op.Value, &value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadPatch, err)
+		}
+	case "move", "copy":
+		from, err := pointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = get(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, fmt.Errorf("%w: cannot move a value into itself", ErrBadPatch)
+			}
+			if doc, err = remove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return add(doc, path, value)
+	case "remove":
+		return remove(doc, path)
+	case "replace":
+		if _, err := get(doc, path); err != nil {
+			return nil, err
+		}
+		if doc, err = remove(doc, path); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "test":
+		actual, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(actual, value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrBadPatch, op.Op)
+	}
+}
+
+// pointer splits an RFC 6901 JSON pointer into its reference tokens.
+func pointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("%w: invalid pointer %q", ErrBadPatch, p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+func get(doc any, path []string) (any, error) {
+	for _, t := range path {
+		switch n := doc.(type) {
+		case map[string]any:
+			v, ok := n[t]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q does not exist", ErrBadPatch, t)
+			}
+			doc = v
+		case []any:
+			i, err := index(t, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = n[i]
+		default:
+			return nil, fmt.Errorf("%w: %q does not exist", ErrBadPatch, t)
+		}
+	}
+	return doc, nil
+}
+
+// update replaces the parent of the last token of path with what leaf
+// makes of it, returning the updated document.
+func update(doc any, path []string, leaf func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return leaf(doc, path[0])
+	}
+	switch n := doc.(type) {
+	case map[string]any:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q does not exist", ErrBadPatch, path[0])
+		}
+		c, err := update(child, path[1:], leaf)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = c
+		return n, nil
+	case []any:
+		i, err := index(path[0], len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		c, err := update(n[i], path[1:], leaf)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = c
+		return n, nil
+	default:
+		return nil, fmt.Errorf("%w: %q does not exist", ErrBadPatch, path[0])
+	}
+}
+
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(parent any, t string) (any, error) {
+		switch n := parent.(type) {
+		case map[string]any:
+			n[t] = value
+			return n, nil
+		case []any:
+			i := len(n)
+			if t != "-" {
+				var err error
+				if i, err = index(t, len(n)); err != nil {
+					return nil, err
+				}
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		default:
+			return nil, fmt.Errorf("%w: cannot add to a scalar", ErrBadPatch)
+		}
+	})
+}
+
+func remove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole document", ErrBadPatch)
+	}
+	return update(doc, path, func(parent any, t string) (any, error) {
+		switch n := parent.(type) {
+		case map[string]any:
+			if _, ok := n[t]; !ok {
+				return nil, fmt.Errorf("%w: %q does not exist", ErrBadPatch, t)
+			}
+			delete(n, t)
+			return n, nil
+		case []any:
+			i, err := index(t, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(n[:i], n[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%w: cannot remove from a scalar", ErrBadPatch)
+		}
+	})
+}
+
+// index parses an array index no greater than max.
+func index(t string, max int) (int, error) {
+	i, err := strconv.Atoi(t)
+	if err != nil || i < 0 || i > max || (len(t) > 1 && t[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrBadPatch, t)
+	}
+	return i, nil
+}
+
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for k, e := range v {
+			c[k] = deepCopy(e)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, e := range v {
+			c[i] = deepCopy(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
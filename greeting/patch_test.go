@@ -0,0 +1,65 @@
+package greeting
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// FuzzPatch checks that a patch either fails or gives a valid greeting
+// with the read-only fields of the original, and that the result can be
+// reached again with a merge patch.
+func FuzzPatch(f *testing.F) {
+	f.Add(false, []byte(`{"message":"Hi"}`))
+	f.Add(false, []byte(`{"language":null,"extra":{"a":[1]}}`))
+	f.Add(true, []byte(`[{"op":"replace","path":"/message","value":"Hi"}]`))
+	f.Add(true, []byte(`[{"op":"test","path":"/name","value":"Alice"},{"op":"remove","path":"/language"}]`))
+	f.Add(true, []byte(`[{"op":"add","path":"/x","value":[1,2]},{"op":"move","from":"/x/1","path":"/x/-"},{"op":"remove","path":"/x"}]`))
+	f.Add(true, []byte(`[{"op":"copy","from":"/name","path":"/message"},{"op":"move","from":"/id","path":"/id"}]`))
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	g := Greeting{
+		ID:        "0123456789abcdef0123456789abcdef",
+		Name:      "Alice",
+		Message:   "Hello",
+		Language:  "en",
+		Version:   3,
+		CreatedAt: created,
+		UpdatedAt: created.Add(time.Hour),
+	}
+	f.Fuzz(func(t *testing.T, jsonPatch bool, patch []byte) {
+		mediaType := MergePatch
+		if jsonPatch {
+			mediaType = JSONPatch
+		}
+		patched, err := Patch(g, mediaType, patch)
+		if err != nil {
+			if !errors.Is(err, ErrBadPatch) && !errors.Is(err, ErrTestFailed) && !errors.Is(err, ErrInvalid) {
+				t.Fatalf("Patch(%s) error = %v, want one of the package's", patch, err)
+			}
+			return
+		}
+		if err := patched.Validate(); err != nil {
+			t.Errorf("Patch(%s) = %+v, which is not valid: %v", patch, patched, err)
+		}
+		if patched.ID != g.ID || patched.Version != g.Version ||
+			!patched.CreatedAt.Equal(g.CreatedAt) || !patched.UpdatedAt.Equal(g.UpdatedAt) {
+			t.Errorf("Patch(%s) = %+v, which changed the read-only fields of %+v", patch, patched, g)
+		}
+
+		// 空の言語は省かれるので、明示的に消す
+		fields := map[string]any{"name": patched.Name, "message": patched.Message, "language": nil}
+		if patched.Language != "" {
+			fields["language"] = patched.Language
+		}
+		merge, err := json.Marshal(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := Patch(g, MergePatch, merge)
+		if err != nil || again.Name != patched.Name || again.Message != patched.Message || again.Language != patched.Language {
+			t.Errorf("Patch(%s) = %+v, but merging it gives %+v, %v", patch, patched, again, err)
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package greeting
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a greeting ID is unknown to the Repository.
+	ErrNotFound = errors.New("greeting: not found")
+	// ErrVersionMismatch is returned by Update when the greeting has
+	// changed since the version the caller based its update on.
+	ErrVersionMismatch = errors.New("greeting: version mismatch")
+)
+
+// Repository stores greetings.
+type Repository interface {
+	Create(ctx context.Context, g Greeting) (Greeting, error)
+	Get(ctx context.Context, id string) (Greeting, error)
+	// Update applies a partial update to the greeting, atomically with
+	// respect to other updates. If version is not zero, the update is
+	// refused with ErrVersionMismatch unless the greeting is still at
+	// that version. The ID and timestamps cannot be changed by apply.
+	Update(ctx context.Context, id string, version int64, apply func(*Greeting) error) (Greeting, error)
+	// List returns every greeting the filter matches.
+	List(ctx context.Context, f Filter) ([]Greeting, error)
+	Delete(ctx context.Context, id string) error
+	// GetAsOf returns the version of the greeting that was current at
+	// the given time, even if it has since been changed or deleted.
+	// Every version is kept, for audits.
+	GetAsOf(ctx context.Context, id string, t time.Time) (Greeting, error)
+}
+
+// checkEvery is how many greetings a scan looks at between checks
+// for the cancellation of its context.
+const checkEvery = 1024
+
+// MemoryRepository is a Repository that keeps greetings in memory,
+// along with their earlier versions. Like a database, it gives up on
+// calls whose context is done.
+// 挨拶をメモリ上で管理する
+type MemoryRepository struct {
+	mu        sync.Mutex
+	greetings map[string]Greeting
+	history   map[string][]revision // by ID, oldest first; kept after deletion
+}
+
+// revision is a version of a greeting and the period it was current for.
+type revision struct {
+	Greeting
+	ValidFrom time.Time
+	ValidTo   time.Time // zero while the revision is current
+}
+
+var _ Repository = (*MemoryRepository)(nil)
+
+// NewMemoryRepository builds an empty MemoryRepository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		greetings: make(map[string]Greeting),
+		history:   make(map[string][]revision),
+	}
+}
+
+// Create stores a new greeting, giving it an ID and its first version.
+func (m *MemoryRepository) Create(ctx context.Context, g Greeting) (Greeting, error) {
+	if err := ctx.Err(); err != nil {
+		return Greeting{}, err
+	}
+	now := time.Now()
+	g.ID = newID()
+	g.Version = 1
+	g.CreatedAt, g.UpdatedAt = now, now
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.greetings[g.ID] = g
+	m.history[g.ID] = []revision{{Greeting: g, ValidFrom: now}}
+	return g, nil
+}
+
+// Get returns the greeting with the given ID.
+func (m *MemoryRepository) Get(ctx context.Context, id string) (Greeting, error) {
+	if err := ctx.Err(); err != nil {
+		return Greeting{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.greetings[id]
+	if !ok {
+		return Greeting{}, ErrNotFound
+	}
+	return g, nil
+}
+
+// Update applies a partial update to the greeting with the given ID.
+func (m *MemoryRepository) Update(ctx context.Context, id string, version int64, apply func(*Greeting) error) (Greeting, error) {
+	if err := ctx.Err(); err != nil {
+		return Greeting{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.greetings[id]
+	if !ok {
+		return Greeting{}, ErrNotFound
+	}
+	if version != 0 && version != old.Version {
+		return Greeting{}, ErrVersionMismatch
+	}
+	g := old
+	if err := apply(&g); err != nil {
+		return Greeting{}, err
+	}
+	g.ID, g.CreatedAt = old.ID, old.CreatedAt
+	g.Version = old.Version + 1
+	g.UpdatedAt = time.Now()
+	m.greetings[id] = g
+	m.supersede(id, g.UpdatedAt)
+	m.history[id] = append(m.history[id], revision{Greeting: g, ValidFrom: g.UpdatedAt})
+	return g, nil
+}
+
+// List returns every greeting the filter matches, oldest first.
+func (m *MemoryRepository) List(ctx context.Context, f Filter) ([]Greeting, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Greeting
+	n := 0
+	for _, g := range m.greetings {
+		if n++; n%checkEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if f.Match(g) {
+			out = append(out, g)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// Delete removes the greeting with the given ID, ending the period of
+// its current version.
+func (m *MemoryRepository) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.greetings[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.greetings, id)
+	m.supersede(id, time.Now()) // 監査のために履歴は残す
+	return nil
+}
+
+// GetAsOf returns the version of the greeting that was current at t.
+func (m *MemoryRepository) GetAsOf(ctx context.Context, id string, t time.Time) (Greeting, error) {
+	if err := ctx.Err(); err != nil {
+		return Greeting{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rev := range m.history[id] {
+		if !t.Before(rev.ValidFrom) && (rev.ValidTo.IsZero() || t.Before(rev.ValidTo)) {
+			return rev.Greeting, nil
+		}
+	}
+	return Greeting{}, ErrNotFound
+}
+
+// supersede ends the period of the current revision of the greeting.
+// It must be called with m.mu held.
+func (m *MemoryRepository) supersede(id string, at time.Time) {
+	revs := m.history[id]
+	if n := len(revs); n > 0 && revs[n-1].ValidTo.IsZero() {
+		revs[n-1].ValidTo = at
+	}
+}
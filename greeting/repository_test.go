@@ -0,0 +1,183 @@
+package greeting
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryRepositoryCanceled(t *testing.T) {
+	m := NewMemoryRepository()
+	g, err := m.Create(context.Background(), Greeting{Name: "a", Message: "hi", Language: "en"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := map[string]func() error{
+		"Create": func() error { _, err := m.Create(ctx, Greeting{Name: "b"}); return err },
+		"Get":    func() error { _, err := m.Get(ctx, g.ID); return err },
+		"Update": func() error {
+			_, err := m.Update(ctx, g.ID, 0, func(g *Greeting) error { g.Message = "changed"; return nil })
+			return err
+		},
+		"List":    func() error { _, err := m.List(ctx, Filter{All: true}); return err },
+		"Delete":  func() error { return m.Delete(ctx, g.ID) },
+		"GetAsOf": func() error { _, err := m.GetAsOf(ctx, g.ID, g.CreatedAt); return err },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: error = %v, want context.Canceled", name, err)
+		}
+	}
+	// 取り消された呼び出しは何も変えない
+	all, err := m.List(context.Background(), Filter{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0] != g {
+		t.Errorf("greetings = %+v, want only %+v", all, g)
+	}
+}
+
+// canceledLater is a context that is canceled once its Err has been
+// checked n times, as if its request went away in the middle of a call.
+type canceledLater struct {
+	context.Context
+	n int
+}
+
+func (c *canceledLater) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+// TestMemoryRepositoryListCanceled checks that a long scan notices the
+// cancellation of its context rather than running to the end.
+func TestMemoryRepositoryListCanceled(t *testing.T) {
+	m := NewMemoryRepository()
+	for range 4 * checkEvery {
+		m.greetings[newID()] = Greeting{Name: "a"}
+	}
+	ctx := &canceledLater{Context: context.Background(), n: 1}
+	if _, err := m.List(ctx, Filter{All: true}); !errors.Is(err, context.Canceled) {
+		t.Errorf("List: error = %v, want context.Canceled", err)
+	}
+}
+
+// TestMemoryRepositoryHistory checks that every version of a greeting
+// stays available through GetAsOf, including after its deletion.
+func TestMemoryRepositoryHistory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryRepository()
+	v1, err := m.Create(ctx, Greeting{Name: "a", Message: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := m.Update(ctx, v1.ID, 0, func(g *Greeting) error { g.Message = "hello"; return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(ctx, v1.ID); err != nil {
+		t.Fatal(err)
+	}
+	deleted := time.Now()
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want int64 // version, or 0 for none
+	}{
+		{"before creation", v1.CreatedAt.Add(-time.Nanosecond), 0},
+		{"created", v1.CreatedAt, 1},
+		{"updated", v2.UpdatedAt, 2},
+		{"deleted", deleted, 0},
+	}
+	for _, tt := range tests {
+		g, err := m.GetAsOf(ctx, v1.ID, tt.at)
+		if tt.want == 0 {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: GetAsOf() = %+v, %v, want ErrNotFound", tt.name, g, err)
+			}
+			continue
+		}
+		if err != nil || g.Version != tt.want {
+			t.Errorf("%s: GetAsOf() = %+v, %v, want version %d", tt.name, g, err, tt.want)
+		}
+	}
+}
+
+// TestMemoryRepositoryConcurrentUpdates counts in a greeting's message
+// from many goroutines at once, half of them with the version they read
+// and retrying on a mismatch, while others read it. No update may be
+// lost. Run it with -race.
+func TestMemoryRepositoryConcurrentUpdates(t *testing.T) {
+	const goroutines, updates = 8, 50
+	ctx := context.Background()
+	m := NewMemoryRepository()
+	g, err := m.Create(ctx, Greeting{Name: "counter", Message: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	increment := func(g *Greeting) error {
+		n, err := strconv.Atoi(g.Message)
+		g.Message = strconv.Itoa(n + 1)
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range updates {
+				if i%2 == 0 {
+					if _, err := m.Update(ctx, g.ID, 0, increment); err != nil {
+						t.Error(err)
+					}
+					continue
+				}
+				for { // 楽観的ロックで読み直す
+					cur, err := m.Get(ctx, g.ID)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					_, err = m.Update(ctx, g.ID, cur.Version, increment)
+					if !errors.Is(err, ErrVersionMismatch) {
+						if err != nil {
+							t.Error(err)
+						}
+						break
+					}
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range updates {
+				m.List(ctx, Filter{All: true})
+				m.GetAsOf(ctx, g.ID, g.CreatedAt)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := m.Get(ctx, g.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const total = goroutines * updates
+	if got.Message != strconv.Itoa(total) || got.Version != total+1 {
+		t.Errorf("after %d updates: message %s at version %d", total, got.Message, got.Version)
+	}
+	if n := len(m.history[g.ID]); n != total+1 {
+		t.Errorf("%d revisions kept, want %d", n, total+1)
+	}
+}
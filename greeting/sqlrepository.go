@@ -0,0 +1,276 @@
+package greeting
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// schema creates the tables of the SQLRepository: the greetings, and
+// their versions with the period each was current for. Their types and
+// the $n placeholders of the queries below work on both Postgres and
+// SQLite.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS greetings (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	message    TEXT NOT NULL,
+	language   TEXT NOT NULL,
+	version    BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`,
+	`CREATE TABLE IF NOT EXISTS greeting_revisions (
+	id         TEXT NOT NULL,
+	version    BIGINT NOT NULL,
+	name       TEXT NOT NULL,
+	message    TEXT NOT NULL,
+	language   TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	valid_from TIMESTAMP NOT NULL,
+	valid_to   TIMESTAMP,
+	PRIMARY KEY (id, version)
+)`,
+}
+
+// SQLRepository is a Repository that keeps greetings, and every version
+// of them, in the SQL database of package db.
+// 挨拶をデータベースに保存する
+type SQLRepository struct {
+	db *sql.DB
+}
+
+var _ Repository = (*SQLRepository)(nil)
+
+// NewSQLRepository builds a SQLRepository on db, creating its tables
+// when the application starts.
+func NewSQLRepository(lc fx.Lifecycle, db *sql.DB) *SQLRepository {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			for _, stmt := range schema {
+				if _, err := db.ExecContext(ctx, stmt); err != nil {
+					return fmt.Errorf("greeting: create table: %w", err)
+				}
+			}
+			return nil
+		},
+	})
+	return &SQLRepository{db: db}
+}
+
+// NewRepository returns a SQLRepository if there is a database, and a
+// MemoryRepository otherwise.
+func NewRepository(lc fx.Lifecycle, db *sql.DB) Repository {
+	if db == nil {
+		return NewMemoryRepository()
+	}
+	return NewSQLRepository(lc, db)
+}
+
+// now returns the current time as the database keeps it.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+const greetingColumns = `id, name, message, language, version, created_at, updated_at`
+
+// Create stores a new greeting, giving it an ID and its first version.
+func (r *SQLRepository) Create(ctx context.Context, g Greeting) (Greeting, error) {
+	g.ID = newID()
+	g.Version = 1
+	g.CreatedAt = now()
+	g.UpdatedAt = g.CreatedAt
+	err := r.tx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO greetings (`+greetingColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			g.ID, g.Name, g.Message, g.Language, g.Version, g.CreatedAt, g.UpdatedAt); err != nil {
+			return fmt.Errorf("greeting: insert: %w", err)
+		}
+		return addRevision(ctx, tx, g)
+	})
+	if err != nil {
+		return Greeting{}, err
+	}
+	return g, nil
+}
+
+// Get returns the greeting with the given ID.
+func (r *SQLRepository) Get(ctx context.Context, id string) (Greeting, error) {
+	return scanGreeting(r.db.QueryRowContext(ctx,
+		`SELECT `+greetingColumns+` FROM greetings WHERE id = $1`, id))
+}
+
+// Update applies a partial update to the greeting with the given ID.
+// The update is made only if the greeting is still at the version it
+// was read at, and tried again if it is not, unless version asked for
+// that one.
+func (r *SQLRepository) Update(ctx context.Context, id string, version int64, apply func(*Greeting) error) (Greeting, error) {
+	for {
+		old, err := r.Get(ctx, id)
+		if err != nil {
+			return Greeting{}, err
+		}
+		if version != 0 && version != old.Version {
+			return Greeting{}, ErrVersionMismatch
+		}
+		g := old
+		if err := apply(&g); err != nil {
+			return Greeting{}, err
+		}
+		g.ID, g.CreatedAt = old.ID, old.CreatedAt
+		g.Version = old.Version + 1
+		g.UpdatedAt = now()
+
+		raced := false
+		err = r.tx(ctx, func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(ctx,
+				`UPDATE greetings SET name = $1, message = $2, language = $3, version = $4, updated_at = $5 WHERE id = $6 AND version = $7`,
+				g.Name, g.Message, g.Language, g.Version, g.UpdatedAt, id, old.Version)
+			if err != nil {
+				return fmt.Errorf("greeting: update: %w", err)
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return fmt.Errorf("greeting: update: %w", err)
+			} else if n == 0 {
+				raced = true // 読んだ後に変更または削除された
+				return nil
+			}
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE greeting_revisions SET valid_to = $1 WHERE id = $2 AND valid_to IS NULL`,
+				g.UpdatedAt, id); err != nil {
+				return fmt.Errorf("greeting: update revision: %w", err)
+			}
+			return addRevision(ctx, tx, g)
+		})
+		if err != nil {
+			return Greeting{}, err
+		}
+		if !raced {
+			return g, nil
+		}
+		if version != 0 {
+			return Greeting{}, ErrVersionMismatch
+		}
+	}
+}
+
+// List returns every greeting the filter matches, oldest first.
+func (r *SQLRepository) List(ctx context.Context, f Filter) ([]Greeting, error) {
+	if f == (Filter{}) { // 条件のないフィルタは何にも一致しない
+		return nil, nil
+	}
+	var (
+		where []string
+		args  []any
+	)
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Name != "" {
+		add("name = $%d", f.Name)
+	}
+	if f.Language != "" {
+		add("language = $%d", f.Language)
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("created_at < $%d", f.CreatedBefore.UTC())
+	}
+	if !f.UpdatedBefore.IsZero() {
+		add("updated_at < $%d", f.UpdatedBefore.UTC())
+	}
+	query := `SELECT ` + greetingColumns + ` FROM greetings`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY created_at, id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("greeting: query: %w", err)
+	}
+	defer rows.Close()
+	var out []Greeting
+	for rows.Next() {
+		g, err := scanGreeting(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("greeting: query: %w", err)
+	}
+	return out, nil
+}
+
+// Delete removes the greeting with the given ID, ending the period of
+// its current version. Its versions are kept.
+func (r *SQLRepository) Delete(ctx context.Context, id string) error {
+	return r.tx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM greetings WHERE id = $1`, id)
+		if err != nil {
+			return fmt.Errorf("greeting: delete: %w", err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return ErrNotFound
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE greeting_revisions SET valid_to = $1 WHERE id = $2 AND valid_to IS NULL`,
+			now(), id); err != nil {
+			return fmt.Errorf("greeting: update revision: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetAsOf returns the version of the greeting that was current at t.
+func (r *SQLRepository) GetAsOf(ctx context.Context, id string, t time.Time) (Greeting, error) {
+	t = t.UTC()
+	return scanGreeting(r.db.QueryRowContext(ctx,
+		`SELECT id, name, message, language, version, created_at, valid_from FROM greeting_revisions
+		WHERE id = $1 AND valid_from <= $2 AND (valid_to IS NULL OR valid_to > $2)`, id, t))
+}
+
+// tx runs f in a transaction, committed if f succeeds.
+func (r *SQLRepository) tx(ctx context.Context, f func(*sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("greeting: begin: %w", err)
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("greeting: commit: %w", err)
+	}
+	return nil
+}
+
+// addRevision records g as the current version of its greeting.
+func addRevision(ctx context.Context, tx *sql.Tx, g Greeting) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO greeting_revisions (id, version, name, message, language, created_at, valid_from) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		g.ID, g.Version, g.Name, g.Message, g.Language, g.CreatedAt, g.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("greeting: insert revision: %w", err)
+	}
+	return nil
+}
+
+// scanGreeting reads a greeting from a row of the queries above.
+func scanGreeting(row interface{ Scan(...any) error }) (Greeting, error) {
+	var g Greeting
+	err := row.Scan(&g.ID, &g.Name, &g.Message, &g.Language, &g.Version, &g.CreatedAt, &g.UpdatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return Greeting{}, ErrNotFound
+	case err != nil:
+		return Greeting{}, fmt.Errorf("greeting: scan: %w", err)
+	}
+	return g, nil
+}
@@ -0,0 +1,162 @@
+// Package headers gives every response the same baseline of headers,
+// whichever handler or middleware wrote it: the request ID, the server
+// token, response timings and a default cache policy. Routes that need a
+// different policy declare it by implementing Custom.
+package headers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/fxdemo/servertiming"
+	"example.com/fxdemo/telemetry"
+)
+
+// TimingHeader reports how long the server took to start the response,
+// in milliseconds.
+const TimingHeader = "X-Response-Time"
+
+// Config is the policy applied to every response.
+type Config struct {
+	// Server is sent as the Server header; if empty, no Server header is
+	// sent, even one copied from a proxied response.
+	Server string `yaml:"server"`
+	// CacheControl is sent when the handler sets no Cache-Control.
+	CacheControl string `yaml:"cache_control"`
+	// Timing adds TimingHeader and a servertiming.Header with the phases
+	// of the request to every response.
+	Timing bool `yaml:"timing"`
+}
+
+// DefaultConfig keeps the server anonymous, keeps responses out of
+// caches unless their handler says otherwise, and reports timings.
+func DefaultConfig() Config {
+	return Config{CacheControl: "no-store", Timing: true}
+}
+
+// Policy is a route's departure from the Config. Empty fields keep the
+// configured behaviour.
+type Policy struct {
+	CacheControl string            // CacheControl replaces the default cache policy.
+	Set          map[string]string // Set adds headers the handler has not set itself.
+}
+
+// Custom is implemented by routes with a Policy of their own.
+type Custom interface {
+	HeaderPolicy() Policy
+}
+
+// state is what the outer handler needs to know to finish the headers.
+type state struct {
+	start   time.Time
+	policy  Policy
+	timings *servertiming.Timings
+}
+
+type stateKey struct{}
+
+// Standardizer applies the header policy.
+// レスポンスヘッダーを揃える
+type Standardizer struct {
+	cfg Config
+}
+
+// NewStandardizer builds a new Standardizer.
+func NewStandardizer(cfg Config) *Standardizer {
+	return &Standardizer{cfg: cfg}
+}
+
+// Wrap returns a handler that applies the policy to the headers of
+// every response just before they are sent.
+func (s *Standardizer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := &state{start: time.Now()}
+		ctx := context.WithValue(r.Context(), stateKey{}, st)
+		if s.cfg.Timing {
+			ctx, st.timings = servertiming.With(ctx)
+		}
+		sw := &writer{ResponseWriter: w, finish: func(h http.Header) { s.apply(ctx, h, st) }}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if !sw.wroteHeader {
+			sw.finish(w.Header()) // the response is empty; net/http sends the headers
+		}
+	})
+}
+
+// Route returns next unchanged unless route is Custom, in which case
+// the returned handler applies its Policy instead of the defaults.
+func (s *Standardizer) Route(route, next http.Handler) http.Handler {
+	c, ok := route.(Custom)
+	if !ok {
+		return next
+	}
+	policy := c.HeaderPolicy()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if st, ok := r.Context().Value(stateKey{}).(*state); ok {
+			st.policy = policy
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Standardizer) apply(ctx context.Context, h http.Header, st *state) {
+	if id := telemetry.From(ctx).RequestID; id != "" {
+		h.Set(telemetry.RequestIDHeader, id)
+	}
+	if s.cfg.Server != "" {
+		h.Set("Server", s.cfg.Server)
+	} else {
+		h.Del("Server")
+	}
+	if h.Get("X-Content-Type-Options") == "" {
+		h.Set("X-Content-Type-Options", "nosniff")
+	}
+	if h.Get("Cache-Control") == "" {
+		cc := s.cfg.CacheControl
+		if st.policy.CacheControl != "" {
+			cc = st.policy.CacheControl
+		}
+		if cc != "" {
+			h.Set("Cache-Control", cc)
+		}
+	}
+	for k, v := range st.policy.Set {
+		if h.Get(k) == "" {
+			h.Set(k, v)
+		}
+	}
+	if s.cfg.Timing {
+		elapsed := time.Since(st.start)
+		h.Set(TimingHeader, strconv.FormatFloat(float64(elapsed.Microseconds())/1000, 'f', 3, 64))
+		h.Set(servertiming.Header, servertiming.Format(st.timings.Metrics(), elapsed))
+	}
+}
+
+// writer calls finish on the headers before they are sent.
+type writer struct {
+	http.ResponseWriter
+	finish      func(http.Header)
+	wroteHeader bool
+}
+
+func (w *writer) WriteHeader(status int) {
+	if !w.wroteHeader && status >= 200 { // informational responses come before the real headers
+		w.wroteHeader = true
+		w.finish(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying writer.
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
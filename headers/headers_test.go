@@ -0,0 +1,175 @@
+package headers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"example.com/fxdemo/servertiming"
+	"example.com/fxdemo/telemetry"
+)
+
+// custom is a route with a header Policy of its own.
+type custom struct {
+	http.Handler
+	policy Policy
+}
+
+func (c custom) HeaderPolicy() Policy { return c.policy }
+
+// respond sets the given headers and writes a body, or nothing if the
+// body is empty.
+func respond(set map[string]string, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range set {
+			w.Header().Set(k, v)
+		}
+		if body != "" {
+			io.WriteString(w, body)
+		}
+	})
+}
+
+func TestStandardizer(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		route http.Handler
+		want  map[string]string // "" means the header must be absent
+	}{
+		{
+			name:  "defaults",
+			cfg:   DefaultConfig(),
+			route: respond(nil, "hi"),
+			want: map[string]string{
+				"X-Request-ID":           "req-1",
+				"Server":                 "",
+				"X-Content-Type-Options": "nosniff",
+				"Cache-Control":          "no-store",
+			},
+		},
+		{
+			name:  "empty response",
+			cfg:   DefaultConfig(),
+			route: respond(nil, ""),
+			want:  map[string]string{"X-Request-ID": "req-1", "Cache-Control": "no-store"},
+		},
+		{
+			name:  "handler headers kept",
+			cfg:   DefaultConfig(),
+			route: respond(map[string]string{"Cache-Control": "max-age=60", "X-Content-Type-Options": "other"}, "hi"),
+			want:  map[string]string{"Cache-Control": "max-age=60", "X-Content-Type-Options": "other"},
+		},
+		{
+			name:  "request ID not forged",
+			cfg:   DefaultConfig(),
+			route: respond(map[string]string{"X-Request-ID": "forged"}, "hi"),
+			want:  map[string]string{"X-Request-ID": "req-1"},
+		},
+		{
+			name:  "proxied server token removed",
+			cfg:   DefaultConfig(),
+			route: respond(map[string]string{"Server": "nginx/1.2.3"}, "hi"),
+			want:  map[string]string{"Server": ""},
+		},
+		{
+			name:  "server token configured",
+			cfg:   Config{Server: "fxdemo"},
+			route: respond(map[string]string{"Server": "nginx/1.2.3"}, "hi"),
+			want:  map[string]string{"Server": "fxdemo", "Cache-Control": ""},
+		},
+		{
+			name:  "route policy",
+			cfg:   DefaultConfig(),
+			route: custom{respond(nil, "hi"), Policy{CacheControl: "private, no-cache", Set: map[string]string{"Vary": "Accept"}}},
+			want:  map[string]string{"Cache-Control": "private, no-cache", "Vary": "Accept"},
+		},
+		{
+			name:  "route policy under handler headers",
+			cfg:   DefaultConfig(),
+			route: custom{respond(map[string]string{"Cache-Control": "no-cache", "Vary": "Origin"}, "hi"), Policy{CacheControl: "private", Set: map[string]string{"Vary": "Accept"}}},
+			want:  map[string]string{"Cache-Control": "no-cache", "Vary": "Origin"},
+		},
+		{
+			name:  "no timing",
+			cfg:   Config{},
+			route: respond(nil, "hi"),
+			want:  map[string]string{TimingHeader: "", servertiming.Header: ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStandardizer(tt.cfg)
+			h := s.Wrap(s.Route(tt.route, tt.route))
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(telemetry.With(r.Context(), telemetry.Context{RequestID: "req-1"}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+
+			for k, v := range tt.want {
+				if got := rec.Header().Get(k); got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+			if tt.cfg.Timing {
+				if rec.Header().Get(TimingHeader) == "" || rec.Header().Get(servertiming.Header) == "" {
+					t.Errorf("headers = %v, want the timings", rec.Header())
+				}
+			}
+		})
+	}
+}
+
+// snapshots is a ResponseWriter that keeps a copy of the headers sent
+// with each status.
+type snapshots struct {
+	header http.Header
+	sent   []http.Header
+	codes  []int
+}
+
+func (s *snapshots) Header() http.Header { return s.header }
+
+func (s *snapshots) Write(b []byte) (int, error) {
+	if len(s.codes) == 0 {
+		s.WriteHeader(http.StatusOK)
+	}
+	return len(b), nil
+}
+
+func (s *snapshots) WriteHeader(code int) {
+	s.codes = append(s.codes, code)
+	s.sent = append(s.sent, s.header.Clone())
+}
+
+// TestStandardizerEarlyHints checks that the policy is applied to the
+// final response rather than to an informational one before it.
+func TestStandardizerEarlyHints(t *testing.T) {
+	h := NewStandardizer(DefaultConfig()).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</app.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "hi")
+	}))
+	w := &snapshots{header: make(http.Header)}
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if len(w.codes) != 2 || w.codes[0] != http.StatusEarlyHints || w.codes[1] != http.StatusOK {
+		t.Fatalf("statuses = %v, want 103 then 200", w.codes)
+	}
+	if got := w.sent[0].Get("X-Content-Type-Options"); got != "" {
+		t.Errorf("103 response has X-Content-Type-Options %q, want the policy left to the final response", got)
+	}
+	if got := w.sent[1].Get("Cache-Control"); got != "max-age=60" {
+		t.Errorf("final Cache-Control = %q, want the one set after the hints", got)
+	}
+	if w.sent[1].Get(TimingHeader) == "" {
+		t.Errorf("final headers = %v, want the timings", w.sent[1])
+	}
+}
+
+func BenchmarkWrap(b *testing.B) {
+	httpfxtest.Benchmark(b, NewStandardizer(DefaultConfig()).Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,305 @@
+// Package health answers the probes of orchestrators and load balancers.
+// /healthz reports only that the process is alive, so that a database
+// going down does not get every instance restarted. /readyz reports
+// whether the checks registered by other components pass, and the
+// application has started and is not stopping, so that traffic is only
+// sent while it can be served.
+//
+// The probes are served ahead of the middleware that may refuse
+// requests, such as the WAF, the denylist and the rate limiter: see
+// NewProbes.
+//
+// Components register checks by providing them to the "healthchecks"
+// value group:
+//
+//	fx.Provide(health.AsCheck[*DatabaseCheck](NewDatabaseCheck))
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Config controls the checks and the drain.
+type Config struct {
+	// CheckTimeout bounds each check.
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+	// DrainDelay is how long the application keeps serving after /readyz
+	// starts failing on shutdown, for load balancers to notice.
+	DrainDelay time.Duration `yaml:"drain_delay"`
+}
+
+// DefaultConfig gives checks 2 seconds and does not delay shutdown.
+func DefaultConfig() Config {
+	return Config{CheckTimeout: 2 * time.Second}
+}
+
+// Checker checks a dependency the application cannot serve without.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// CheckFunc lets an ordinary function be used as a Checker.
+type CheckFunc struct {
+	CheckName string
+	Func      func(ctx context.Context) error
+}
+
+// Name returns c.CheckName.
+func (c CheckFunc) Name() string { return c.CheckName }
+
+// Check returns c.Func(ctx).
+func (c CheckFunc) Check(ctx context.Context) error { return c.Func(ctx) }
+
+// ChecksGroup is the value group of the checks provided with AsCheck.
+const ChecksGroup = "healthchecks"
+
+// AsCheck annotates the given constructor to state that it provides a
+// Checker to the "healthchecks" group, f returning a C.
+func AsCheck[C Checker](f any) any {
+	return httpfx.AsGroup[Checker, C](f, ChecksGroup)
+}
+
+// failures counts failed checks by name.
+var failures = expvar.NewMap("health_check_failures")
+
+// Params are the dependencies of NewRegistry.
+type Params struct {
+	fx.In
+
+	Config Config
+	Checks []Checker `group:"healthchecks"`
+	Log    *zap.Logger
+}
+
+// Registry holds the checks and whether the application is ready.
+// ヘルスチェックと準備状態
+type Registry struct {
+	cfg   Config
+	log   *zap.Logger
+	ready atomic.Bool
+
+	mu     sync.RWMutex
+	checks []Checker
+}
+
+// NewRegistry builds a Registry with the checks of the group. The
+// application is not ready until Track says so.
+func NewRegistry(p Params) *Registry {
+	return &Registry{cfg: p.Config, log: p.Log, checks: p.Checks}
+}
+
+// Register adds a check.
+func (r *Registry) Register(c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, c)
+}
+
+// Track makes the application ready once every OnStart hook appended
+// before it has run, and not ready as soon as it begins to stop, then
+// waits for the DrainDelay. It must be invoked after everything else,
+// as Fx runs the OnStop hooks in reverse.
+// 全てのOnStartの後に準備完了、停止の最初に準備中に戻す
+func Track(lc fx.Lifecycle, r *Registry) {
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			r.ready.Store(true)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			r.ready.Store(false)
+			if r.cfg.DrainDelay <= 0 {
+				return nil
+			}
+			r.log.Info("Waiting for load balancers to stop sending requests", zap.Duration("delay", r.cfg.DrainDelay))
+			select {
+			case <-time.After(r.cfg.DrainDelay):
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	})
+}
+
+// Ready reports whether the application has started and is not
+// stopping.
+func (r *Registry) Ready() bool {
+	return r.ready.Load()
+}
+
+// Run runs the checks concurrently and returns the error of each one
+// that failed, by name.
+func (r *Registry) Run(ctx context.Context) map[string]error {
+	r.mu.RLock()
+	checks := append([]Checker(nil), r.checks...)
+	r.mu.RUnlock()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c Checker) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, r.cfg.CheckTimeout)
+			defer cancel()
+			errs[i] = c.Check(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	failed := make(map[string]error)
+	for i, err := range errs {
+		if err != nil {
+			failed[checks[i].Name()] = err
+			failures.Add(checks[i].Name(), 1)
+		}
+	}
+	return failed
+}
+
+// report is the body of the probes' responses.
+type report struct {
+	Status string            `json:"status"`
+	Ready  *bool             `json:"ready,omitempty"`
+	Failed []string          `json:"failed,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"` // only for the loopback interface
+}
+
+// serve answers whether the application is alive or, for readiness,
+// runs the checks and answers 200 if they pass and it is ready, 503
+// otherwise.
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request, readiness bool) {
+	rep := report{Status: "ok"}
+	ok := true
+	var failed map[string]error
+	if readiness {
+		// 依存先の障害で再起動されないよう、生存確認では検査しない
+		failed = r.Run(req.Context())
+		ready := r.Ready()
+		rep.Ready = &ready
+		ok = len(failed) == 0 && ready
+	}
+	// 失敗の詳細は内部の情報を含みうるのでホスト内にだけ返す
+	local := false
+	if clientip.IsLoopback(req) {
+		local = true
+		rep.Errors = make(map[string]string, len(failed))
+	}
+	for name, err := range failed {
+		rep.Failed = append(rep.Failed, name)
+		if local {
+			rep.Errors[name] = err.Error()
+		}
+	}
+	sort.Strings(rep.Failed)
+	status := http.StatusOK
+	if !ok {
+		rep.Status, status = "failing", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rep)
+}
+
+// HealthHandler serves /healthz.
+type HealthHandler struct {
+	registry *Registry
+}
+
+// NewHealthHandler builds a new HealthHandler.
+func NewHealthHandler(r *Registry) *HealthHandler {
+	return &HealthHandler{registry: r}
+}
+
+// Pattern reports the path at which this is registered.
+func (*HealthHandler) Pattern() string {
+	return "/healthz"
+}
+
+// Methods reports the methods this serves.
+func (*HealthHandler) Methods() []string {
+	return []string{http.MethodGet}
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.registry.serve(w, r, false)
+}
+
+// ReadyHandler serves /readyz.
+type ReadyHandler struct {
+	registry *Registry
+}
+
+// NewReadyHandler builds a new ReadyHandler.
+func NewReadyHandler(r *Registry) *ReadyHandler {
+	return &ReadyHandler{registry: r}
+}
+
+// Pattern reports the path at which this is registered.
+func (*ReadyHandler) Pattern() string {
+	return "/readyz"
+}
+
+// Methods reports the methods this serves.
+func (*ReadyHandler) Methods() []string {
+	return []string{http.MethodGet}
+}
+
+func (h *ReadyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.registry.serve(w, r, true)
+}
+
+// Probes serves the probes, and passes every other request on to the
+// application's handler.
+type Probes struct {
+	health *HealthHandler
+	ready  *ReadyHandler
+}
+
+// NewProbes builds a new Probes.
+func NewProbes(health *HealthHandler, ready *ReadyHandler) *Probes {
+	return &Probes{health: health, ready: ready}
+}
+
+// Name names the middleware in traces.
+func (*Probes) Name() string {
+	return "health"
+}
+
+// Wrap returns a handler that answers the probes itself, so that they
+// are not refused by the middleware of next, such as the WAF or the
+// denylist, and passes everything else on to next.
+func (p *Probes) Wrap(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	for _, h := range []httpfx.Route{p.health, p.ready} {
+		mux.Handle(http.MethodGet+" "+h.Pattern(), h)
+	}
+	mux.Handle("/", next)
+	return mux
+}
+
+// Module provides the Registry and the probes. Track is left to the
+// application, which knows what is invoked last, and so is mounting
+// the Probes ahead of its middleware.
+var Module = fx.Module("health",
+	fx.Provide(
+		NewRegistry,
+		NewHealthHandler,
+		NewReadyHandler,
+		NewProbes,
+	),
+)
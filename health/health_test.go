@@ -0,0 +1,48 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// TestRegistryConcurrent runs the checks while others are registered and
+// the readiness changes. Run it with -race.
+func TestRegistryConcurrent(t *testing.T) {
+	const goroutines = 8
+	r := NewRegistry(Params{Config: DefaultConfig(), Log: zap.NewNop()})
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r.Register(CheckFunc{
+				CheckName: fmt.Sprintf("check-%d", i),
+				Func: func(context.Context) error {
+					if i%2 == 1 {
+						return errors.New("down")
+					}
+					return nil
+				},
+			})
+			r.ready.Store(i%2 == 0)
+		}()
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				r.Run(context.Background())
+				r.Ready()
+			}
+		}()
+	}
+	wg.Wait()
+
+	failed := r.Run(context.Background())
+	if len(failed) != goroutines/2 {
+		t.Errorf("failed = %v, want the %d odd checks", failed, goroutines/2)
+	}
+}
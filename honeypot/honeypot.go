@@ -0,0 +1,93 @@
+// Package honeypot serves decoy routes that no legitimate client has any
+// reason to request. Hits are reported to the audit log and may get the
+// client temporarily denied.
+package honeypot
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/audit"
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/denylist"
+)
+
+// Config lists the decoy paths and what happens when one is hit.
+type Config struct {
+	Paths   []string      `yaml:"paths"`    // Paths are decoy path prefixes.
+	Exempt  []string      `yaml:"exempt"`   // Exempt are paths that are never decoys, such as the health probes.
+	DenyFor time.Duration `yaml:"deny_for"` // DenyFor is how long to deny a client that hit a decoy; 0 disables.
+}
+
+// DefaultConfig returns decoys for paths commonly probed by scanners.
+// Hits are only reported: denying clients is opt-in, since behind a
+// proxy that is not trusted one scanner would get every client denied.
+func DefaultConfig() Config {
+	return Config{
+		Paths: []string{
+			"/wp-admin",
+			"/wp-login.php",
+			"/.env",
+			"/.git/",
+			"/phpmyadmin",
+		},
+		Exempt: []string{"/healthz", "/readyz"},
+	}
+}
+
+// Trap intercepts requests for decoy paths before they reach the mux.
+// おとりのパスへのアクセスを検知する
+type Trap struct {
+	cfg   Config
+	audit *audit.Logger
+	deny  *denylist.List
+}
+
+// NewTrap builds a new Trap.
+func NewTrap(cfg Config, auditLog *audit.Logger, deny *denylist.List) *Trap {
+	return &Trap{cfg: cfg, audit: auditLog, deny: deny}
+}
+
+// Wrap returns a handler that answers decoy paths itself
+// and passes everything else on to next.
+func (t *Trap) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !t.decoy(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := clientip.FromRequest(r)
+		t.audit.Record(r.Context(), audit.Event{
+			Kind:   "honeypot.hit",
+			Client: client,
+			Detail: map[string]string{
+				"method":     r.Method,
+				"path":       r.URL.Path,
+				"user_agent": r.UserAgent(),
+			},
+		})
+		// 自ホストは拒否しない。管理用のエンドポイントが使えなくなる
+		if ip := net.ParseIP(client); t.cfg.DenyFor > 0 && (ip == nil || !ip.IsLoopback()) {
+			t.deny.Add(client, t.cfg.DenyFor)
+		}
+		// Look like any other missing page so the scanner learns nothing.
+		apperror.NotFoundHandler().ServeHTTP(w, r)
+	})
+}
+
+func (t *Trap) decoy(path string) bool {
+	if slices.Contains(t.cfg.Exempt, path) {
+		return false
+	}
+	for _, p := range t.cfg.Paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
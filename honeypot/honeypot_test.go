@@ -0,0 +1,16 @@
+package honeypot
+
+import (
+	"testing"
+
+	"example.com/fxdemo/audit"
+	"example.com/fxdemo/denylist"
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+// BenchmarkWrap measures requests for paths that are not decoys.
+func BenchmarkWrap(b *testing.B) {
+	t := NewTrap(DefaultConfig(), audit.NewLogger(zap.NewNop(), nil), denylist.New(zap.NewNop()))
+	httpfxtest.Benchmark(b, t.Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,148 @@
+// Package ja3 computes JA3 fingerprints of TLS clients from the
+// ClientHello they send, giving a signal for classifying clients
+// (browsers, SDKs, bots) that does not depend on headers they control.
+//
+// See https://github.com/salesforce/ja3 for the fingerprint format.
+package ja3
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	recordTypeHandshake      = 22
+	handshakeTypeClientHello = 1
+
+	extSupportedGroups = 10
+	extPointFormats    = 11
+)
+
+var errMalformed = errors.New("ja3: malformed client hello")
+
+// Fingerprint is the JA3 string of a ClientHello and its MD5 hash.
+type Fingerprint struct {
+	String string
+	Hash   string
+}
+
+// Parse computes the fingerprint of the TLS record holding a ClientHello.
+func Parse(record []byte) (Fingerprint, error) {
+	if len(record) < 5 || record[0] != recordTypeHandshake {
+		return Fingerprint{}, errMalformed
+	}
+	r := reader(record[5:])
+	if t, ok := r.u8(); !ok || t != handshakeTypeClientHello {
+		return Fingerprint{}, errMalformed
+	}
+	r.skip(3) // handshake length
+
+	version, _ := r.u16()
+	r.skip(32) // random
+	sessionID, _ := r.u8()
+	r.skip(int(sessionID))
+
+	cipherLen, _ := r.u16()
+	ciphers := r.next(int(cipherLen))
+	compression, _ := r.u8()
+	r.skip(int(compression))
+	if r == nil {
+		return Fingerprint{}, errMalformed
+	}
+
+	var exts, groups, points []string
+	extLen, _ := r.u16()
+	rest := r.next(int(extLen))
+	for len(rest) >= 4 {
+		typ := binary.BigEndian.Uint16(rest)
+		n := int(binary.BigEndian.Uint16(rest[2:]))
+		if len(rest) < 4+n {
+			return Fingerprint{}, errMalformed
+		}
+		data := rest[4 : 4+n]
+		rest = rest[4+n:]
+
+		if grease(typ) {
+			continue
+		}
+		exts = append(exts, strconv.Itoa(int(typ)))
+		switch typ {
+		case extSupportedGroups:
+			if len(data) >= 2 {
+				groups = uint16s(data[2:])
+			}
+		case extPointFormats:
+			if len(data) >= 1 {
+				for _, p := range data[1:] {
+					points = append(points, strconv.Itoa(int(p)))
+				}
+			}
+		}
+	}
+
+	s := strings.Join([]string{
+		strconv.Itoa(int(version)),
+		strings.Join(uint16s(ciphers), "-"),
+		strings.Join(exts, "-"),
+		strings.Join(groups, "-"),
+		strings.Join(points, "-"),
+	}, ",")
+	sum := md5.Sum([]byte(s))
+	return Fingerprint{String: s, Hash: hex.EncodeToString(sum[:])}, nil
+}
+
+// uint16s formats a list of big endian uint16 values, leaving out
+// GREASE values as JA3 requires.
+func uint16s(b []byte) []string {
+	var out []string
+	for ; len(b) >= 2; b = b[2:] {
+		v := binary.BigEndian.Uint16(b)
+		if !grease(v) {
+			out = append(out, strconv.Itoa(int(v)))
+		}
+	}
+	return out
+}
+
+// grease reports whether v is one of the reserved GREASE values
+// from RFC 8701 (0x0a0a, 0x1a1a, ... 0xfafa).
+func grease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// reader consumes a byte slice, becoming nil once it runs out.
+type reader []byte
+
+func (r *reader) next(n int) []byte {
+	if *r == nil || len(*r) < n {
+		*r = nil
+		return nil
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b
+}
+
+func (r *reader) skip(n int) {
+	r.next(n)
+}
+
+func (r *reader) u8() (uint8, bool) {
+	b := r.next(1)
+	if b == nil {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (r *reader) u16() (uint16, bool) {
+	b := r.next(2)
+	if b == nil {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(b), true
+}
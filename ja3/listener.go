@@ -0,0 +1,149 @@
+package ja3
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+
+	"example.com/fxdemo/pkg/boundedvar"
+	"go.uber.org/zap"
+)
+
+// maxTracked bounds the number of distinct fingerprints counted
+// individually in the ja3_fingerprints expvar map.
+const maxTracked = 500
+
+var fingerprints = boundedvar.New("ja3_fingerprints", maxTracked)
+
+// Listener wraps a net.Listener so that the ClientHello of every
+// TLS connection it accepts is fingerprinted.
+// TLSのClientHelloからJA3を計算するリスナー
+type Listener struct {
+	net.Listener
+	log *zap.Logger
+}
+
+// NewListener wraps ln.
+func NewListener(ln net.Listener, log *zap.Logger) *Listener {
+	return &Listener{Listener: ln, log: log}
+}
+
+// Accept waits for the next connection. The connection is fingerprinted
+// as the TLS server reads the ClientHello from it, not here, so that a
+// slow client cannot hold up the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: c, l: l}, nil
+}
+
+// Conn is a connection accepted by Listener.
+type Conn struct {
+	net.Conn
+	l *Listener
+
+	once sync.Once
+	buf  []byte // the first record, replayed to the reader
+
+	mu    sync.Mutex
+	fp    Fingerprint
+	label string // fp.Hash, or "other" beyond maxTracked
+}
+
+// Read reads from the connection, capturing the first TLS record
+// on the first call.
+func (c *Conn) Read(b []byte) (int, error) {
+	var err error
+	c.once.Do(func() { err = c.capture() })
+	if len(c.buf) > 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// capture reads the first record and fingerprints it if it is a
+// ClientHello. Plaintext connections are left alone.
+func (c *Conn) capture() error {
+	header := make([]byte, 5)
+	n, err := io.ReadFull(c.Conn, header)
+	c.buf = header[:n]
+	if err != nil || header[0] != recordTypeHandshake {
+		return err
+	}
+
+	length := int(header[3])<<8 | int(header[4])
+	record := make([]byte, 5+length)
+	copy(record, header)
+	n, err = io.ReadFull(c.Conn, record[5:])
+	c.buf = record[:5+n]
+	if err != nil {
+		return err
+	}
+
+	fp, err := Parse(record)
+	if err != nil {
+		c.l.log.Debug("Failed to fingerprint client hello", zap.String("remote", c.RemoteAddr().String()), zap.Error(err))
+		return nil
+	}
+	label := fingerprints.Add(fp.Hash)
+	c.mu.Lock()
+	c.fp, c.label = fp, label
+	c.mu.Unlock()
+	c.l.log.Debug("TLS client hello", zap.String("remote", c.RemoteAddr().String()), zap.String("ja3", fp.Hash))
+	return nil
+}
+
+// Fingerprint returns the fingerprint of the connection's ClientHello,
+// or the zero Fingerprint if none has been seen.
+func (c *Conn) Fingerprint() Fingerprint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fp
+}
+
+type connKey struct{}
+
+// ConnContext is an http.Server ConnContext function that makes
+// the connection's fingerprint available to FromContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if conn, ok := c.(*Conn); ok {
+		return context.WithValue(ctx, connKey{}, conn)
+	}
+	return ctx
+}
+
+// FromContext returns the fingerprint of the connection the request
+// arrived on.
+func FromContext(ctx context.Context) Fingerprint {
+	if conn, ok := ctx.Value(connKey{}).(*Conn); ok {
+		return conn.Fingerprint()
+	}
+	return Fingerprint{}
+}
+
+// Label returns the hash of the fingerprint of the connection the
+// request arrived on, for use as a metric label: "none" if there is no
+// fingerprint, and "other" for the hashes beyond the first 500 seen, so
+// that clients cannot grow the metrics without bounds.
+func Label(ctx context.Context) string {
+	if conn, ok := ctx.Value(connKey{}).(*Conn); ok {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		if conn.label != "" {
+			return conn.label
+		}
+	}
+	return "none"
+}
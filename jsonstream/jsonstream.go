@@ -0,0 +1,178 @@
+// Package jsonstream writes large lists to HTTP responses one element at
+// a time, flushing as it goes, so that clients start receiving results
+// before the last one is encoded and the response is never held in
+// memory whole. Lists are written as a JSON array, or as newline
+// delimited JSON (NDJSON) to clients that ask for it.
+package jsonstream
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NDJSON is the media type of newline delimited JSON, one element per
+// line.
+const NDJSON = "application/x-ndjson"
+
+// Defaults of an Array's flushing.
+const (
+	DefaultFlushEvery    = 100
+	DefaultFlushInterval = 200 * time.Millisecond
+)
+
+// Encoder writes the elements of a list.
+type Encoder interface {
+	// Encode writes v as the next element. Once writing has failed, for
+	// instance because the client went away, Encode returns the same
+	// error without writing anything, so that the caller can stop
+	// producing elements.
+	Encode(v any) error
+	// Close ends the list and sends what is left to the client.
+	Close() error
+}
+
+// New returns an Encoder writing NDJSON if r accepts it, and a JSON array
+// otherwise, having set the Content-Type of w accordingly. The caller
+// sets the other headers before, and must Close the encoder once the
+// last element is written.
+// Acceptに応じて形式を選ぶ
+func New(w http.ResponseWriter, r *http.Request) Encoder {
+	if strings.Contains(r.Header.Get("Accept"), NDJSON) {
+		w.Header().Set("Content-Type", NDJSON)
+		return NewLines(w)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return NewArray(w)
+}
+
+// stream encodes values to a response, flushing them to the client every
+// FlushEvery values or FlushInterval, whichever comes first. Wrappers of
+// the response that cannot flush merely delay them.
+//
+// A flush does not return before the connection has taken the data, so a
+// client reading slowly holds up the writer, rather than letting encoded
+// values pile up in memory.
+type stream struct {
+	FlushEvery    int
+	FlushInterval time.Duration
+
+	w         http.ResponseWriter
+	rc        *http.ResponseController
+	enc       *json.Encoder
+	n         int // values written
+	pending   int // values written since the last flush
+	lastFlush time.Time
+	err       error
+}
+
+func newStream(w http.ResponseWriter) stream {
+	return stream{
+		FlushEvery:    DefaultFlushEvery,
+		FlushInterval: DefaultFlushInterval,
+		w:             w,
+		rc:            http.NewResponseController(w),
+		enc:           json.NewEncoder(w),
+		lastFlush:     time.Now(),
+	}
+}
+
+// Len returns the number of elements written.
+func (s *stream) Len() int {
+	return s.n
+}
+
+// write writes prefix and v, followed by a newline.
+func (s *stream) write(prefix string, v any) error {
+	if s.err != nil {
+		return s.err
+	}
+	if prefix != "" {
+		if _, s.err = s.w.Write([]byte(prefix)); s.err != nil {
+			return s.err
+		}
+	}
+	if s.err = s.enc.Encode(v); s.err != nil {
+		return s.err
+	}
+	s.n++
+	s.pending++
+	if s.pending >= s.FlushEvery || time.Since(s.lastFlush) >= s.FlushInterval {
+		s.flush()
+	}
+	return s.err
+}
+
+// end writes suffix, if any, and flushes.
+func (s *stream) end(suffix string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if suffix != "" {
+		if _, s.err = s.w.Write([]byte(suffix)); s.err != nil {
+			return s.err
+		}
+	}
+	s.flush()
+	return s.err
+}
+
+func (s *stream) flush() {
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.err = err
+	}
+	s.pending, s.lastFlush = 0, time.Now()
+}
+
+// Array writes a list as a JSON array.
+// JSON配列を要素ごとに書き出す
+type Array struct {
+	stream
+}
+
+// NewArray starts an array on w.
+func NewArray(w http.ResponseWriter) *Array {
+	return &Array{newStream(w)}
+}
+
+// Encode writes v as the next element of the array.
+func (a *Array) Encode(v any) error {
+	sep := ","
+	if a.n == 0 {
+		sep = "["
+	}
+	// Encoderは改行を付けるが、JSONとしては空白にすぎない
+	return a.write(sep, v)
+}
+
+// Close ends the array, writing "[]" if it has no elements.
+func (a *Array) Close() error {
+	if a.n == 0 {
+		return a.end("[]\n")
+	}
+	return a.end("]\n")
+}
+
+// Lines writes a list as NDJSON.
+// 1行に1要素ずつ書き出す
+type Lines struct {
+	stream
+}
+
+// NewLines starts NDJSON on w.
+func NewLines(w http.ResponseWriter) *Lines {
+	return &Lines{newStream(w)}
+}
+
+// Encode writes v on a line of its own.
+func (l *Lines) Encode(v any) error {
+	return l.write("", v)
+}
+
+// Close sends what is left to the client. An empty list is an empty
+// body.
+func (l *Lines) Close() error {
+	return l.end("")
+}
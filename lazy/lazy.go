@@ -0,0 +1,94 @@
+// Package lazy defers the initialization of expensive dependencies, such
+// as clients that dial a remote service, from startup to first use.
+//
+// A constructor provides a *lazy.Of[T] instead of a T:
+//
+//	func NewSearch(cfg search.Config) *lazy.Of[*search.Client] {
+//		return lazy.New("search", func() (*search.Client, error) {
+//			return search.Dial(cfg)
+//		})
+//	}
+//
+// and its users call Get when they first need the client.
+package lazy
+
+import (
+	"expvar"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// firstUse holds how long each dependency took to initialize, in
+	// milliseconds, by name.
+	firstUse = expvar.NewMap("lazy_first_use_ms")
+	// failures counts failed initializations by name.
+	failures = expvar.NewMap("lazy_init_failures")
+)
+
+// Of is a value of type T that is initialized the first time it is needed.
+// 初回利用時に初期化する値
+type Of[T any] struct {
+	name string
+	init func() (T, error)
+
+	mu    sync.Mutex
+	done  atomic.Bool
+	value T
+}
+
+// New returns an Of that calls init on first use. The name identifies
+// the dependency in the metrics.
+func New[T any](name string, init func() (T, error)) *Of[T] {
+	return &Of[T]{name: name, init: init}
+}
+
+// Get returns the value, initializing it if this is the first call.
+// Concurrent first calls wait for a single initialization. If it fails,
+// the error is returned and the next call tries again.
+func (l *Of[T]) Get() (T, error) {
+	if l.done.Load() {
+		return l.value, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done.Load() {
+		return l.value, nil
+	}
+
+	start := time.Now()
+	v, err := l.init()
+	if err != nil {
+		failures.Add(l.name, 1)
+		return v, err
+	}
+	ms := new(expvar.Float)
+	ms.Set(time.Since(start).Seconds() * 1000)
+	firstUse.Set(l.name, ms)
+
+	l.value = v
+	l.done.Store(true)
+	return v, nil
+}
+
+// Initialized reports whether the value has been initialized.
+func (l *Of[T]) Initialized() bool {
+	return l.done.Load()
+}
+
+// Close closes the value if it has been initialized and is an io.Closer,
+// so that it can be called from an OnStop hook without initializing a
+// dependency that was never used.
+func (l *Of[T]) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.done.Load() {
+		return nil
+	}
+	if c, ok := any(l.value).(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
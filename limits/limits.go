@@ -0,0 +1,118 @@
+// Package limits bounds what each route accepts: how large a request
+// body may be, and how long the route may take to answer.
+//
+//	limits:
+//	  max_body_bytes: 1048576
+//	  routes:
+//	    /echo: {max_body_bytes: 4096, timeout: 2s}
+package limits
+
+import (
+	"net/http"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/pkg/httpfx"
+)
+
+// NoLimit, as a route's MaxBodyBytes, lets it read bodies of any size.
+const NoLimit = -1
+
+// Config sets the limits of the routes.
+type Config struct {
+	// MaxBodyBytes is the largest request body a route accepts, unless
+	// its own limits say otherwise; zero means no limit.
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// Routes are the limits of particular routes, by their main pattern.
+	// They take precedence over those the routes declare themselves.
+	Routes map[string]Limits `yaml:"routes"`
+}
+
+// DefaultConfig accepts bodies of up to a megabyte, and leaves timeouts
+// to the deadline of each request.
+func DefaultConfig() Config {
+	return Config{MaxBodyBytes: 1 << 20}
+}
+
+// Limits are the limits of a route. Zero fields keep the defaults.
+type Limits struct {
+	// MaxBodyBytes is the largest request body the route accepts, or
+	// NoLimit.
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// Timeout is how long the route may take to answer. Past it, the
+	// client gets 503 Service Unavailable and whatever the route wrote
+	// is discarded. As the response is buffered until the route returns,
+	// routes that stream their responses should not have one.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Limited is implemented by routes with limits of their own, such as
+// those taking uploads larger than the default.
+type Limited interface {
+	Limits() Limits
+}
+
+// Enforcer applies the limits to each route.
+// ルートごとの制限
+type Enforcer struct {
+	cfg Config
+}
+
+// NewEnforcer builds a new Enforcer.
+func NewEnforcer(cfg Config) *Enforcer {
+	return &Enforcer{cfg: cfg}
+}
+
+// Of returns the limits of route: those of the Config for its pattern,
+// then those it declares, then the defaults.
+func (e *Enforcer) Of(route httpfx.Route) Limits {
+	var l Limits
+	if r, ok := route.(Limited); ok {
+		l = r.Limits()
+	}
+	if c, ok := e.cfg.Routes[route.Pattern()]; ok {
+		if c.MaxBodyBytes != 0 {
+			l.MaxBodyBytes = c.MaxBodyBytes
+		}
+		if c.Timeout != 0 {
+			l.Timeout = c.Timeout
+		}
+	}
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = e.cfg.MaxBodyBytes
+	}
+	return l
+}
+
+// Applies reports whether route has any limit, and so whether Route
+// wraps it.
+func (e *Enforcer) Applies(route httpfx.Route) bool {
+	l := e.Of(route)
+	return l.MaxBodyBytes > 0 || l.Timeout > 0
+}
+
+// Route returns next bounded by the limits of route, or next itself if
+// it has none. Bodies declared larger than the limit are refused with
+// 413 Content Too Large before next is called; those found to be while
+// next reads them make the read fail with an *http.MaxBytesError, which
+// apperror answers with the same status.
+func (e *Enforcer) Route(route httpfx.Route, next http.Handler) http.Handler {
+	l := e.Of(route)
+	h := next
+	if l.Timeout > 0 {
+		h = http.TimeoutHandler(h, l.Timeout, "Request timed out\n")
+	}
+	if l.MaxBodyBytes <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > l.MaxBodyBytes {
+			apperror.Write(w, r, nil, apperror.TooLarge(l.MaxBodyBytes))
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, l.MaxBodyBytes)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,118 @@
+// Package links builds absolute links for responses, such as the self
+// and next links of a JSON resource, as the client sees the server. When
+// the request came through a trusted reverse proxy, the X-Forwarded-Proto,
+// X-Forwarded-Host and X-Forwarded-Prefix headers it set are honoured.
+package links
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"example.com/fxdemo/clientip"
+)
+
+// Config lists the reverse proxies whose X-Forwarded-* headers are believed.
+type Config struct {
+	// TrustedProxies holds IP addresses and CIDR ranges.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// DefaultConfig trusts no proxy.
+func DefaultConfig() Config {
+	return Config{}
+}
+
+// Link is a link in a JSON response.
+type Link struct {
+	Href string `json:"href"`
+}
+
+// Links holds the links of a resource by relation, as "_links".
+type Links map[string]Link
+
+// Builder builds links relative to the URL the client used.
+// 絶対URLのリンクを作る
+type Builder struct {
+	proxies *clientip.Resolver
+}
+
+// NewBuilder builds a new Builder.
+func NewBuilder(proxies *clientip.Resolver) *Builder {
+	return &Builder{proxies: proxies}
+}
+
+// NewResolver builds the clientip.Resolver believing the proxies that
+// cfg trusts, so that the client of a request is the same for every
+// middleware as for the links.
+func NewResolver(cfg Config) (*clientip.Resolver, error) {
+	return clientip.NewResolver(cfg.TrustedProxies)
+}
+
+// Base returns the scheme, host and path prefix under which the client
+// reached the server, such as "https://example.com/api".
+func (b *Builder) Base(r *http.Request) string {
+	scheme, host, prefix := "http", r.Host, ""
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if b.proxies.TrustedPeer(r) {
+		if v := first(r.Header.Get("X-Forwarded-Proto")); v == "http" || v == "https" {
+			scheme = v
+		}
+		if v := first(r.Header.Get("X-Forwarded-Host")); v != "" {
+			host = v
+		}
+		prefix = strings.TrimSuffix(first(r.Header.Get("X-Forwarded-Prefix")), "/")
+	}
+	return scheme + "://" + host + prefix
+}
+
+// URL returns the absolute URL of path, with the given query if any.
+func (b *Builder) URL(r *http.Request, path string, query url.Values) string {
+	u := b.Base(r) + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// Self links to the resource the request asked for.
+func (b *Builder) Self(r *http.Request) Link {
+	u := requested(r)
+	return Link{Href: b.URL(r, u.Path, u.Query())}
+}
+
+// Next links to the current resource with the query parameters in
+// set replaced, as in the next page of a list.
+func (b *Builder) Next(r *http.Request, set url.Values) Link {
+	u := requested(r)
+	q := u.Query()
+	for k, v := range set {
+		q[k] = v
+	}
+	return Link{Href: b.URL(r, u.Path, q)}
+}
+
+// Related links to another resource of the server.
+func (b *Builder) Related(r *http.Request, path string) Link {
+	return Link{Href: b.URL(r, path, nil)}
+}
+
+// requested returns the URL the client asked for. r.URL may have lost a
+// prefix on the way in, such as the version of a versioned route.
+func requested(r *http.Request) *url.URL {
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		return u
+	}
+	return r.URL
+}
+
+// first returns the first of the comma separated values a chain of
+// proxies may have built up.
+func first(v string) string {
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"example.com/fxdemo/client"
+	"example.com/fxdemo/pkg/httpfx"
+)
+
+// target is an operation loadtest can call. payload is the body of
+// operations that send one, sized by -size.
+type target func(ctx context.Context, c *client.Client, payload []byte) (*http.Response, error)
+
+// targets are the operations loadtest knows, by name.
+var targets = map[string]target{
+	"health": func(ctx context.Context, c *client.Client, _ []byte) (*http.Response, error) {
+		return c.GetHealth(ctx)
+	},
+	"ready": func(ctx context.Context, c *client.Client, _ []byte) (*http.Response, error) {
+		return c.GetReadiness(ctx)
+	},
+	"greeting": func(ctx context.Context, c *client.Client, payload []byte) (*http.Response, error) {
+		body, _ := json.Marshal(map[string]string{"name": "loadtest", "message": string(payload[:min(len(payload), 1000)])})
+		return c.CreateGreeting(ctx, bytes.NewReader(body))
+	},
+	"users": func(ctx context.Context, c *client.Client, _ []byte) (*http.Response, error) {
+		return c.ListUsers(ctx)
+	},
+	"notes": func(ctx context.Context, c *client.Client, _ []byte) (*http.Response, error) {
+		return c.ListNotes(ctx, client.ListNotesParams{})
+	},
+	"echo": func(ctx context.Context, c *client.Client, payload []byte) (*http.Response, error) {
+		return c.Echo(ctx, bytes.NewReader(payload)) // -tags demo のサーバーだけ
+	},
+}
+
+// loadtest sends requests to a running server at a steady rate for a
+// while, then reports the latency of each target. Requests are sent on
+// schedule whether or not earlier ones have been answered, up to
+// -concurrency in flight; those that would exceed it are counted as
+// skipped rather than delayed, so that a slow server shows as such
+// instead of slowing the test down.
+func loadtest(args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	server := fs.String("server", "http://localhost"+httpfx.DefaultAddr, "base URL of the server")
+	spec := fs.String("targets", "health", "comma-separated targets, each optionally weighted as name:weight; known: "+strings.Join(targetNames(), ", "))
+	rate := fs.Float64("rate", 50, "requests per second, across all targets")
+	duration := fs.Duration("duration", 10*time.Second, "how long to send requests")
+	concurrency := fs.Int("concurrency", 64, "most requests in flight at once")
+	size := fs.Int("size", 256, "bytes in the body of the targets that send one")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("loadtest takes no arguments, got %q", fs.Args())
+	}
+	if *rate <= 0 || *concurrency < 1 || *size < 0 {
+		return errors.New("-rate and -concurrency must be positive and -size not negative")
+	}
+	schedule, err := parseTargets(*spec)
+	if err != nil {
+		return err
+	}
+
+	c := client.New(*server)
+	c.HTTPClient = &http.Client{Transport: &http.Transport{
+		MaxIdleConnsPerHost: *concurrency,
+	}}
+	payload := bytes.Repeat([]byte("x"), *size)
+
+	// Ctrl-Cで止めても、それまでの結果は報告する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	results := make(map[string]*result)
+	for _, name := range schedule {
+		results[name] = &result{}
+	}
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, *concurrency)
+		tick    = time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		begun   = time.Now()
+		skipped int
+	)
+	defer tick.Stop()
+	fmt.Fprintf(os.Stderr, "Sending %g requests/s to %s for %v\n", *rate, *server, *duration)
+loop:
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-tick.C:
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			skipped++
+			continue
+		}
+		name := schedule[i%len(schedule)]
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			results[name].record(send(c, targets[name], payload, *timeout))
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(begun)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "target\trequests\terrors\treq/s\tp50\tp90\tp99\tmax\t")
+	names := slices.Clone(schedule)
+	slices.Sort(names)
+	names = slices.Compact(names)
+	for _, name := range names {
+		r := results[name]
+		slices.Sort(r.latencies)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%v\t%v\t%v\t%v\t\n", name, len(r.latencies), r.errors,
+			float64(len(r.latencies))/elapsed.Seconds(),
+			r.percentile(0.50), r.percentile(0.90), r.percentile(0.99), r.percentile(1))
+	}
+	w.Flush()
+	if skipped > 0 {
+		fmt.Printf("%d requests skipped with %d already in flight\n", skipped, *concurrency)
+	}
+	for _, name := range names {
+		if msg := results[name].firstError; msg != "" {
+			fmt.Printf("%s: first error: %s\n", name, msg)
+		}
+	}
+	return nil
+}
+
+// send calls t once and returns how long it took, reading the whole
+// response, and its error, if any.
+func send(c *client.Client, t target, payload []byte, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := t(ctx, c, payload)
+	if err != nil {
+		return time.Since(start), err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return time.Since(start), err
+}
+
+// result gathers the outcome of the requests of a target.
+type result struct {
+	mu         sync.Mutex
+	latencies  []time.Duration // of every request, failed or not
+	errors     int
+	firstError string
+}
+
+func (r *result) record(d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = append(r.latencies, d)
+	if err != nil {
+		r.errors++
+		if r.firstError == "" {
+			r.firstError = err.Error()
+		}
+	}
+}
+
+// percentile returns the latency below which the fraction p of the
+// requests fall, rounded to the microsecond. The latencies must have
+// been sorted.
+func (r *result) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(r.latencies)))) - 1
+	return r.latencies[max(i, 0)].Round(time.Microsecond)
+}
+
+// parseTargets turns "health,greeting:3" into the order targets are
+// called in, each appearing as often as its weight.
+func parseTargets(spec string) ([]string, error) {
+	var schedule []string
+	for _, s := range strings.Split(spec, ",") {
+		name, weight, hasWeight := strings.Cut(strings.TrimSpace(s), ":")
+		if _, ok := targets[name]; !ok {
+			return nil, fmt.Errorf("unknown target %q; known: %s", name, strings.Join(targetNames(), ", "))
+		}
+		n := 1
+		if hasWeight {
+			var err error
+			if n, err = strconv.Atoi(weight); err != nil || n < 1 || n > 100 {
+				return nil, fmt.Errorf("target %s: weight must be between 1 and 100", name)
+			}
+		}
+		for range n {
+			schedule = append(schedule, name)
+		}
+	}
+	return schedule, nil
+}
+
+func targetNames() []string {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,127 @@
+// Package logging builds the application's zap logger from its
+// configuration.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxevent"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Config configures the logger.
+type Config struct {
+	// Level is the lowest level logged: debug, info, warn or error.
+	// Requests with a debug token are logged in full regardless.
+	Level string `yaml:"level"`
+	// Encoding is json, for machines, or console, for people.
+	Encoding string `yaml:"encoding"`
+	// Development adds the caller and stack traces of warnings to
+	// entries and makes DPanic panic.
+	Development bool `yaml:"development"`
+	// Sampling, if set, caps repeated entries.
+	Sampling *Sampling `yaml:"sampling"`
+	// FxLevel is the level of Fx's own events, such as the constructors
+	// provided and the hooks run: info logs them, warn only their errors.
+	FxLevel string `yaml:"fx_level"`
+}
+
+// Sampling logs the first Initial entries with the same level and
+// message each second, then every Thereafter-th of them. Both must be at
+// least 1.
+type Sampling struct {
+	Initial    int `yaml:"initial"`
+	Thereafter int `yaml:"thereafter"`
+}
+
+// DefaultConfig logs everything as JSON, as the demo always has.
+func DefaultConfig() Config {
+	return Config{Level: "debug", Encoding: "json", FxLevel: "info"}
+}
+
+// Build builds the logger of cfg, writing to standard output.
+func Build(cfg Config) (*zap.Logger, error) {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, err
+	}
+	encoderCfg := zapcore.EncoderConfig{
+		MessageKey:     "msg",
+		LevelKey:       "level",
+		NameKey:        "logger",
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+	}
+	var opts []zap.Option
+	if cfg.Development {
+		encoderCfg.TimeKey = "ts"
+		encoderCfg.CallerKey = "caller"
+		encoderCfg.StacktraceKey = "stacktrace"
+		encoderCfg.EncodeCaller = zapcore.ShortCallerEncoder
+		opts = append(opts, zap.Development(), zap.AddCaller(), zap.AddStacktrace(zapcore.WarnLevel))
+	}
+
+	var encoder zapcore.Encoder
+	switch cfg.Encoding {
+	case "", "json":
+		encoder = zapcore.NewJSONEncoder(encoderCfg)
+	case "console":
+		encoderCfg.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderCfg)
+	default:
+		return nil, fmt.Errorf("logging: unknown encoding %q", cfg.Encoding)
+	}
+
+	core := zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), level)
+	if s := cfg.Sampling; s != nil {
+		if s.Initial < 1 || s.Thereafter < 1 {
+			return nil, errors.New("logging: sampling needs initial and thereafter of at least 1")
+		}
+		core = zapcore.NewSamplerWithOptions(core, time.Second, s.Initial, s.Thereafter)
+	}
+	return zap.New(core, opts...), nil
+}
+
+// New builds the logger of cfg and flushes it when the application
+// stops.
+// ロガーを作る
+func New(lc fx.Lifecycle, cfg Config) (*zap.Logger, error) {
+	log, err := Build(cfg)
+	if err != nil {
+		return nil, err
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			err := log.Sync()
+			// 端末やパイプへの標準出力はfsyncできない
+			if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTTY) {
+				return nil
+			}
+			return err
+		},
+	})
+	return log, nil
+}
+
+// NewFxLogger returns the logger of Fx's own events, writing to log at
+// the level of cfg.FxLevel.
+func NewFxLogger(log *zap.Logger, cfg Config) (*fxevent.ZapLogger, error) {
+	level := zapcore.InfoLevel
+	if cfg.FxLevel != "" {
+		if err := level.UnmarshalText([]byte(cfg.FxLevel)); err != nil {
+			return nil, fmt.Errorf("logging: fx_level: %w", err)
+		}
+	}
+	return &fxevent.ZapLogger{Logger: log.WithOptions(zap.IncreaseLevel(level))}, nil
+}
+
+// Module provides the logger, configured by a Config.
+var Module = fx.Module("logging", fx.Provide(New))
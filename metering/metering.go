@@ -0,0 +1,175 @@
+// Package metering records how much of the API each tenant uses — calls,
+// bytes transferred and time spent serving them — aggregated by day, so
+// that usage can be attributed and billed. A tenant is the subject an
+// authenticated client was authenticated as.
+package metering
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/auth"
+	"example.com/fxdemo/telemetry"
+	"example.com/fxdemo/transfer"
+	"go.uber.org/zap"
+)
+
+// DayFormat is the layout of the days usage is aggregated by.
+const DayFormat = "2006-01-02"
+
+// Usage is what a tenant used of the API on a day.
+type Usage struct {
+	Tenant       string  `json:"tenant"`
+	Day          string  `json:"day"` // in DayFormat, UTC
+	Calls        int64   `json:"calls"`
+	BytesRead    int64   `json:"bytes_read"`
+	BytesWritten int64   `json:"bytes_written"`
+	ComputeMS    float64 `json:"compute_ms"` // time spent in handlers
+}
+
+// add accumulates u into the receiver.
+func (a *Usage) add(u Usage) {
+	a.Calls += u.Calls
+	a.BytesRead += u.BytesRead
+	a.BytesWritten += u.BytesWritten
+	a.ComputeMS += u.ComputeMS
+}
+
+// Store aggregates usage by tenant and day.
+type Store interface {
+	// Add adds u to the usage already recorded for its tenant and day.
+	Add(ctx context.Context, u Usage) error
+	// Query returns the usage from the day from to the day to, both
+	// included, sorted by day and tenant. An empty tenant selects
+	// every tenant.
+	Query(ctx context.Context, tenant, from, to string) ([]Usage, error)
+}
+
+// maxTenants bounds the tenants kept apart in memory, as telemetry does
+// for the requests_by_tenant expvar map. The usage of those beyond is
+// kept as that of the "other" tenant.
+const maxTenants = 200
+
+// tenants are the tenants kept apart so far.
+type tenants map[string]bool
+
+// admit returns tenant if it is one of the first maxTenants, and
+// "other" otherwise. The caller holds the lock guarding t.
+func (t tenants) admit(tenant string) string {
+	if !t[tenant] && len(t) >= maxTenants {
+		return "other"
+	}
+	t[tenant] = true
+	return tenant
+}
+
+// MemoryStore is a Store that keeps usage in memory, for up to
+// maxTenants tenants.
+// 利用量をメモリ上で集計する
+type MemoryStore struct {
+	mu      sync.Mutex
+	usage   map[[2]string]*Usage // by day and tenant
+	tenants tenants
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore builds an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{usage: make(map[[2]string]*Usage), tenants: make(tenants)}
+}
+
+// Add implements Store.
+func (s *MemoryStore) Add(ctx context.Context, u Usage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.Tenant = s.tenants.admit(u.Tenant)
+	key := [2]string{u.Day, u.Tenant}
+	if a, ok := s.usage[key]; ok {
+		a.add(u)
+		return nil
+	}
+	s.usage[key] = &u
+	return nil
+}
+
+// Query implements Store.
+func (s *MemoryStore) Query(ctx context.Context, tenant, from, to string) ([]Usage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []Usage{}
+	for key, u := range s.usage {
+		if key[0] < from || key[0] > to || (tenant != "" && key[1] != tenant) {
+			continue
+		}
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Day != out[j].Day {
+			return out[i].Day < out[j].Day
+		}
+		return out[i].Tenant < out[j].Tenant
+	})
+	return out, nil
+}
+
+// Recorder records the usage of every request made by an authenticated
+// client, billed to the subject it was authenticated as. It wraps the
+// routes inside the auth.Guard, which establishes the identity, and
+// relies on a transfer.Meter further out for the byte counts.
+type Recorder struct {
+	store  Store
+	notify *Notifier
+	log    *zap.Logger
+}
+
+// NewRecorder builds a new Recorder.
+func NewRecorder(store Store, notify *Notifier, log *zap.Logger) *Recorder {
+	return &Recorder{store: store, notify: notify, log: log}
+}
+
+// Wrap returns a handler that records the usage of each request once
+// next has served it. The tenant of the request's telemetry is that of
+// its identity, rather than the one the client claimed. Requests
+// without an identity are not recorded.
+func (rec *Recorder) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := auth.FromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// X-Tenant-IDは自己申告なので、課金には認証された主体を使う
+		tenant := id.Subject
+		ctx := telemetry.Update(r.Context(), func(tc *telemetry.Context) { tc.Tenant = tenant })
+		r = r.WithContext(ctx)
+		start := time.Now()
+		next.ServeHTTP(w, r)
+
+		u := Usage{
+			Tenant:    tenant,
+			Day:       start.UTC().Format(DayFormat),
+			Calls:     1,
+			ComputeMS: time.Since(start).Seconds() * 1000,
+		}
+		if c := transfer.FromContext(r.Context()); c != nil {
+			u.BytesRead, u.BytesWritten = c.Read, c.Written
+		}
+		// The request's own deadline may have passed; usage is
+		// recorded regardless.
+		if err := rec.store.Add(context.Background(), u); err != nil {
+			telemetry.Logger(r.Context(), rec.log).Error("Failed to record usage", zap.Error(err))
+			return
+		}
+		rec.notify.Observe(u)
+	})
+}
@@ -0,0 +1,27 @@
+package metering
+
+import (
+	"net/http"
+	"testing"
+
+	"example.com/fxdemo/auth"
+	"example.com/fxdemo/events"
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+// BenchmarkWrap measures requests of an authenticated tenant, whose
+// usage is recorded.
+func BenchmarkWrap(b *testing.B) {
+	lc := fxtest.NewLifecycle(b)
+	notify, err := NewNotifier(lc, DefaultConfig(), zap.NewNop(), events.NewBus(events.Params{Lifecycle: lc, Log: zap.NewNop()}))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	h := NewRecorder(NewMemoryStore(), notify, zap.NewNop()).Wrap(httpfxtest.Noop)
+	httpfxtest.Benchmark(b, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), auth.Identity{Subject: "acme"})))
+	}))
+}
@@ -0,0 +1,22 @@
+package metering
+
+import (
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+)
+
+// Module provides the Recorder, the Notifier and the usage route over
+// an in-memory Store. The Recorder is middleware; the application puts
+// it in its chain.
+// テナントごとの利用量
+var Module = fx.Module("metering",
+	fx.Provide(
+		NewRecorder,
+		NewNotifier, // しきい値を超えたら課金システムに通知
+		fx.Annotate(
+			NewMemoryStore,
+			fx.As(new(Store)),
+		),
+		httpfx.AsRoute[*Handler](NewHandler), // 利用量の参照とCSV出力
+	),
+)
@@ -0,0 +1,288 @@
+package metering
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/deadline"
+	"example.com/fxdemo/events"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Config sets the usage thresholds that trigger billing notifications,
+// and where those are sent.
+type Config struct {
+	Thresholds []Threshold `yaml:"thresholds"`
+	// WebhookURL receives a signed POST for each threshold crossed. No
+	// webhooks are sent if it is empty; crossings are still logged.
+	WebhookURL    string        `yaml:"webhook_url"`
+	WebhookSecret string        `yaml:"webhook_secret"` // WebhookSecret signs the webhooks; required with WebhookURL.
+	Timeout       time.Duration `yaml:"timeout"`        // Timeout bounds each delivery attempt.
+}
+
+// Threshold is a level of monthly usage billing wants to hear about.
+type Threshold struct {
+	Name   string  `yaml:"name"`   // Name identifies the threshold to the receiver, e.g. "calls_80".
+	Metric string  `yaml:"metric"` // Metric is one of "calls", "bytes" or "compute_ms".
+	Limit  float64 `yaml:"limit"`  // Limit is crossed once a tenant's month-to-date usage reaches it.
+}
+
+// DefaultConfig sets no thresholds.
+func DefaultConfig() Config {
+	return Config{Timeout: 10 * time.Second}
+}
+
+// MonthFormat is the layout of the billing periods thresholds apply to.
+const MonthFormat = "2006-01"
+
+// Event is the notification that a tenant crossed a threshold. It is
+// the body of the webhook.
+type Event struct {
+	ID        string    `json:"id"` // the same for every delivery of the event
+	Type      string    `json:"type"`
+	Tenant    string    `json:"tenant"`
+	Month     string    `json:"month"` // in MonthFormat, UTC
+	Threshold string    `json:"threshold"`
+	Metric    string    `json:"metric"`
+	Limit     float64   `json:"limit"`
+	Value     float64   `json:"value"` // the usage that crossed the limit
+	Time      time.Time `json:"time"`
+}
+
+const (
+	eventType   = "usage.threshold_crossed"
+	queueLength = 256 // events waiting for delivery
+	attempts    = 3   // deliveries tried per event
+)
+
+var (
+	thresholdsCrossed = expvar.NewInt("metering_thresholds_crossed")
+	webhookFailures   = expvar.NewInt("metering_webhook_failures")
+)
+
+// Notifier follows each tenant's usage over the month and notifies
+// billing, by log, webhook and event bus, when it crosses a threshold. Each
+// threshold is notified once per tenant and month.
+//
+// Crossings are remembered in memory only, so a restart may notify one
+// again; receivers should deduplicate by Event.ID, which is stable.
+// 利用量がしきい値を超えたら課金システムに通知する
+type Notifier struct {
+	cfg    Config
+	log    *zap.Logger
+	client *http.Client
+	queue  chan Event
+	bus    *events.Bus
+
+	mu       sync.Mutex
+	month    string
+	tenants  tenants           // up to maxTenants for the month
+	totals   map[string]*Usage // by tenant, for the month
+	notified map[string]bool   // by Event.ID
+}
+
+// NewNotifier builds a Notifier whose deliveries run for as long as the
+// Fx application.
+func NewNotifier(lc fx.Lifecycle, cfg Config, log *zap.Logger, bus *events.Bus) (*Notifier, error) {
+	for _, t := range cfg.Thresholds {
+		switch t.Metric {
+		case "calls", "bytes", "compute_ms":
+		default:
+			return nil, fmt.Errorf("metering: threshold %q has unknown metric %q", t.Name, t.Metric)
+		}
+	}
+	if cfg.WebhookURL != "" && cfg.WebhookSecret == "" {
+		return nil, errors.New("metering: webhook_url requires webhook_secret")
+	}
+	n := &Notifier{
+		cfg:      cfg,
+		log:      log,
+		client:   &http.Client{Transport: &deadline.Transport{Limit: cfg.Timeout}},
+		queue:    make(chan Event, queueLength),
+		bus:      bus,
+		tenants:  make(tenants),
+		totals:   make(map[string]*Usage),
+		notified: make(map[string]bool),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go n.run(ctx, stopped)
+			return nil
+		},
+		OnStop: func(stop context.Context) error {
+			cancel()
+			select {
+			case <-stopped:
+				return nil
+			case <-stop.Done():
+				return stop.Err()
+			}
+		},
+	})
+	return n, nil
+}
+
+// Observe adds u to its tenant's usage for the month, and notifies any
+// threshold that pushes it over.
+func (n *Notifier) Observe(u Usage) {
+	if len(n.cfg.Thresholds) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	month := now.Format(MonthFormat)
+
+	n.mu.Lock()
+	if month != n.month {
+		n.month = month
+		n.tenants = make(tenants)
+		n.totals = make(map[string]*Usage)
+		n.notified = make(map[string]bool)
+	}
+	u.Tenant = n.tenants.admit(u.Tenant)
+	total, ok := n.totals[u.Tenant]
+	if !ok {
+		total = &Usage{Tenant: u.Tenant}
+		n.totals[u.Tenant] = total
+	}
+	total.add(u)
+	var crossed []Event
+	for _, t := range n.cfg.Thresholds {
+		value := metric(*total, t.Metric)
+		id := eventID(u.Tenant, month, t.Name)
+		if value < t.Limit || n.notified[id] {
+			continue
+		}
+		n.notified[id] = true
+		crossed = append(crossed, Event{
+			ID:        id,
+			Type:      eventType,
+			Tenant:    u.Tenant,
+			Month:     month,
+			Threshold: t.Name,
+			Metric:    t.Metric,
+			Limit:     t.Limit,
+			Value:     value,
+			Time:      now,
+		})
+	}
+	n.mu.Unlock()
+
+	for _, e := range crossed {
+		thresholdsCrossed.Add(1)
+		n.log.Info("Usage threshold crossed",
+			zap.String("event", e.ID),
+			zap.String("tenant", e.Tenant),
+			zap.String("threshold", e.Threshold),
+			zap.Float64("value", e.Value),
+		)
+		n.bus.Publish(context.Background(), events.Event{Kind: e.Type, Time: e.Time, Data: e})
+		if n.cfg.WebhookURL == "" {
+			continue
+		}
+		select {
+		case n.queue <- e:
+		default:
+			webhookFailures.Add(1)
+			n.log.Error("Dropped usage webhook, queue full", zap.String("event", e.ID))
+		}
+	}
+}
+
+func metric(u Usage, name string) float64 {
+	switch name {
+	case "calls":
+		return float64(u.Calls)
+	case "bytes":
+		return float64(u.BytesRead + u.BytesWritten)
+	default:
+		return u.ComputeMS
+	}
+}
+
+// eventID derives a stable ID for the crossing of a threshold by a
+// tenant in a month.
+func eventID(tenant, month, threshold string) string {
+	sum := sha256.Sum256([]byte(tenant + "\x00" + month + "\x00" + threshold))
+	return "evt_" + hex.EncodeToString(sum[:12])
+}
+
+func (n *Notifier) run(ctx context.Context, stopped chan<- struct{}) {
+	defer close(stopped)
+	for {
+		select {
+		case e := <-n.queue:
+			n.deliver(ctx, e)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver posts the event to the webhook, retrying with backoff.
+func (n *Notifier) deliver(ctx context.Context, e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		n.log.Error("Failed to encode usage webhook", zap.Error(err))
+		return
+	}
+	backoff := time.Second
+	for i := 1; ; i++ {
+		err = n.post(ctx, e.ID, body)
+		if err == nil {
+			return
+		}
+		if i == attempts || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+		}
+	}
+	webhookFailures.Add(1)
+	n.log.Error("Failed to deliver usage webhook", zap.String("event", e.ID), zap.Error(err))
+}
+
+// post sends one delivery, signed as described by the Standard Webhooks
+// specification: an HMAC-SHA256 of "id.timestamp.body".
+func (n *Notifier) post(ctx context.Context, id string, body []byte) error {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(n.cfg.WebhookSecret))
+	mac.Write([]byte(id + "." + ts + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", id)
+	req.Header.Set("Webhook-Timestamp", ts)
+	req.Header.Set("Webhook-Signature", "v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("metering: webhook returned %s", resp.Status)
+	}
+	return nil
+}
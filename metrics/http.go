@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/fxdemo/ja3"
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+)
+
+// ContentType is the media type of the text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the metrics of a Registry and the expvar variables at
+// /metrics.
+// Prometheusに読ませるハンドラ
+type Handler struct {
+	registry *Registry
+}
+
+// NewHandler builds a new Handler.
+func NewHandler(registry *Registry) *Handler {
+	return &Handler{registry: registry}
+}
+
+// Pattern reports the path at which this is registered.
+func (*Handler) Pattern() string {
+	return "/metrics"
+}
+
+// Methods reports the methods this serves.
+func (*Handler) Methods() []string {
+	return []string{http.MethodGet}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	h.registry.write(&b)
+	writeExpvars(&b)
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(b.String()))
+}
+
+// writeExpvars writes the numeric expvar variables, and maps of them,
+// as untyped metrics; map keys become the "key" label. Other variables,
+// such as memstats and cmdline, are left to /debug/vars.
+func writeExpvars(b *strings.Builder) {
+	expvar.Do(func(kv expvar.KeyValue) {
+		name := "expvar_" + sanitize(kv.Key)
+		switch v := kv.Value.(type) {
+		case *expvar.Int, *expvar.Float:
+			b.WriteString("# TYPE " + name + " untyped\n")
+			b.WriteString(name + " " + v.String() + "\n")
+		case *expvar.Map:
+			b.WriteString("# TYPE " + name + " untyped\n")
+			v.Do(func(e expvar.KeyValue) {
+				switch e.Value.(type) {
+				case *expvar.Int, *expvar.Float:
+					b.WriteString(name + `{key="` + escapeValue(e.Key) + `"} ` + e.Value.String() + "\n")
+				}
+			})
+		}
+	})
+}
+
+// sanitize makes s a valid metric name.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Instrumentation records the requests of each route: how many were
+// served, with which status, how long they took and how many are in
+// progress, labelled with the route's pattern. It also counts them by
+// the JA3 fingerprint of their TLS client.
+// ルートごとのリクエスト数・所要時間・処理中の数
+type Instrumentation struct {
+	requests CounterVec
+	duration HistogramVec
+	inflight GaugeVec
+	clients  CounterVec
+	slow     Counter
+}
+
+// NewInstrumentation registers the request metrics in registry.
+func NewInstrumentation(registry *Registry) *Instrumentation {
+	return &Instrumentation{
+		requests: registry.Counter("http_requests_total", "Requests served, by route, method and status.", "route", "method", "code"),
+		duration: registry.Histogram("http_request_duration_seconds", "Time taken to serve requests, by route and method.", DefaultBuckets, "route", "method"),
+		inflight: registry.Gauge("http_requests_in_flight", "Requests in progress, by route.", "route"),
+		clients:  registry.Counter("http_requests_by_ja3_total", "Requests served, by JA3 fingerprint of the TLS client; none for plaintext.", "ja3"),
+		slow:     registry.Counter("http_slow_connections_closed_total", "Connections closed because their client was too slow to send the headers of a request.").With(),
+	}
+}
+
+// SlowClient counts a connection closed because its client was too
+// slow; it is the server's httpfx.ServerOptions.SlowClient.
+func (in *Instrumentation) SlowClient(net.Conn) {
+	in.slow.Inc()
+}
+
+// Route returns a handler that records the requests next serves for
+// route.
+func (in *Instrumentation) Route(route httpfx.Route, next http.Handler) http.Handler {
+	pattern := route.Pattern()
+	inflight := in.inflight.With(pattern)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		inflight.Inc()
+		defer inflight.Dec()
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		method := methodLabel(r.Method)
+		in.requests.With(pattern, method, strconv.Itoa(rec.status)).Inc()
+		in.duration.With(pattern, method).Observe(time.Since(start).Seconds())
+		in.clients.With(ja3.Label(r.Context())).Inc() // 種類はja3側で抑えてある
+	})
+}
+
+// methodLabel bounds the method label to the standard methods, as
+// clients may send any method they like.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// recorder remembers the status of the response.
+type recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *recorder) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying writer.
+func (w *recorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Module provides a Registry, the Instrumentation and /metrics. The
+// application puts the Instrumentation in front of each route.
+var Module = fx.Module("metrics",
+	fx.Provide(
+		NewRegistry,
+		NewInstrumentation,
+		httpfx.AsRoute[*Handler](NewHandler),
+	),
+)
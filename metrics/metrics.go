@@ -0,0 +1,262 @@
+// Package metrics exposes metrics in the Prometheus text format at
+// /metrics: counters, gauges and histograms kept in a Registry, along
+// with the expvar variables the rest of the server publishes.
+//
+// It implements just enough of the exposition format (version 0.0.4)
+// for Prometheus to scrape the server; it is not a client library.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the histogram
+// buckets of request durations.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics to expose. Each test can use a Registry
+// of its own.
+// メトリクスの登録先
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*family
+}
+
+// NewRegistry builds an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*family)}
+}
+
+// family is a metric and its series, one per combination of label
+// values.
+type family struct {
+	name, help, kind string
+	labels           []string
+	buckets          []float64 // histograms only
+
+	mu     sync.Mutex
+	series map[string]*series // by joined label values
+}
+
+type series struct {
+	values []string
+	value  float64   // counters and gauges; the sum of histograms
+	counts []uint64  // histograms: observations per bucket, not cumulated
+	count  uint64    // histograms: observations
+	bounds []float64 // histograms: the family's buckets
+}
+
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.metrics[name]; ok {
+		if f.kind != kind || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s registered again as a different metric", name))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	r.metrics[name] = f
+	return f
+}
+
+// with returns the series with the given label values.
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...), bounds: f.buckets}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	f *family
+	s *series
+}
+
+// Add adds v, which must not be negative.
+func (c Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.f.mu.Lock()
+	c.s.value += v
+	c.f.mu.Unlock()
+}
+
+// Inc adds 1.
+func (c Counter) Inc() { c.Add(1) }
+
+// CounterVec is a counter with labels.
+type CounterVec struct{ f *family }
+
+// Counter registers a counter, or returns the one registered under name.
+func (r *Registry) Counter(name, help string, labels ...string) CounterVec {
+	return CounterVec{r.register(name, help, "counter", nil, labels)}
+}
+
+// With returns the counter with the given label values.
+func (v CounterVec) With(values ...string) Counter {
+	return Counter{v.f, v.f.with(values)}
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	f *family
+	s *series
+}
+
+// Add adds v.
+func (g Gauge) Add(v float64) {
+	g.f.mu.Lock()
+	g.s.value += v
+	g.f.mu.Unlock()
+}
+
+// Set sets the gauge to v.
+func (g Gauge) Set(v float64) {
+	g.f.mu.Lock()
+	g.s.value = v
+	g.f.mu.Unlock()
+}
+
+// Inc adds 1.
+func (g Gauge) Inc() { g.Add(1) }
+
+// Dec subtracts 1.
+func (g Gauge) Dec() { g.Add(-1) }
+
+// GaugeVec is a gauge with labels.
+type GaugeVec struct{ f *family }
+
+// Gauge registers a gauge, or returns the one registered under name.
+func (r *Registry) Gauge(name, help string, labels ...string) GaugeVec {
+	return GaugeVec{r.register(name, help, "gauge", nil, labels)}
+}
+
+// With returns the gauge with the given label values.
+func (v GaugeVec) With(values ...string) Gauge {
+	return Gauge{v.f, v.f.with(values)}
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	f *family
+	s *series
+}
+
+// Observe records v.
+func (h Histogram) Observe(v float64) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	for i, bound := range h.s.bounds {
+		if v <= bound {
+			h.s.counts[i]++
+			break
+		}
+	}
+	h.s.count++
+	h.s.value += v
+}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct{ f *family }
+
+// Histogram registers a histogram with the given bucket upper bounds, in
+// increasing order, or returns the one registered under name.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) HistogramVec {
+	return HistogramVec{r.register(name, help, "histogram", buckets, labels)}
+}
+
+// With returns the histogram with the given label values.
+func (v HistogramVec) With(values ...string) Histogram {
+	return Histogram{v.f, v.f.with(values)}
+}
+
+// write writes the metrics in the text format, sorted by name and by
+// label values.
+func (r *Registry) write(b *strings.Builder) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.metrics))
+	for _, f := range r.metrics {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	for _, f := range families {
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.kind != "histogram" {
+				fmt.Fprintf(b, "%s%s %s\n", f.name, labelSet(f.labels, s.values, "", ""), number(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range s.bounds {
+				cumulative += s.counts[i]
+				fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, labelSet(f.labels, s.values, "le", number(bound)), cumulative)
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, labelSet(f.labels, s.values, "le", "+Inf"), s.count)
+			fmt.Fprintf(b, "%s_sum%s %s\n", f.name, labelSet(f.labels, s.values, "", ""), number(s.value))
+			fmt.Fprintf(b, "%s_count%s %d\n", f.name, labelSet(f.labels, s.values, "", ""), s.count)
+		}
+		f.mu.Unlock()
+	}
+}
+
+// labelSet formats labels as {a="x",b="y"}, with an extra label if
+// extra is set, or as nothing if there are none.
+func labelSet(names, values []string, extra, extraValue string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeValue(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeValue(s string) string { return valueEscaper.Replace(s) }
+
+func number(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestRegistryConcurrent registers and updates the same metrics from
+// many goroutines while they are exposed, and checks that no update is
+// lost. Run it with -race.
+func TestRegistryConcurrent(t *testing.T) {
+	const goroutines, updates = 16, 500
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			label := []string{"even", "odd"}[i%2]
+			for range updates {
+				r.Counter("requests_total", "Requests.", "parity").With(label).Inc()
+				r.Gauge("in_flight", "In flight.").With().Add(1)
+				r.Histogram("duration_seconds", "Durations.", DefaultBuckets).With().Observe(0.01)
+				r.Gauge("in_flight", "In flight.").With().Add(-1)
+			}
+		}()
+	}
+	// 更新中に書き出す
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 50 {
+			var b strings.Builder
+			r.write(&b)
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	var b strings.Builder
+	r.write(&b)
+	for _, want := range []string{
+		`requests_total{parity="even"} 4000`,
+		`requests_total{parity="odd"} 4000`,
+		"in_flight 0",
+		"duration_seconds_count 8000",
+		`duration_seconds_bucket{le="0.01"} 8000`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("exposition lacks %q:\n%s", want, b.String())
+		}
+	}
+}
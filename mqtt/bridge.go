@@ -0,0 +1,118 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"strings"
+	"time"
+
+	"example.com/fxdemo/events"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	bridgeQueue    = 256 // events waiting to be published
+	publishTimeout = 5 * time.Second
+)
+
+var (
+	eventsPublished = expvar.NewInt("mqtt_events_published")
+	eventsDropped   = expvar.NewInt("mqtt_events_dropped") // the queue was full or the broker unreachable
+)
+
+// Bridge publishes the events of the Config's kinds to the broker, as
+// JSON, on the topic prefix followed by the kind with its dots turned
+// into slashes: "honeypot.hit" goes to fxdemo/events/honeypot/hit.
+//
+// Events are queued and published in the background, and dropped while
+// the broker cannot be reached; the bridge is no substitute for the
+// audit log or the billing webhook.
+// 選んだイベントをMQTTに流す
+type Bridge struct {
+	cfg    Config
+	client *Client
+	log    *zap.Logger
+	all    bool
+	kinds  map[string]bool
+	queue  chan events.Event
+}
+
+// NewBridge builds a Bridge publishing on client for as long as the
+// application runs. Without a client, it publishes nothing.
+func NewBridge(lc fx.Lifecycle, cfg Config, client *Client, log *zap.Logger) *Bridge {
+	b := &Bridge{cfg: cfg, client: client, log: log, kinds: make(map[string]bool)}
+	for _, k := range cfg.Events {
+		if k == "*" {
+			b.all = true
+		}
+		b.kinds[k] = true
+	}
+	if client == nil || (!b.all && len(b.kinds) == 0) {
+		return b
+	}
+	b.queue = make(chan events.Event, bridgeQueue)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go b.run(ctx, stopped)
+			return nil
+		},
+		OnStop: func(stop context.Context) error {
+			cancel()
+			select {
+			case <-stopped:
+				return nil
+			case <-stop.Done():
+				return stop.Err()
+			}
+		},
+	})
+	return b
+}
+
+// Publish queues e if its kind is bridged, dropping it if the queue is
+// full.
+func (b *Bridge) Publish(_ context.Context, e events.Event) {
+	if b.queue == nil || !b.all && !b.kinds[e.Kind] {
+		return
+	}
+	select {
+	case b.queue <- e:
+	default:
+		eventsDropped.Add(1)
+	}
+}
+
+func (b *Bridge) run(ctx context.Context, stopped chan<- struct{}) {
+	defer close(stopped)
+	for {
+		select {
+		case e := <-b.queue:
+			b.publish(ctx, e)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *Bridge) publish(ctx context.Context, e events.Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		b.log.Error("Failed to encode event", zap.String("kind", e.Kind), zap.Error(err))
+		eventsDropped.Add(1)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	topic := b.cfg.TopicPrefix + strings.ReplaceAll(e.Kind, ".", "/")
+	if err := b.client.Publish(ctx, topic, payload, b.cfg.QoS, false); err != nil {
+		b.log.Debug("Dropped event for MQTT", zap.String("kind", e.Kind), zap.Error(err))
+		eventsDropped.Add(1)
+		return
+	}
+	eventsPublished.Add(1)
+}
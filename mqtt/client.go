@@ -0,0 +1,462 @@
+// Package mqtt connects the application to an MQTT broker, for devices
+// and other IoT consumers to follow what happens in it. The Client keeps
+// one connection open for as long as the application runs, reconnecting
+// when it is lost, and the Bridge publishes the events chosen in the
+// Config on it.
+//
+// It speaks just enough of MQTT 3.1.1 for this: QoS 0 and 1, clean
+// sessions and no will. Components receive messages by providing a
+// Subscription to the "mqtt.subscriptions" value group:
+//
+//	fx.Provide(mqtt.AsSubscription[*CommandHandler](NewCommandHandler))
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Config sets the broker and what is bridged to it.
+type Config struct {
+	// Broker is the URL of the broker: tcp://host:1883, or
+	// tls://host:8883 for TLS. The bridge is off if it is empty.
+	Broker   string `yaml:"broker"`
+	ClientID string `yaml:"client_id"` // fxdemo-<hostname> if empty
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// KeepAlive is how often the connection is checked.
+	KeepAlive time.Duration `yaml:"keep_alive"`
+	// QoS is the quality of service, 0 or 1, of the events published
+	// and of the subscriptions.
+	QoS byte `yaml:"qos"`
+	// TopicPrefix is prepended to the topic of each event, which is its
+	// kind with dots turned into slashes.
+	TopicPrefix string `yaml:"topic_prefix"`
+	// Events are the kinds of events published, such as "honeypot.hit";
+	// "*" publishes all of them. None are published by default.
+	Events []string `yaml:"events"`
+}
+
+// DefaultConfig connects to no broker. Once one is set, events are
+// published at least once under fxdemo/events/.
+func DefaultConfig() Config {
+	return Config{KeepAlive: 30 * time.Second, QoS: 1, TopicPrefix: "fxdemo/events/"}
+}
+
+// Message is a message received from the broker.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+// Subscription receives the messages published on the topics matching
+// its filter, which may contain the + and # wildcards. Handle is called
+// on the connection's reader, in the order messages arrive, so it must
+// return quickly.
+type Subscription interface {
+	Topic() string
+	Handle(ctx context.Context, m Message)
+}
+
+// SubscriptionsGroup is the value group of the subscriptions provided
+// with AsSubscription.
+const SubscriptionsGroup = "mqtt.subscriptions"
+
+// AsSubscription annotates the given constructor to state that it
+// provides a Subscription to the "mqtt.subscriptions" group, f
+// returning an S.
+func AsSubscription[S Subscription](f any) any {
+	return httpfx.AsGroup[Subscription, S](f, SubscriptionsGroup)
+}
+
+// ErrNotConnected is returned by Publish while the client is not
+// connected to the broker.
+var ErrNotConnected = errors.New("mqtt: not connected")
+
+// Limits of the connection.
+const (
+	dialTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+	maxBackoff   = 30 * time.Second
+	maxPacket    = 1 << 20 // 受信するメッセージの上限
+)
+
+// Params are the dependencies of NewClient.
+type Params struct {
+	fx.In
+
+	Lifecycle     fx.Lifecycle
+	Config        Config
+	Log           *zap.Logger
+	Subscriptions []Subscription `group:"mqtt.subscriptions"`
+}
+
+// Client is a connection to the broker, kept open while the application
+// runs.
+// MQTTブローカーへの接続
+type Client struct {
+	cfg    Config
+	addr   string
+	tls    *tls.Config // nil for plain TCP
+	log    *zap.Logger
+	subs   []Subscription
+	closed atomic.Bool
+
+	mu     sync.Mutex // serializes writes
+	conn   net.Conn
+	w      *bufio.Writer
+	nextID uint16
+	acks   map[uint16]chan error // QoS 1 publications awaiting their PUBACK
+}
+
+// NewClient builds a Client that connects when the application starts,
+// or returns nil if no broker is configured. Starting does not wait for
+// the broker: the client connects in the background, and keeps trying
+// with backoff for as long as the application runs.
+func NewClient(p Params) (*Client, error) {
+	cfg := p.Config
+	if cfg.Broker == "" {
+		return nil, nil
+	}
+	if cfg.QoS > 1 {
+		return nil, fmt.Errorf("mqtt: unsupported QoS %d", cfg.QoS)
+	}
+	if cfg.KeepAlive <= 0 || cfg.KeepAlive > 18*time.Hour {
+		return nil, fmt.Errorf("mqtt: keep_alive must be between 1s and 18h, got %v", cfg.KeepAlive)
+	}
+	u, err := url.Parse(cfg.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: invalid broker: %w", err)
+	}
+	c := &Client{cfg: cfg, log: p.Log.Named("mqtt"), subs: p.Subscriptions, acks: make(map[uint16]chan error)}
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "tls", "ssl", "mqtts":
+		port = "8883"
+		c.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	default:
+		return nil, fmt.Errorf("mqtt: unsupported broker scheme %q", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	c.addr = net.JoinHostPort(u.Hostname(), port)
+	if c.cfg.ClientID == "" {
+		host, _ := os.Hostname()
+		c.cfg.ClientID = "fxdemo-" + host
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go c.run(ctx, stopped)
+			return nil
+		},
+		OnStop: func(stop context.Context) error {
+			cancel()
+			c.disconnect()
+			select {
+			case <-stopped:
+				return nil
+			case <-stop.Done():
+				return stop.Err()
+			}
+		},
+	})
+	return c, nil
+}
+
+// run keeps a session open until ctx is done.
+func (c *Client) run(ctx context.Context, stopped chan<- struct{}) {
+	defer close(stopped)
+	backoff := time.Second
+	for {
+		connected, err := c.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		msg := "Failed to connect to MQTT broker"
+		if connected {
+			msg, backoff = "Lost the MQTT broker", time.Second
+		}
+		c.log.Warn(msg, zap.String("broker", c.addr), zap.Error(err), zap.Duration("retry_in", backoff))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session connects, subscribes and reads from the broker until the
+// connection fails. It reports whether it got connected.
+func (c *Client) session(ctx context.Context) (bool, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := writePacket(w, connectPacket(c.cfg)); err != nil {
+		conn.Close()
+		return false, err
+	}
+	ack, err := readPacket(r, maxPacket)
+	if err == nil {
+		err = connackError(ack)
+	}
+	if err != nil {
+		conn.Close()
+		return false, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	if c.closed.Load() { // 接続中に停止した
+		c.mu.Unlock()
+		conn.Close()
+		return true, ctx.Err()
+	}
+	c.conn, c.w = conn, w
+	c.mu.Unlock()
+	c.log.Info("Connected to MQTT broker", zap.String("broker", c.addr), zap.String("client_id", c.cfg.ClientID))
+	defer c.drop(conn)
+
+	if len(c.subs) > 0 {
+		filters := make([]string, len(c.subs))
+		for i, s := range c.subs {
+			filters[i] = s.Topic()
+		}
+		c.mu.Lock()
+		err := c.write(subscribePacket(c.id(), filters, c.cfg.QoS))
+		c.mu.Unlock()
+		if err != nil {
+			return true, err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go c.ping(done)
+	return true, c.read(ctx, conn, r)
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	if c.tls != nil {
+		d := tls.Dialer{Config: c.tls}
+		return d.DialContext(ctx, "tcp", c.addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", c.addr)
+}
+
+// read handles the packets of the broker. A broker that stays silent for
+// one and a half keep-alive periods, despite the pings, is gone.
+func (c *Client) read(ctx context.Context, conn net.Conn, r *bufio.Reader) error {
+	for {
+		conn.SetReadDeadline(time.Now().Add(c.cfg.KeepAlive * 3 / 2))
+		p, err := readPacket(r, maxPacket)
+		if err != nil {
+			return err
+		}
+		switch p.kind {
+		case typePublish:
+			m, qos, id, err := parsePublish(p)
+			if err != nil {
+				return err
+			}
+			c.deliver(ctx, m)
+			if qos > 0 {
+				c.mu.Lock()
+				err = c.write(idPacket(typePuback, id))
+				c.mu.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+		case typePuback:
+			if len(p.body) == 2 {
+				c.acked(uint16(p.body[0])<<8|uint16(p.body[1]), nil)
+			}
+		case typeSuback:
+			for _, code := range p.body[min(2, len(p.body)):] {
+				if code == 0x80 {
+					c.log.Error("MQTT broker refused a subscription")
+				}
+			}
+		case typePingresp:
+		default:
+			return fmt.Errorf("mqtt: unexpected packet type %d", p.kind)
+		}
+	}
+}
+
+// deliver hands m to the subscriptions whose filter matches its topic.
+func (c *Client) deliver(ctx context.Context, m Message) {
+	for _, s := range c.subs {
+		if matches(s.Topic(), m.Topic) {
+			s.Handle(ctx, m)
+		}
+	}
+}
+
+// ping keeps the connection alive until done is closed.
+func (c *Client) ping(done <-chan struct{}) {
+	t := time.NewTicker(c.cfg.KeepAlive / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.mu.Lock()
+			err := c.write(packet{kind: typePingreq})
+			c.mu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// write writes p on the connection. It must be called with mu held.
+func (c *Client) write(p packet) error {
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return writePacket(c.w, p)
+}
+
+// id returns a packet identifier not in use. It must be called with mu
+// held.
+func (c *Client) id() uint16 {
+	for {
+		c.nextID++
+		if _, used := c.acks[c.nextID]; c.nextID != 0 && !used {
+			return c.nextID
+		}
+	}
+}
+
+func (c *Client) acked(id uint16, err error) {
+	c.mu.Lock()
+	ch, ok := c.acks[id]
+	delete(c.acks, id)
+	c.mu.Unlock()
+	if ok {
+		ch <- err
+	}
+}
+
+// drop forgets conn once its session is over, failing the publications
+// still waiting for their acknowledgement.
+func (c *Client) drop(conn net.Conn) {
+	conn.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == conn {
+		c.conn, c.w = nil, nil
+	}
+	for id, ch := range c.acks {
+		ch <- ErrNotConnected
+		delete(c.acks, id)
+	}
+}
+
+// disconnect says goodbye to the broker, so that it does not treat the
+// closed connection as a failure.
+func (c *Client) disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed.Store(true)
+	if c.conn != nil {
+		c.write(packet{kind: typeDisconnect})
+		c.conn.Close()
+	}
+}
+
+// Publish publishes payload on topic. With QoS 1, it returns once the
+// broker has acknowledged it, or ctx is done. A publication is not sent
+// again after the connection is lost; Publish then returns
+// ErrNotConnected, as it does while the client is not connected.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte, qos byte, retain bool) error {
+	if c == nil {
+		return ErrNotConnected
+	}
+	if qos > 1 {
+		return fmt.Errorf("mqtt: unsupported QoS %d", qos)
+	}
+	c.mu.Lock()
+	var id uint16
+	var ack chan error
+	if qos > 0 && c.conn != nil {
+		id, ack = c.id(), make(chan error, 1)
+		c.acks[id] = ack
+	}
+	err := c.write(publishPacket(topic, payload, qos, retain, id))
+	if err != nil && ack != nil {
+		delete(c.acks, id)
+	}
+	c.mu.Unlock()
+	if err != nil || ack == nil {
+		return err
+	}
+	select {
+	case err := <-ack:
+		return err
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.acks, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// matches reports whether topic matches filter, which may contain the
+// single-level wildcard + and the multi-level wildcard # at its end.
+func matches(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			// $で始まるトピックはワイルドカードで始まるフィルターに一致しない
+			return i > 0 || !strings.HasPrefix(topic, "$")
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f == "+" {
+			if i == 0 && strings.HasPrefix(topic, "$") {
+				return false
+			}
+			continue
+		}
+		if f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
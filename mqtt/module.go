@@ -0,0 +1,16 @@
+package mqtt
+
+import (
+	"example.com/fxdemo/events"
+	"go.uber.org/fx"
+)
+
+// Module provides the Client and adds the Bridge to the event sinks.
+// Both do nothing until a broker is configured.
+// MQTTへのブリッジ
+var Module = fx.Module("mqtt",
+	fx.Provide(
+		NewClient,
+		events.AsSink[*Bridge](NewBridge),
+	),
+)
@@ -0,0 +1,196 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Control packet types of MQTT 3.1.1, as far as they are used here.
+const (
+	typeConnect     = 1
+	typeConnack     = 2
+	typePublish     = 3
+	typePuback      = 4
+	typeSubscribe   = 8
+	typeSuback      = 9
+	typePingreq     = 12
+	typePingresp    = 13
+	typeDisconnect  = 14
+	maxRemainingLen = 268435455
+)
+
+// packet is a control packet: its type, the flags of the fixed header
+// and what follows it.
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// writePacket writes p with its fixed header.
+func writePacket(w *bufio.Writer, p packet) error {
+	if len(p.body) > maxRemainingLen {
+		return errors.New("mqtt: packet too large")
+	}
+	w.WriteByte(p.kind<<4 | p.flags)
+	n := len(p.body)
+	for {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		w.WriteByte(b)
+		if n == 0 {
+			break
+		}
+	}
+	w.Write(p.body)
+	return w.Flush()
+}
+
+// readPacket reads the next packet, refusing bodies over limit bytes.
+func readPacket(r *bufio.Reader, limit int) (packet, error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return packet{}, errors.New("mqtt: malformed remaining length")
+		}
+	}
+	if n > limit {
+		return packet{}, fmt.Errorf("mqtt: %d byte packet exceeds the limit", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: h >> 4, flags: h & 0x0f, body: body}, nil
+}
+
+// appendString appends s as a length-prefixed UTF-8 string.
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// readString reads a length-prefixed string from the start of b and
+// returns it with the rest of b.
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("mqtt: truncated string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("mqtt: truncated string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+// connectPacket builds the CONNECT packet of cfg, with a clean session.
+func connectPacket(cfg Config) packet {
+	b := appendString(nil, "MQTT")
+	b = append(b, 4) // プロトコルレベル 3.1.1
+	flags := byte(0x02)
+	if cfg.Username != "" {
+		flags |= 0x80
+	}
+	if cfg.Password != "" {
+		flags |= 0x40
+	}
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint16(b, uint16(cfg.KeepAlive.Seconds()))
+	b = appendString(b, cfg.ClientID)
+	if cfg.Username != "" {
+		b = appendString(b, cfg.Username)
+	}
+	if cfg.Password != "" {
+		b = appendString(b, cfg.Password)
+	}
+	return packet{kind: typeConnect, body: b}
+}
+
+// connackError returns the error of a CONNACK's return code.
+func connackError(p packet) error {
+	if p.kind != typeConnack || len(p.body) != 2 {
+		return errors.New("mqtt: expected CONNACK")
+	}
+	switch p.body[1] {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("mqtt: broker refused the protocol version")
+	case 2:
+		return errors.New("mqtt: broker refused the client ID")
+	case 3:
+		return errors.New("mqtt: broker unavailable")
+	case 4:
+		return errors.New("mqtt: bad user name or password")
+	case 5:
+		return errors.New("mqtt: not authorized")
+	}
+	return fmt.Errorf("mqtt: connection refused with code %d", p.body[1])
+}
+
+// publishPacket builds a PUBLISH packet; id is only sent for QoS 1.
+func publishPacket(topic string, payload []byte, qos byte, retain bool, id uint16) packet {
+	flags := qos << 1
+	if retain {
+		flags |= 0x01
+	}
+	b := appendString(make([]byte, 0, 2+len(topic)+2+len(payload)), topic)
+	if qos > 0 {
+		b = binary.BigEndian.AppendUint16(b, id)
+	}
+	return packet{kind: typePublish, flags: flags, body: append(b, payload...)}
+}
+
+// parsePublish reads a received PUBLISH packet.
+func parsePublish(p packet) (m Message, qos byte, id uint16, err error) {
+	qos = p.flags >> 1 & 0x03
+	if qos > 2 {
+		return Message{}, 0, 0, errors.New("mqtt: PUBLISH with QoS 3") // 不正なパケット
+	}
+	m.Retained = p.flags&0x01 != 0
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return Message{}, 0, 0, err
+	}
+	m.Topic = topic
+	if qos > 0 {
+		if len(rest) < 2 {
+			return Message{}, 0, 0, errors.New("mqtt: truncated PUBLISH")
+		}
+		id, rest = binary.BigEndian.Uint16(rest), rest[2:]
+	}
+	m.Payload = rest
+	return m, qos, id, nil
+}
+
+// subscribePacket builds a SUBSCRIBE packet for the given topic filters.
+func subscribePacket(id uint16, filters []string, qos byte) packet {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	for _, f := range filters {
+		b = append(appendString(b, f), qos)
+	}
+	return packet{kind: typeSubscribe, flags: 0x02, body: b}
+}
+
+// idPacket builds a packet whose body is a packet identifier, such as
+// PUBACK.
+func idPacket(kind byte, id uint16) packet {
+	return packet{kind: kind, body: binary.BigEndian.AppendUint16(nil, id)}
+}
@@ -0,0 +1,56 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// FuzzReadPacket checks that a packet read from the wire is written back
+// as it was read, and that a PUBLISH packet is built again as it was
+// parsed.
+func FuzzReadPacket(f *testing.F) {
+	const limit = 1 << 16
+	encode := func(p packet) []byte {
+		var b bytes.Buffer
+		w := bufio.NewWriter(&b)
+		if err := writePacket(w, p); err != nil {
+			f.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	f.Add(encode(publishPacket("sensors/1", []byte(`{"t":21.5}`), 0, false, 0)))
+	f.Add(encode(publishPacket("a/b", make([]byte, 200), 1, true, 7)))
+	f.Add(encode(idPacket(typePuback, 7)))
+	f.Add(encode(connectPacket(Config{ClientID: "fxdemo", Username: "u", Password: "p"})))
+	f.Add([]byte{typePingresp << 4, 0})
+	f.Add([]byte{typePublish<<4 | 0x06, 0x80, 0x80, 0x80, 0x80, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := readPacket(bufio.NewReader(bytes.NewReader(data)), limit)
+		if err != nil {
+			return
+		}
+		if len(p.body) > limit {
+			t.Fatalf("readPacket() read a %d byte body, over the limit", len(p.body))
+		}
+		again, err := readPacket(bufio.NewReader(bytes.NewReader(encode(p))), limit)
+		if err != nil || again.kind != p.kind || again.flags != p.flags || !bytes.Equal(again.body, p.body) {
+			t.Errorf("readPacket(writePacket(%+v)) = %+v, %v", p, again, err)
+		}
+
+		if p.kind != typePublish {
+			return
+		}
+		m, qos, id, err := parsePublish(p)
+		if err != nil {
+			return
+		}
+		if qos > 2 {
+			t.Fatalf("parsePublish() accepted QoS %d", qos)
+		}
+		built := publishPacket(m.Topic, m.Payload, qos, m.Retained, id)
+		if built.flags != p.flags&^0x08 || !bytes.Equal(built.body, p.body) { // DUPフラグは作らない
+			t.Errorf("parsePublish(%+v) = %+v, QoS %d, ID %d, which builds %+v", p, m, qos, id, built)
+		}
+	})
+}
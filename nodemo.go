@@ -0,0 +1,8 @@
+//go:build !demo
+
+package main
+
+import "go.uber.org/fx"
+
+// demoRoutes is empty without the demo tag; see demo.go.
+var demoRoutes = fx.Options()
@@ -0,0 +1,163 @@
+// Package normalize gives every URL path one canonical spelling before
+// it is routed: duplicate slashes merged, a trailing slash dropped and
+// letters folded to lower case, as configured. Clients are either
+// redirected to the canonical path or served as if they had asked for it.
+package normalize
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"example.com/fxdemo/pkg/httpfx"
+)
+
+// Actions taken on a path that is not canonical.
+const (
+	Redirect = "redirect" // send the client to the canonical path
+	Rewrite  = "rewrite"  // serve the canonical path in place
+)
+
+// Config is the normalization policy.
+type Config struct {
+	Action             string `yaml:"action"`               // Action is Redirect or Rewrite.
+	MergeSlashes       bool   `yaml:"merge_slashes"`        // MergeSlashes turns "//" into "/".
+	StripTrailingSlash bool   `yaml:"strip_trailing_slash"` // StripTrailingSlash turns "/a/" into "/a".
+	Lowercase          bool   `yaml:"lowercase"`            // Lowercase folds the path to lower case.
+	// Exempt lists mux patterns whose paths are left as they are, on top
+	// of the routes that are Exempt.
+	Exempt []string `yaml:"exempt"`
+}
+
+// DefaultConfig redirects paths with duplicate slashes and leaves the
+// rest alone.
+func DefaultConfig() Config {
+	return Config{Action: Redirect, MergeSlashes: true}
+}
+
+// Exempt is implemented by routes whose paths must not be normalized,
+// such as those with case-sensitive IDs in them.
+type Exempt interface {
+	NormalizationExempt() bool
+}
+
+var normalized = expvar.NewMap("normalized_paths") // by action
+
+// Normalizer applies the policy to each request.
+// パスの表記を揃える
+type Normalizer struct {
+	cfg    Config
+	routes atomic.Pointer[routeSet]
+}
+
+// routeSet is what the Normalizer knows of the routes.
+type routeSet struct {
+	exempt *http.ServeMux // matches requests to the exempt patterns
+	// subtrees are the patterns ending in a slash. Their trailing slash
+	// is kept, or the mux would redirect back to it.
+	subtrees map[string]bool
+}
+
+// NewNormalizer builds a new Normalizer for the given routes.
+func NewNormalizer(cfg Config, routes httpfx.Routes) (*Normalizer, error) {
+	if cfg.Action != Redirect && cfg.Action != Rewrite {
+		return nil, fmt.Errorf("normalize: unknown action %q", cfg.Action)
+	}
+	n := &Normalizer{cfg: cfg}
+	if err := n.SetRoutes(routes.Versions()); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// SetRoutes replaces the routes whose patterns are kept as they are,
+// given by version as by httpfx.Routes.Versions, such as after they
+// were rebuilt with a new configuration.
+func (n *Normalizer) SetRoutes(versions [][]httpfx.Route) (err error) {
+	set := &routeSet{exempt: http.NewServeMux(), subtrees: make(map[string]bool)}
+	exempt := make(map[string]bool)
+	for _, pattern := range n.cfg.Exempt {
+		exempt[pattern] = true
+	}
+	for v, group := range versions {
+		for _, route := range group {
+			e, ok := route.(Exempt)
+			for _, pattern := range httpfx.PatternsOf(route) {
+				// バージョン付きのパスも対象にする
+				for _, p := range []string{pattern, fmt.Sprintf("/v%d%s", v+1, pattern)} {
+					if strings.HasSuffix(p, "/") {
+						set.subtrees[p] = true
+					}
+					if ok && e.NormalizationExempt() {
+						exempt[p] = true
+					}
+				}
+			}
+		}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("normalize: %v", p) // 不正なパターン
+		}
+	}()
+	for pattern := range exempt {
+		set.exempt.Handle(pattern, http.NotFoundHandler())
+	}
+	n.routes.Store(set)
+	return nil
+}
+
+// Canonical returns the canonical spelling of path under the policy.
+func (n *Normalizer) Canonical(path string) string {
+	if n.cfg.MergeSlashes {
+		for strings.Contains(path, "//") {
+			path = strings.ReplaceAll(path, "//", "/")
+		}
+	}
+	if n.cfg.Lowercase {
+		path = strings.ToLower(path)
+	}
+	if n.cfg.StripTrailingSlash && len(path) > 1 && !n.routes.Load().subtrees[path] {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path
+}
+
+// Wrap returns a handler that redirects or rewrites requests for paths
+// that are not canonical, unless they are exempt.
+func (n *Normalizer) Wrap(next http.Handler) http.Handler {
+	if !n.cfg.MergeSlashes && !n.cfg.StripTrailingSlash && !n.cfg.Lowercase {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := n.Canonical(r.URL.Path)
+		if path == r.URL.Path || n.isExempt(r.Host, r.URL.Path) || n.isExempt(r.Host, path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		normalized.Add(n.cfg.Action, 1)
+		if n.cfg.Action == Redirect {
+			u := url.URL{Path: path, RawQuery: r.URL.RawQuery}
+			status := http.StatusPermanentRedirect // 本文を保ったまま送り直させる
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				status = http.StatusMovedPermanently
+			}
+			http.Redirect(w, r, u.String(), status)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path, r2.URL.RawPath = path, ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// isExempt reports whether path falls under an exempt pattern. Both the
+// requested and the canonical path are checked, as either may be the
+// one the pattern is spelled like.
+func (n *Normalizer) isExempt(host, path string) bool {
+	_, pattern := n.routes.Load().exempt.Handler(&http.Request{Method: http.MethodGet, Host: host, URL: &url.URL{Path: path}})
+	return pattern != ""
+}
@@ -0,0 +1,16 @@
+package normalize
+
+import (
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	n, err := NewNormalizer(DefaultConfig(), httpfx.Routes{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	httpfxtest.Benchmark(b, n.Wrap(httpfxtest.Noop))
+}
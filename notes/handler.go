@@ -0,0 +1,112 @@
+package notes
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/deadline"
+	"go.uber.org/zap"
+)
+
+const (
+	maxText      = 1000 // characters in a note
+	defaultLimit = 50
+	maxLimit     = 500
+	queryTimeout = 5 * time.Second
+)
+
+// Handler serves /api/v1/notes: GET lists the notes and POST adds one.
+// ノートのハンドラ
+type Handler struct {
+	repo *Repository
+	log  *zap.Logger
+	h    http.Handler
+}
+
+// NewHandler builds a new Handler.
+func NewHandler(repo *Repository, log *zap.Logger) *Handler {
+	h := &Handler{repo: repo, log: log}
+	h.h = apperror.Handler(log, h.serve)
+	return h
+}
+
+// Pattern reports the path at which this is registered.
+func (*Handler) Pattern() string {
+	return "/api/v1/notes"
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.h.ServeHTTP(w, r)
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return h.list(w, r)
+	case http.MethodPost:
+		return h.create(w, r)
+	}
+	w.Header().Set("Allow", "GET, HEAD, POST")
+	return apperror.New(http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed here", r.Method)
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) error {
+	limit := defaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			return apperror.BadRequest("limit must be between 1 and %d", maxLimit)
+		}
+		limit = n
+	}
+	ctx, cancel := deadline.Downstream(r.Context(), queryTimeout)
+	defer cancel()
+	notes, err := h.repo.List(ctx, limit)
+	if err != nil {
+		return storeError(err)
+	}
+	return write(w, http.StatusOK, notes)
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) error {
+	var in struct {
+		Text string `json:"text"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, 4*maxText+64))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return apperror.BadRequest("Invalid note: %v", err)
+	}
+	if in.Text == "" || len([]rune(in.Text)) > maxText {
+		return apperror.BadRequest("text must have between 1 and %d characters", maxText)
+	}
+	ctx, cancel := deadline.Downstream(r.Context(), queryTimeout)
+	defer cancel()
+	n, err := h.repo.Create(ctx, in.Text)
+	if err != nil {
+		return storeError(err)
+	}
+	return write(w, http.StatusCreated, n)
+}
+
+// storeError turns a Repository error into the error to answer with.
+func storeError(err error) error {
+	if errors.Is(err, ErrNoDatabase) {
+		return apperror.New(http.StatusServiceUnavailable, "no_database", "No database is configured")
+	}
+	return apperror.Internal(err)
+}
+
+// write answers with v as JSON. It always returns nil, for handlers to
+// return its result.
+func write(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) // 書き始めた後はエラーを返せない
+	return nil
+}
@@ -0,0 +1,16 @@
+package notes
+
+import (
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+)
+
+// Module provides the notes route and its Repository. It needs the
+// *sql.DB of db.Module.
+// ノートリソース
+var Module = fx.Module("notes",
+	fx.Provide(
+		httpfx.AsRoute[*Handler](NewHandler),
+		NewRepository,
+	),
+)
@@ -0,0 +1,101 @@
+// Package notes is the example of a resource kept in the SQL database
+// of package db: short notes, which clients can add and list at
+// /api/v1/notes. Without a database, the route answers 503.
+package notes
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// ErrNoDatabase is returned by the Repository when no database is
+// configured.
+var ErrNoDatabase = errors.New("notes: no database")
+
+// Note is a short text kept for later.
+type Note struct {
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// schema creates the notes table. Its types and the $n placeholders of
+// the queries below work on both Postgres and SQLite.
+const schema = `CREATE TABLE IF NOT EXISTS notes (
+	id         TEXT PRIMARY KEY,
+	text       TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+)`
+
+// Repository stores notes in the database.
+// ノートをデータベースに保存する
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository builds a Repository on db, creating its table when the
+// application starts. db may be nil, in which case every call fails with
+// ErrNoDatabase.
+func NewRepository(lc fx.Lifecycle, db *sql.DB) *Repository {
+	r := &Repository{db: db}
+	if db != nil {
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				if _, err := db.ExecContext(ctx, schema); err != nil {
+					return fmt.Errorf("notes: create table: %w", err)
+				}
+				return nil
+			},
+		})
+	}
+	return r
+}
+
+// Create stores a new note with the given text.
+func (r *Repository) Create(ctx context.Context, text string) (Note, error) {
+	if r.db == nil {
+		return Note{}, ErrNoDatabase
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	n := Note{ID: hex.EncodeToString(id), Text: text, CreatedAt: time.Now().UTC().Truncate(time.Microsecond)}
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO notes (id, text, created_at) VALUES ($1, $2, $3)`,
+		n.ID, n.Text, n.CreatedAt)
+	if err != nil {
+		return Note{}, fmt.Errorf("notes: insert: %w", err)
+	}
+	return n, nil
+}
+
+// List returns the latest notes, newest first, up to limit.
+func (r *Repository) List(ctx context.Context, limit int) ([]Note, error) {
+	if r.db == nil {
+		return nil, ErrNoDatabase
+	}
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, text, created_at FROM notes ORDER BY created_at DESC, id LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("notes: query: %w", err)
+	}
+	defer rows.Close()
+	notes := []Note{}
+	for rows.Next() {
+		var n Note
+		if err := rows.Scan(&n.ID, &n.Text, &n.CreatedAt); err != nil {
+			return nil, fmt.Errorf("notes: scan: %w", err)
+		}
+		notes = append(notes, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("notes: query: %w", err)
+	}
+	return notes, nil
+}
@@ -0,0 +1,52 @@
+// Package boundedvar publishes expvar maps that count events by a label
+// clients have a say in, such as their tenant or TLS fingerprint,
+// without letting them grow the map without bound.
+package boundedvar
+
+import (
+	"expvar"
+	"sync"
+)
+
+// Other is the label under which the events of the labels beyond the
+// bound are counted.
+const Other = "other"
+
+// Map is an expvar map of counts by label, which counts the events of
+// any label after the first max under Other. It is safe for concurrent
+// use.
+// ラベル数に上限のあるexpvarのカウンタ
+type Map struct {
+	vars *expvar.Map
+	max  int
+
+	mu   sync.RWMutex
+	seen map[string]bool
+}
+
+// New publishes a new Map under name, which must be unique as for
+// expvar.NewMap.
+func New(name string, max int) *Map {
+	return &Map{vars: expvar.NewMap(name), max: max, seen: make(map[string]bool)}
+}
+
+// Add counts an event with label, and returns the label it was counted
+// under: label itself, or Other once max labels have been seen.
+func (m *Map) Add(label string) string {
+	m.mu.RLock()
+	seen := m.seen[label]
+	m.mu.RUnlock()
+	if !seen {
+		m.mu.Lock()
+		switch {
+		case m.seen[label]: // 他のゴルーチンが先に加えた
+		case len(m.seen) >= m.max:
+			label = Other
+		default:
+			m.seen[label] = true
+		}
+		m.mu.Unlock()
+	}
+	m.vars.Add(label, 1)
+	return label
+}
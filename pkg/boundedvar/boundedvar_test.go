@@ -0,0 +1,56 @@
+package boundedvar
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestMapConcurrent counts labels from many goroutines at once, more of
+// them than the bound, and checks that every event is counted and no
+// more than the bound is kept apart. Run it with -race.
+func TestMapConcurrent(t *testing.T) {
+	const goroutines, labels, max = 16, 40, 100
+	m := &Map{vars: new(expvar.Map), max: max, seen: make(map[string]bool)} // 公開せずに作る
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range labels {
+				// 半分のラベルは全員に共通
+				label := strconv.Itoa(j)
+				if j%2 == 1 {
+					label = strconv.Itoa(i*labels + j)
+				}
+				if got := m.Add(label); got != label && got != Other {
+					t.Errorf("Add(%s) = %s", label, got)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	kept, total := 0, int64(0)
+	m.vars.Do(func(kv expvar.KeyValue) {
+		if kv.Key != Other {
+			kept++
+		}
+		total += kv.Value.(*expvar.Int).Value()
+	})
+	if kept != max {
+		t.Errorf("%d labels kept apart, want %d", kept, max)
+	}
+	if total != goroutines*labels {
+		t.Errorf("%d events counted, want %d", total, goroutines*labels)
+	}
+	// 一度数えたラベルは上限に達しても数え続ける
+	m.vars.Do(func(kv expvar.KeyValue) {
+		if kv.Key != Other {
+			if got := m.Add(kv.Key); got != kv.Key {
+				t.Errorf("Add(%s) after the bound = %s, want it kept apart", kv.Key, got)
+			}
+		}
+	})
+}
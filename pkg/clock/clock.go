@@ -0,0 +1,53 @@
+// Package clock is the time as the components that expire things or run
+// on a schedule see it, so that tests can run them on simulated time
+// with package clocktest rather than wait for the real one.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and calls functions on a schedule.
+// 時刻と定期実行
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Every calls f every d, until stop is called. Stop waits for a call
+	// in progress to return.
+	Every(d time.Duration, f func()) (stop func())
+}
+
+// Real is the clock of the system.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Every(d time.Duration, f func()) func() {
+	ticker := time.NewTicker(d)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				f()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+		<-stopped
+	}
+}
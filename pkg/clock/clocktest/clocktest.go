@@ -0,0 +1,96 @@
+// Package clocktest provides a clock.Clock whose time only moves when a
+// test says so, to simulate schedules and expiries deterministically:
+//
+//	c := clocktest.New(start)
+//	l := newList(log, c)
+//	l.Add(ip, time.Minute)
+//	c.Advance(time.Minute + 1)
+//	// l.Denied(ip) is now false
+package clocktest
+
+import (
+	"sync"
+	"time"
+
+	"example.com/fxdemo/pkg/clock"
+)
+
+// Fake is a clock.Clock that stands still until it is advanced. The
+// functions scheduled with Every are called by Advance, in the goroutine
+// of the test, so that their effects are seen as soon as it returns.
+// テスト用の進めるまで止まっている時計
+type Fake struct {
+	mu   sync.Mutex
+	now  time.Time
+	jobs []*job // in the order they were scheduled
+}
+
+var _ clock.Clock = (*Fake)(nil)
+
+// job is a function scheduled with Every.
+type job struct {
+	every time.Duration
+	next  time.Time
+	f     func()
+}
+
+// New builds a new Fake showing start.
+func New(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now returns the time the clock shows.
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Every schedules f to be called every d of simulated time.
+func (c *Fake) Every(d time.Duration, f func()) func() {
+	if d <= 0 {
+		panic("clocktest: non-positive interval for Every")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	j := &job{every: d, next: c.now.Add(d), f: f}
+	c.jobs = append(c.jobs, j)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, s := range c.jobs {
+			if s == j {
+				c.jobs = append(c.jobs[:i], c.jobs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Advance moves the clock forward by d. Each time a scheduled function
+// is due meanwhile, the clock is set to that time and the function is
+// called, earliest first; functions due at the same time are called in
+// the order they were scheduled.
+func (c *Fake) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		var due *job
+		for _, j := range c.jobs {
+			if !j.next.After(end) && (due == nil || j.next.Before(due.next)) {
+				due = j
+			}
+		}
+		if due == nil {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+		c.now = due.next
+		due.next = due.next.Add(due.every)
+		c.mu.Unlock()
+		due.f() // ロックを持たずに呼ぶ
+	}
+}
@@ -0,0 +1,57 @@
+package httpfx
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// drainNotice answers the requests that arrive while the server drains
+// with 503 Service Unavailable, saying when it expects to be done,
+// rather than letting them through to the handler.
+type drainNotice struct {
+	until    atomic.Pointer[time.Time] // nil unless draining
+	requests func() int64              // requests in progress
+}
+
+// start makes the notice answer every request from now on, announcing
+// the end of the drain at until.
+func (d *drainNotice) start(until time.Time) {
+	d.until.Store(&until)
+}
+
+// drainStatus is the JSON body of the notice.
+type drainStatus struct {
+	Status             string `json:"status"`
+	EstimatedDrainSecs int    `json:"estimated_drain_seconds"`
+	RequestsInProgress int64  `json:"requests_in_progress"`
+}
+
+func (d *drainNotice) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		until := d.until.Load()
+		if until == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		secs := int(math.Ceil(time.Until(*until).Seconds()))
+		secs = max(secs, 1)
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Connection", "close")
+		if strings.Contains(r.Header.Get("Accept"), "json") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(drainStatus{Status: "draining", EstimatedDrainSecs: secs, RequestsInProgress: d.requests()})
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "<!doctype html><title>Restarting</title><h1>Restarting</h1><p>The server is finishing its requests before it restarts. Please retry in %d seconds.</p>\n", secs)
+	})
+}
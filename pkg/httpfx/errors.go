@@ -0,0 +1,64 @@
+package httpfx
+
+import (
+	"strings"
+
+	"go.uber.org/dig"
+)
+
+// ConstructorError is a constructor's failure to build what the
+// application needed, typically a route whose constructor returned an
+// error.
+type ConstructorError struct {
+	// Group is the value group being built, such as
+	// `httpfx.Route[group="routes"]`, or empty if the value was not
+	// part of one.
+	Group string
+	// Constructor names the function that failed and where it is, as
+	// `"example.com/fxdemo/upload".NewHandler (upload/handler.go:39)`.
+	Constructor string
+	Err         error
+}
+
+func (e *ConstructorError) Error() string {
+	if e.Group != "" {
+		return e.Group + ": " + e.Constructor + ": " + e.Err.Error()
+	}
+	return e.Constructor + ": " + e.Err.Error()
+}
+
+// Unwrap returns the constructor's error.
+func (e *ConstructorError) Unwrap() error {
+	return e.Err
+}
+
+// Messages in the chain of errors Fx reports a failed constructor with.
+const (
+	failedConstructor = "received non-nil error from function "
+	failedGroup       = "could not build value group "
+)
+
+// Explain reduces an error from fx.New, which lists every dependency
+// that could not be built because of a failing constructor, to a
+// ConstructorError naming that constructor. Other errors are returned
+// as they are.
+// 起動エラーから失敗したコンストラクタを取り出す
+func Explain(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	i := strings.LastIndex(msg, failedConstructor)
+	if i < 0 {
+		return err
+	}
+	constructor, _, ok := strings.Cut(msg[i+len(failedConstructor):], "): ")
+	if !ok {
+		return err
+	}
+	e := &ConstructorError{Constructor: constructor + ")", Err: dig.RootCause(err)}
+	if j := strings.LastIndex(msg[:i], failedGroup); j >= 0 {
+		e.Group, _, _ = strings.Cut(msg[j+len(failedGroup):i], ": ")
+	}
+	return e
+}
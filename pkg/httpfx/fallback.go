@@ -0,0 +1,76 @@
+package httpfx
+
+import "net/http"
+
+// NotFoundHandler serves the requests that no route matches, in place
+// of http.NotFound. Providing one is optional.
+type NotFoundHandler interface {
+	http.Handler
+}
+
+// MethodNotAllowedHandler answers the requests that a route refuses
+// with 405 Method Not Allowed, in place of the route's own response.
+// The route's Allow header is already set when it is called. Providing
+// one is optional.
+type MethodNotAllowedHandler interface {
+	http.Handler
+}
+
+// Fallbacks are the handlers of the requests no route serves. Nil
+// handlers keep the default responses.
+type Fallbacks struct {
+	NotFound         http.Handler
+	MethodNotAllowed http.Handler
+}
+
+// refuseWith returns a handler that serves requests with h, except that
+// the responses h refuses with 405 are replaced by those of refuse.
+func refuseWith(h, refuse http.Handler) http.Handler {
+	if refuse == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&refusalWriter{ResponseWriter: w, r: r, refuse: refuse}, r)
+	})
+}
+
+// refusalWriter hands 405 responses over to refuse and discards what
+// the route writes after them.
+type refusalWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	refuse      http.Handler
+	wroteHeader bool
+	refused     bool
+}
+
+func (w *refusalWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status >= 200 {
+		w.wroteHeader = true
+	}
+	if status == http.StatusMethodNotAllowed {
+		w.refused = true
+		w.Header().Del("Content-Type") // set by http.Error for its own body
+		w.refuse.ServeHTTP(w.ResponseWriter, w.r)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *refusalWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.refused {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying writer.
+func (w *refusalWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
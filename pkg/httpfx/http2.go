@@ -0,0 +1,65 @@
+package httpfx
+
+import (
+	"net/http"
+	"time"
+)
+
+// HTTP2Config tunes HTTP/2, which the server speaks to the clients that
+// negotiate it over TLS. Zero fields keep the defaults of net/http.
+// Idle HTTP/2 connections are closed after the server's IdleTimeout,
+// as HTTP/1.1 ones are.
+type HTTP2Config struct {
+	// MaxConcurrentStreams is how many requests a client may have in
+	// progress at once on a connection.
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams"`
+	// MaxReadFrameSize is the largest frame accepted, between 16 KiB
+	// and 16 MiB.
+	MaxReadFrameSize int `yaml:"max_read_frame_size"`
+	// MaxReceiveBufferPerStream and MaxReceiveBufferPerConnection are
+	// the flow-control windows: how much of the request bodies clients
+	// may send ahead of what the handlers have read, per request and
+	// for all the requests of a connection.
+	MaxReceiveBufferPerStream     int `yaml:"max_receive_buffer_per_stream"`
+	MaxReceiveBufferPerConnection int `yaml:"max_receive_buffer_per_connection"`
+	// SendPingTimeout is how long a connection may go without a frame
+	// from its client before the server pings it, and PingTimeout how
+	// long it then waits for the answer before closing it.
+	SendPingTimeout time.Duration `yaml:"send_ping_timeout"`
+	PingTimeout     time.Duration `yaml:"ping_timeout"`
+	// WriteByteTimeout closes connections the server has been unable to
+	// write anything to for that long.
+	WriteByteTimeout time.Duration `yaml:"write_byte_timeout"`
+}
+
+// DefaultHTTP2 returns the HTTP/2 settings for production, tighter than
+// those of net/http: 100 streams a connection rather than 250, frames
+// of at most 64 KiB rather than 1 MiB, and windows of 256 KiB a stream
+// and 4 MiB a connection rather than 1 MiB each. Connections silent for
+// 30 seconds are pinged, and those that cannot be written to for 30
+// seconds closed, where net/http does neither.
+// HTTP/2の既定値
+func DefaultHTTP2() HTTP2Config {
+	return HTTP2Config{
+		MaxConcurrentStreams:          100,
+		MaxReadFrameSize:              64 << 10,
+		MaxReceiveBufferPerStream:     256 << 10,
+		MaxReceiveBufferPerConnection: 4<<20 - 1, // net/httpは4MiB未満しか受け付けない
+		SendPingTimeout:               30 * time.Second,
+		PingTimeout:                   15 * time.Second,
+		WriteByteTimeout:              30 * time.Second,
+	}
+}
+
+// server returns c as the settings of an http.Server.
+func (c HTTP2Config) server() *http.HTTP2Config {
+	return &http.HTTP2Config{
+		MaxConcurrentStreams:          c.MaxConcurrentStreams,
+		MaxReadFrameSize:              c.MaxReadFrameSize,
+		MaxReceiveBufferPerStream:     c.MaxReceiveBufferPerStream,
+		MaxReceiveBufferPerConnection: c.MaxReceiveBufferPerConnection,
+		SendPingTimeout:               c.SendPingTimeout,
+		PingTimeout:                   c.PingTimeout,
+		WriteByteTimeout:              c.WriteByteTimeout,
+	}
+}
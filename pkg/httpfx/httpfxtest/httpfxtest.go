@@ -0,0 +1,53 @@
+// Package httpfxtest provides utilities for benchmarking middleware, so
+// that each middleware package measures what it costs a request the same
+// way:
+//
+//	func BenchmarkWrap(b *testing.B) {
+//		httpfxtest.Benchmark(b, NewMiddleware(DefaultConfig()).Wrap(httpfxtest.Noop))
+//	}
+//
+// The results are printed by go test -bench, and can be compared with
+// benchstat:
+//
+//	go test -run '^$' -bench . -count 10 ./... > old.txt
+//	# change something
+//	go test -run '^$' -bench . -count 10 ./... > new.txt
+//	benchstat old.txt new.txt
+package httpfxtest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Path is the path of the requests served by Benchmark.
+const Path = "/users"
+
+// Noop answers every request with an empty 200, so that the cost of a
+// middleware around it is the cost of the middleware.
+// 何もしないハンドラ
+var Noop http.Handler = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+// NewRequest returns a GET request for path from a local client, as
+// curl would send it.
+func NewRequest(path string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = "127.0.0.1:40000"
+	r.Header.Set("User-Agent", "curl/8.5.0")
+	r.Header.Set("Accept", "*/*")
+	return r
+}
+
+// Benchmark measures h serving a request for Path, reporting its
+// allocations too.
+func Benchmark(b *testing.B, h http.Handler) {
+	b.Helper()
+	b.ReportAllocs()
+	for b.Loop() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, NewRequest(Path))
+		io.Copy(io.Discard, w.Body)
+	}
+}
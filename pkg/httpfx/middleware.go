@@ -0,0 +1,93 @@
+package httpfx
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"go.uber.org/fx"
+)
+
+// Middleware is implemented by everything that wraps a handler to apply
+// to the requests it serves.
+type Middleware interface {
+	Wrap(next http.Handler) http.Handler
+}
+
+// MiddlewareFunc lets an ordinary function be used as a Middleware.
+type MiddlewareFunc func(next http.Handler) http.Handler
+
+// Wrap returns f(next).
+func (f MiddlewareFunc) Wrap(next http.Handler) http.Handler {
+	return f(next)
+}
+
+// Chain wraps h with the middleware, the first of which sees each
+// request first.
+func Chain(h http.Handler, chain ...Middleware) http.Handler {
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i].Wrap(h)
+	}
+	return h
+}
+
+// Named is implemented by middleware with a name of its own, for logs,
+// traces and ordering. Other middleware is named after its type.
+type Named interface {
+	Name() string
+}
+
+// NameOf returns the name of mw.
+func NameOf(mw Middleware) string {
+	if n, ok := mw.(Named); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", mw)
+}
+
+// Ordered is implemented by middleware that says where it goes in a
+// Pipeline. Middleware that does not implement it has order 0.
+type Ordered interface {
+	Order() int
+}
+
+// OrderOf returns the order of mw.
+func OrderOf(mw Middleware) int {
+	if o, ok := mw.(Ordered); ok {
+		return o.Order()
+	}
+	return 0
+}
+
+// MiddlewareGroup is the value group of the middleware provided with
+// AsMiddleware.
+const MiddlewareGroup = "middleware"
+
+// AsMiddleware annotates the given constructor to state that it
+// provides a Middleware to the "middleware" group, f returning an M.
+// ミドルウェアをグループに登録する
+func AsMiddleware[M Middleware](f any) any {
+	return AsGroup[Middleware, M](f, MiddlewareGroup)
+}
+
+// Pipeline collects the middleware provided with AsMiddleware.
+type Pipeline struct {
+	fx.In
+
+	Middleware []Middleware `group:"middleware"`
+}
+
+// Sorted returns the middleware by ascending Order, then by name, as
+// value groups come in no particular order. The first one is meant to
+// see each request first.
+// 順番を決める
+func (p Pipeline) Sorted() []Middleware {
+	mws := append([]Middleware(nil), p.Middleware...)
+	sort.SliceStable(mws, func(i, j int) bool {
+		if oi, oj := OrderOf(mws[i]), OrderOf(mws[j]); oi != oj {
+			return oi < oj
+		}
+		return NameOf(mws[i]) < NameOf(mws[j])
+	})
+	return mws
+}
@@ -0,0 +1,323 @@
+package httpfx
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Plain serves a route as it is, without any per-route middleware.
+func Plain(route Route) http.Handler {
+	return route
+}
+
+// Resolver returns the handler serving a pattern that several API
+// versions serve, given the handler of each version. It picks the
+// version each request asks for, however the API lets clients name it,
+// and answers those naming none that exists.
+// バージョンの選び方はAPIごとに異なる
+type Resolver func(versions map[int]http.Handler) http.Handler
+
+// NewRouter builds a router that will route requests to the given
+// routes, versions[0] being version 1, under each of their PatternsOf.
+// Each route is served by the handler wrap returns for it, which is
+// where per-route middleware goes.
+//
+// Patterns are those of http.ServeMux, so they may contain path
+// parameters, such as "/users/{id}", which routes read with PathValue.
+// Patterns do not name a method; routes are Restricted instead.
+//
+// A pattern served by several API versions is also registered under
+// "/v1", "/v2" and so on, while the pattern itself is served by the
+// handler resolve returns, which picks the version the client asked
+// for. If resolve is nil, the pattern serves the oldest version.
+//
+// Routes are registered in a fixed order: by descending Priority, then
+// by pattern and type. Of several routes of a version with the same
+// pattern, the one with the highest priority is served. Routes that
+// would otherwise be registered under the same pattern, rather than one
+// silently replacing the other, make NewRouter fail with an error
+// listing every conflict and the routes involved. So do patterns that
+// http.ServeMux refuses, such as "/{a}/x" alongside "/x/{b}", which
+// match some of the same paths without either being more specific.
+//
+// Requests with a method that a Restricted route does not serve are
+// refused with 405 and an Allow header listing the methods it does.
+// Unless the route's AutoMethods say otherwise, the mux answers OPTIONS
+// for it with that header, and serves HEAD with its GET handler.
+//
+// Requests that no route matches, or that a route refuses with 405
+// Method Not Allowed, are handed to the Fallbacks, if they are set. A
+// route registered at "/" matches every request and leaves no request
+// for the NotFound fallback.
+func NewRouter(versions [][]Route, wrap func(Route) http.Handler, resolve Resolver, fallbacks Fallbacks) (*http.ServeMux, error) {
+	if resolve == nil {
+		resolve = oldest
+	}
+	byPattern := make(map[string]map[int][]*served) // パターンごと、バージョンごとのルート
+	var patterns []string
+	for v, group := range versions {
+		for _, route := range sorted(group) {
+			s := &served{route: route}
+			for _, pattern := range PatternsOf(route) {
+				if byPattern[pattern] == nil {
+					patterns = append(patterns, pattern)
+					byPattern[pattern] = make(map[int][]*served)
+				}
+				byPattern[pattern][v+1] = append(byPattern[pattern][v+1], s)
+			}
+		}
+	}
+	if err := conflicts(byPattern); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	for _, pattern := range patterns {
+		byVersion := byPattern[pattern]
+		if len(byVersion) == 1 {
+			for _, routes := range byVersion {
+				if err := handle(mux, pattern, refuseWith(routes[0].handler(wrap), fallbacks.MethodNotAllowed)); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		handlers := make(map[int]http.Handler, len(byVersion))
+		for _, v := range versionsOf(byVersion) {
+			handlers[v] = refuseWith(byVersion[v][0].handler(wrap), fallbacks.MethodNotAllowed)
+			prefix := fmt.Sprintf("/v%d", v)
+			if err := handle(mux, prefix+pattern, http.StripPrefix(prefix, handlers[v])); err != nil {
+				return nil, err
+			}
+		}
+		if err := handle(mux, pattern, resolve(handlers)); err != nil {
+			return nil, err
+		}
+	}
+	if _, claimed := byPattern["/"]; !claimed && fallbacks.NotFound != nil {
+		mux.Handle("/", fallbacks.NotFound)
+	}
+	return mux, nil
+}
+
+// handle registers h under pattern, returning the error http.ServeMux
+// panics with for an invalid or conflicting pattern.
+// ServeMuxのpanicをエラーにする
+func handle(mux *http.ServeMux, pattern string, h http.Handler) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("httpfx: %v", p)
+		}
+	}()
+	mux.Handle(pattern, h)
+	return nil
+}
+
+// PathValue returns the value of the path parameter name of the route
+// pattern that matched r, or "" if it has none, as for "/users/{id}":
+//
+//	id := httpfx.PathValue(r, "id")
+func PathValue(r *http.Request, name string) string {
+	return r.PathValue(name)
+}
+
+// PathFor fills the path parameters of pattern with values, in order,
+// escaping them, to build a path the pattern matches. Parameters left
+// without a value are dropped, along with the rest of the pattern. The
+// mux matches no path built with an empty value, a value of "/", or a
+// {name...} value with empty segments.
+// パターンからパスを組み立てる
+func PathFor(pattern string, values ...string) string {
+	var b strings.Builder
+	for {
+		before, rest, ok := strings.Cut(pattern, "{")
+		b.WriteString(before)
+		if !ok {
+			return b.String()
+		}
+		name, after, _ := strings.Cut(rest, "}")
+		if name == "$" { // {$}は末尾のスラッシュで終わることを示すだけ
+			pattern = after
+			continue
+		}
+		if len(values) == 0 {
+			return b.String()
+		}
+		if strings.HasSuffix(name, "...") {
+			// 残り全体はスラッシュを含みうるので、区切りごとにエスケープする
+			for i, seg := range strings.Split(values[0], "/") {
+				if i > 0 {
+					b.WriteByte('/')
+				}
+				b.WriteString(escapeSegment(seg))
+			}
+		} else {
+			b.WriteString(escapeSegment(values[0]))
+		}
+		pattern, values = after, values[1:]
+	}
+}
+
+// escapeSegment escapes s as one segment of a path, including the dot
+// segments that the mux would otherwise clean away.
+func escapeSegment(s string) string {
+	if s == "." || s == ".." {
+		return strings.Repeat("%2E", len(s))
+	}
+	return url.PathEscape(s)
+}
+
+// served is a route and the handler serving it, which is shared by all
+// of the route's patterns. The handler refuses the methods the route
+// does not serve before any per-route middleware runs.
+type served struct {
+	route Route
+	h     http.Handler
+}
+
+func (s *served) handler(wrap func(Route) http.Handler) http.Handler {
+	if s.h == nil {
+		s.h = allow(MethodsOf(s.route), AutoMethodsOf(s.route), wrap(s.route))
+	}
+	return s.h
+}
+
+// allow returns a handler that refuses requests with methods other than
+// the given ones with 405, and serves the others with h, answering
+// OPTIONS and HEAD as auto says if they are not among them. If methods
+// is empty, h serves every request.
+// 許可されていないメソッドは405で拒否する
+func allow(methods []string, auto AutoMethods, h http.Handler) http.Handler {
+	if len(methods) == 0 {
+		return h
+	}
+	allowed := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	head := auto.Head && allowed[http.MethodGet] && !allowed[http.MethodHead]
+	options := auto.Options && !allowed[http.MethodOptions]
+	list := append([]string(nil), methods...)
+	if head {
+		list = append(list, http.MethodHead)
+	}
+	if options {
+		list = append(list, http.MethodOptions)
+	}
+	header := strings.Join(list, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case allowed[r.Method]:
+			h.ServeHTTP(w, r)
+		case head && r.Method == http.MethodHead:
+			// GETとして処理し、ボディは捨てる
+			get := r.Clone(r.Context())
+			get.Method = http.MethodGet
+			h.ServeHTTP(&headWriter{ResponseWriter: w}, get)
+		case options && r.Method == http.MethodOptions:
+			w.Header().Set("Allow", header)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", header)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// headWriter discards the body of a GET response served for a HEAD
+// request.
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Unwrap returns the underlying writer.
+func (w *headWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// sorted returns the routes by descending priority, then by pattern and
+// type, as value groups come in no particular order.
+// 優先度の高い順に並べる
+func sorted(routes []Route) []Route {
+	routes = append([]Route(nil), routes...)
+	sort.SliceStable(routes, func(i, j int) bool {
+		if pi, pj := PriorityOf(routes[i]), PriorityOf(routes[j]); pi != pj {
+			return pi > pj
+		}
+		if routes[i].Pattern() != routes[j].Pattern() {
+			return routes[i].Pattern() < routes[j].Pattern()
+		}
+		return fmt.Sprintf("%T", routes[i]) < fmt.Sprintf("%T", routes[j])
+	})
+	return routes
+}
+
+// oldest is the Resolver of APIs that do not let clients choose a
+// version other than by path.
+func oldest(versions map[int]http.Handler) http.Handler {
+	v := 0
+	for version := range versions {
+		if v == 0 || version < v {
+			v = version
+		}
+	}
+	return versions[v]
+}
+
+func versionsOf(byVersion map[int][]*served) []int {
+	vs := make([]int, 0, len(byVersion))
+	for v := range byVersion {
+		vs = append(vs, v)
+	}
+	sort.Ints(vs)
+	return vs
+}
+
+// describe names a route by its type, and by its String method if the
+// type alone is not enough to tell routes apart.
+func describe(route Route) string {
+	if s, ok := route.(fmt.Stringer); ok {
+		return fmt.Sprintf("%T %s", route, s)
+	}
+	return fmt.Sprintf("%T", route)
+}
+
+// conflicts reports the patterns that more than one route of the highest
+// priority would be registered under, including the "/v2"-prefixed
+// patterns of versioned routes. The routes are sorted by priority.
+func conflicts(byPattern map[string]map[int][]*served) error {
+	owners := make(map[string][]string) // パターンごとのルートの型
+	for pattern, byVersion := range byPattern {
+		for v, routes := range byVersion {
+			at := pattern
+			if len(byVersion) > 1 {
+				at = fmt.Sprintf("/v%d%s", v, pattern)
+			}
+			for _, s := range routes {
+				if PriorityOf(s.route) < PriorityOf(routes[0].route) {
+					break // 優先度の低いルートは使われない
+				}
+				owners[at] = append(owners[at], fmt.Sprintf("%s (v%d)", describe(s.route), v))
+			}
+		}
+	}
+	var found []string
+	for pattern, routes := range owners {
+		if len(routes) > 1 {
+			sort.Strings(routes)
+			found = append(found, fmt.Sprintf("%q is served by %s", pattern, strings.Join(routes, " and ")))
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	sort.Strings(found)
+	return fmt.Errorf("httpfx: conflicting routes: %s", strings.Join(found, "; "))
+}
@@ -0,0 +1,236 @@
+package httpfx
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testRoute answers every request with its name.
+type testRoute struct {
+	pattern  string
+	name     string
+	priority int
+}
+
+func (r testRoute) Pattern() string { return r.pattern }
+func (r testRoute) Priority() int   { return r.priority }
+func (r testRoute) String() string  { return r.name }
+
+func (r testRoute) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	io.WriteString(w, r.name)
+}
+
+// plainRoute is a route without a priority.
+type plainRoute struct{ testRoute }
+
+func (plainRoute) Priority() {} // Prioritizedを満たさない
+
+// servedBy returns the name of the route mux serves path with.
+func servedBy(t *testing.T, mux http.Handler, path string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec.Body.String()
+}
+
+// permutations returns every order of routes.
+func permutations(routes []Route) [][]Route {
+	if len(routes) <= 1 {
+		return [][]Route{routes}
+	}
+	var out [][]Route
+	for i := range routes {
+		rest := append(slices.Clone(routes[:i]), routes[i+1:]...)
+		for _, p := range permutations(rest) {
+			out = append(out, append([]Route{routes[i]}, p...))
+		}
+	}
+	return out
+}
+
+func TestSorted(t *testing.T) {
+	first, second := testRoute{"/c", "c first", -1}, testRoute{"/c", "c second", -1}
+	routes := []Route{
+		testRoute{"/b", "b", 0},
+		plainRoute{testRoute{"/a", "plain a", 0}},
+		testRoute{"/a", "a", 0},
+		testRoute{"/z", "z", 5},
+		first,
+		second,
+	}
+	for _, order := range permutations(routes) {
+		var got []string
+		for _, r := range sorted(order) {
+			got = append(got, fmt.Sprint(r))
+		}
+		want := []string{"z", "plain a", "a", "b", "c first", "c second"}
+		// 優先度・パターン・型が同じルートは元の順序を保つ
+		if slices.Index(order, Route(first)) > slices.Index(order, Route(second)) {
+			want[4], want[5] = want[5], want[4]
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("sorted(%v) = %v, want %v", order, got, want)
+		}
+	}
+}
+
+func TestNewRouterPriority(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []Route
+		paths  map[string]string // path: name of the route serving it
+	}{
+		{
+			name: "highest priority wins",
+			routes: []Route{
+				testRoute{"/items/", "low", -1},
+				testRoute{"/items/", "default", 0},
+				testRoute{"/items/", "high", 10},
+			},
+			paths: map[string]string{"/items/": "high", "/items/1": "high"},
+		},
+		{
+			name: "plain route loses to a prioritized one",
+			routes: []Route{
+				plainRoute{testRoute{"/items/", "plain", 0}},
+				testRoute{"/items/", "high", 1},
+			},
+			paths: map[string]string{"/items/1": "high"},
+		},
+		{
+			name: "longest pattern wins regardless of priority",
+			routes: []Route{
+				testRoute{"/items/", "subtree", 10},
+				testRoute{"/items/special", "special", -10},
+			},
+			paths: map[string]string{"/items/1": "subtree", "/items/special": "special"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 登録の順序によらず同じ結果になる
+			for _, order := range permutations(tt.routes) {
+				mux, err := NewRouter([][]Route{order}, Plain, nil, Fallbacks{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for path, want := range tt.paths {
+					if got := servedBy(t, mux, path); got != want {
+						t.Errorf("%v: %s served by %q, want %q", order, path, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewRouterPriorityConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		routes   []Route
+		conflict bool
+	}{
+		{"tie at the top", []Route{testRoute{"/x", "a", 1}, testRoute{"/x", "b", 1}, testRoute{"/x", "c", 0}}, true},
+		{"tie below the top", []Route{testRoute{"/x", "a", 2}, testRoute{"/x", "b", 1}, testRoute{"/x", "c", 1}}, false},
+		{"tie without priorities", []Route{testRoute{"/x", "a", 0}, plainRoute{testRoute{"/x", "b", 0}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRouter([][]Route{tt.routes}, Plain, nil, Fallbacks{})
+			if (err != nil) != tt.conflict {
+				t.Fatalf("NewRouter() error = %v, want a conflict: %v", err, tt.conflict)
+			}
+			if err != nil && !strings.Contains(err.Error(), `"/x" is served by`) {
+				t.Errorf("NewRouter() error = %v, want it to name the pattern", err)
+			}
+		})
+	}
+}
+
+func TestNewRouterVersions(t *testing.T) {
+	versions := [][]Route{
+		{testRoute{"/items", "v1 items", 0}, testRoute{"/v1only", "v1 only", 0}},
+		{testRoute{"/items", "v2 items", 0}},
+	}
+	// X-Versionヘッダでバージョンを選ぶ
+	byHeader := func(handlers map[int]http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			v, _ := strconv.Atoi(r.Header.Get("X-Version"))
+			if h, ok := handlers[v]; ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+			io.WriteString(w, "none")
+		})
+	}
+	tests := []struct {
+		name    string
+		resolve Resolver
+		version string
+		want    string
+	}{
+		{"oldest by default", nil, "2", "v1 items"},
+		{"resolved", byHeader, "2", "v2 items"},
+		{"resolved to none", byHeader, "3", "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, err := NewRouter(versions, Plain, tt.resolve, Fallbacks{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/items", nil)
+			r.Header.Set("X-Version", tt.version)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, r)
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("/items served by %q, want %q", got, tt.want)
+			}
+			// パスで選んだバージョンと単一バージョンのパターンはresolveを経ない
+			for path, want := range map[string]string{"/v1/items": "v1 items", "/v2/items": "v2 items", "/v1only": "v1 only"} {
+				if got := servedBy(t, mux, path); got != want {
+					t.Errorf("%s served by %q, want %q", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+// FuzzPathFor checks that a path built by PathFor is matched by its
+// pattern, with the values it was built from.
+func FuzzPathFor(f *testing.F) {
+	f.Add("42", "docs/intro.md")
+	f.Add("a b", "")
+	f.Add("..", "a/./b/")
+	f.Add("x?y#z", "%2F/100%")
+	const pattern = "/files/{id}/{path...}"
+	var id, path string
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, func(_ http.ResponseWriter, r *http.Request) {
+		id, path = r.PathValue("id"), r.PathValue("path")
+	})
+	f.Fuzz(func(t *testing.T, wantID, wantPath string) {
+		// PathForが組み立てられないパス
+		if wantID == "" || wantID == "/" || strings.Contains("/"+wantPath, "//") {
+			t.Skip()
+		}
+		id, path = "", ""
+		built := PathFor(pattern, wantID, wantPath)
+		r, err := http.NewRequest("GET", built, nil)
+		if err != nil {
+			t.Fatalf("PathFor(%q, %q) = %q, which is not a URL: %v", wantID, wantPath, built, err)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK || id != wantID || path != wantPath {
+			t.Errorf("PathFor(%q, %q) = %q, served with %d as id %q and path %q",
+				wantID, wantPath, built, rec.Code, id, path)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package httpfx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+)
+
+// Redaction removes secrets from the panic values and stack traces that
+// Recover logs. Nil funcs leave them as they are.
+// panicの出力から秘密情報を消す関数
+type Redaction struct {
+	String func(string) string
+	Bytes  func([]byte) []byte
+}
+
+func (r Redaction) string(s string) string {
+	if r.String == nil {
+		return s
+	}
+	return r.String(s)
+}
+
+func (r Redaction) bytes(b []byte) []byte {
+	if r.Bytes == nil {
+		return b
+	}
+	return r.Bytes(b)
+}
+
+// Recover returns middleware that stops a panic in the handler it wraps
+// from reaching net/http. The panic is logged with its stack trace, and
+// the request answered with 500 Internal Server Error if nothing has
+// been sent yet; otherwise the connection is dropped, so that the client
+// does not take a truncated response for a whole one.
+//
+// The panic value and the stack trace may hold credentials the handler
+// was working with, and are logged as redact leaves them. The log entry
+// carries the request ID the response was given in its X-Request-ID
+// header, if any, as the panic happens inside the middleware that sets
+// it.
+//
+// Panics with http.ErrAbortHandler, which handlers use to abort a
+// response on purpose, are passed on untouched.
+// panicを500に変えてログに残す
+func Recover(log *zap.Logger, redact Redaction) Middleware {
+	return MiddlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pw := &panicWriter{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(v)
+				}
+				id := zap.Skip()
+				if rid := w.Header().Get("X-Request-ID"); rid != "" {
+					id = zap.String("request_id", rid)
+				}
+				log.Error("Recovered from a panic in a handler",
+					id,
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.String("panic", redact.string(fmt.Sprint(v))), // 値や引数に秘密が含まれうる
+					zap.Bool("response_started", pw.wroteHeader),
+					zap.ByteString("stack", redact.bytes(debug.Stack())),
+				)
+				if pw.wroteHeader {
+					panic(http.ErrAbortHandler) // net/httpに接続を切らせる
+				}
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(pw, r)
+		})
+	})
+}
+
+// panicWriter remembers whether the response has been started.
+type panicWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *panicWriter) WriteHeader(status int) {
+	if status >= 200 {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *panicWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying writer.
+func (w *panicWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,43 @@
+package httpfx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRecoverRedaction(t *testing.T) {
+	hide := func(s string) string { return strings.ReplaceAll(s, "hunter2", "***") }
+	tests := []struct {
+		name   string
+		redact Redaction
+		want   string
+	}{
+		{"none by default", Redaction{}, "password hunter2"},
+		{"redacted", Redaction{String: hide}, "password ***"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.ErrorLevel)
+			h := Recover(zap.New(core), tt.redact).Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				panic("password hunter2")
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("status %d, want 500", rec.Code)
+			}
+			entries := logs.All()
+			if len(entries) != 1 {
+				t.Fatalf("%d entries logged, want 1", len(entries))
+			}
+			if got := entries[0].ContextMap()["panic"]; got != tt.want {
+				t.Errorf("panic logged as %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+// Package httpfx is the reusable part of the fxdemo server: the HTTP
+// server and its lifecycle, routes collected from Fx value groups, API
+// versions and middleware chains. It knows nothing of the fxdemo API
+// itself, so other services can build on the same wiring:
+//
+//	fx.New(
+//		httpfx.Module,
+//		fx.Provide(
+//			httpfx.AsRoute[*PingHandler](NewPingHandler),
+//			func(routes httpfx.Routes) (http.Handler, error) {
+//				return httpfx.NewRouter(routes.Versions(), httpfx.Plain, nil, routes.Fallbacks())
+//			},
+//		),
+//	).Run()
+package httpfx
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"go.uber.org/fx"
+)
+
+// Route is an http.Handler that knows the mux pattern
+// under which it will be registered.
+// インターフェースを定義
+type Route interface {
+	http.Handler
+	Pattern() string // Pattern reports the path at which this is registered.
+}
+
+// Multi is implemented by routes registered under several patterns,
+// such as a route claiming both "/assets/" and "/favicon.ico". As with
+// http.ServeMux, a pattern ending in a slash claims the whole subtree
+// below it. Pattern remains the route's main pattern, by which it is
+// known to per-route middleware.
+type Multi interface {
+	Patterns() []string
+}
+
+// PatternsOf returns the patterns route is registered under.
+func PatternsOf(route Route) []string {
+	if m, ok := route.(Multi); ok {
+		if patterns := m.Patterns(); len(patterns) > 0 {
+			return patterns
+		}
+	}
+	return []string{route.Pattern()}
+}
+
+// Prioritized is implemented by routes that take precedence over others
+// registered under the same pattern. Routes that do not implement it
+// have priority 0.
+type Prioritized interface {
+	Priority() int
+}
+
+// PriorityOf returns the priority of route.
+func PriorityOf(route Route) int {
+	if p, ok := route.(Prioritized); ok {
+		return p.Priority()
+	}
+	return 0
+}
+
+// Restricted is implemented by routes that only serve some HTTP
+// methods. The mux refuses requests with other methods with 405 Method
+// Not Allowed before they reach the route. Routes that do not implement
+// it serve every method.
+type Restricted interface {
+	Methods() []string
+}
+
+// MethodsOf returns the methods route serves, or nil if it serves all
+// of them.
+func MethodsOf(route Route) []string {
+	if r, ok := route.(Restricted); ok {
+		return r.Methods()
+	}
+	return nil
+}
+
+// AutoMethods says which methods the mux answers on behalf of a
+// Restricted route that does not serve them itself.
+type AutoMethods struct {
+	// Options answers OPTIONS with 204 and the Allow header.
+	Options bool
+	// Head serves HEAD with the route's GET handler, without the body.
+	Head bool
+}
+
+// Automatic is implemented by Restricted routes that choose which
+// methods the mux answers for them. Restricted routes that do not
+// implement it get both OPTIONS and HEAD.
+type Automatic interface {
+	AutoMethods() AutoMethods
+}
+
+// AutoMethodsOf returns the methods the mux answers for route.
+func AutoMethodsOf(route Route) AutoMethods {
+	if a, ok := route.(Automatic); ok {
+		return a.AutoMethods()
+	}
+	return AutoMethods{Options: true, Head: true}
+}
+
+// RoutesGroup is the value group of the version 1 routes.
+const RoutesGroup = "routes"
+
+// VersionGroup returns the value group of the routes of an API version.
+func VersionGroup(version int) string {
+	if version == 1 {
+		return RoutesGroup
+	}
+	return fmt.Sprintf("routes.v%d", version)
+}
+
+// AsGroup annotates the given constructor to state that it provides a T
+// to the named value group, T being the interface the group collects
+// and R the type the constructor returns.
+//
+// R must implement T. Go cannot express that as a constraint of AsGroup,
+// so each group has a helper taking R with T as its constraint, such as
+// AsRoute, and the compiler checks the type at its call:
+//
+//	httpfx.AsRoute[*PingHandler](NewPingHandler)
+//
+// AsGroup itself checks, when it is called, that the constructor's
+// first result is R and that R implements T, and panics naming the types
+// if not. With T as R, it accepts any constructor whose result
+// implements T, for the constructors only known at run time.
+// グループに登録するための汎用ヘルパー
+func AsGroup[T, R any](f any, group string) any {
+	iface, want := reflect.TypeFor[T](), reflect.TypeFor[R]()
+	if iface.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("httpfx: AsGroup: %v is not an interface", iface))
+	}
+	ft := reflect.TypeOf(f)
+	if ft == nil || ft.Kind() != reflect.Func || ft.NumOut() == 0 {
+		panic(fmt.Sprintf("httpfx: AsGroup[%v]: %T is not a constructor", iface, f))
+	}
+	out := ft.Out(0)
+	if want != iface && out != want {
+		panic(fmt.Sprintf("httpfx: AsGroup[%v, %v]: %v returns %v", iface, want, ft, out))
+	}
+	if !out.Implements(iface) {
+		panic(fmt.Sprintf("httpfx: AsGroup[%v]: %v, returned by %v, does not implement it", iface, out, ft))
+	}
+	return fx.Annotate(
+		f,
+		fx.As(new(T)),
+		fx.ResultTags(`group:"`+group+`"`),
+	)
+}
+
+// AsRoute annotates the given constructor to state that
+// it provides a route to the "routes" group, f returning an R. A route
+// that only serves some methods says so by implementing Restricted.
+// ハンドラを入力して、fx.Annotate()を出力する
+func AsRoute[R Route](f any) any {
+	return AsGroup[Route, R](f, RoutesGroup)
+}
+
+// AsRouteVersion annotates the given constructor to state that it
+// provides a route to the group of the given API version.
+// Routes provided with AsRoute are version 1.
+// バージョンごとのグループに登録する
+func AsRouteVersion[R Route](f any, version int) any {
+	return AsGroup[Route, R](f, VersionGroup(version))
+}
+
+// Routes collects the routes provided with AsRoute and AsRouteVersion,
+// versions up to 2, along with the fallbacks provided for the requests
+// they do not serve.
+type Routes struct {
+	fx.In
+
+	V1 []Route `group:"routes"`
+	V2 []Route `group:"routes.v2"`
+
+	NotFound         NotFoundHandler         `optional:"true"`
+	MethodNotAllowed MethodNotAllowedHandler `optional:"true"`
+}
+
+// Versions returns the routes of each version, version 1 first.
+func (r Routes) Versions() [][]Route {
+	return [][]Route{r.V1, r.V2}
+}
+
+// Fallbacks returns the fallbacks that were provided.
+func (r Routes) Fallbacks() Fallbacks {
+	return Fallbacks{NotFound: r.NotFound, MethodNotAllowed: r.MethodNotAllowed}
+}
@@ -0,0 +1,336 @@
+package httpfx
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// DefaultAddr is the address the server listens on unless configured
+// otherwise.
+const DefaultAddr = ":8080"
+
+// Config configures the server.
+type Config struct {
+	Addr string `yaml:"addr"`
+	// ReadTimeout and WriteTimeout are set on the http.Server; zero
+	// means no timeout.
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// ReadHeaderTimeout bounds the reading of the request headers alone,
+	// so that slow clients cannot hold connections open for nothing.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	// IdleTimeout is how long a keep-alive connection is kept waiting
+	// for its next request; zero means the ReadTimeout.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// MaxHeaderBytes bounds the size of the request line and headers of
+	// each request; larger ones are refused with 431 Request Header
+	// Fields Too Large. Zero means net/http's default of a megabyte.
+	MaxHeaderBytes int `yaml:"max_header_bytes"`
+	// ShutdownTimeout is how long the server waits for the requests in
+	// progress when it stops before closing their connections; zero
+	// means as long as Fx allows.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// DrainNotice, if set, is how long at most the server goes on
+	// accepting connections when it stops, to answer new requests with
+	// 503 Service Unavailable and an estimate of when it will be done,
+	// while those in progress finish. It is part of the ShutdownTimeout.
+	DrainNotice time.Duration `yaml:"drain_notice"`
+	// TLS makes the server serve HTTPS.
+	TLS TLSConfig `yaml:"tls"`
+	// HTTP2 tunes HTTP/2, which is only served over TLS.
+	HTTP2 HTTP2Config `yaml:"http2"`
+	// LogRoutes logs the routes served, sorted by pattern, when the
+	// server starts.
+	LogRoutes bool `yaml:"log_routes"`
+}
+
+// DefaultConfig serves on DefaultAddr. Requests may take as long as
+// they need, but their headers must arrive within 10 seconds and fit in
+// 64 KiB, and idle connections are closed after 2 minutes. HTTP/2 has
+// the DefaultHTTP2 settings. When stopping, it waits up to 10 seconds
+// for requests.
+func DefaultConfig() Config {
+	return Config{
+		Addr:              DefaultAddr,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    64 << 10,
+		ShutdownTimeout:   10 * time.Second,
+		HTTP2:             DefaultHTTP2(),
+	}
+}
+
+// ServerOptions customize the server built by NewServer beyond its
+// Config. Providing them is optional.
+type ServerOptions struct {
+	// ConnContext and ErrorLog are set on the http.Server.
+	ConnContext func(ctx context.Context, c net.Conn) context.Context
+	ErrorLog    *log.Logger
+	// Listener, if set, wraps the listener the server accepts from.
+	Listener func(net.Listener) net.Listener
+	// SlowClient, if set, is called for each connection the server
+	// closes because its client did not send a request's headers within
+	// the ReadHeaderTimeout, as the clients of slowloris attacks do.
+	SlowClient func(c net.Conn)
+	// Redact redacts the panics recovered from the handler before they
+	// are logged; see Recover.
+	Redact Redaction
+}
+
+// ServerParams are the dependencies of NewServer. Without a Config, or
+// with an empty one, the DefaultConfig is used.
+type ServerParams struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Handler   http.Handler
+	Log       *zap.Logger
+	Config    Config        `optional:"true"`
+	Options   ServerOptions `optional:"true"`
+	// TLS, if set, makes the server serve HTTPS; see NewTLSConfig.
+	TLS *tls.Config `optional:"true"`
+}
+
+// NewServer builds an HTTP server that will begin serving requests
+// when the Fx application starts, and shut down when it stops. Panics
+// in the handler are recovered and logged; see Recover. Given a TLS
+// config, it serves HTTPS, and redirects plaintext requests to it from
+// the TLS.RedirectAddr of the Config if set.
+//
+// Stopping drains the server: it stops accepting connections, closes
+// the idle ones and waits for the requests in progress, up to the
+// ShutdownTimeout. Connections still open then are closed, and how many
+// there were, and how many requests they were serving, is logged.
+//
+// With a DrainNotice, the server keeps accepting connections for a
+// while before that, answering new requests with a short page, or JSON
+// to clients that accept it, until those in progress are done or the
+// DrainNotice is over, so that clients arriving meanwhile are told to
+// retry rather than refused.
+//
+// Connections closed before their client finished sending the headers
+// of a request, after waiting for them for the ReadHeaderTimeout, or
+// the ReadTimeout if there is none, are reported to the SlowClient
+// option.
+func NewServer(p ServerParams) *http.Server {
+	cfg := p.Config
+	if cfg == (Config{}) {
+		cfg = DefaultConfig()
+	}
+	t := &tracker{conns: make(map[net.Conn]*connState), slow: p.Options.SlowClient, headerTimeout: cfg.ReadHeaderTimeout}
+	if t.headerTimeout == 0 {
+		t.headerTimeout = cfg.ReadTimeout
+	}
+	notice := &drainNotice{requests: t.requests.Load}
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           notice.wrap(t.wrap(Recover(p.Log, p.Options.Redact).Wrap(p.Handler))),
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		HTTP2:             cfg.HTTP2.server(),
+		ConnContext:       t.connContext(p.Options.ConnContext),
+		ConnState:         t.track,
+		ErrorLog:          p.Options.ErrorLog,
+		TLSConfig:         p.TLS,
+	}
+	if p.TLS != nil && cfg.TLS.RedirectAddr != "" {
+		serveRedirects(p.Lifecycle, redirectServer(cfg.TLS.RedirectAddr, cfg.Addr), p.Log)
+	}
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			if p.Options.Listener != nil {
+				ln = p.Options.Listener(ln)
+			}
+			// JA3などのラッパーはTLSの下で生のClientHelloを読む
+			if p.TLS != nil {
+				ln = tls.NewListener(ln, p.TLS)
+			}
+			p.Log.Info("Starting HTTP server", zap.String("addr", srv.Addr), zap.Bool("tls", p.TLS != nil))
+			go srv.Serve(ln)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			if cfg.ShutdownTimeout > 0 { // Fx's own stop timeout still applies if it is shorter
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, cfg.ShutdownTimeout)
+				defer cancel()
+			}
+			start := time.Now()
+			if cfg.DrainNotice > 0 {
+				announceDrain(ctx, srv, notice, t, cfg.DrainNotice, p.Log)
+			}
+			p.Log.Info("Draining HTTP server",
+				zap.Int64("requests", t.requests.Load()),
+				zap.Duration("timeout", cfg.ShutdownTimeout),
+			)
+			if err := srv.Shutdown(ctx); err != nil {
+				active, idle := t.open()
+				p.Log.Warn("Closing connections with requests in progress",
+					zap.Error(err),
+					zap.Int("active_connections", active),
+					zap.Int("idle_connections", idle),
+					zap.Int64("requests", t.requests.Load()),
+					zap.Duration("waited", time.Since(start)),
+				)
+				return srv.Close()
+			}
+			p.Log.Info("Drained HTTP server", zap.Duration("waited", time.Since(start)))
+			return nil
+		},
+	})
+	return srv
+}
+
+// announceDrain answers new requests with the notice until the requests
+// in progress are done, for up to limit, or until ctx is done.
+// 停止中であることを新しいリクエストに伝える
+func announceDrain(ctx context.Context, srv *http.Server, notice *drainNotice, t *tracker, limit time.Duration, log *zap.Logger) {
+	until := time.Now().Add(limit)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(until) {
+		until = deadline
+	}
+	srv.SetKeepAlivesEnabled(false) // 応答したら接続を閉じてもらう
+	notice.start(until)
+	log.Info("Answering new requests with 503 while draining",
+		zap.Int64("requests", t.requests.Load()),
+		zap.Time("until", until),
+	)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	for t.requests.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tracker keeps count of the server's connections and of the requests
+// in progress, for the shutdown to report on, and tells the connections
+// of slow clients from the others.
+type tracker struct {
+	requests atomic.Int64
+
+	slow          func(net.Conn)
+	headerTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[net.Conn]*connState
+}
+
+// connState is what tracker knows of a connection.
+type connState struct {
+	state http.ConnState
+	// waiting is when the connection started waiting for a request, as
+	// a new or idle connection.
+	waiting time.Time
+	// served reports whether the request the connection is active for
+	// reached the handler.
+	served bool
+}
+
+// connKey is the context key of the connection a request came on.
+type connKey struct{}
+
+// connContext returns the server's ConnContext hook, which records the
+// connection for wrap before calling next, if set.
+func (t *tracker) connContext(next func(context.Context, net.Conn) context.Context) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		ctx = context.WithValue(ctx, connKey{}, c)
+		if next != nil {
+			ctx = next(ctx, c)
+		}
+		return ctx
+	}
+}
+
+func (t *tracker) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.requests.Add(1)
+		defer t.requests.Add(-1)
+		if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+			t.mu.Lock()
+			if cs := t.conns[c]; cs != nil {
+				cs.served = true
+			}
+			t.mu.Unlock()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// track is the server's ConnState hook.
+func (t *tracker) track(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	cs := t.conns[c]
+	switch state {
+	case http.StateNew, http.StateIdle:
+		t.conns[c] = &connState{state: state, waiting: time.Now()}
+	case http.StateActive:
+		if cs != nil {
+			cs.state, cs.served = state, false
+		}
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, c)
+	}
+	t.mu.Unlock()
+	if state == http.StateClosed && t.slow != nil && t.timedOut(cs) {
+		t.slow(c)
+	}
+}
+
+// timedOut reports whether a connection closed in the state cs was
+// waiting for the headers of a request until the server gave up on
+// them. Idle connections closed after the IdleTimeout were not.
+func (t *tracker) timedOut(cs *connState) bool {
+	if cs == nil || t.headerTimeout <= 0 {
+		return false
+	}
+	waiting := cs.state == http.StateNew || cs.state == http.StateActive && !cs.served
+	return waiting && time.Since(cs.waiting) >= t.headerTimeout
+}
+
+// open returns the number of connections that are serving a request,
+// or about to, and of those that are idle.
+func (t *tracker) open() (active, idle int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, cs := range t.conns {
+		if cs.state == http.StateIdle {
+			idle++
+		} else {
+			active++
+		}
+	}
+	return active, idle
+}
+
+// Module provides the HTTP server and starts it with the application,
+// with the TLS settings of NewTLSConfig. The application provides the
+// http.Handler it serves.
+// サーバーを提供して起動する
+var Module = fx.Module("httpfx",
+	fx.Provide(NewServer, NewTLSConfig),
+	fx.Invoke(func(*http.Server) {}), // インスタンス化する
+)
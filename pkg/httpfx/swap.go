@@ -0,0 +1,95 @@
+package httpfx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainPoll is how often Swap checks whether the previous handler has
+// finished its requests.
+const drainPoll = 10 * time.Millisecond
+
+// generation is a handler along with the requests it is serving.
+type generation struct {
+	handler  http.Handler
+	inflight atomic.Int64
+}
+
+// Swapper serves requests with a handler that can be replaced while the
+// server runs, such as a mux rebuilt after plugins or templates were
+// reloaded. Each request is served entirely by the handler that was
+// current when it arrived.
+// 実行中にハンドラを入れ替える
+type Swapper struct {
+	build   func() (http.Handler, error)
+	mu      sync.Mutex // serializes swaps
+	current atomic.Pointer[generation]
+}
+
+// NewSwapper returns a Swapper serving the handler build returns, and
+// that Reload replaces with the next one build returns.
+func NewSwapper(build func() (http.Handler, error)) (*Swapper, error) {
+	h, err := build()
+	if err != nil {
+		return nil, err
+	}
+	s := &Swapper{build: build}
+	s.current.Store(&generation{handler: h})
+	return s, nil
+}
+
+func (s *Swapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for {
+		g := s.current.Load()
+		g.inflight.Add(1)
+		if s.current.Load() != g {
+			// Swapped in the meantime: the old handler may already
+			// have been released.
+			g.inflight.Add(-1)
+			continue
+		}
+		defer g.inflight.Add(-1)
+		g.handler.ServeHTTP(w, r)
+		return
+	}
+}
+
+// Reload builds a new handler and swaps it in; see Swap. If the build
+// fails, the current handler stays in place.
+func (s *Swapper) Reload(ctx context.Context) error {
+	h, err := s.build()
+	if err != nil {
+		return err
+	}
+	return s.Swap(ctx, h)
+}
+
+// Swap makes h serve all new requests, then waits for the requests
+// still being served by the previous handler to finish and releases it,
+// closing it if it is an io.Closer. If ctx is done first, Swap returns
+// an error and leaves the previous handler unreleased; the swap itself
+// is not undone.
+func (s *Swapper) Swap(ctx context.Context, h http.Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.current.Swap(&generation{handler: h})
+
+	ticker := time.NewTicker(drainPoll)
+	defer ticker.Stop()
+	for old.inflight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("httpfx: %d requests still served by the previous handler: %w", old.inflight.Load(), ctx.Err())
+		}
+	}
+	if c, ok := old.handler.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package httpfx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// TLSConfig configures HTTPS. The server serves plaintext unless both
+// the certificate and the key are set.
+type TLSConfig struct {
+	// CertFile and KeyFile are PEM files; the certificate file may hold
+	// the intermediates after the leaf.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// RedirectAddr, if set, is the address of a plaintext listener that
+	// redirects every request to HTTPS, typically ":80".
+	RedirectAddr string `yaml:"redirect_addr"`
+}
+
+// Enabled reports whether a certificate is configured.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// DefaultTLS returns the TLS settings the server uses: TLS 1.2 at
+// least, and for TLS 1.2 only ECDHE key exchanges with AEAD ciphers.
+// TLS 1.3's suites are not configurable and are all sound.
+// TLSの既定値
+func DefaultTLS() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+}
+
+// NewTLSConfig returns DefaultTLS with the configured certificate, or
+// nil if there is none. Applications that need other settings decorate
+// the *tls.Config:
+//
+//	fx.Decorate(func(c *tls.Config) *tls.Config { ... })
+func NewTLSConfig(cfg Config) (*tls.Config, error) {
+	if !cfg.TLS.Enabled() {
+		return nil, nil
+	}
+	if cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "" {
+		return nil, errors.New("httpfx: tls needs both cert_file and key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("httpfx: loading TLS certificate: %w", err)
+	}
+	c := DefaultTLS()
+	c.Certificates = []tls.Certificate{cert}
+	return c, nil
+}
+
+// redirectServer builds the plaintext server that sends clients to the
+// HTTPS server listening on addr.
+func redirectServer(redirectAddr, addr string) *http.Server {
+	_, port, _ := net.SplitHostPort(addr)
+	return &http.Server{
+		Addr:              redirectAddr,
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if host == "" {
+				http.Error(w, "missing host", http.StatusBadRequest)
+				return
+			}
+			if port != "" && port != "443" {
+				host = net.JoinHostPort(host, port)
+			}
+			// GET/HEAD以外もメソッドと本文を保ったまま移す
+			status := http.StatusPermanentRedirect
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				status = http.StatusMovedPermanently
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+		}),
+	}
+}
+
+// serveRedirects runs srv with the application; it has nothing in
+// progress worth waiting for when stopping.
+func serveRedirects(lc fx.Lifecycle, srv *http.Server, log *zap.Logger) {
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			log.Info("Redirecting HTTP to HTTPS", zap.String("addr", srv.Addr))
+			go srv.Serve(ln)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			return srv.Close()
+		},
+	})
+}
@@ -0,0 +1,194 @@
+// Package ratelimit refuses requests beyond a steady rate with 429 Too
+// Many Requests. Each client IP has a token bucket of its own, or all
+// clients share one, as the Config says. The client IP is the one
+// clientip finds past the trusted proxies, so that clients behind a
+// proxy do not share its bucket.
+package ratelimit
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/pkg/clock"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+// Modes of the limiter.
+const (
+	ModeOff    = ""       // no limit
+	ModeIP     = "ip"     // a bucket per client IP
+	ModeGlobal = "global" // one bucket for every client
+)
+
+// Config sets the limit.
+type Config struct {
+	// Mode is ip, global, or empty for no limit.
+	Mode string `yaml:"mode"`
+	// Rate is how many requests a second are allowed in the long run.
+	Rate float64 `yaml:"rate"`
+	// Burst is how many requests are allowed at once after a quiet spell.
+	Burst int `yaml:"burst"`
+	// Exempt are path prefixes never limited, such as the probes of
+	// load balancers.
+	Exempt []string `yaml:"exempt"`
+}
+
+// DefaultConfig limits nothing. Once a mode is set, each bucket allows
+// 10 requests a second with bursts of 20; the probes and metrics are
+// exempt.
+func DefaultConfig() Config {
+	return Config{Rate: 10, Burst: 20, Exempt: []string{"/healthz", "/readyz", "/metrics"}}
+}
+
+// sweepEvery is how often the buckets that have refilled are dropped.
+const sweepEvery = time.Minute
+
+var rejected = expvar.NewInt("ratelimit_rejected")
+
+// Limiter is a set of token buckets, one per key.
+// トークンバケットによる流量制限
+type Limiter struct {
+	clock clock.Clock
+
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time // when tokens was last brought up to date
+}
+
+// NewLimiter builds the Limiter of cfg. It is provided on its own so
+// that its limit can be changed while the application runs.
+func NewLimiter(cfg Config) *Limiter {
+	return newLimiter(cfg, clock.Real)
+}
+
+func newLimiter(cfg Config, clk clock.Clock) *Limiter {
+	l := &Limiter{clock: clk, buckets: make(map[string]*bucket), lastSweep: clk.Now()}
+	l.SetLimit(cfg.Rate, cfg.Burst)
+	return l
+}
+
+// SetLimit changes the rate and burst of every bucket, keeping the
+// tokens they hold up to the new burst.
+func (l *Limiter) SetLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.burst = rate, float64(max(burst, 1))
+	for _, b := range l.buckets {
+		b.tokens = min(b.tokens, l.burst)
+	}
+}
+
+// Allow takes a token from the bucket of key. If there is none, it
+// returns false and how long until there will be.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := l.clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= sweepEvery {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.rate <= 0 {
+		return false, time.Hour // 補充されない
+	}
+	// 切り捨てると言われた時間だけ待っても足りない
+	return false, time.Duration(math.Ceil((1 - b.tokens) / l.rate * float64(time.Second)))
+}
+
+// sweep forgets the buckets that have refilled, which are no different
+// from new ones.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Middleware limits the requests that reach the routes.
+type Middleware struct {
+	cfg     Config
+	limiter *Limiter
+	log     *zap.Logger
+}
+
+// New builds the Middleware of cfg, taking its tokens from limiter.
+func New(cfg Config, limiter *Limiter, log *zap.Logger) (*Middleware, error) {
+	switch cfg.Mode {
+	case ModeOff, ModeIP, ModeGlobal:
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown mode %q", cfg.Mode)
+	}
+	if cfg.Mode != ModeOff && (cfg.Rate <= 0 || cfg.Burst < 1) {
+		return nil, fmt.Errorf("ratelimit: rate and burst must be positive, got %g and %d", cfg.Rate, cfg.Burst)
+	}
+	return &Middleware{cfg: cfg, limiter: limiter, log: log}, nil
+}
+
+// Name names the middleware in traces.
+func (*Middleware) Name() string {
+	return "ratelimit"
+}
+
+// Wrap returns a handler refusing the requests over the limit.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	if m.cfg.Mode == ModeOff {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.exempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := ""
+		if m.cfg.Mode == ModeIP {
+			key = clientip.FromRequest(r)
+		}
+		ok, wait := m.limiter.Allow(key)
+		if !ok {
+			rejected.Add(1)
+			telemetry.Logger(r.Context(), m.log).Debug("Rate limited request", zap.String("client", clientip.FromRequest(r)), zap.Duration("retry_after", wait))
+			// 秒単位に切り上げる
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			apperror.Write(w, r, nil, apperror.New(http.StatusTooManyRequests, "rate_limited", "Too many requests; retry later"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middleware) exempt(path string) bool {
+	for _, p := range m.cfg.Exempt {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package ratelimit
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"example.com/fxdemo/pkg/clock/clocktest"
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// TestLimiterSimulation takes tokens from buckets on a fake clock,
+// checking what is allowed and how long the rest are told to wait.
+func TestLimiterSimulation(t *testing.T) {
+	fake := clocktest.New(epoch)
+	l := newLimiter(Config{Rate: 2, Burst: 3}, fake)
+	steps := []struct {
+		advance time.Duration
+		key     string
+		ok      bool
+		wait    time.Duration
+	}{
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+		{0, "b", true, 0}, // バケットはキーごと
+		{499 * time.Millisecond, "a", false, time.Millisecond},
+		{time.Millisecond, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+		{10 * time.Second, "a", true, 0}, // 満杯まで補充され、それ以上は貯まらない
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+	}
+	for i, step := range steps {
+		fake.Advance(step.advance)
+		ok, wait := l.Allow(step.key)
+		// 浮動小数点の誤差は許す
+		if ok != step.ok || (wait-step.wait).Abs() > time.Microsecond {
+			t.Errorf("step %d: Allow(%s) at %v = %v, %v, want %v, %v",
+				i, step.key, fake.Now().Sub(epoch), ok, wait, step.ok, step.wait)
+		}
+	}
+
+	// 満杯に戻ったバケットは次の掃除で捨てられる
+	fake.Advance(sweepEvery)
+	l.Allow("c")
+	if len(l.buckets) != 1 || l.buckets["c"] == nil {
+		t.Errorf("buckets after a sweep = %v, want only c's", l.buckets)
+	}
+}
+
+// TestRetryAfter simulates a client that sends requests as fast as it
+// can, waiting each time for as long as Retry-After tells it to. Every
+// retry succeeds, and the client gets the limit's rate in the long run.
+func TestRetryAfter(t *testing.T) {
+	fake := clocktest.New(epoch)
+	cfg := Config{Mode: ModeGlobal, Rate: 0.4, Burst: 5}
+	m, err := New(cfg, newLimiter(cfg, fake), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Wrap(httpfxtest.Noop)
+	served, refused := 0, false
+	for range 200 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httpfxtest.NewRequest(httpfxtest.Path))
+		if rec.Code == http.StatusOK {
+			served, refused = served+1, false
+			continue
+		}
+		if refused {
+			t.Fatalf("refused at %v after waiting as told", fake.Now().Sub(epoch))
+		}
+		refused = true
+		retry, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+		if rec.Code != http.StatusTooManyRequests || err != nil || retry < 1 {
+			t.Fatalf("status %d with Retry-After %q, want a 429 with a wait", rec.Code, rec.Header().Get("Retry-After"))
+		}
+		fake.Advance(time.Duration(retry) * time.Second)
+	}
+	elapsed := fake.Now().Sub(epoch).Seconds()
+	if want := float64(cfg.Burst) + elapsed*cfg.Rate; float64(served) > want || float64(served) < want-1 {
+		t.Errorf("served %d requests in %gs, want the burst and %g a second", served, elapsed, cfg.Rate)
+	}
+}
+
+// scenario is a limit and the requests made against it: after each
+// advance of the clock, a request with one of a few keys.
+type scenario struct {
+	Rate  float64
+	Burst int
+	Steps []step
+}
+
+type step struct {
+	Advance time.Duration
+	Key     string
+}
+
+// Generate makes scenarios whose requests are sometimes far apart and
+// often bunched together, around the pace the limit allows.
+func (scenario) Generate(r *rand.Rand, size int) reflect.Value {
+	s := scenario{Rate: 0.1 + r.Float64()*100, Burst: 1 + r.Intn(20)}
+	pace := time.Duration(float64(time.Second) / s.Rate)
+	for range 1 + r.Intn(size) {
+		var advance time.Duration
+		switch r.Intn(4) {
+		case 0: // 同時
+		case 1:
+			advance = time.Duration(r.Int63n(int64(pace)))
+		case 2:
+			advance = time.Duration(r.Int63n(int64(3 * pace)))
+		case 3:
+			advance = time.Duration(r.Int63n(int64(time.Minute)))
+		}
+		s.Steps = append(s.Steps, step{advance, string(rune('a' + r.Intn(3)))})
+	}
+	return reflect.ValueOf(s)
+}
+
+// TestNeverExceedsBurst checks that in any span of time, a bucket allows
+// at most its burst and the tokens the rate brings meanwhile, and that a
+// request refused is allowed once it has waited as long as it was told.
+func TestNeverExceedsBurst(t *testing.T) {
+	property := func(s scenario) bool {
+		fake := clocktest.New(epoch)
+		l := newLimiter(Config{Rate: s.Rate, Burst: s.Burst}, fake)
+		allowed := map[string][]time.Time{}
+		for _, step := range s.Steps {
+			fake.Advance(step.Advance)
+			ok, wait := l.Allow(step.Key)
+			if !ok {
+				if wait <= 0 {
+					t.Logf("refused without a wait at %v", fake.Now().Sub(epoch))
+					return false
+				}
+				// 言われた時間だけ待てば通る。待つのは複製したバケットで
+				later := clocktest.New(fake.Now())
+				check := newLimiter(Config{Rate: s.Rate, Burst: s.Burst}, later)
+				check.buckets[step.Key] = &bucket{tokens: l.buckets[step.Key].tokens, last: later.Now()}
+				later.Advance(wait)
+				if ok, _ := check.Allow(step.Key); !ok {
+					t.Logf("refused again after waiting %v as told", wait)
+					return false
+				}
+				continue
+			}
+			times := append(allowed[step.Key], fake.Now())
+			allowed[step.Key] = times
+			last := times[len(times)-1]
+			for i, first := range times {
+				limit := float64(s.Burst) + last.Sub(first).Seconds()*s.Rate
+				if n := len(times) - i; float64(n) > limit+1e-9 {
+					t.Logf("%s: %d requests allowed in %v, over %g", step.Key, n, last.Sub(first), limit)
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestBurstUnderContention checks that requests racing for a bucket at
+// the same instant are allowed no more than its burst, or the burst it
+// is changed to meanwhile.
+func TestBurstUnderContention(t *testing.T) {
+	property := func(burst, changed uint8) bool {
+		b, c := 1+int(burst%50), 1+int(changed%50)
+		l := newLimiter(Config{Rate: 1, Burst: b}, clocktest.New(epoch))
+		var allowed atomic.Int32
+		var wg sync.WaitGroup
+		for i := range 4 * b {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if i == b { // 途中で上限を変える
+					l.SetLimit(1, c)
+				}
+				if ok, _ := l.Allow("a"); ok {
+					allowed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := int(allowed.Load()); n > max(b, c) || n < min(b, c) {
+			t.Logf("burst %d changed to %d: %d allowed", b, c, n)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkWrap(b *testing.B) {
+	cfg := DefaultConfig()
+	cfg.Mode, cfg.Rate, cfg.Burst = ModeIP, math.MaxInt32, math.MaxInt32 // 計測中に制限しない
+	m, err := New(cfg, NewLimiter(cfg), zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	httpfxtest.Benchmark(b, m.Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,114 @@
+// Package region makes an instance aware of the region it runs in: the
+// region is added to every log entry, published as a metric and reported
+// in a response header. Tenants can be pinned to a region, in which case
+// their requests are proxied to it from any other region.
+package region
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/deadline"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+// Headers read and written by the Router.
+const (
+	Header          = "X-Region"           // the region that served the response
+	ForwardedHeader = "X-Forwarded-Region" // set on requests proxied from another region
+)
+
+// Config places the instance in a region and says where tenants must be
+// served.
+type Config struct {
+	Name string `yaml:"name"` // Name is the region of this instance; empty if it is not region aware.
+	// Pins maps tenants to the region they must be served from.
+	Pins map[string]string `yaml:"pins"`
+	// Peers maps the other regions to the base URL requests are proxied to.
+	Peers map[string]string `yaml:"peers"`
+	// Timeout bounds a proxied request; the request's deadline still applies.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// DefaultConfig is not region aware.
+func DefaultConfig() Config {
+	return Config{Timeout: 30 * time.Second}
+}
+
+var (
+	current = expvar.NewString("region")
+	proxied = expvar.NewMap("region_proxied_requests") // by destination region
+)
+
+// Logger adds the region to every entry of log. It is meant for
+// fx.Decorate.
+func Logger(cfg Config, log *zap.Logger) *zap.Logger {
+	if cfg.Name == "" {
+		return log
+	}
+	return log.With(zap.String("region", cfg.Name))
+}
+
+// Router serves requests for tenants pinned to another region by
+// proxying them there.
+// テナントを指定リージョンに固定する
+type Router struct {
+	cfg     Config
+	log     *zap.Logger
+	proxies map[string]*httputil.ReverseProxy
+}
+
+// NewRouter builds a Router. Every region a tenant is pinned to must be
+// this one or have a peer.
+func NewRouter(cfg Config, log *zap.Logger) (*Router, error) {
+	current.Set(cfg.Name)
+	rt := &Router{cfg: cfg, log: log, proxies: make(map[string]*httputil.ReverseProxy)}
+	for name, base := range cfg.Peers {
+		u, err := url.Parse(base)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("region: peer %s has an invalid URL %q", name, base)
+		}
+		name := name
+		p := httputil.NewSingleHostReverseProxy(u)
+		p.Transport = &deadline.Transport{Limit: cfg.Timeout}
+		p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			telemetry.Logger(r.Context(), log).Error("Failed to proxy request", zap.String("to", name), zap.Error(err))
+			apperror.Write(w, r, nil, apperror.New(http.StatusBadGateway, "bad_gateway", "The upstream server could not be reached"))
+		}
+		rt.proxies[name] = p
+	}
+	for tenant, name := range cfg.Pins {
+		if _, ok := rt.proxies[name]; !ok && name != cfg.Name {
+			return nil, fmt.Errorf("region: tenant %s is pinned to %s, which has no peer", tenant, name)
+		}
+	}
+	return rt, nil
+}
+
+// Wrap returns a handler that reports the region in the response, and
+// proxies requests for tenants pinned elsewhere. A request that has
+// already been proxied once is served where it lands, so that
+// misconfigured pins cannot make requests loop between regions.
+func (rt *Router) Wrap(next http.Handler) http.Handler {
+	if rt.cfg.Name == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := telemetry.From(r.Context()).Tenant
+		pinned, ok := rt.cfg.Pins[tenant]
+		if !ok || pinned == rt.cfg.Name || r.Header.Get(ForwardedHeader) != "" {
+			w.Header().Set(Header, rt.cfg.Name)
+			next.ServeHTTP(w, r)
+			return
+		}
+		proxied.Add(pinned, 1)
+		r.Header.Set(ForwardedHeader, rt.cfg.Name)
+		rt.proxies[pinned].ServeHTTP(w, r)
+	})
+}
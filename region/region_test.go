@@ -0,0 +1,16 @@
+package region
+
+import (
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	rt, err := NewRouter(DefaultConfig(), zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	httpfxtest.Benchmark(b, rt.Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,265 @@
+// Package rewrite redirects requests, or rewrites their path before they
+// are routed, according to regular expression rules loaded from a file.
+// The rules file is reloaded when it changes.
+package rewrite
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Config controls where rules are loaded from.
+type Config struct {
+	RulesFile string `yaml:"rules_file"`
+	// ReloadInterval is how often the file is checked for changes; zero
+	// means it is only read again by Reload and Load.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+// DefaultConfig returns a Config without any rules file.
+func DefaultConfig() Config {
+	return Config{ReloadInterval: 5 * time.Second}
+}
+
+// Rule is a single rule as written in the rules file.
+//
+//	{"name": "docs", "pattern": "^/docs/(.*)$", "replacement": "https://docs.example.com/$1", "action": "redirect", "status": 301}
+//	{"name": "v0", "pattern": "^/api/v0/(.*)$", "replacement": "/api/v1/$1", "action": "rewrite"}
+//
+// The pattern is matched against the request path, and the replacement
+// may refer to its submatches as in regexp.Regexp.Expand. The query of
+// the request is kept, unless a redirect's replacement has its own.
+type Rule struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+	Action      string `json:"action"`           // redirect or rewrite
+	Status      int    `json:"status,omitempty"` // Status of a redirect; 302 if zero.
+}
+
+// rule is a Rule ready to be evaluated.
+type rule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// ruleSet is an immutable, compiled set of rules.
+type ruleSet struct {
+	rules   []*rule
+	file    string // the file the rules were loaded from
+	modTime time.Time
+}
+
+// matches counts the requests each rule applied to.
+var matches = expvar.NewMap("rewrite_matches")
+
+// Engine applies the first rule that matches each request.
+// リダイレクトとパスの書き換え
+type Engine struct {
+	cfg   Config
+	log   *zap.Logger
+	rules atomic.Pointer[ruleSet]
+	mu    sync.Mutex // serializes loads
+}
+
+// NewEngine loads the rules file and keeps it up to date for as long
+// as the Fx application runs.
+func NewEngine(lc fx.Lifecycle, cfg Config, log *zap.Logger) (*Engine, error) {
+	e := &Engine{cfg: cfg, log: log}
+	if err := e.Load(cfg.RulesFile); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadInterval <= 0 {
+		return e, nil
+	}
+
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go e.watch(done)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			close(done)
+			return nil
+		},
+	})
+	return e, nil
+}
+
+// Reload reads the rules file again. The previous rules stay in
+// effect if the new file cannot be loaded.
+func (e *Engine) Reload() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.load(e.rules.Load().file)
+}
+
+// Load switches to the rules file at path, which is watched for changes
+// from then on; an empty path means no rules. The previous rules, and
+// their file, stay in effect if the new file cannot be loaded.
+func (e *Engine) Load(path string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.load(path)
+}
+
+// RulesFile returns the path of the rules file in effect.
+func (e *Engine) RulesFile() string {
+	return e.rules.Load().file
+}
+
+// load loads the rules file at path. e.mu must be held.
+func (e *Engine) load(path string) error {
+	set, err := loadRules(path)
+	if err != nil {
+		return err
+	}
+	e.rules.Store(set)
+	if path != "" {
+		e.log.Info("Loaded rewrite rules", zap.String("file", path), zap.Int("rules", len(set.rules)))
+	}
+	return nil
+}
+
+// watch reloads the rules whenever the file's modification time changes.
+func (e *Engine) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(e.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			set := e.rules.Load()
+			if set.file == "" {
+				continue
+			}
+			info, err := os.Stat(set.file)
+			if err != nil || info.ModTime().Equal(set.modTime) {
+				continue
+			}
+			if err := e.Reload(); err != nil {
+				e.log.Error("Failed to reload rewrite rules", zap.Error(err))
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Wrap returns a handler that redirects the requests a redirect rule
+// matches, and passes the others to next, with their path rewritten if
+// a rewrite rule matches.
+func (e *Engine) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl, target := e.rules.Load().apply(r.URL.Path)
+		if rl == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		matches.Add(rl.Name, 1)
+		log := telemetry.Logger(r.Context(), e.log).With(zap.String("rule", rl.Name), zap.String("path", r.URL.Path))
+
+		if rl.Action == "redirect" {
+			if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+				target += "?" + r.URL.RawQuery
+			}
+			log.Debug("Redirecting request", zap.String("to", target))
+			http.Redirect(w, r, target, rl.status())
+			return
+		}
+		log.Debug("Rewriting request path", zap.String("to", target))
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path, r2.URL.RawPath = target, ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// apply returns the first rule matching path and what it maps path to,
+// or a nil rule if none matches.
+func (s *ruleSet) apply(path string) (*rule, string) {
+	for _, rl := range s.rules {
+		m := rl.re.FindStringSubmatchIndex(path)
+		if m == nil {
+			continue
+		}
+		return rl, string(rl.re.ExpandString(nil, rl.Replacement, path, m))
+	}
+	return nil, ""
+}
+
+func (r *rule) status() int {
+	if r.Status == 0 {
+		return http.StatusFound
+	}
+	return r.Status
+}
+
+// loadRules reads and compiles the rules file. A missing file
+// yields an empty rule set.
+func loadRules(path string) (*ruleSet, error) {
+	if path == "" {
+		return &ruleSet{}, nil
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return &ruleSet{file: path}, nil // 作られたら読み込む
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw []Rule
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("rewrite: parse %s: %w", path, err)
+	}
+
+	set := &ruleSet{file: path, modTime: info.ModTime()}
+	for _, r := range raw {
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite: rule %q: %w", r.Name, err)
+		}
+		set.rules = append(set.rules, compiled)
+	}
+	return set, nil
+}
+
+func compile(r Rule) (*rule, error) {
+	switch r.Action {
+	case "redirect":
+		if r.Status != 0 && (r.Status < 300 || r.Status > 399) {
+			return nil, fmt.Errorf("status %d is not a redirection", r.Status)
+		}
+	case "rewrite":
+		if !strings.HasPrefix(r.Replacement, "/") {
+			return nil, fmt.Errorf("a rewrite must yield a path starting with a slash")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q", r.Action)
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &rule{Rule: r, re: re}, nil
+}
@@ -0,0 +1,173 @@
+package rewrite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+const testRules = `[
+	{"name": "docs", "pattern": "^/docs/(.*)$", "replacement": "https://docs.example.com/$1", "action": "redirect", "status": 301},
+	{"name": "search", "pattern": "^/find$", "replacement": "/search?q=all", "action": "redirect"},
+	{"name": "v0", "pattern": "^/api/v0/(?P<rest>.*)$", "replacement": "/api/v1/${rest}", "action": "rewrite"},
+	{"name": "shadowed", "pattern": "^/api/v0/users$", "replacement": "/never", "action": "rewrite"},
+	{"name": "legacy", "pattern": "^/old/([a-z]+)/([0-9]+)$", "replacement": "/new/$2/$1", "action": "rewrite"}
+]`
+
+// writeRules writes a rules file to a new temporary directory and
+// returns its path.
+func writeRules(t *testing.T, rules string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestEngine(t *testing.T, path string) *Engine {
+	t.Helper()
+	e, err := NewEngine(fxtest.NewLifecycle(t), Config{RulesFile: path, ReloadInterval: time.Millisecond}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// seen answers with the path and query it was given.
+var seen = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(r.URL.RequestURI()))
+})
+
+func TestWrap(t *testing.T) {
+	h := newTestEngine(t, writeRules(t, testRules)).Wrap(seen)
+	tests := []struct {
+		name     string
+		target   string
+		status   int
+		location string // for redirects
+		path     string // what next saw, otherwise
+	}{
+		{"no match", "/api/v1/users?page=2", 200, "", "/api/v1/users?page=2"},
+		{"permanent redirect", "/docs/guide/intro", 301, "https://docs.example.com/guide/intro", ""},
+		{"redirect keeps the query", "/docs/a?lang=ja", 301, "https://docs.example.com/a?lang=ja", ""},
+		{"redirect with a query of its own", "/find?q=mine", 302, "/search?q=all", ""},
+		{"rewrite with a named submatch", "/api/v0/users?page=2", 200, "", "/api/v1/users?page=2"},
+		{"first match wins", "/api/v0/users", 200, "", "/api/v1/users"},
+		{"submatches reordered", "/old/item/42", 200, "", "/new/42/item"},
+		{"anchored pattern", "/x/old/item/42", 200, "", "/x/old/item/42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+			if tt.path != "" && rec.Body.String() != tt.path {
+				t.Errorf("next saw %q, want %q", rec.Body.String(), tt.path)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string // empty if the rules are valid
+	}{
+		{"valid", testRules, ""},
+		{"empty", `[]`, ""},
+		{"not JSON", `[{"name":`, "parse"},
+		{"unknown action", `[{"name": "a", "pattern": "^/a$", "replacement": "/b", "action": "proxy"}]`, `unknown action "proxy"`},
+		{"redirect status out of range", `[{"name": "a", "pattern": "^/a$", "replacement": "/b", "action": "redirect", "status": 200}]`, "not a redirection"},
+		{"rewrite to a URL", `[{"name": "a", "pattern": "^/a$", "replacement": "https://b/", "action": "rewrite"}]`, "starting with a slash"},
+		{"invalid pattern", `[{"name": "a", "pattern": "^/(a$", "replacement": "/b", "action": "rewrite"}]`, "missing closing )"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadRules(writeRules(t, tt.rules))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("loadRules() error = %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("loadRules() error = %v, want one mentioning %q", err, tt.err)
+			}
+		})
+	}
+	if set, err := loadRules(filepath.Join(t.TempDir(), "missing.json")); err != nil || len(set.rules) != 0 {
+		t.Errorf("loadRules(missing) = %v, %v, want no rules", set, err)
+	}
+}
+
+// TestReload checks that the rules file is picked up when it changes,
+// and that the previous rules stay in effect if it becomes invalid.
+func TestReload(t *testing.T) {
+	path := writeRules(t, `[{"name": "a", "pattern": "^/a$", "replacement": "/one", "action": "rewrite"}]`)
+	lc := fxtest.NewLifecycle(t)
+	e, err := NewEngine(lc, Config{RulesFile: path, ReloadInterval: time.Millisecond}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc.RequireStart()
+	defer lc.RequireStop()
+	h := e.Wrap(seen)
+	get := func() string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/a", nil))
+		return rec.Body.String()
+	}
+	// update replaces the file, with a modification time of its own so
+	// that the change is noticed however coarse the file system's clock.
+	mtime := time.Now()
+	update := func(rules string) {
+		mtime = mtime.Add(time.Second)
+		if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); get() != want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("GET /a: next saw %q, want %q", get(), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	waitFor("/one")
+	update(`[{"name": "a", "pattern": "^/a$", "replacement": "/two", "action": "rewrite"}]`)
+	waitFor("/two")
+
+	update(`[{"name": "a", "pattern": "^/(a$", "replacement": "/three", "action": "rewrite"}]`)
+	if err := e.Reload(); err == nil {
+		t.Fatal("Reload() of an invalid file succeeded")
+	}
+	if got := get(); got != "/two" {
+		t.Errorf("after an invalid file: next saw %q, want the previous rules kept", got)
+	}
+}
+
+func BenchmarkWrap(b *testing.B) {
+	e, err := NewEngine(fxtest.NewLifecycle(b), DefaultConfig(), zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	httpfxtest.Benchmark(b, e.Wrap(httpfxtest.Noop))
+}
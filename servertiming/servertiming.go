@@ -0,0 +1,114 @@
+// Package servertiming collects how long the phases of a request took —
+// database calls, cache lookups, rendering — so that they can be reported
+// to the client in a Server-Timing header (W3C Server Timing), letting
+// anyone see where latency went without access to the traces.
+//
+// Handlers time a phase with
+//
+//	defer servertiming.Start(ctx, "db")()
+//
+// and the time is added to the entry of that name. Only phases finished
+// before the response headers are sent make it into the header.
+package servertiming
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header is the name of the header the timings are reported in.
+const Header = "Server-Timing"
+
+// Metric is the time spent in one phase of the request.
+type Metric struct {
+	Name  string
+	Dur   time.Duration
+	Count int // how many times the phase ran
+}
+
+// Timings collects the metrics of a request. A nil *Timings discards
+// what is recorded in it.
+type Timings struct {
+	mu      sync.Mutex
+	metrics []Metric
+}
+
+type timingsKey struct{}
+
+// With returns a copy of ctx that collects timings, and the Timings
+// they are collected in.
+func With(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// From returns the Timings collected for ctx, or nil if none are.
+func From(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
+
+// Start begins timing a phase of the request; the returned function
+// ends it.
+func Start(ctx context.Context, name string) func() {
+	t := From(ctx)
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { t.Add(name, time.Since(start)) }
+}
+
+// Add adds d to the metric called name.
+func (t *Timings) Add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.metrics {
+		if t.metrics[i].Name == name {
+			t.metrics[i].Dur += d
+			t.metrics[i].Count++
+			return
+		}
+	}
+	t.metrics = append(t.metrics, Metric{Name: name, Dur: d, Count: 1})
+}
+
+// Metrics returns the metrics collected so far, in the order their
+// phases first ran.
+func (t *Timings) Metrics() []Metric {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Metric(nil), t.metrics...)
+}
+
+// Format returns the metrics as the value of a Server-Timing header,
+// followed by a total of the given duration, e.g.
+// `db;dur=1.2;desc="2 calls", total;dur=3.4`.
+func Format(metrics []Metric, total time.Duration) string {
+	var b strings.Builder
+	for _, m := range metrics {
+		b.WriteString(m.Name)
+		b.WriteString(";dur=")
+		b.WriteString(ms(m.Dur))
+		if m.Count > 1 {
+			b.WriteString(`;desc="` + strconv.Itoa(m.Count) + ` calls"`)
+		}
+		b.WriteString(", ")
+	}
+	b.WriteString("total;dur=")
+	b.WriteString(ms(total))
+	return b.String()
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64)
+}
@@ -0,0 +1,122 @@
+// Package shape serves the old JSON shape of a resource to the clients
+// that have not yet been moved to its new shape, so that a schema change
+// can be rolled out gradually with a feature flag.
+package shape
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"example.com/fxdemo/flags"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+// Migration describes a change to the JSON objects a route responds with.
+type Migration struct {
+	// Flag names the feature flag; clients it is on for get the new shape.
+	Flag string
+	// Down turns an object of the new shape into the old one.
+	Down func(obj map[string]any) map[string]any
+}
+
+// Migrating is implemented by routes whose responses are changing shape.
+// Their handlers only produce the new shape.
+type Migrating interface {
+	Migration() Migration
+}
+
+// Translator translates responses back to their old shape.
+// レスポンスの形を旧形式に戻す
+type Translator struct {
+	flags *flags.Set
+	log   *zap.Logger
+}
+
+// NewTranslator builds a new Translator.
+func NewTranslator(set *flags.Set, log *zap.Logger) *Translator {
+	return &Translator{flags: set, log: log}
+}
+
+// Wrap returns next unchanged unless route is Migrating, in which case
+// the returned handler translates the successful JSON responses of next
+// for the clients the migration's flag is off for.
+func (t *Translator) Wrap(route, next http.Handler) http.Handler {
+	m, ok := route.(Migrating)
+	if !ok {
+		return next
+	}
+	migration := m.Migration()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.flags.Enabled(r, migration.Flag) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &recorder{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		body := rec.body.Bytes()
+		if rec.status/100 == 2 && isJSON(rec.header.Get("Content-Type")) {
+			if old, err := down(body, migration.Down); err != nil {
+				telemetry.Logger(r.Context(), t.log).Warn("Failed to translate response", zap.String("flag", migration.Flag), zap.Error(err))
+			} else {
+				body = old
+				rec.header.Set("Content-Length", strconv.Itoa(len(body)))
+			}
+		}
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.status)
+		w.Write(body)
+	})
+}
+
+// down applies f to the object, or each object of the array, in body.
+func down(body []byte, f func(map[string]any) map[string]any) ([]byte, error) {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		return marshal(f(v))
+	case []any:
+		for i, item := range v {
+			if obj, ok := item.(map[string]any); ok {
+				v[i] = f(obj)
+			}
+		}
+		return marshal(v)
+	default:
+		return body, nil
+	}
+}
+
+// marshal encodes v the way json.Encoder does, with a trailing newline.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// recorder is a minimal http.ResponseWriter that keeps the response
+// in memory until it has been translated.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header         { return r.header }
+func (r *recorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *recorder) WriteHeader(status int)      { r.status = status }
@@ -0,0 +1,215 @@
+// Package staticfiles serves a directory of files, or a file system
+// built into the binary, under a path prefix: the assets of a web page,
+// or a whole single-page application.
+//
+//	static_files:
+//	  dir: ./web/dist
+//	  prefix: /app/
+//	  spa: true
+package staticfiles
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Config says what is served, and where.
+type Config struct {
+	// Dir is the directory served. If the application provides a file
+	// system with WithFS, Dir is a directory within it instead, and may
+	// be empty to serve all of it. Nothing is served if Dir is empty and
+	// there is no such file system.
+	Dir string `yaml:"dir"`
+	// Prefix is the path the files are served under. It must start and
+	// end with a slash.
+	Prefix string `yaml:"prefix"`
+	// Index is the file served for a directory.
+	Index string `yaml:"index"`
+	// SPA serves the Index of Dir for the paths with no file and no
+	// extension, so that an application routing on the client can be
+	// loaded from any of its URLs. Missing assets, such as /app/x.js,
+	// are still not found.
+	SPA bool `yaml:"spa"`
+	// MaxAge is how long caches may keep a file without revalidating it.
+	// Index files are always revalidated, so that a new version of the
+	// application is picked up as soon as it is deployed.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// DefaultConfig serves nothing. Once a directory is set, it is served
+// under /static/ and its files cached for an hour.
+func DefaultConfig() Config {
+	return Config{Prefix: "/static/", Index: "index.html", MaxAge: time.Hour}
+}
+
+// fsName names the file system provided with WithFS.
+const fsName = "staticfiles"
+
+// WithFS serves fsys, such as an embed.FS, rather than a directory of
+// the host:
+//
+//	//go:embed web/dist
+//	var dist embed.FS
+//
+//	app.New(app.WithModules(staticfiles.WithFS(dist)))
+//
+// with dir: web/dist in the configuration.
+func WithFS(fsys fs.FS) fx.Option {
+	// Supplyでは具体的な型で登録されてしまう
+	return fx.Provide(fx.Annotated{Name: fsName, Target: func() fs.FS { return fsys }})
+}
+
+// Params are the inputs of NewRoutes.
+type Params struct {
+	fx.In
+
+	Config Config
+	FS     fs.FS `name:"staticfiles" optional:"true"`
+	Log    *zap.Logger
+}
+
+// Result is the output of NewRoutes.
+type Result struct {
+	fx.Out
+
+	Routes []httpfx.Route `group:"routes,flatten"`
+}
+
+// Handler serves the files of a file system.
+// 静的ファイルの配信
+type Handler struct {
+	cfg   Config
+	files fs.FS
+	log   *zap.Logger
+}
+
+// NewRoutes returns the route serving the configured files, or no route
+// if there are none.
+func NewRoutes(p Params) (Result, error) {
+	cfg := p.Config
+	files := p.FS
+	switch {
+	case files == nil && cfg.Dir == "":
+		return Result{}, nil
+	case files == nil:
+		info, err := os.Stat(cfg.Dir)
+		if err != nil {
+			return Result{}, fmt.Errorf("staticfiles: %w", err)
+		}
+		if !info.IsDir() {
+			return Result{}, fmt.Errorf("staticfiles: %s is not a directory", cfg.Dir)
+		}
+		files = os.DirFS(cfg.Dir)
+	case cfg.Dir != "":
+		sub, err := fs.Sub(files, path.Clean(cfg.Dir))
+		if err != nil {
+			return Result{}, fmt.Errorf("staticfiles: %w", err)
+		}
+		files = sub
+	}
+	if !strings.HasPrefix(cfg.Prefix, "/") || !strings.HasSuffix(cfg.Prefix, "/") {
+		return Result{}, fmt.Errorf("staticfiles: prefix %q must start and end with a slash", cfg.Prefix)
+	}
+	if cfg.Index == "" || strings.Contains(cfg.Index, "/") {
+		return Result{}, fmt.Errorf("staticfiles: index %q must be a file name", cfg.Index)
+	}
+	return Result{Routes: []httpfx.Route{&Handler{cfg: cfg, files: files, log: p.Log}}}, nil
+}
+
+// Pattern reports the path at which this is registered.
+func (h *Handler) Pattern() string {
+	return h.cfg.Prefix
+}
+
+// Methods reports the methods served: files can only be read.
+func (*Handler) Methods() []string {
+	return []string{http.MethodGet, http.MethodHead}
+}
+
+// NormalizationExempt reports that file names are left as they were
+// requested.
+func (*Handler) NormalizationExempt() bool {
+	return true
+}
+
+// ServeHTTP serves the file at the path below the prefix, the Index of
+// a directory, or the Index of the root for client-side routes.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.lookup(strings.TrimPrefix(r.URL.Path, h.cfg.Prefix))
+	if !ok {
+		apperror.Write(w, r, h.log, apperror.NotFound("No file at %s", r.URL.Path))
+		return
+	}
+	f, err := h.files.Open(name)
+	if err != nil {
+		apperror.Write(w, r, h.log, apperror.Internal(err))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		apperror.Write(w, r, h.log, apperror.Internal(err))
+		return
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		apperror.Write(w, r, h.log, apperror.Internal(fmt.Errorf("staticfiles: %s cannot seek", name)))
+		return
+	}
+	if path.Base(name) == h.cfg.Index || h.cfg.MaxAge <= 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(h.cfg.MaxAge.Seconds())))
+	}
+	// 種類は拡張子から、なければ中身から判定される
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// lookup returns the name of the file served for rel, the path below
+// the prefix. Directories are served their Index; there are no
+// listings, and no hidden files such as .env or .git.
+func (h *Handler) lookup(rel string) (string, bool) {
+	name := strings.TrimPrefix(path.Clean("/"+rel), "/")
+	if name == "" {
+		name = "."
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem != "." && strings.HasPrefix(elem, ".") {
+			return "", false
+		}
+	}
+	if info, err := fs.Stat(h.files, name); err == nil {
+		if !info.IsDir() {
+			return name, true
+		}
+		name = path.Join(name, h.cfg.Index)
+		if info, err := fs.Stat(h.files, name); err == nil && !info.IsDir() {
+			return name, true
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		h.log.Warn("Cannot read static file", zap.String("name", name), zap.Error(err))
+		return "", false
+	}
+	if h.cfg.SPA && path.Ext(rel) == "" {
+		if info, err := fs.Stat(h.files, h.cfg.Index); err == nil && !info.IsDir() {
+			return h.cfg.Index, true
+		}
+	}
+	return "", false
+}
+
+// Module provides the static files route, configured by a Config.
+var Module = fx.Module("staticfiles", fx.Provide(NewRoutes))
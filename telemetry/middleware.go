@@ -0,0 +1,72 @@
+package telemetry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"example.com/fxdemo/pkg/boundedvar"
+)
+
+// Headers read and written by the middleware.
+const (
+	RequestIDHeader = "X-Request-ID"
+	TenantHeader    = "X-Tenant-ID"
+)
+
+// maxTenants bounds the number of tenants counted individually in
+// the requests_by_tenant expvar map.
+const maxTenants = 200
+
+var requestsByTenant = boundedvar.New("requests_by_tenant", maxTenants)
+
+// validID limits the request IDs and tenants accepted from clients
+// to something safe to log and use as a label.
+var validID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// Middleware establishes the request ID and tenant of each request.
+type Middleware struct{}
+
+// NewMiddleware builds a new Middleware.
+func NewMiddleware() *Middleware {
+	return &Middleware{}
+}
+
+// Wrap returns a handler that takes the request ID from X-Request-ID, or
+// generates one, echoes it in the response and picks up the tenant
+// from X-Tenant-ID.
+//
+// The tenant header is taken at face value; it is meant for attribution,
+// not access control. Routes requiring authentication replace it with
+// the subject the client was authenticated as, which metering bills.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		tenant := r.Header.Get(TenantHeader)
+		if !validID.MatchString(tenant) {
+			tenant = ""
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		if tenant == "" {
+			requestsByTenant.Add("none")
+		} else {
+			requestsByTenant.Add(tenant)
+		}
+
+		ctx := Update(r.Context(), func(tc *Context) {
+			tc.RequestID = requestID
+			tc.Tenant = tenant
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
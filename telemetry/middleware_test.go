@@ -0,0 +1,11 @@
+package telemetry
+
+import (
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	httpfxtest.Benchmark(b, NewMiddleware().Wrap(httpfxtest.Noop))
+}
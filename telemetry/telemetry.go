@@ -0,0 +1,114 @@
+// Package telemetry keeps the identifiers that tie logs, traces and
+// metrics of a request together — the request ID, the trace ID and the
+// tenant — in one place, so that every signal carries the same values.
+package telemetry
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Context identifies the request a piece of telemetry belongs to.
+// ログ・トレース・メトリクスで共通に使う識別子
+type Context struct {
+	RequestID string
+	TraceID   string
+	Tenant    string
+
+	// DebugSubject is set when the request is in debug mode, naming
+	// who the debug token was issued for.
+	DebugSubject string
+
+	// Country and ASN locate the client, when GeoIP databases are set up.
+	Country string
+	ASN     uint32
+}
+
+type contextKey struct{}
+
+// From returns the telemetry Context of the request.
+func From(ctx context.Context) Context {
+	tc, _ := ctx.Value(contextKey{}).(Context)
+	return tc
+}
+
+// With returns a copy of ctx carrying tc.
+func With(ctx context.Context, tc Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, tc)
+}
+
+// Update returns a copy of ctx whose telemetry Context has been
+// changed by f.
+func Update(ctx context.Context, f func(*Context)) context.Context {
+	tc := From(ctx)
+	f(&tc)
+	return With(ctx, tc)
+}
+
+// Fields returns tc as log fields, leaving out empty values.
+func (tc Context) Fields() []zap.Field {
+	fields := make([]zap.Field, 0, 6)
+	if tc.RequestID != "" {
+		fields = append(fields, zap.String("request_id", tc.RequestID))
+	}
+	if tc.TraceID != "" {
+		fields = append(fields, zap.String("trace_id", tc.TraceID))
+	}
+	if tc.Tenant != "" {
+		fields = append(fields, zap.String("tenant", tc.Tenant))
+	}
+	if tc.DebugSubject != "" {
+		fields = append(fields, zap.String("debug_subject", tc.DebugSubject))
+	}
+	if tc.Country != "" {
+		fields = append(fields, zap.String("country", tc.Country))
+	}
+	if tc.ASN != 0 {
+		fields = append(fields, zap.Uint32("asn", tc.ASN))
+	}
+	return fields
+}
+
+// Exemplar returns the labels to attach as an exemplar to a metric
+// observed while serving the request, linking it back to the trace.
+func (tc Context) Exemplar() map[string]string {
+	ex := make(map[string]string, 2)
+	if tc.TraceID != "" {
+		ex["trace_id"] = tc.TraceID
+	}
+	if tc.RequestID != "" {
+		ex["request_id"] = tc.RequestID
+	}
+	return ex
+}
+
+// Logger returns log annotated with the request's telemetry Context.
+// For a request in debug mode the logger writes every level.
+// リクエスト用のロガーを取得する
+func Logger(ctx context.Context, log *zap.Logger) *zap.Logger {
+	tc := From(ctx)
+	if tc.DebugSubject != "" {
+		log = log.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return verbose{core}
+		}))
+	}
+	return log.With(tc.Fields()...)
+}
+
+// verbose is a zapcore.Core that accepts entries at every level,
+// bypassing the level check of the core it wraps.
+type verbose struct {
+	zapcore.Core
+}
+
+func (verbose) Enabled(zapcore.Level) bool { return true }
+
+func (v verbose) With(fields []zapcore.Field) zapcore.Core {
+	return verbose{v.Core.With(fields)}
+}
+
+func (v verbose) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, v)
+}
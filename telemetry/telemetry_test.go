@@ -0,0 +1,145 @@
+package telemetry_test
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/fxdemo/degrade"
+	"example.com/fxdemo/telemetry"
+	"example.com/fxdemo/tracing"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// signals is what a request left behind in each of the three signals.
+type signals struct {
+	header   string            // the X-Request-ID of the response
+	log      map[string]any    // the fields of the handler's log line
+	trace    map[string]any    // the fields of the trace's log line
+	spans    []any             // the spans of the trace
+	exemplar map[string]string // the exemplar of a metric observed by the handler
+}
+
+// serve sends r through the telemetry middleware and a tracer that
+// samples every request, to a handler that logs, starts a span and
+// observes a metric, and collects what each signal recorded.
+func serve(t *testing.T, r *http.Request) signals {
+	t.Helper()
+	core, logs := observer.New(zap.DebugLevel)
+	log := zap.New(core)
+	tracer := tracing.NewTracer(tracing.Config{SampleRate: 1}, log, degrade.NewRegistry(zap.NewNop()), nil)
+
+	var s signals
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), "handler")
+		defer span.End()
+		telemetry.Logger(ctx, log).Info("Handled")
+		s.exemplar = telemetry.From(ctx).Exemplar()
+	})
+	rec := httptest.NewRecorder()
+	telemetry.NewMiddleware().Wrap(tracer.Wrap(handler)).ServeHTTP(rec, r)
+
+	s.header = rec.Header().Get(telemetry.RequestIDHeader)
+	for _, e := range logs.All() {
+		switch e.Message {
+		case "Handled":
+			s.log = e.ContextMap()
+		case "Request trace":
+			s.trace = e.ContextMap()
+			s.spans, _ = s.trace["spans"].([]any)
+		}
+	}
+	if s.log == nil || s.trace == nil {
+		t.Fatalf("logs = %v, want the handler's and the trace's", logs.All())
+	}
+	return s
+}
+
+func TestLinkage(t *testing.T) {
+	r := httptest.NewRequest("GET", "/greetings", nil)
+	r.Header.Set(telemetry.RequestIDHeader, "req-1")
+	r.Header.Set(telemetry.TenantHeader, "acme")
+	s := serve(t, r)
+
+	if s.header != "req-1" {
+		t.Errorf("%s = %q, want the client's", telemetry.RequestIDHeader, s.header)
+	}
+	traceID, _ := s.log["trace_id"].(string)
+	if traceID == "" {
+		t.Fatalf("handler log = %v, want a trace_id", s.log)
+	}
+	want := map[string]string{"request_id": "req-1", "trace_id": traceID, "tenant": "acme"}
+	for k, v := range want {
+		if s.log[k] != v {
+			t.Errorf("handler log %s = %v, want %q", k, s.log[k], v)
+		}
+		if s.trace[k] != v {
+			t.Errorf("trace log %s = %v, want %q", k, s.trace[k], v)
+		}
+	}
+	if s.exemplar["trace_id"] != traceID || s.exemplar["request_id"] != "req-1" {
+		t.Errorf("exemplar = %v, want trace_id %s and request_id req-1", s.exemplar, traceID)
+	}
+
+	// 子スパンはルートの後に終わるので、ルートは最後に記録される
+	if len(s.spans) != 2 {
+		t.Fatalf("spans = %v, want the handler's and the root", s.spans)
+	}
+	root, _ := s.spans[1].(map[string]any)
+	if root["request_id"] != "req-1" || root["tenant"] != "acme" {
+		t.Errorf("root span = %v, want request_id req-1 and tenant acme", root)
+	}
+}
+
+func TestLinkageContinuesTrace(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := httptest.NewRequest("GET", "/greetings", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	s := serve(t, r)
+
+	if s.log["trace_id"] != traceID || s.trace["trace_id"] != traceID || s.exemplar["trace_id"] != traceID {
+		t.Errorf("trace IDs = %v, %v, %v, want the caller's %s",
+			s.log["trace_id"], s.trace["trace_id"], s.exemplar["trace_id"], traceID)
+	}
+}
+
+func TestLinkageGeneratesRequestID(t *testing.T) {
+	for _, header := range []string{"", "bad id", "x\nrequest_id=forged"} {
+		r := httptest.NewRequest("GET", "/greetings", nil)
+		if header != "" {
+			r.Header.Set(telemetry.RequestIDHeader, header)
+		}
+		r.Header.Set(telemetry.TenantHeader, "bad tenant")
+		s := serve(t, r)
+
+		if s.header == "" || s.header == header {
+			t.Errorf("%q: %s = %q, want a generated one", header, telemetry.RequestIDHeader, s.header)
+		}
+		if s.log["request_id"] != s.header || s.trace["request_id"] != s.header || s.exemplar["request_id"] != s.header {
+			t.Errorf("%q: request IDs = %v, %v, %v, want %s in every signal",
+				header, s.log["request_id"], s.trace["request_id"], s.exemplar["request_id"], s.header)
+		}
+		if _, ok := s.log["tenant"]; ok {
+			t.Errorf("%q: handler log = %v, want no tenant", header, s.log)
+		}
+	}
+}
+
+func TestRequestsByTenant(t *testing.T) {
+	count := func(tenant string) int64 {
+		m := expvar.Get("requests_by_tenant").(*expvar.Map)
+		if n, ok := m.Get(tenant).(*expvar.Int); ok {
+			return n.Value()
+		}
+		return 0
+	}
+	before := count("counted")
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(telemetry.TenantHeader, "counted")
+	serve(t, r)
+	if n := count("counted"); n != before+1 {
+		t.Errorf("requests_by_tenant[counted] = %d, want %d", n, before+1)
+	}
+}
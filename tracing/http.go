@@ -0,0 +1,163 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/fxdemo/degrade"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Config controls how many requests are traced.
+type Config struct {
+	// SampleRate is the fraction of requests traced when the caller
+	// has not already decided by sending a sampled traceparent.
+	SampleRate float64 `yaml:"sample_rate"`
+}
+
+// DefaultConfig traces one request in ten.
+func DefaultConfig() Config {
+	return Config{SampleRate: 0.1}
+}
+
+// Sampling is the degradable feature of tracing sampled requests.
+const Sampling = "tracing.sampling"
+
+// Tracer starts a trace for each sampled request.
+// リクエストごとにトレースを開始する
+type Tracer struct {
+	cfg      Config
+	log      *zap.Logger
+	degraded *degrade.Registry
+	exporter *Exporter
+}
+
+// NewTracer builds a new Tracer. The exporter may be nil.
+func NewTracer(cfg Config, log *zap.Logger, degraded *degrade.Registry, exporter *Exporter) *Tracer {
+	degraded.Register(Sampling, "only requests in debug mode are traced")
+	return &Tracer{cfg: cfg, log: log, degraded: degraded, exporter: exporter}
+}
+
+// Start begins a child of the current span, as the package's Start
+// does. It is there for handlers that take the Tracer as a dependency,
+// so that it is explicit that they are traced.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	return Start(ctx, name)
+}
+
+// Wrap returns a handler that starts the root span of the request,
+// continuing the caller's trace if it sent one, and logs the trace once
+// the request is done. Requests in debug mode are always traced, even
+// while Sampling is degraded.
+func (t *Tracer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc := telemetry.From(r.Context())
+		traceID, parentID, sampled := parseTraceparent(r.Header.Get("traceparent"))
+		if t.degraded.Degraded(Sampling) {
+			sampled = false
+		} else if !sampled {
+			sampled = rand.Float64() < t.cfg.SampleRate
+		}
+		sampled = sampled || tc.DebugSubject != ""
+		if traceID == "" {
+			traceID = newID(16)
+		}
+
+		root := &Span{
+			TraceID:  traceID,
+			SpanID:   newID(8),
+			ParentID: parentID,
+			Name:     r.Method + " " + r.URL.Path,
+			Start:    time.Now(),
+			root:     true,
+		}
+		if sampled {
+			root.rec = &recording{}
+			root.SetAttr("request_id", tc.RequestID)
+			if tc.Tenant != "" {
+				root.SetAttr("tenant", tc.Tenant)
+			}
+		}
+		ctx := context.WithValue(r.Context(), spanKey{}, root)
+		ctx = telemetry.Update(ctx, func(tc *telemetry.Context) { tc.TraceID = traceID })
+		next.ServeHTTP(w, r.WithContext(ctx))
+		if sampled {
+			root.End()
+			t.export(telemetry.Logger(ctx, t.log), root)
+		}
+	})
+}
+
+func (t *Tracer) export(log *zap.Logger, root *Span) {
+	root.rec.mu.Lock()
+	defer root.rec.mu.Unlock()
+	log.Debug("Request trace",
+		zap.Duration("duration", root.Duration),
+		zap.Array("spans", spanList{root: root, spans: root.rec.spans}),
+	)
+	t.exporter.Enqueue(root.rec.spans)
+}
+
+// spanList logs spans relative to the root of their trace.
+type spanList struct {
+	root  *Span
+	spans []*Span
+}
+
+func (l spanList) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, s := range l.spans {
+		s := s
+		err := enc.AppendObject(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("name", s.Name)
+			enc.AddString("id", s.SpanID)
+			enc.AddString("parent", s.ParentID)
+			enc.AddDuration("offset", s.Start.Sub(l.root.Start))
+			enc.AddDuration("duration", s.Duration)
+			for k, v := range s.Attrs {
+				enc.AddString(k, v)
+			}
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler wraps h so that it runs in a span of its own.
+func Handler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(r.Context(), name)
+		defer span.End()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// parseTraceparent extracts the trace ID, parent span ID and sampled flag
+// from a W3C traceparent header: "00-<trace id>-<parent id>-<flags>".
+func parseTraceparent(h string) (traceID, parentID string, sampled bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 || !validID(parts[1]) || !validID(parts[2]) {
+		return "", "", false
+	}
+	return parts[1], parts[2], flags[0]&1 == 1
+}
+
+// Module provides the Tracer, exporting traces if the environment names
+// a collector. The application puts the Tracer in front of its handler.
+// トレースとOTLPでの送信
+var Module = fx.Module("tracing",
+	fx.Provide(NewTracer, NewExporter),
+)
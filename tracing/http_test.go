@@ -0,0 +1,14 @@
+package tracing
+
+import (
+	"testing"
+
+	"example.com/fxdemo/degrade"
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	t := NewTracer(DefaultConfig(), zap.NewNop(), degrade.NewRegistry(zap.NewNop()), nil)
+	httpfxtest.Benchmark(b, t.Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,287 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Batching of the exported spans.
+const (
+	queueSize     = 2048
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+)
+
+var (
+	spansExported = expvar.NewInt("tracing_spans_exported")
+	spansDropped  = expvar.NewInt("tracing_spans_dropped") // the queue was full or the collector failed
+)
+
+// Exporter sends finished spans to an OpenTelemetry collector with
+// OTLP over HTTP, in its JSON encoding. It is set up from the standard
+// environment variables:
+//
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT  the full URL spans are posted to
+//	OTEL_EXPORTER_OTLP_ENDPOINT         the collector's base URL, if the above is not set
+//	OTEL_EXPORTER_OTLP_HEADERS          extra headers, as key1=value1,key2=value2
+//	OTEL_SERVICE_NAME                   the service.name of the spans; fxdemo if not set
+//
+// Without an endpoint, a nil *Exporter is used, which drops spans.
+// OTLPでコレクターにスパンを送る
+type Exporter struct {
+	endpoint string
+	headers  http.Header
+	service  string
+	client   *http.Client
+	log      *zap.Logger
+
+	queue chan *Span
+	done  chan struct{}
+}
+
+// NewExporter builds an Exporter from the environment and runs it for
+// as long as the Fx application does. Spans still queued when the
+// application stops are sent before it does.
+func NewExporter(lc fx.Lifecycle, log *zap.Logger) (*Exporter, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return nil, fmt.Errorf("tracing: invalid OTLP endpoint: %w", err)
+	}
+	headers, err := parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return nil, err
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "fxdemo"
+	}
+	e := &Exporter{
+		endpoint: endpoint,
+		headers:  headers,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		log:      log,
+		queue:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+	}
+	stopped := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			log.Info("Exporting traces", zap.String("endpoint", endpoint), zap.String("service", service))
+			go e.run(stopped)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(e.done)
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+	return e, nil
+}
+
+// parseHeaders parses the OTEL_EXPORTER_OTLP_HEADERS format, whose
+// values are URL encoded.
+func parseHeaders(s string) (http.Header, error) {
+	h := make(http.Header)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("tracing: invalid OTLP header %q", pair)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("tracing: invalid OTLP header %q: %w", pair, err)
+		}
+		h.Set(strings.TrimSpace(k), value)
+	}
+	return h, nil
+}
+
+// Enqueue queues the spans of a trace for export, dropping them if the
+// queue is full rather than holding up the request.
+func (e *Exporter) Enqueue(spans []*Span) {
+	if e == nil {
+		return
+	}
+	for _, s := range spans {
+		select {
+		case e.queue <- s:
+		default:
+			spansDropped.Add(1)
+		}
+	}
+}
+
+// run sends the queued spans in batches until done is closed, then
+// sends what is left.
+func (e *Exporter) run(stopped chan<- struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			e.send(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.done:
+			for {
+				select {
+				case s := <-e.queue:
+					if batch = append(batch, s); len(batch) == batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *Exporter) send(spans []*Span) {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		e.log.Error("Failed to encode spans", zap.Error(err))
+		spansDropped.Add(int64(len(spans)))
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		e.log.Error("Failed to export spans", zap.Error(err))
+		spansDropped.Add(int64(len(spans)))
+		return
+	}
+	for k, vs := range e.headers {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		e.log.Warn("Failed to export spans", zap.Error(err), zap.Int("spans", len(spans)))
+		spansDropped.Add(int64(len(spans)))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		e.log.Warn("Collector refused spans", zap.Int("status", resp.StatusCode), zap.Int("spans", len(spans)))
+		spansDropped.Add(int64(len(spans)))
+		return
+	}
+	spansExported.Add(int64(len(spans)))
+}
+
+// The OTLP/JSON messages, as far as they are used here. IDs are hex
+// encoded and times are nanoseconds since the epoch, as strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+)
+
+// Span kinds.
+const (
+	kindInternal = 1
+	kindServer   = 2
+)
+
+func (e *Exporter) request(spans []*Span) otlpRequest {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		kind := kindInternal
+		if s.root {
+			kind = kindServer
+		}
+		o := otlpSpan{
+			TraceID:      s.TraceID,
+			SpanID:       s.SpanID,
+			ParentSpanID: s.ParentID,
+			Name:         s.Name,
+			Kind:         kind,
+			Start:        strconv.FormatInt(s.Start.UnixNano(), 10),
+			End:          strconv.FormatInt(s.Start.Add(s.Duration).UnixNano(), 10),
+		}
+		for k, v := range s.Attrs {
+			o.Attributes = append(o.Attributes, otlpAttr{Key: k, Value: otlpValue{StringValue: v}})
+		}
+		out[i] = o
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttr{
+			{Key: "service.name", Value: otlpValue{StringValue: e.service}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "example.com/fxdemo/tracing"},
+			Spans: out,
+		}},
+	}}}
+}
@@ -0,0 +1,124 @@
+// Package tracing records a tree of timed spans for each sampled request
+// so that it is visible where time goes inside the server: every
+// middleware and the route handler get a span of their own.
+//
+// Trace context is taken from, and compatible with, the W3C traceparent
+// header. Finished traces are written to the log, and exported to an
+// OpenTelemetry collector when one is configured; see Exporter.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Span is a timed operation within a trace. The root span of a request
+// that is not sampled still carries the trace ID, so that it can be
+// correlated and propagated, but it does not record anything.
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Attrs    map[string]string
+
+	rec  *recording
+	root bool // the request's span, which the server started
+}
+
+// recording collects the spans of one trace.
+type recording struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+type spanKey struct{}
+
+// FromContext returns the current span, or nil if there is none.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// TraceID returns the ID of the trace the request belongs to,
+// whether or not it is being recorded.
+func TraceID(ctx context.Context) string {
+	if s := FromContext(ctx); s != nil {
+		return s.TraceID
+	}
+	return ""
+}
+
+// Start begins a child of the current span. If the request is not being
+// recorded, the returned span is nil, which is safe to use.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if !parent.Recording() {
+		return ctx, nil
+	}
+	s := &Span{
+		TraceID:  parent.TraceID,
+		SpanID:   newID(8),
+		ParentID: parent.SpanID,
+		Name:     name,
+		Start:    time.Now(),
+		rec:      parent.rec,
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Recording reports whether the span is part of a sampled trace.
+func (s *Span) Recording() bool {
+	return s != nil && s.rec != nil
+}
+
+// SetAttr annotates the span with a key/value pair.
+func (s *Span) SetAttr(key, value string) {
+	if !s.Recording() {
+		return
+	}
+	s.rec.mu.Lock()
+	defer s.rec.mu.Unlock()
+	if s.Attrs == nil {
+		s.Attrs = make(map[string]string)
+	}
+	s.Attrs[key] = value
+}
+
+// End finishes the span.
+func (s *Span) End() {
+	if !s.Recording() {
+		return
+	}
+	s.rec.mu.Lock()
+	defer s.rec.mu.Unlock()
+	s.Duration = time.Since(s.Start)
+	s.rec.spans = append(s.rec.spans, s)
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validID reports whether id is lowercase hex and not all zeros,
+// as trace context requires.
+func validID(id string) bool {
+	zero := true
+	for _, c := range id {
+		switch {
+		case c == '0':
+		case '1' <= c && c <= '9', 'a' <= c && c <= 'f':
+			zero = false
+		default:
+			return false
+		}
+	}
+	return !zero
+}
@@ -0,0 +1,215 @@
+// Package transfer counts the bytes each request reads and writes, adds
+// them up per client and per day, and can hold every client to a daily
+// transfer quota.
+package transfer
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/telemetry"
+	"example.com/fxdemo/tracing"
+	"go.uber.org/zap"
+)
+
+// Config sets the daily transfer quota.
+type Config struct {
+	// DailyQuota is how many bytes, read and written together, a client
+	// may transfer in a UTC day. Zero means no quota.
+	DailyQuota int64 `yaml:"daily_quota"`
+}
+
+// DefaultConfig sets no quota.
+func DefaultConfig() Config {
+	return Config{}
+}
+
+// maxClients bounds the number of clients counted individually each day.
+// Clients beyond it are counted together as "other", and are not held
+// to the quota.
+const maxClients = 1000
+
+var (
+	bytesRead     = expvar.NewInt("transfer_bytes_read")
+	bytesWritten  = expvar.NewInt("transfer_bytes_written")
+	quotaExceeded = expvar.NewInt("transfer_quota_exceeded")
+	// clientsToday is how many clients have been counted today. What
+	// each transferred stays in the Meter: client addresses would make
+	// unbounded metric labels, and are personal data.
+	clientsToday = expvar.NewInt("transfer_clients_today")
+)
+
+// Usage is what a client has transferred so far today.
+type Usage struct {
+	Requests int64 `json:"requests"`
+	Read     int64 `json:"read"`
+	Written  int64 `json:"written"`
+}
+
+// Total returns the bytes read and written together.
+func (u Usage) Total() int64 {
+	return u.Read + u.Written
+}
+
+// Meter counts the bytes transferred by each request.
+// リクエストごとの転送量を数える
+type Meter struct {
+	cfg Config
+	log *zap.Logger
+
+	mu      sync.Mutex
+	day     string // the UTC date the counts are for
+	clients map[string]*Usage
+}
+
+// NewMeter builds a new Meter.
+func NewMeter(cfg Config, log *zap.Logger) *Meter {
+	return &Meter{cfg: cfg, log: log, clients: make(map[string]*Usage)}
+}
+
+// Wrap returns a handler that refuses requests from clients over their
+// quota with 429, and counts what the others transfer.
+func (m *Meter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientip.FromRequest(r)
+		if m.cfg.DailyQuota > 0 && m.Usage(client).Total() >= m.cfg.DailyQuota {
+			quotaExceeded.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(untilTomorrow(time.Now()).Seconds())+1))
+			apperror.Write(w, r, nil, apperror.New(http.StatusTooManyRequests, "quota_exceeded", "Daily transfer quota exceeded"))
+			return
+		}
+
+		c := &Counts{}
+		r.Body = &countingReader{ReadCloser: r.Body, c: c}
+		ctx := context.WithValue(r.Context(), countsKey{}, c)
+		next.ServeHTTP(&countingWriter{ResponseWriter: w, c: c}, r.WithContext(ctx))
+
+		m.add(client, c.Read, c.Written)
+		if span := tracing.FromContext(ctx); span.Recording() {
+			span.SetAttr("bytes_read", strconv.FormatInt(c.Read, 10))
+			span.SetAttr("bytes_written", strconv.FormatInt(c.Written, 10))
+		}
+		telemetry.Logger(ctx, m.log).Debug("Request transferred",
+			zap.Int64("bytes_read", c.Read),
+			zap.Int64("bytes_written", c.Written),
+		)
+	})
+}
+
+// Usage returns what the client has transferred today.
+func (m *Meter) Usage(client string) Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover(time.Now())
+	if u, ok := m.clients[client]; ok {
+		return *u
+	}
+	return Usage{}
+}
+
+// Today returns what every client has transferred today.
+func (m *Meter) Today() map[string]Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover(time.Now())
+	out := make(map[string]Usage, len(m.clients))
+	for client, u := range m.clients {
+		out[client] = *u
+	}
+	return out
+}
+
+func (m *Meter) add(client string, read, written int64) {
+	bytesRead.Add(read)
+	bytesWritten.Add(written)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover(time.Now())
+	u, ok := m.clients[client]
+	if !ok {
+		if len(m.clients) >= maxClients {
+			client = "other"
+			u = m.clients[client]
+		}
+		if u == nil {
+			u = &Usage{}
+			m.clients[client] = u
+		}
+	}
+	u.Requests++
+	u.Read += read
+	u.Written += written
+	clientsToday.Set(int64(len(m.clients)))
+}
+
+// rollover starts the counts afresh on a new day. It must be called
+// with m.mu held.
+func (m *Meter) rollover(now time.Time) {
+	day := now.UTC().Format("2006-01-02")
+	if day != m.day {
+		m.day = day
+		m.clients = make(map[string]*Usage)
+		clientsToday.Set(0)
+	}
+}
+
+// untilTomorrow returns the time left before the next UTC midnight,
+// when quotas are reset.
+func untilTomorrow(now time.Time) time.Duration {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return midnight.Sub(now)
+}
+
+// Counts is what a request has transferred so far.
+type Counts struct {
+	Read    int64 // bytes of the request body read by the handler
+	Written int64 // bytes of the response body
+}
+
+type countsKey struct{}
+
+// FromContext returns the counts of the request being served with ctx,
+// or nil if it is not going through a Meter. They are only up to date
+// once the handler has returned.
+func FromContext(ctx context.Context) *Counts {
+	c, _ := ctx.Value(countsKey{}).(*Counts)
+	return c
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	c *Counts
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.c.Read += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes of a response body.
+type countingWriter struct {
+	http.ResponseWriter
+	c *Counts
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.c.Written += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying writer.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,54 @@
+package transfer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/zap"
+)
+
+// TestMeter sends requests from two clients, one of them past its quota,
+// and checks that what each transferred is counted for the quota but
+// only the number of clients is exported.
+func TestMeter(t *testing.T) {
+	m := NewMeter(Config{DailyQuota: 10}, zap.NewNop())
+	h := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body) // 読んだ分を書き返す
+	}))
+	send := func(ip, body string) int {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.RemoteAddr = ip + ":40000"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	steps := []struct {
+		ip, body string
+		want     int
+	}{
+		{"192.0.2.1", "abc", http.StatusOK},
+		{"192.0.2.1", "ab", http.StatusOK},
+		{"192.0.2.1", "", http.StatusTooManyRequests}, // 10バイトに達した
+		{"192.0.2.2", "abcd", http.StatusOK},
+	}
+	for i, step := range steps {
+		if got := send(step.ip, step.body); got != step.want {
+			t.Errorf("step %d: %s got %d, want %d", i, step.ip, got, step.want)
+		}
+	}
+	if got, want := m.Usage("192.0.2.1"), (Usage{Requests: 2, Read: 5, Written: 5}); got != want {
+		t.Errorf("Usage(192.0.2.1) = %+v, want %+v", got, want)
+	}
+	if n := clientsToday.Value(); n != 2 {
+		t.Errorf("transfer_clients_today = %d, want 2", n)
+	}
+}
+
+func BenchmarkWrap(b *testing.B) {
+	httpfxtest.Benchmark(b, NewMeter(DefaultConfig(), zap.NewNop()).Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,78 @@
+package upload
+
+import (
+	"context"
+	"os"
+
+	"example.com/fxdemo/pkg/clock"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Cleaner periodically removes partial uploads that have not
+// been resumed within the configured expiry.
+// 放置されたアップロードを定期的に削除する
+type Cleaner struct {
+	cfg   Config
+	store Store
+	log   *zap.Logger
+	clock clock.Clock
+}
+
+// NewCleaner builds a Cleaner that runs for as long as the Fx application.
+func NewCleaner(lc fx.Lifecycle, cfg Config, store Store, log *zap.Logger) *Cleaner {
+	return newCleaner(lc, cfg, store, log, clock.Real)
+}
+
+func newCleaner(lc fx.Lifecycle, cfg Config, store Store, log *zap.Logger, clk clock.Clock) *Cleaner {
+	c := &Cleaner{cfg: cfg, store: store, log: log, clock: clk}
+
+	// A sweep in progress at shutdown stops at the next upload.
+	ctx, cancel := context.WithCancel(context.Background())
+	var stop func()
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			stop = c.clock.Every(c.cfg.CleanupInterval, func() { c.Sweep(ctx) })
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			stopped := make(chan struct{})
+			go func() {
+				stop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-stopCtx.Done():
+				return stopCtx.Err()
+			}
+		},
+	})
+	return c
+}
+
+// Sweep removes every upload that has been idle for longer than the
+// expiry, stopping early if ctx is done.
+func (c *Cleaner) Sweep(ctx context.Context) {
+	uploads, err := c.store.Abandoned(ctx, c.clock.Now().Add(-c.cfg.Expiry))
+	if err != nil {
+		c.log.Error("Failed to list abandoned uploads", zap.Error(err))
+		return
+	}
+	for _, u := range uploads {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := os.Remove(c.cfg.path(u.ID)); err != nil && !os.IsNotExist(err) {
+			c.log.Warn("Failed to remove upload file", zap.String("id", u.ID), zap.Error(err))
+			continue
+		}
+		if err := c.store.Delete(ctx, u.ID); err != nil {
+			c.log.Warn("Failed to delete upload", zap.String("id", u.ID), zap.Error(err))
+			continue
+		}
+		c.log.Info("Removed abandoned upload", zap.String("id", u.ID), zap.Int64("offset", u.Offset))
+	}
+}
@@ -0,0 +1,138 @@
+package upload
+
+import (
+	"context"
+	"os"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/fxdemo/pkg/clock"
+	"example.com/fxdemo/pkg/clock/clocktest"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+// cancelingStore cancels a sweep once it has deleted its first upload,
+// as the application stopping in the middle of one would.
+type cancelingStore struct {
+	Store
+	cancel context.CancelFunc
+}
+
+func (s cancelingStore) Delete(ctx context.Context, id string) error {
+	defer s.cancel()
+	return s.Store.Delete(ctx, id)
+}
+
+func TestSweepStopsWhenCanceled(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	store := NewMemoryStore()
+	old := time.Now().Add(-2 * cfg.Expiry)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := store.Create(context.Background(), Upload{ID: id, Length: 10, CreatedAt: old, UpdatedAt: old}); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(cfg.path(id), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &Cleaner{cfg: cfg, store: cancelingStore{store, cancel}, log: zap.NewNop(), clock: clock.Real}
+	c.Sweep(ctx)
+
+	left, err := store.Abandoned(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 2 {
+		t.Errorf("%d uploads left, want the sweep to stop after the first", len(left))
+	}
+	for _, u := range left {
+		if _, err := os.Stat(cfg.path(u.ID)); err != nil {
+			t.Errorf("file of %s: %v, want it kept with its upload", u.ID, err)
+		}
+	}
+}
+
+// countingStore counts the sweeps made on it.
+type countingStore struct {
+	Store
+	sweeps atomic.Int32
+}
+
+func (s *countingStore) Abandoned(ctx context.Context, before time.Time) ([]Upload, error) {
+	s.sweeps.Add(1)
+	return s.Store.Abandoned(ctx, before)
+}
+
+// TestCleanerSimulation runs the cleaner on a fake clock for a day and a
+// half, checking after each step which uploads it swept.
+func TestCleanerSimulation(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clocktest.New(start)
+	store := &countingStore{Store: NewMemoryStore()}
+	add := func(id string, updated time.Duration, done bool) {
+		t.Helper()
+		u := Upload{ID: id, Length: 10, CreatedAt: start, UpdatedAt: start.Add(updated)}
+		if done {
+			u.Offset = u.Length
+		}
+		if err := store.Create(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(cfg.path(id), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("idle", 0, false)
+	add("resumed", 5*time.Hour, false)
+	add("done", 0, true)
+	add("future", 30*time.Hour, false) // 時計が進むまで更新されていないことになる
+
+	lc := fxtest.NewLifecycle(t)
+	newCleaner(lc, cfg, store, zap.NewNop(), fake)
+	lc.RequireStart()
+	defer lc.RequireStop()
+
+	steps := []struct {
+		advance time.Duration
+		sweeps  int32    // made so far, one per cleanup interval
+		left    []string // uploads kept
+	}{
+		{cfg.CleanupInterval - 1, 0, []string{"done", "future", "idle", "resumed"}},
+		{1, 1, []string{"done", "future", "idle", "resumed"}},
+		{cfg.Expiry - cfg.CleanupInterval, 144, []string{"done", "future", "idle", "resumed"}},
+		{cfg.CleanupInterval, 145, []string{"done", "future", "resumed"}}, // 24時間10分: idleが期限切れ
+		{5 * time.Hour, 175, []string{"done", "future"}},                  // 29時間10分: resumedも
+		{24*time.Hour + 50*time.Minute, 324, []string{"done", "future"}},  // 54時間: futureはちょうど期限
+		{cfg.CleanupInterval, 325, []string{"done"}},
+	}
+	for _, step := range steps {
+		fake.Advance(step.advance)
+		at := fake.Now().Sub(start)
+		if n := store.sweeps.Load(); n != step.sweeps {
+			t.Errorf("at %v: %d sweeps, want %d", at, n, step.sweeps)
+		}
+		var left []string
+		for _, id := range []string{"done", "future", "idle", "resumed"} {
+			if _, err := store.Get(context.Background(), id); err == nil {
+				left = append(left, id)
+			}
+		}
+		if !slices.Equal(left, step.left) {
+			t.Errorf("at %v: uploads left = %v, want %v", at, left, step.left)
+		}
+		for _, id := range step.left {
+			if _, err := os.Stat(cfg.path(id)); err != nil {
+				t.Errorf("at %v: file of %s: %v", at, id, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/disposition"
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/zap"
+)
+
+// DownloadHandler is an http.Handler serving completed uploads
+// under /files/.
+type DownloadHandler struct {
+	cfg   Config
+	store Store
+	log   *zap.Logger
+}
+
+// NewDownloadHandler builds a new DownloadHandler.
+// 完了したアップロードをダウンロードさせるハンドラ
+func NewDownloadHandler(cfg Config, store Store, log *zap.Logger) *DownloadHandler {
+	return &DownloadHandler{cfg: cfg, store: store, log: log}
+}
+
+// Pattern reports the path at which this is registered.
+func (*DownloadHandler) Pattern() string {
+	return "/files/{id}"
+}
+
+// Methods reports the methods this serves. HEAD is served here rather
+// than by the mux, so that the content is not read for it.
+func (*DownloadHandler) Methods() []string {
+	return []string{http.MethodGet, http.MethodHead}
+}
+
+func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apperror.Handler(h.log, h.serve).ServeHTTP(w, r)
+}
+
+// serve sends the upload named in the path. Its media type is taken
+// from the file name rather than sniffed from the content, and only the
+// configured types are allowed to be displayed inline.
+func (h *DownloadHandler) serve(w http.ResponseWriter, r *http.Request) error {
+	id := httpfx.PathValue(r, "id")
+	if !validID(id) {
+		return errNoFile
+	}
+
+	u, err := h.store.Get(r.Context(), id)
+	if errors.Is(err, ErrNotFound) || (err == nil && !u.Done()) {
+		return errNoFile
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("looking up upload %s: %w", id, err))
+	}
+
+	f, err := os.Open(h.cfg.path(id))
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("opening upload file %s: %w", id, err))
+	}
+	defer f.Close()
+
+	name := disposition.SanitizeFilename(u.Filename())
+	contentType := mediaType(name)
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	if h.inline(contentType) && r.URL.Query().Get("download") == "" {
+		header.Set("Content-Disposition", disposition.Inline(name))
+		// Inline content must not be able to run script in our origin.
+		header.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	} else {
+		header.Set("Content-Disposition", disposition.Attachment(name))
+	}
+	http.ServeContent(w, r, "", u.UpdatedAt, f)
+	return nil
+}
+
+// errNoFile answers for uploads that do not exist or are not complete.
+var errNoFile = apperror.NotFound("No such file")
+
+// inline reports whether the media type may be displayed by the client.
+func (h *DownloadHandler) inline(contentType string) bool {
+	base, _, _ := mime.ParseMediaType(contentType)
+	for _, t := range h.cfg.InlineTypes {
+		if t == base {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType guesses the media type from the file extension,
+// falling back to an opaque binary type.
+func mediaType(name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
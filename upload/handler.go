@@ -0,0 +1,281 @@
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/limits"
+	"example.com/fxdemo/links"
+	"example.com/fxdemo/pkg/httpfx"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/zap"
+)
+
+const (
+	tusVersion        = "1.0.0"
+	offsetContentType = "application/offset+octet-stream"
+)
+
+// Handler is an http.Handler serving the resumable upload endpoints
+// under /uploads/.
+type Handler struct {
+	cfg   Config
+	store Store
+	links *links.Builder
+	log   *zap.Logger
+	h     http.Handler
+
+	mu      sync.Mutex
+	writing map[string]bool // uploads with a PATCH in progress
+}
+
+// NewHandler builds a new Handler, creating the upload directory if needed.
+func NewHandler(cfg Config, store Store, links *links.Builder, log *zap.Logger) (*Handler, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, err
+	}
+	h := &Handler{
+		cfg:     cfg,
+		store:   store,
+		links:   links,
+		log:     log,
+		writing: make(map[string]bool),
+	}
+	h.h = apperror.Handler(log, h.serve)
+	return h, nil
+}
+
+// Pattern reports the path at which this is registered.
+func (*Handler) Pattern() string {
+	return "/uploads/"
+}
+
+// Limits lifts the default body limit: each PATCH is bounded by the
+// Upload-Length of its upload instead, itself at most MaxSize.
+func (*Handler) Limits() limits.Limits {
+	return limits.Limits{MaxBodyBytes: limits.NoLimit}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.h.ServeHTTP(w, r)
+}
+
+// serve dispatches to the tus operations based on the request method.
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	id := strings.TrimPrefix(r.URL.Path, h.Pattern())
+	if id != "" && !validID(id) {
+		return errNoUpload
+	}
+	switch {
+	case r.Method == http.MethodOptions:
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,expiration")
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.cfg.MaxSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case id == "" && r.Method == http.MethodPost:
+		return h.create(w, r)
+	case id != "" && r.Method == http.MethodHead:
+		return h.head(w, r, id)
+	case id != "" && r.Method == http.MethodPatch:
+		return h.patch(w, r, id)
+	default:
+		return apperror.New(http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed here", r.Method)
+	}
+}
+
+// errNoUpload answers for uploads that do not exist.
+var errNoUpload = apperror.NotFound("No such upload")
+
+// create starts a new upload and reports its location.
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) error {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return apperror.BadRequest("Invalid Upload-Length")
+	}
+	if length > h.cfg.MaxSize {
+		return apperror.TooLarge(h.cfg.MaxSize)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("generating upload ID: %w", err))
+	}
+	f, err := os.OpenFile(h.cfg.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("creating upload file: %w", err))
+	}
+	f.Close()
+
+	meta, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		os.Remove(h.cfg.path(id))
+		return apperror.BadRequest("Invalid Upload-Metadata")
+	}
+
+	now := time.Now()
+	u := Upload{ID: id, Length: length, Metadata: meta, CreatedAt: now, UpdatedAt: now}
+	if err := h.store.Create(r.Context(), u); err != nil {
+		os.Remove(h.cfg.path(id))
+		return apperror.Internal(fmt.Errorf("recording upload: %w", err))
+	}
+
+	h.logger(r).Info("Upload created", zap.String("id", id), zap.Int64("length", length))
+	w.Header().Set("Location", h.links.Related(r, h.Pattern()+id).Href)
+	w.Header().Set("Upload-Expires", now.Add(h.cfg.Expiry).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// head reports how much of the upload has been received.
+func (h *Handler) head(w http.ResponseWriter, r *http.Request, id string) error {
+	u, err := h.lookup(r, id)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if u.Done() {
+		download := h.links.Related(r, httpfx.PathFor((*DownloadHandler)(nil).Pattern(), id))
+		w.Header().Add("Link", "<"+download.Href+`>; rel="related"`)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// patch appends the request body to the upload at the offset
+// the client claims to be resuming from.
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, id string) error {
+	if r.Header.Get("Content-Type") != offsetContentType {
+		return apperror.New(http.StatusUnsupportedMediaType, "unsupported_media_type", "The body must be of type %s", offsetContentType)
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return apperror.BadRequest("Invalid Upload-Offset")
+	}
+
+	if !h.acquire(id) {
+		return apperror.New(http.StatusLocked, "locked", "Upload is locked by another request")
+	}
+	defer h.release(id)
+
+	u, err := h.lookup(r, id)
+	if err != nil {
+		return err
+	}
+	if offset != u.Offset {
+		return apperror.New(http.StatusConflict, "offset_mismatch", "Upload-Offset does not match")
+	}
+
+	f, err := os.OpenFile(h.cfg.path(id), os.O_WRONLY, 0)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("opening upload file %s: %w", id, err))
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return apperror.Internal(fmt.Errorf("seeking upload file %s: %w", id, err))
+	}
+
+	// Whatever made it to disk counts, even if the client went away
+	// halfway through; that is what makes the upload resumable.
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, u.Length-offset))
+	if err := h.store.SetOffset(r.Context(), id, offset+n); err != nil {
+		return apperror.Internal(fmt.Errorf("recording offset of upload %s: %w", id, err))
+	}
+	if copyErr != nil {
+		h.logger(r).Warn("Upload interrupted", zap.String("id", id), zap.Int64("offset", offset+n), zap.Error(copyErr))
+		return &apperror.Error{Status: http.StatusBadRequest, Code: apperror.CodeBadRequest, Message: "Failed to read the request body", Err: copyErr}
+	}
+
+	if offset+n == u.Length {
+		h.logger(r).Info("Upload completed", zap.String("id", id), zap.Int64("length", u.Length))
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset+n, 10))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// lookup fetches the upload, or returns the error to answer with.
+func (h *Handler) lookup(r *http.Request, id string) (Upload, error) {
+	u, err := h.store.Get(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		return Upload{}, errNoUpload
+	}
+	if err != nil {
+		return Upload{}, apperror.Internal(fmt.Errorf("looking up upload %s: %w", id, err))
+	}
+	return u, nil
+}
+
+// acquire marks the upload as being written, reporting false
+// if another request already holds it.
+func (h *Handler) acquire(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.writing[id] {
+		return false
+	}
+	h.writing[id] = true
+	return true
+}
+
+func (h *Handler) release(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.writing, id)
+}
+
+// parseMetadata decodes an Upload-Metadata header: comma separated
+// pairs of a key and an optional base64 encoded value.
+func parseMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
+
+// validID reports whether id looks like one produced by newID,
+// so that it is safe to use as a file name.
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// logger returns the logger for messages about the request.
+func (h *Handler) logger(r *http.Request) *zap.Logger {
+	return telemetry.Logger(r.Context(), h.log)
+}
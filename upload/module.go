@@ -0,0 +1,20 @@
+package upload
+
+import (
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+)
+
+// Module provides the upload and download routes, and runs the Cleaner.
+// Upload offsets are kept in the *sql.DB of db.Module, or in memory if
+// there is no database.
+// 再開可能なアップロード
+var Module = fx.Module("upload",
+	fx.Provide(
+		httpfx.AsRoute[*Handler](NewHandler),
+		httpfx.AsRoute[*DownloadHandler](NewDownloadHandler),
+		NewStore,
+		NewCleaner,
+	),
+	fx.Invoke(func(*Cleaner) {}), // 定期削除ジョブを起動する
+)
@@ -0,0 +1,146 @@
+package upload
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// uploadsSchema creates the uploads table. Its types and the $n
+// placeholders of the queries below work on both Postgres and SQLite.
+// The offset is kept as "received", OFFSET being a keyword of SQL.
+const uploadsSchema = `CREATE TABLE IF NOT EXISTS uploads (
+	id         TEXT PRIMARY KEY,
+	length     BIGINT NOT NULL,
+	received   BIGINT NOT NULL,
+	metadata   TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`
+
+// SQLStore is a Store that keeps upload state in the SQL database of
+// package db, so that uploads can be resumed across restarts and on any
+// instance sharing the upload directory.
+// アップロードの進捗をデータベースに保存する
+type SQLStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore builds a SQLStore on db, creating its table when the
+// application starts.
+func NewSQLStore(lc fx.Lifecycle, db *sql.DB) *SQLStore {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if _, err := db.ExecContext(ctx, uploadsSchema); err != nil {
+				return fmt.Errorf("upload: create table: %w", err)
+			}
+			return nil
+		},
+	})
+	return &SQLStore{db: db}
+}
+
+// NewStore returns a SQLStore if there is a database, and a MemoryStore
+// otherwise.
+func NewStore(lc fx.Lifecycle, db *sql.DB) Store {
+	if db == nil {
+		return NewMemoryStore()
+	}
+	return NewSQLStore(lc, db)
+}
+
+// Create records a new upload.
+func (s *SQLStore) Create(ctx context.Context, u Upload) error {
+	meta, err := json.Marshal(u.Metadata)
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO uploads (id, length, received, metadata, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		u.ID, u.Length, u.Offset, string(meta), u.CreatedAt.UTC(), u.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("upload: insert: %w", err)
+	}
+	return nil
+}
+
+// Get returns the upload with the given ID.
+func (s *SQLStore) Get(ctx context.Context, id string) (Upload, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id, length, received, metadata, created_at, updated_at FROM uploads WHERE id = $1`, id)
+	u, err := scanUpload(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Upload{}, ErrNotFound
+	}
+	return u, err
+}
+
+// SetOffset records how many bytes of the upload have been received.
+func (s *SQLStore) SetOffset(ctx context.Context, id string, offset int64) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE uploads SET received = $1, updated_at = $2 WHERE id = $3`,
+		offset, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("upload: update: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete forgets the upload with the given ID.
+func (s *SQLStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM uploads WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("upload: delete: %w", err)
+	}
+	return nil
+}
+
+// Abandoned lists incomplete uploads last written before the given time.
+func (s *SQLStore) Abandoned(ctx context.Context, before time.Time) ([]Upload, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, length, received, metadata, created_at, updated_at FROM uploads WHERE received < length AND updated_at < $1`,
+		before.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("upload: query: %w", err)
+	}
+	defer rows.Close()
+	var out []Upload
+	for rows.Next() {
+		u, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("upload: query: %w", err)
+	}
+	return out, nil
+}
+
+// scanUpload reads an upload from a row of the queries above.
+func scanUpload(row interface{ Scan(...any) error }) (Upload, error) {
+	var (
+		u    Upload
+		meta string
+	)
+	if err := row.Scan(&u.ID, &u.Length, &u.Offset, &meta, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Upload{}, err
+		}
+		return Upload{}, fmt.Errorf("upload: scan: %w", err)
+	}
+	if err := json.Unmarshal([]byte(meta), &u.Metadata); err != nil {
+		return Upload{}, fmt.Errorf("upload: metadata of %s: %w", u.ID, err)
+	}
+	return u, nil
+}
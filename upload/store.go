@@ -0,0 +1,126 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when an upload ID is unknown to the Store.
+var ErrNotFound = errors.New("upload: not found")
+
+// Upload describes an upload and how much of it has been received so far.
+type Upload struct {
+	ID        string
+	Length    int64 // Length is the total size declared by the client.
+	Offset    int64 // Offset is the number of bytes received so far.
+	Metadata  map[string]string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Filename returns the file name the client sent in the upload metadata.
+func (u Upload) Filename() string {
+	return u.Metadata["filename"]
+}
+
+// Done reports whether every byte of the upload has been received.
+func (u Upload) Done() bool {
+	return u.Offset >= u.Length
+}
+
+// Store tracks upload offsets so that an interrupted upload
+// can be resumed from where it stopped.
+type Store interface {
+	Create(ctx context.Context, u Upload) error
+	Get(ctx context.Context, id string) (Upload, error)
+	SetOffset(ctx context.Context, id string, offset int64) error
+	Delete(ctx context.Context, id string) error
+	// Abandoned lists incomplete uploads that have not been
+	// written to since before.
+	Abandoned(ctx context.Context, before time.Time) ([]Upload, error)
+}
+
+// MemoryStore is a Store that keeps upload state in memory.
+// アップロードの進捗をメモリ上で管理する
+type MemoryStore struct {
+	mu      sync.Mutex
+	uploads map[string]Upload
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore builds an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{uploads: make(map[string]Upload)}
+}
+
+// Create records a new upload.
+func (s *MemoryStore) Create(ctx context.Context, u Upload) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[u.ID] = u
+	return nil
+}
+
+// Get returns the upload with the given ID.
+func (s *MemoryStore) Get(ctx context.Context, id string) (Upload, error) {
+	if err := ctx.Err(); err != nil {
+		return Upload{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		return Upload{}, ErrNotFound
+	}
+	return u, nil
+}
+
+// SetOffset records how many bytes of the upload have been received.
+func (s *MemoryStore) SetOffset(ctx context.Context, id string, offset int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Offset = offset
+	u.UpdatedAt = time.Now()
+	s.uploads[id] = u
+	return nil
+}
+
+// Delete forgets the upload with the given ID.
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploads, id)
+	return nil
+}
+
+// Abandoned lists incomplete uploads last written before the given time.
+func (s *MemoryStore) Abandoned(ctx context.Context, before time.Time) ([]Upload, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Upload
+	for _, u := range s.uploads {
+		if !u.Done() && u.UpdatedAt.Before(before) {
+			out = append(out, u)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,44 @@
+// Package upload implements resumable uploads using a small subset
+// of the tus protocol (https://tus.io/protocols/resumable-upload):
+// creation via POST, offset discovery via HEAD and appending via PATCH.
+// Completed uploads can be fetched again from the download endpoint.
+package upload
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config controls where uploads are stored and how long
+// incomplete uploads are kept around.
+type Config struct {
+	Dir             string        `yaml:"dir"`              // Dir is where upload data is written.
+	MaxSize         int64         `yaml:"max_size"`         // MaxSize is the largest Upload-Length accepted.
+	Expiry          time.Duration `yaml:"expiry"`           // Expiry is how long an idle partial upload is kept.
+	CleanupInterval time.Duration `yaml:"cleanup_interval"` // CleanupInterval is how often expired uploads are removed.
+	// InlineTypes lists the media types that downloads may display
+	// inline; everything else is served as an attachment.
+	InlineTypes []string `yaml:"inline_types"`
+}
+
+// DefaultConfig returns the Config used when nothing else is provided.
+func DefaultConfig() Config {
+	return Config{
+		Dir:             filepath.Join(os.TempDir(), "fxdemo-uploads"),
+		MaxSize:         1 << 30,
+		Expiry:          24 * time.Hour,
+		CleanupInterval: 10 * time.Minute,
+		InlineTypes: []string{
+			"application/pdf",
+			"image/gif",
+			"image/jpeg",
+			"image/png",
+			"text/plain",
+		},
+	}
+}
+
+func (c Config) path(id string) string {
+	return filepath.Join(c.Dir, id)
+}
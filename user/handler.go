@@ -0,0 +1,184 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/deadline"
+	"example.com/fxdemo/links"
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/zap"
+)
+
+const (
+	maxBody     = 16 << 10        // maxBody bounds the size of the users clients send.
+	repoTimeout = 5 * time.Second // repoTimeout bounds each repository call, within the request's deadline.
+	itemPattern = "/users/{id}"
+)
+
+// UserHandler serves the users resource: the collection at /users and
+// each user at /users/{id}.
+// ユーザーリソースのハンドラ
+type UserHandler struct {
+	repo  Repository
+	links *links.Builder
+	h     http.Handler
+}
+
+// NewUserHandler builds a new UserHandler.
+func NewUserHandler(repo Repository, links *links.Builder, log *zap.Logger) *UserHandler {
+	h := &UserHandler{repo: repo, links: links}
+	h.h = apperror.Handler(log, h.serve)
+	return h
+}
+
+// Pattern reports the path at which this is registered.
+func (*UserHandler) Pattern() string {
+	return "/users"
+}
+
+// Patterns adds the path of each user to that of the collection.
+func (h *UserHandler) Patterns() []string {
+	return []string{h.Pattern(), itemPattern}
+}
+
+// Methods reports the methods UserHandler serves.
+func (*UserHandler) Methods() []string {
+	return []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete}
+}
+
+func (h *UserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.h.ServeHTTP(w, r)
+}
+
+// serve dispatches on the method and on whether the request is for the
+// collection or for a single user.
+func (h *UserHandler) serve(w http.ResponseWriter, r *http.Request) error {
+	id := httpfx.PathValue(r, "id")
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return h.list(w, r)
+		case http.MethodPost:
+			return h.create(w, r)
+		}
+		return notAllowed(w, r, "GET, HEAD, POST")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return h.get(w, r, id)
+	case http.MethodPut:
+		return h.replace(w, r, id)
+	case http.MethodDelete:
+		return h.delete(w, r, id)
+	}
+	return notAllowed(w, r, "GET, HEAD, PUT, DELETE")
+}
+
+func (h *UserHandler) list(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := deadline.Downstream(r.Context(), repoTimeout)
+	defer cancel()
+	users, err := h.repo.List(ctx)
+	if err != nil {
+		return repoError(err)
+	}
+	return write(w, http.StatusOK, users)
+}
+
+func (h *UserHandler) create(w http.ResponseWriter, r *http.Request) error {
+	u, err := decode(r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := deadline.Downstream(r.Context(), repoTimeout)
+	defer cancel()
+	u, err = h.repo.Create(ctx, u)
+	if err != nil {
+		return repoError(err)
+	}
+	w.Header().Set("Location", h.links.Related(r, httpfx.PathFor(itemPattern, u.ID)).Href)
+	return write(w, http.StatusCreated, u)
+}
+
+func (h *UserHandler) get(w http.ResponseWriter, r *http.Request, id string) error {
+	ctx, cancel := deadline.Downstream(r.Context(), repoTimeout)
+	defer cancel()
+	u, err := h.repo.Get(ctx, id)
+	if err != nil {
+		return repoError(err)
+	}
+	return write(w, http.StatusOK, u)
+}
+
+// replace sets the name and email of the user; a PUT carries the whole
+// user, so fields left out are refused as they would be on creation.
+func (h *UserHandler) replace(w http.ResponseWriter, r *http.Request, id string) error {
+	u, err := decode(r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := deadline.Downstream(r.Context(), repoTimeout)
+	defer cancel()
+	u, err = h.repo.Replace(ctx, id, u)
+	if err != nil {
+		return repoError(err)
+	}
+	return write(w, http.StatusOK, u)
+}
+
+func (h *UserHandler) delete(w http.ResponseWriter, r *http.Request, id string) error {
+	ctx, cancel := deadline.Downstream(r.Context(), repoTimeout)
+	defer cancel()
+	if err := h.repo.Delete(ctx, id); err != nil {
+		return repoError(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// decode reads the name and email of a user from the request body and
+// validates them.
+func decode(r *http.Request) (User, error) {
+	var in struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return User{}, apperror.BadRequest("Invalid user: %v", err)
+	}
+	u := User{Name: in.Name, Email: in.Email}
+	if err := u.Validate(); err != nil {
+		return User{}, apperror.New(http.StatusUnprocessableEntity, "invalid", "%v", err)
+	}
+	return u, nil
+}
+
+// repoError turns a Repository error into the error to answer with.
+func repoError(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return apperror.NotFound("No such user")
+	case errors.Is(err, ErrEmailTaken):
+		return apperror.New(http.StatusConflict, "email_taken", "Another user has this email")
+	}
+	return apperror.Internal(err)
+}
+
+func notAllowed(w http.ResponseWriter, r *http.Request, allow string) error {
+	w.Header().Set("Allow", allow)
+	return apperror.New(http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed here", r.Method)
+}
+
+func write(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+	return nil
+}
@@ -0,0 +1,43 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/fxdemo/apperror"
+)
+
+// FuzzDecode checks that a request body is either refused as a client
+// error or decoded to a valid user that encodes back to itself.
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte(`{"name":"Bob","email":"bob@example.com"}`))
+	f.Add([]byte(`{"name":"Bob","email":"bob@example.com","admin":true}`))
+	f.Add([]byte(`{"name":"","email":"bob"}`))
+	f.Add([]byte(`{"name":"\u0000","email":"a@b"} trailing`))
+	f.Add([]byte(`[]`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		u, err := decode(httptest.NewRequest("POST", "/users", bytes.NewReader(body)))
+		if err != nil {
+			var e *apperror.Error
+			if !errors.As(err, &e) || (e.Status != http.StatusBadRequest && e.Status != http.StatusUnprocessableEntity) {
+				t.Fatalf("decode(%q) error = %v, want a 400 or 422", body, err)
+			}
+			return
+		}
+		if err := u.Validate(); err != nil {
+			t.Errorf("decode(%q) = %+v, which is not valid: %v", body, u, err)
+		}
+		encoded, err := json.Marshal(map[string]string{"name": u.Name, "email": u.Email})
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := decode(httptest.NewRequest("POST", "/users", bytes.NewReader(encoded)))
+		if err != nil || again != u {
+			t.Errorf("decode(%q) = %+v, but decoding it again gives %+v, %v", body, u, again, err)
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package user
+
+import (
+	"example.com/fxdemo/pkg/httpfx"
+	"go.uber.org/fx"
+)
+
+// Module provides the users resource and an in-memory Repository.
+// Providing another Repository, such as one on the database of package
+// db, only means replacing the annotated constructor.
+// ユーザーリソース
+var Module = fx.Module("user",
+	fx.Provide(
+		httpfx.AsRoute[*UserHandler](NewUserHandler),
+		fx.Annotate(
+			NewMemoryRepository,
+			fx.As(new(Repository)),
+		),
+	),
+)
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Repository stores users. Emails are unique, regardless of case.
+type Repository interface {
+	Create(ctx context.Context, u User) (User, error)
+	Get(ctx context.Context, id string) (User, error)
+	// Replace sets the name and email of the user.
+	Replace(ctx context.Context, id string, u User) (User, error)
+	Delete(ctx context.Context, id string) error
+	// List returns every user, oldest first.
+	List(ctx context.Context) ([]User, error)
+}
+
+// MemoryRepository is a Repository that keeps users in memory.
+// ユーザーをメモリ上で管理する
+type MemoryRepository struct {
+	mu      sync.Mutex
+	users   map[string]User
+	byEmail map[string]string // 小文字のメールアドレスからID
+}
+
+var _ Repository = (*MemoryRepository)(nil)
+
+// NewMemoryRepository builds an empty MemoryRepository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{users: make(map[string]User), byEmail: make(map[string]string)}
+}
+
+// Create stores a new user, giving it an ID.
+func (m *MemoryRepository) Create(ctx context.Context, u User) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	email := strings.ToLower(u.Email)
+	if _, ok := m.byEmail[email]; ok {
+		return User{}, ErrEmailTaken
+	}
+	now := time.Now()
+	u.ID = newID()
+	u.CreatedAt, u.UpdatedAt = now, now
+	m.users[u.ID] = u
+	m.byEmail[email] = u.ID
+	return u, nil
+}
+
+// Get returns the user with the given ID.
+func (m *MemoryRepository) Get(ctx context.Context, id string) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+// Replace sets the name and email of the user with the given ID.
+func (m *MemoryRepository) Replace(ctx context.Context, id string, u User) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	email := strings.ToLower(u.Email)
+	if other, ok := m.byEmail[email]; ok && other != id {
+		return User{}, ErrEmailTaken
+	}
+	delete(m.byEmail, strings.ToLower(old.Email))
+	old.Name, old.Email = u.Name, u.Email
+	old.UpdatedAt = time.Now()
+	m.users[id] = old
+	m.byEmail[email] = id
+	return old, nil
+}
+
+// Delete removes the user with the given ID.
+func (m *MemoryRepository) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.users, id)
+	delete(m.byEmail, strings.ToLower(u.Email))
+	return nil
+}
+
+// List returns every user, oldest first.
+func (m *MemoryRepository) List(ctx context.Context) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	out := make([]User, 0, len(m.users))
+	for _, u := range m.users {
+		out = append(out, u)
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
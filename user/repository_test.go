@@ -0,0 +1,53 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestMemoryRepositoryConcurrentEmails creates and renames
+// users with the same email, in any case, from many goroutines at once.
+// The email must never be taken by two users. Run it with -race.
+func TestMemoryRepositoryConcurrentEmails(t *testing.T) {
+	const goroutines = 16
+	ctx := context.Background()
+	m := NewMemoryRepository()
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			email := "bob@example.com"
+			if i%2 == 1 {
+				email = strings.ToUpper(email)
+			}
+			u, err := m.Create(ctx, User{Name: "Bob", Email: email})
+			switch {
+			case errors.Is(err, ErrEmailTaken):
+				return
+			case err != nil:
+				t.Error(err)
+				return
+			}
+			created.Add(1)
+			if _, err := m.Replace(ctx, u.ID, User{Name: "Robert", Email: email}); err != nil {
+				t.Error(err)
+			}
+			m.List(ctx)
+		}()
+	}
+	wg.Wait()
+
+	users, err := m.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Load() != 1 || len(users) != 1 || users[0].Name != "Robert" {
+		t.Errorf("%d users created, %+v left, want one", created.Load(), users)
+	}
+}
@@ -0,0 +1,61 @@
+// Package user is a complete example resource: users, created, read,
+// replaced and deleted at /users and /users/{id}. It shows the layout a
+// resource takes in this repository — the type and its validation, a
+// Repository interface with an in-memory implementation, a handler
+// registered with httpfx.AsRoute, and an fx.Module tying them together.
+package user
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a user ID is unknown to the Repository.
+	ErrNotFound = errors.New("user: not found")
+	// ErrEmailTaken is returned when another user has the same email.
+	ErrEmailTaken = errors.New("user: email taken")
+	// ErrInvalid is wrapped by the errors of Validate.
+	ErrInvalid = errors.New("user: invalid")
+)
+
+// User is an account of the application.
+type User struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the fields a client may set.
+func (u User) Validate() error {
+	switch {
+	case strings.TrimSpace(u.Name) == "":
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	case len(u.Name) > 100:
+		return fmt.Errorf("%w: name is longer than 100 bytes", ErrInvalid)
+	case u.Email == "":
+		return fmt.Errorf("%w: email is required", ErrInvalid)
+	case len(u.Email) > 254:
+		return fmt.Errorf("%w: email is longer than 254 bytes", ErrInvalid)
+	}
+	if a, err := mail.ParseAddress(u.Email); err != nil || a.Address != u.Email {
+		return fmt.Errorf("%w: email is not an address", ErrInvalid)
+	}
+	return nil
+}
+
+// newID returns a random ID of 16 hex characters.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
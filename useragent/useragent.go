@@ -0,0 +1,168 @@
+// Package useragent classifies clients by their User-Agent header —
+// browsers, mobile browsers, bots, HTTP libraries and our own SDK — so
+// that metrics and handlers can tell them apart.
+//
+// User-Agent is chosen by the client and unbounded, so classification
+// only ever yields labels from a fixed vocabulary, plus SDK versions,
+// which are capped in number.
+package useragent
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"example.com/fxdemo/pkg/boundedvar"
+)
+
+// Classes of client.
+const (
+	Browser = "browser"
+	Mobile  = "mobile"
+	Bot     = "bot"
+	Library = "library" // HTTP libraries and command line tools
+	SDK     = "sdk"     // the fxdemo client package
+	Unknown = "unknown"
+)
+
+// SDKProduct is the product token the fxdemo client sends.
+const SDKProduct = "fxdemo-go"
+
+// Client is what a User-Agent says about the client.
+type Client struct {
+	Class   string
+	Family  string // e.g. "chrome", "googlebot", "curl"; "other" if not recognized
+	Version string // major.minor, only for the SDK
+}
+
+// Label returns the client as a metric label, such as "browser/firefox"
+// or "sdk/fxdemo-go/1.2".
+func (c Client) Label() string {
+	if c.Version != "" {
+		return c.Class + "/" + c.Family + "/" + c.Version
+	}
+	return c.Class + "/" + c.Family
+}
+
+// families are matched, case insensitively and in order, against the
+// User-Agent of clients of a class.
+var (
+	bots      = []string{"googlebot", "bingbot", "yandexbot", "duckduckbot", "baiduspider", "applebot", "facebookexternalhit", "slackbot", "twitterbot"}
+	libraries = []struct{ token, family string }{
+		{"curl/", "curl"},
+		{"wget/", "wget"},
+		{"go-http-client/", "go"},
+		{"python-requests/", "python-requests"},
+		{"python-urllib/", "python-urllib"},
+		{"okhttp/", "okhttp"},
+		{"postmanruntime/", "postman"},
+		{"axios/", "axios"},
+		{"node-fetch/", "node-fetch"},
+		{"java/", "java"},
+	}
+	browsers = []struct{ token, family string }{
+		// Order matters: Edge and Opera also claim to be Chrome, and
+		// Chrome to be Safari.
+		{"edg/", "edge"},
+		{"opr/", "opera"},
+		{"samsungbrowser/", "samsung"},
+		{"firefox/", "firefox"},
+		{"fxios/", "firefox"},
+		{"crios/", "chrome"},
+		{"chrome/", "chrome"},
+		{"safari/", "safari"},
+	}
+	botHints    = []string{"bot", "crawler", "spider", "slurp", "headless"}
+	mobileHints = []string{"mobile", "android", "iphone", "ipad"}
+
+	sdkVersion = regexp.MustCompile(`^` + SDKProduct + `/(\d{1,3}\.\d{1,3})`)
+)
+
+// Parse classifies the client that sent the User-Agent ua.
+func Parse(ua string) Client {
+	if ua == "" {
+		return Client{Class: Unknown, Family: "none"}
+	}
+	if m := sdkVersion.FindStringSubmatch(ua); m != nil {
+		return Client{Class: SDK, Family: SDKProduct, Version: m[1]}
+	}
+	lower := strings.ToLower(ua)
+	if containsAny(lower, botHints) {
+		return Client{Class: Bot, Family: family(lower, bots)}
+	}
+	for _, l := range libraries {
+		if strings.HasPrefix(lower, l.token) {
+			return Client{Class: Library, Family: l.family}
+		}
+	}
+	if strings.HasPrefix(lower, "mozilla/") {
+		c := Client{Class: Browser, Family: "other"}
+		if containsAny(lower, mobileHints) {
+			c.Class = Mobile
+		}
+		for _, b := range browsers {
+			if strings.Contains(lower, b.token) {
+				c.Family = b.family
+				break
+			}
+		}
+		return c
+	}
+	return Client{Class: Unknown, Family: "other"}
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func family(s string, names []string) string {
+	for _, name := range names {
+		if strings.Contains(s, name) {
+			return name
+		}
+	}
+	return "other"
+}
+
+// maxLabels bounds the labels counted individually in the
+// requests_by_client expvar map. The vocabulary is fixed except for SDK
+// versions, which a client could make up to inflate it.
+const maxLabels = 100
+
+var requestsByClient = boundedvar.New("requests_by_client", maxLabels)
+
+type clientKey struct{}
+
+// FromContext returns the Client that sent the request.
+func FromContext(ctx context.Context) Client {
+	c, ok := ctx.Value(clientKey{}).(Client)
+	if !ok {
+		return Client{Class: Unknown, Family: "none"}
+	}
+	return c
+}
+
+// Classifier classifies the client of each request.
+// クライアントの種類を判定する
+type Classifier struct{}
+
+// NewClassifier builds a new Classifier.
+func NewClassifier() *Classifier {
+	return &Classifier{}
+}
+
+// Wrap returns a handler that makes the Client available through
+// FromContext and counts requests by its Label.
+func (c *Classifier) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := Parse(r.UserAgent())
+		requestsByClient.Add(client.Label())
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+	})
+}
@@ -0,0 +1,11 @@
+package useragent
+
+import (
+	"testing"
+
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	httpfxtest.Benchmark(b, NewClassifier().Wrap(httpfxtest.Noop))
+}
@@ -0,0 +1,78 @@
+// Package versioning picks the version of a route a client asked for,
+// either by path ("/v2/hello") or by media type
+// ("Accept: application/vnd.fxdemo.v2+json").
+package versioning
+
+import (
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"example.com/fxdemo/apperror"
+)
+
+// MediaType returns the vendor media type of the given API version.
+func MediaType(version int) string {
+	return "application/vnd.fxdemo.v" + strconv.Itoa(version) + "+json"
+}
+
+// FromAccept returns the API version requested by an Accept header, or 0
+// if the header does not name one.
+func FromAccept(accept string) int {
+	for _, r := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(r))
+		if err != nil {
+			continue
+		}
+		rest := strings.TrimPrefix(mediaType, "application/vnd.fxdemo.v")
+		if rest == mediaType || !strings.HasSuffix(rest, "+json") {
+			continue
+		}
+		if v, err := strconv.Atoi(strings.TrimSuffix(rest, "+json")); err == nil && v > 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// Resolve returns a handler that serves each request with the handler of
+// the version named in its Accept header. Requests that do not name a
+// version get the oldest one, and requests for a version that does not
+// exist are refused with 406 Not Acceptable.
+// Acceptヘッダでバージョンを選ぶ
+func Resolve(versions map[int]http.Handler) http.Handler {
+	oldest := 0
+	for v := range versions {
+		if oldest == 0 || v < oldest {
+			oldest = v
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		v := FromAccept(r.Header.Get("Accept"))
+		if v == 0 {
+			v = oldest
+		}
+		h, ok := versions[v]
+		if !ok {
+			apperror.Write(w, r, nil, apperror.New(http.StatusNotAcceptable, "not_acceptable", "Not acceptable; available versions: %s", list(versions)))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func list(versions map[int]http.Handler) string {
+	var vs []int
+	for v := range versions {
+		vs = append(vs, v)
+	}
+	sort.Ints(vs)
+	names := make([]string, len(vs))
+	for i, v := range vs {
+		names[i] = "v" + strconv.Itoa(v)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,155 @@
+package waf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"example.com/fxdemo/geoip"
+)
+
+// Rule is a single inspection rule as written in the rules file.
+//
+//	{"name": "sqli", "target": "query", "pattern": "(?i)union\\s+select", "action": "block"}
+//
+// The country target matches the client's ISO country code, and asn its
+// autonomous system as "AS" followed by the number, e.g. "AS64500".
+// Without GeoIP databases the country is empty and asn never matches.
+type Rule struct {
+	Name    string `json:"name"`
+	Target  string `json:"target"`           // path, query, header, body, country or asn
+	Header  string `json:"header,omitempty"` // Header is the header inspected when Target is "header".
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"` // block, tag or ratelimit
+
+	// Limit and Window configure the ratelimit action: at most Limit
+	// matching requests per client per Window.
+	Limit  int      `json:"limit,omitempty"`
+	Window Duration `json:"window,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "1m" in JSON.
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration with time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// rule is a Rule ready to be evaluated.
+type rule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// match reports whether the rule matches the request. body is the
+// inspected prefix of the request body, if any rule needed it.
+func (r *rule) match(req *http.Request, body []byte) bool {
+	switch r.Target {
+	case "path":
+		return r.re.MatchString(req.URL.Path)
+	case "query":
+		q, err := url.QueryUnescape(req.URL.RawQuery)
+		if err != nil {
+			q = req.URL.RawQuery
+		}
+		return r.re.MatchString(q)
+	case "header":
+		for _, v := range req.Header.Values(r.Header) {
+			if r.re.MatchString(v) {
+				return true
+			}
+		}
+		return false
+	case "body":
+		return r.re.Match(body)
+	case "country":
+		return r.re.MatchString(geoip.FromContext(req.Context()).Country)
+	case "asn":
+		asn := geoip.FromContext(req.Context()).ASN
+		return asn != 0 && r.re.MatchString("AS"+strconv.FormatUint(uint64(asn), 10))
+	}
+	return false
+}
+
+// ruleSet is an immutable, compiled set of rules.
+type ruleSet struct {
+	rules    []*rule
+	needBody bool
+	file     string // the file the rules were loaded from
+	modTime  time.Time
+}
+
+// loadRules reads and compiles the rules file. A missing file
+// yields an empty rule set.
+func loadRules(path string) (*ruleSet, error) {
+	if path == "" {
+		return &ruleSet{}, nil
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return &ruleSet{file: path}, nil // 作られたら読み込む
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw []Rule
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("waf: parse %s: %w", path, err)
+	}
+
+	set := &ruleSet{file: path, modTime: info.ModTime()}
+	for _, r := range raw {
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("waf: rule %q: %w", r.Name, err)
+		}
+		set.rules = append(set.rules, compiled)
+		set.needBody = set.needBody || r.Target == "body"
+	}
+	return set, nil
+}
+
+func compile(r Rule) (*rule, error) {
+	switch r.Target {
+	case "path", "query", "body", "country", "asn":
+	case "header":
+		if r.Header == "" {
+			return nil, fmt.Errorf("header target needs a header name")
+		}
+	default:
+		return nil, fmt.Errorf("unknown target %q", r.Target)
+	}
+	switch r.Action {
+	case "block", "tag":
+	case "ratelimit":
+		if r.Limit <= 0 || r.Window <= 0 {
+			return nil, fmt.Errorf("ratelimit action needs a limit and a window")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q", r.Action)
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &rule{Rule: r, re: re}, nil
+}
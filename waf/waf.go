@@ -0,0 +1,273 @@
+// Package waf inspects requests against a set of regular expression
+// rules loaded from a file, blocking, tagging or rate limiting the
+// requests that match. The rules file is reloaded when it changes.
+package waf
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/fxdemo/apperror"
+	"example.com/fxdemo/audit"
+	"example.com/fxdemo/clientip"
+	"example.com/fxdemo/degrade"
+	"example.com/fxdemo/ja3"
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Config controls where rules are loaded from.
+type Config struct {
+	RulesFile string `yaml:"rules_file"`
+	// ReloadInterval is how often the file is checked for changes; zero
+	// means it is only read again by Reload and Load.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	MaxBodyBytes   int64         `yaml:"max_body_bytes"` // MaxBodyBytes is how much of a body the body rules see.
+}
+
+// DefaultConfig returns a Config without any rules file.
+func DefaultConfig() Config {
+	return Config{
+		ReloadInterval: 5 * time.Second,
+		MaxBodyBytes:   64 << 10,
+	}
+}
+
+// matches counts rule matches by "rule:action"; it is published
+// with the other expvar variables.
+var matches = expvar.NewMap("waf_matches")
+
+// BodyRules is the degradable feature of inspecting request bodies.
+const BodyRules = "waf.body_rules"
+
+type tagsKey struct{}
+
+// Tags returns the names of the tag rules that matched the request.
+func Tags(ctx context.Context) []string {
+	tags, _ := ctx.Value(tagsKey{}).([]string)
+	return tags
+}
+
+// Engine evaluates the current rule set against every request.
+// リクエストをルールで検査する
+type Engine struct {
+	cfg      Config
+	log      *zap.Logger
+	audit    *audit.Logger
+	degraded *degrade.Registry
+	rules    atomic.Pointer[ruleSet]
+	loading  sync.Mutex // serializes loads
+
+	mu      sync.Mutex
+	windows map[string]*window // ratelimit windows by rule and client
+}
+
+type window struct {
+	start  time.Time
+	length time.Duration
+	count  int
+}
+
+// NewEngine loads the rules file and keeps it up to date for as long
+// as the Fx application runs.
+func NewEngine(lc fx.Lifecycle, cfg Config, log *zap.Logger, auditLog *audit.Logger, degraded *degrade.Registry) (*Engine, error) {
+	e := &Engine{
+		cfg:      cfg,
+		log:      log,
+		audit:    auditLog,
+		degraded: degraded,
+		windows:  make(map[string]*window),
+	}
+	degraded.Register(BodyRules, "requests are inspected without reading their bodies")
+	if err := e.Load(cfg.RulesFile); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadInterval <= 0 {
+		return e, nil
+	}
+
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go e.watch(done)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			close(done)
+			return nil
+		},
+	})
+	return e, nil
+}
+
+// Reload reads the rules file again. The previous rules stay in
+// effect if the new file cannot be loaded.
+func (e *Engine) Reload() error {
+	e.loading.Lock()
+	defer e.loading.Unlock()
+	return e.load(e.rules.Load().file)
+}
+
+// Load switches to the rules file at path, which is watched for changes
+// from then on; an empty path means no rules. The previous rules, and
+// their file, stay in effect if the new file cannot be loaded.
+func (e *Engine) Load(path string) error {
+	e.loading.Lock()
+	defer e.loading.Unlock()
+	return e.load(path)
+}
+
+// RulesFile returns the path of the rules file in effect.
+func (e *Engine) RulesFile() string {
+	return e.rules.Load().file
+}
+
+// load loads the rules file at path. e.loading must be held.
+func (e *Engine) load(path string) error {
+	set, err := loadRules(path)
+	if err != nil {
+		return err
+	}
+	e.rules.Store(set)
+	e.log.Info("Loaded WAF rules", zap.String("file", path), zap.Int("rules", len(set.rules)))
+	return nil
+}
+
+// watch reloads the rules whenever the file's modification time changes.
+func (e *Engine) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(e.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			set := e.rules.Load()
+			if set.file == "" {
+				continue
+			}
+			info, err := os.Stat(set.file)
+			if err != nil || info.ModTime().Equal(set.modTime) {
+				continue
+			}
+			if err := e.Reload(); err != nil {
+				e.log.Error("Failed to reload WAF rules", zap.Error(err))
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Wrap returns a handler that inspects requests before calling next.
+// While BodyRules is degraded, the body rules are skipped.
+func (e *Engine) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set := e.rules.Load()
+		if len(set.rules) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		skipBody := e.degraded.Degraded(BodyRules)
+		if set.needBody && !skipBody && r.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, e.cfg.MaxBodyBytes))
+			if err != nil {
+				apperror.Write(w, r, nil, &apperror.Error{Status: http.StatusBadRequest, Code: apperror.CodeBadRequest, Message: "Failed to read the request body", Err: err})
+				return
+			}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		}
+
+		client := clientip.FromRequest(r)
+		var tags []string
+		for _, rl := range set.rules {
+			if rl.Target == "body" && skipBody {
+				continue
+			}
+			if !rl.match(r, body) {
+				continue
+			}
+			matches.Add(rl.Name+":"+rl.Action, 1)
+			switch rl.Action {
+			case "block":
+				e.reject(w, r, rl, client, apperror.New(http.StatusForbidden, "blocked", "The request was blocked"))
+				return
+			case "ratelimit":
+				if e.hit(rl, client) > rl.Limit {
+					e.reject(w, r, rl, client, apperror.New(http.StatusTooManyRequests, "rate_limited", "Too many requests; retry later"))
+					return
+				}
+			case "tag":
+				tags = append(tags, rl.Name)
+			}
+		}
+
+		if len(tags) > 0 {
+			telemetry.Logger(r.Context(), e.log).Info("WAF rules matched",
+				zap.String("client", client),
+				zap.String("path", r.URL.Path),
+				zap.Strings("tags", tags),
+				zap.String("ja3", ja3.FromContext(r.Context()).Hash),
+			)
+			r = r.WithContext(context.WithValue(r.Context(), tagsKey{}, tags))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (e *Engine) reject(w http.ResponseWriter, r *http.Request, rl *rule, client string, err *apperror.Error) {
+	e.audit.Record(r.Context(), audit.Event{
+		Kind:   "waf." + rl.Action,
+		Client: client,
+		Detail: map[string]string{
+			"rule":   rl.Name,
+			"method": r.Method,
+			"path":   r.URL.Path,
+		},
+	})
+	apperror.Write(w, r, nil, err)
+}
+
+// hit counts a request matching a ratelimit rule and returns the
+// client's count in the current window.
+func (e *Engine) hit(rl *rule, client string) int {
+	key := rl.Name + "\x00" + client
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w, ok := e.windows[key]
+	if !ok || now.Sub(w.start) >= w.length {
+		if !ok {
+			e.prune(now)
+		}
+		w = &window{start: now, length: time.Duration(rl.Window)}
+		e.windows[key] = w
+	}
+	w.count++
+	return w.count
+}
+
+// prune drops windows that have already ended. e.mu must be held.
+func (e *Engine) prune(now time.Time) {
+	if len(e.windows) < 1024 {
+		return
+	}
+	for key, w := range e.windows {
+		if now.Sub(w.start) >= w.length {
+			delete(e.windows, key)
+		}
+	}
+}
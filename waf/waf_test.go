@@ -0,0 +1,200 @@
+package waf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/fxdemo/audit"
+	"example.com/fxdemo/degrade"
+	"example.com/fxdemo/pkg/httpfx/httpfxtest"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+const testRules = `[
+	{"name": "sqli", "target": "query", "pattern": "(?i)union\\s+select", "action": "block"},
+	{"name": "scanner", "target": "header", "header": "User-Agent", "pattern": "(?i)sqlmap", "action": "block"},
+	{"name": "script", "target": "body", "pattern": "(?i)<script", "action": "tag"},
+	{"name": "admin", "target": "path", "pattern": "^/admin", "action": "tag"},
+	{"name": "flood", "target": "path", "pattern": "^/limited$", "action": "ratelimit", "limit": 2, "window": "1h"}
+]`
+
+// writeRules writes a rules file to a new temporary directory and
+// returns its path.
+func writeRules(t *testing.T, rules string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestEngine(tb testing.TB, lc *fxtest.Lifecycle, path string) *Engine {
+	tb.Helper()
+	cfg := DefaultConfig()
+	cfg.RulesFile, cfg.ReloadInterval = path, time.Millisecond
+	e, err := NewEngine(lc, cfg, zap.NewNop(), audit.NewLogger(zap.NewNop(), nil), degrade.NewRegistry(zap.NewNop()))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return e
+}
+
+// tagged answers with the tags of the request.
+var tagged = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(strings.Join(Tags(r.Context()), ",")))
+})
+
+func TestWrap(t *testing.T) {
+	h := newTestEngine(t, fxtest.NewLifecycle(t), writeRules(t, testRules)).Wrap(tagged)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		agent  string
+		body   string
+		client string
+		status int
+		tags   string // for requests let through
+	}{
+		{"no match", "GET", "/users?page=2", "curl/8.5.0", "", "192.0.2.1", 200, ""},
+		{"block by query", "GET", "/users?q=1%20UNION%20SELECT%20password", "", "", "192.0.2.1", 403, ""},
+		{"block by header", "GET", "/users", "sqlmap/1.7", "", "192.0.2.1", 403, ""},
+		{"tag by body", "POST", "/users", "", `{"name": "<script>"}`, "192.0.2.1", 200, "script"},
+		{"several tags", "POST", "/admin/reload", "", "<SCRIPT>", "192.0.2.1", 200, "script,admin"},
+		{"under the limit", "GET", "/limited", "", "", "192.0.2.1", 200, ""},
+		{"at the limit", "GET", "/limited", "", "", "192.0.2.1", 200, ""},
+		{"over the limit", "GET", "/limited", "", "", "192.0.2.1", 429, ""},
+		{"limit is per client", "GET", "/limited", "", "", "192.0.2.2", 200, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		r.Header.Set("User-Agent", tt.agent)
+		r.RemoteAddr = tt.client + ":40000"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != tt.status {
+			t.Errorf("%s: %s %s = %d, want %d", tt.name, tt.method, tt.target, rec.Code, tt.status)
+			continue
+		}
+		if tt.status == http.StatusOK && rec.Body.String() != tt.tags {
+			t.Errorf("%s: tags %q, want %q", tt.name, rec.Body.String(), tt.tags)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string // empty if the rules are valid
+	}{
+		{"valid", testRules, ""},
+		{"empty", `[]`, ""},
+		{"not JSON", `[{"name":`, "parse"},
+		{"unknown target", `[{"name": "a", "target": "cookie", "pattern": "x", "action": "block"}]`, `unknown target "cookie"`},
+		{"header without a name", `[{"name": "a", "target": "header", "pattern": "x", "action": "block"}]`, "needs a header name"},
+		{"unknown action", `[{"name": "a", "target": "path", "pattern": "x", "action": "drop"}]`, `unknown action "drop"`},
+		{"ratelimit without a window", `[{"name": "a", "target": "path", "pattern": "x", "action": "ratelimit", "limit": 5}]`, "needs a limit and a window"},
+		{"invalid window", `[{"name": "a", "target": "path", "pattern": "x", "action": "ratelimit", "limit": 5, "window": "soon"}]`, "parse"},
+		{"invalid pattern", `[{"name": "a", "target": "path", "pattern": "(x", "action": "block"}]`, "missing closing )"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadRules(writeRules(t, tt.rules))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("loadRules() error = %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("loadRules() error = %v, want one mentioning %q", err, tt.err)
+			}
+		})
+	}
+	if set, err := loadRules(filepath.Join(t.TempDir(), "missing.json")); err != nil || len(set.rules) != 0 {
+		t.Errorf("loadRules(missing) = %v, %v, want no rules", set, err)
+	}
+}
+
+// TestShippedRules checks that the rules file the application ships
+// with loads, and applies to routes that are served without the demo
+// tag.
+func TestShippedRules(t *testing.T) {
+	set, err := loadRules("../waf-rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matched := false
+	for _, rl := range set.rules {
+		if rl.Action == "ratelimit" && rl.match(httptest.NewRequest("POST", "/uploads/", nil), nil) {
+			matched = true
+		}
+		if rl.Target == "path" && rl.match(httptest.NewRequest("GET", "/echo", nil), nil) {
+			t.Errorf("rule %s targets /echo, which only the demo build serves", rl.Name)
+		}
+	}
+	if !matched {
+		t.Error("no rule limits the creation of uploads")
+	}
+}
+
+// TestReload checks that the rules file is picked up when it changes,
+// and that the previous rules stay in effect if it becomes invalid.
+func TestReload(t *testing.T) {
+	path := writeRules(t, `[{"name": "a", "target": "path", "pattern": "^/a$", "action": "tag"}]`)
+	lc := fxtest.NewLifecycle(t)
+	e := newTestEngine(t, lc, path)
+	lc.RequireStart()
+	defer lc.RequireStop()
+	h := e.Wrap(tagged)
+	get := func() string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/a", nil))
+		return rec.Body.String()
+	}
+	// update replaces the file, with a modification time of its own so
+	// that the change is noticed however coarse the file system's clock.
+	mtime := time.Now()
+	update := func(rules string) {
+		mtime = mtime.Add(time.Second)
+		if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); get() != want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("GET /a: tagged %q, want %q", get(), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	waitFor("a")
+	update(`[{"name": "b", "target": "path", "pattern": "^/a$", "action": "tag"}]`)
+	waitFor("b")
+
+	update(`[{"name": "c", "target": "path", "pattern": "^/(a$", "action": "tag"}]`)
+	if err := e.Reload(); err == nil {
+		t.Fatal("Reload() of an invalid file succeeded")
+	}
+	if got := get(); got != "b" {
+		t.Errorf("after an invalid file: tagged %q, want the previous rules kept", got)
+	}
+}
+
+// BenchmarkWrap measures requests matching none of the rules of the
+// rules file the application ships with.
+func BenchmarkWrap(b *testing.B) {
+	e := newTestEngine(b, fxtest.NewLifecycle(b), "../waf-rules.json")
+	httpfxtest.Benchmark(b, e.Wrap(httpfxtest.Noop))
+}
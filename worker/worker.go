@@ -0,0 +1,171 @@
+// Package worker runs jobs in the background, on a fixed number of
+// goroutines fed by a bounded queue. Handlers enqueue the work they
+// need not wait for:
+//
+//	if err := queue.Enqueue(r.Context(), job); err != nil { ... }
+//
+// When the application stops, the queue takes no more jobs, and the
+// workers finish those already queued before it is stopped.
+package worker
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"example.com/fxdemo/telemetry"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Config sizes the pool of workers and their queue.
+type Config struct {
+	Workers   int `yaml:"workers"`    // Workers is how many jobs run at once.
+	QueueSize int `yaml:"queue_size"` // QueueSize is how many jobs may wait for a worker.
+}
+
+// DefaultConfig runs 4 jobs at once, with up to 100 waiting.
+func DefaultConfig() Config {
+	return Config{Workers: 4, QueueSize: 100}
+}
+
+// Job is a piece of work run by a worker.
+type Job interface {
+	// Name names the job in the logs and the counters, such as
+	// "demo.delayed_hello".
+	Name() string
+	// Run does the work. Its context carries the telemetry of the
+	// request that enqueued the job, and is canceled if the application
+	// stops before the job is done.
+	Run(ctx context.Context) error
+}
+
+// Errors of Enqueue.
+var (
+	ErrQueueFull = errors.New("worker: queue is full")
+	ErrStopped   = errors.New("worker: queue is stopped")
+)
+
+// jobs counts the jobs by outcome: "done", "failed" or "rejected".
+var jobs = expvar.NewMap("worker_jobs")
+
+// queued is a job with the context it was enqueued with.
+type queued struct {
+	ctx context.Context
+	job Job
+}
+
+// Queue hands the jobs enqueued to its workers.
+// ジョブキューとワーカー
+type Queue struct {
+	log  *zap.Logger
+	base context.Context // canceled when the workers must give up
+
+	mu      sync.RWMutex // guards stopped against the closing of jobs
+	stopped bool
+	jobs    chan queued
+}
+
+// NewQueue builds a Queue whose workers run from when the Fx
+// application starts until it stops. Jobs enqueued before it starts
+// wait for it to. Stopping waits for the jobs already queued, as long
+// as Fx allows; those still running then have their context canceled.
+func NewQueue(lc fx.Lifecycle, cfg Config, log *zap.Logger) (*Queue, error) {
+	if cfg.Workers < 1 {
+		return nil, fmt.Errorf("worker: %d workers", cfg.Workers)
+	}
+	base, cancel := context.WithCancel(context.Background())
+	q := &Queue{log: log, base: base, jobs: make(chan queued, max(cfg.QueueSize, 0))}
+
+	var wg sync.WaitGroup
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			for range cfg.Workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := range q.jobs {
+						q.run(j)
+					}
+				}()
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			q.mu.Lock()
+			q.stopped = true
+			close(q.jobs)
+			q.mu.Unlock()
+			log.Info("Draining the job queue", zap.Int("queued", len(q.jobs)))
+			drained := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(drained)
+			}()
+			select {
+			case <-drained:
+				cancel()
+				return nil
+			case <-ctx.Done():
+				cancel() // 実行中のジョブを打ち切る
+				return fmt.Errorf("worker: jobs still running: %w", ctx.Err())
+			}
+		},
+	})
+	return q, nil
+}
+
+// Enqueue queues job to be run with the values of ctx, but not its
+// cancellation, so that it outlives the request that enqueued it. It
+// returns ErrQueueFull if too many jobs are waiting already, and
+// ErrStopped once the application is stopping.
+func (q *Queue) Enqueue(ctx context.Context, job Job) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.stopped {
+		jobs.Add("rejected", 1)
+		return ErrStopped
+	}
+	select {
+	case q.jobs <- queued{ctx: context.WithoutCancel(ctx), job: job}:
+		return nil
+	default:
+		jobs.Add("rejected", 1)
+		return ErrQueueFull
+	}
+}
+
+// run runs one job, recovering from a panic in it so that the worker
+// goes on with the next one.
+func (q *Queue) run(j queued) {
+	ctx, cancel := context.WithCancel(j.ctx)
+	defer cancel()
+	defer context.AfterFunc(q.base, cancel)()
+	log := telemetry.Logger(ctx, q.log).With(zap.String("job", j.job.Name()))
+
+	start := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				err = fmt.Errorf("panic: %v", v)
+			}
+		}()
+		return j.job.Run(ctx)
+	}()
+	if err != nil {
+		jobs.Add("failed", 1)
+		log.Error("Job failed", zap.Error(err), zap.Duration("took", time.Since(start)))
+		return
+	}
+	jobs.Add("done", 1)
+	log.Debug("Job done", zap.Duration("took", time.Since(start)))
+}
+
+// Module provides the Queue.
+// バックグラウンドジョブ
+var Module = fx.Module("worker",
+	fx.Provide(NewQueue),
+)